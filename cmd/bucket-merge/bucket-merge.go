@@ -0,0 +1,115 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var (
+	ctconfig = config.NewCTConfig()
+	toFlag   = flag.String("to", "day", "bucket granularity to merge into, day or week")
+)
+
+func main() {
+	ctconfig.Init()
+	ctx := context.Background()
+	storageDB, remoteCache, backend := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("bucket-merge", ctconfig)
+	defer glog.Flush()
+
+	to, err := storage.ParseBucketGranularity(*toFlag)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	encoding, err := storage.ParseSerialEncoding(*ctconfig.SerialEncoding)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	issuerList, err := storageDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	bucketChan := make(chan storage.IssuerAndDate, 1024)
+	go func() {
+		defer close(bucketChan)
+		for _, issuerObj := range issuerList {
+			for _, expDate := range issuerObj.ExpDates {
+				if !to.CoarserThan(expDate.Granularity()) {
+					continue
+				}
+				bucketChan <- storage.IssuerAndDate{
+					ExpDate: expDate,
+					Issuer:  issuerObj.Issuer,
+				}
+			}
+		}
+	}()
+
+	var totalSerials, totalBuckets, failedBuckets int64
+	wg := sync.WaitGroup{}
+	for t := 0; t < *ctconfig.NumThreads; t++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bucket := range bucketChan {
+				target, count, err := storage.MergeCachedBucket(remoteCache, encoding, bucket, to)
+				if err != nil {
+					glog.Errorf("[%s] Merge failed after %d serials: %v", bucket.String(), count, err)
+					atomic.AddInt64(&failedBuckets, 1)
+					continue
+				}
+				glog.V(1).Infof("[%s] Merged %d serials into %s", bucket.String(), count, target.String())
+				atomic.AddInt64(&totalSerials, count)
+				atomic.AddInt64(&totalBuckets, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	glog.Infof("cache totals: merged %d serials from %d buckets into %s buckets, %d buckets failed",
+		totalSerials, totalBuckets, to, failedBuckets)
+
+	if ctconfig.CertPath != nil && len(*ctconfig.CertPath) > 0 {
+		expDates, err := backend.ListExpirationDates(ctx, time.Time{})
+		if err != nil {
+			glog.Fatal(err)
+		}
+
+		var totalCerts, totalDates int64
+		for _, expDate := range expDates {
+			if !to.CoarserThan(expDate.Granularity()) {
+				continue
+			}
+			count, err := storage.MergeBackendExpDate(ctx, backend, expDate, to)
+			if err != nil {
+				glog.Errorf("[%s] Merge failed after %d certificates: %v", expDate.ID(), count, err)
+				failedBuckets++
+				continue
+			}
+			totalCerts += count
+			totalDates++
+		}
+		glog.Infof("backend totals: merged %d certificates from %d expiration dates into %s buckets",
+			totalCerts, totalDates, to)
+	}
+
+	if failedBuckets > 0 {
+		glog.Flush()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,51 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+)
+
+var (
+	ctconfig   = config.NewCTConfig()
+	dryRun     = flag.Bool("dryrun", false, "report what would be removed without removing it")
+	reportPath = flag.String("report", "", "write a JSON report of what was removed to this file")
+)
+
+func main() {
+	ctconfig.Init()
+	storageDB, _, _ := engine.GetConfiguredStorage(context.Background(), ctconfig)
+	engine.PrepareTelemetry("cache-gc", ctconfig)
+	defer glog.Flush()
+
+	policy := engine.GetGCPolicy(ctconfig)
+	policy.DryRun = *dryRun
+
+	report, err := storageDB.CollectGarbage(policy)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	glog.Infof("Removed data expired before %s: %d buckets of %d serials, %d issuer keys, %d log states, "+
+		"%d expiration dates from the backend (dry run=%v)", report.Cutoff, report.SerialBuckets,
+		report.Serials, report.IssuerKeys, report.LogStates, report.BackendExpDates, report.DryRun)
+
+	if len(*reportPath) > 0 {
+		encoded, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			glog.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*reportPath, encoded, 0644); err != nil {
+			glog.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var (
+	ctconfig       = config.NewCTConfig()
+	dryRun         = flag.Bool("dryrun", false, "report what is missing from the cache without writing")
+	checkpointPath = flag.String("checkpoint", "", "file recording completed buckets, to resume an interrupted rebuild")
+	reportPath     = flag.String("report", "", "write a JSON report of the differences to this file")
+	includeExpired = flag.Bool("expired", false, "also rebuild buckets that have already expired")
+)
+
+func main() {
+	ctconfig.Init()
+	ctx := context.Background()
+
+	if ctconfig.CertPath == nil || len(*ctconfig.CertPath) == 0 {
+		glog.Error("certPath must be set to rebuild the cache from it")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	storageDB, remoteCache, backend := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("cache-rebuild", ctconfig)
+	defer glog.Flush()
+
+	logShortURLs := []string{}
+	if ctconfig.LogUrlList != nil && len(*ctconfig.LogUrlList) > 5 {
+		for _, part := range strings.Split(*ctconfig.LogUrlList, ",") {
+			ctLogUrl, err := url.Parse(strings.TrimSpace(part))
+			if err != nil {
+				glog.Fatalf("unable to set Certificate Log: %s", err)
+			}
+			logShortURLs = append(logShortURLs, fmt.Sprintf("%s%s", ctLogUrl.Host, ctLogUrl.Path))
+		}
+	}
+
+	rebuilder := storage.NewCacheRebuilder(backend, storageDB, remoteCache)
+	rebuilder.DryRun = *dryRun
+	rebuilder.NumWorkers = *ctconfig.NumThreads
+
+	if len(*checkpointPath) > 0 {
+		checkpoint, err := storage.NewRebuildCheckpoint(*checkpointPath)
+		if err != nil {
+			glog.Fatalf("Unable to open checkpoint %s: %v", *checkpointPath, err)
+		}
+		defer checkpoint.Close()
+		glog.Infof("Resuming from checkpoint %s with %d buckets complete", *checkpointPath,
+			checkpoint.Count())
+		rebuilder.Checkpoint = checkpoint
+	}
+
+	notBefore := time.Now()
+	if *includeExpired {
+		notBefore = time.Time{}
+	}
+
+	report, err := rebuilder.Rebuild(ctx, notBefore, logShortURLs)
+	if err != nil {
+		glog.Errorf("Rebuild did not complete: %v", err)
+	}
+
+	for _, issuer := range report.Issuers {
+		glog.Infof("Issuer %s: %d CRLs and %d DNs missing", issuer.Issuer, len(issuer.MissingCRLs),
+			len(issuer.MissingDNs))
+	}
+	for _, log := range report.Logs {
+		glog.Infof("Log %s: MaxEntry=%d missing=%v", log.ShortURL, log.MaxEntry, log.Missing)
+	}
+	glog.Infof("overall totals: %d buckets (%d skipped), %d serials, %d missing from cache, %d errors, dryRun=%v",
+		len(report.Buckets), report.SkippedBuckets, report.TotalSerials, report.TotalMissing,
+		report.TotalErrors, report.DryRun)
+
+	if len(*reportPath) > 0 {
+		encoded, jsonErr := json.MarshalIndent(report, "", "  ")
+		if jsonErr != nil {
+			glog.Fatal(jsonErr)
+		}
+		if writeErr := ioutil.WriteFile(*reportPath, encoded, 0644); writeErr != nil {
+			glog.Fatal(writeErr)
+		}
+	}
+
+	if err != nil {
+		glog.Flush()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,76 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/crls"
+	"github.com/jcjones/ct-mapreduce/engine"
+)
+
+var (
+	ctconfig       = config.NewCTConfig()
+	includeExpFlag = flag.Bool("includeExpired", false, "partition buckets which have already expired")
+)
+
+func main() {
+	ctconfig.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storageDB, remoteCache, _ := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("crl-fetch", ctconfig)
+	defer glog.Flush()
+
+	if len(*ctconfig.CRLPath) == 0 {
+		glog.Errorf("The crlPath directive is required")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	fetcher, err := crls.NewFetcher(*ctconfig.CRLPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	updater := crls.NewUpdater(storageDB, remoteCache, fetcher, crls.NewAIAIssuers(storageDB, fetcher))
+	updater.NumWorkers = *ctconfig.NumThreads
+	if !*includeExpFlag {
+		updater.NotBefore = time.Now()
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		glog.Infof("Signal caught, stopping.")
+		cancel()
+	}()
+
+	startTime := time.Now()
+	report, err := updater.Update(ctx)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Updated the revocations of %d issuers in %s, %d failed, %d without CRLs",
+		len(report.Issuers)-report.Failed-report.Skipped, time.Since(startTime), report.Failed, report.Skipped)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		glog.Fatal(err)
+	}
+	if report.Failed > 0 {
+		glog.Flush()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,91 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var (
+	ctconfig       = config.NewCTConfig()
+	outputFlag     = flag.String("output", "", "write the plan to this file rather than stdout")
+	includeExpFlag = flag.Bool("includeExpired", false, "count certificates which have already expired")
+)
+
+const kProgressPeriod = 5 * time.Second
+
+func main() {
+	ctconfig.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storageDB, _, backend := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("crl-plan", ctconfig)
+	defer glog.Flush()
+
+	planner := storage.NewCRLPlanner(storageDB, backend)
+	planner.NumWorkers = *ctconfig.NumThreads
+	if !*includeExpFlag {
+		planner.NotBefore = time.Now()
+	}
+
+	startTime := time.Now()
+	lastProgress := startTime
+	planner.Progress = func(aDone int, aTotal int) {
+		if time.Since(lastProgress) >= kProgressPeriod || aDone == aTotal {
+			lastProgress = time.Now()
+			glog.Infof("Planned %d of %d issuers in %s", aDone, aTotal, time.Since(startTime))
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		glog.Infof("Signal caught, stopping.")
+		cancel()
+	}()
+
+	plan, err := planner.Plan(ctx)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	var partitioned, uncovered int64
+	for _, issuer := range plan.Issuers {
+		if issuer.Partitioned {
+			partitioned++
+		}
+		uncovered += issuer.Uncovered
+	}
+	glog.Infof("%d CRLs across %d issuers, %d of them partitioned; %d certificates name no CRL",
+		len(plan.CRLs), len(plan.Issuers), partitioned, uncovered)
+
+	out := os.Stdout
+	if len(*outputFlag) > 0 {
+		out, err = os.Create(*outputFlag)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		glog.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		glog.Fatal(err)
+	}
+}
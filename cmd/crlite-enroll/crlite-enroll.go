@@ -0,0 +1,89 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/crlite"
+	"github.com/jcjones/ct-mapreduce/engine"
+)
+
+var (
+	ctconfig   = config.NewCTConfig()
+	outputFlag = flag.String("output", "", "write the enrollment to this file rather than stdout")
+)
+
+func main() {
+	ctconfig.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storageDB, remoteCache, backend := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("crlite-enroll", ctconfig)
+	defer glog.Flush()
+
+	if ctconfig.CertPath == nil || len(*ctconfig.CertPath) == 0 {
+		glog.Warning("Issuer certificates are kept under certPath, so without it no issuer can be enrolled")
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		glog.Infof("Signal caught, stopping.")
+		cancel()
+	}()
+
+	enroller := engine.GetEnroller(storageDB, remoteCache, backend, ctconfig)
+	enroller.NotBefore = time.Now()
+	enrollment, err := enroller.Enroll(ctx)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	reasons := make(map[string]int)
+	for _, issuer := range enrollment.Issuers {
+		if !issuer.Enrolled {
+			reasons[issuer.Reason]++
+		}
+	}
+	glog.Infof("%d of %d issuers enrolled; not enrolled: %v", len(enrollment.EnrolledIDs()),
+		len(enrollment.Issuers), reasons)
+
+	if ctconfig.CertPath != nil && len(*ctconfig.CertPath) > 0 {
+		previous, runID, err := crlite.LatestEnrollment(ctx, backend)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		if previous != nil {
+			added, removed := enrollment.Changes(previous)
+			glog.Infof("Since run %s, enrolled %v and unenrolled %v", runID, added, removed)
+		}
+	}
+
+	out := os.Stdout
+	if len(*outputFlag) > 0 {
+		out, err = os.Create(*outputFlag)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(enrollment); err != nil {
+		glog.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		glog.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/crlite"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/mlbf"
+)
+
+var (
+	ctconfig   = config.NewCTConfig()
+	outputFlag = flag.String("output", "filter", "write the filter cascade to this file")
+	runIDFlag  = flag.String("runId", "", "store the run under certPath with this ID, by default the time it started")
+)
+
+func main() {
+	ctconfig.Init()
+	ctx := context.Background()
+	storageDB, remoteCache, backend := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("crlite-filter", ctconfig)
+	defer glog.Flush()
+
+	startTime := time.Now()
+
+	// The filter covers exactly the enrolled issuers, as clients expect
+	enroller := engine.GetEnroller(storageDB, remoteCache, backend, ctconfig)
+	enroller.NotBefore = startTime
+	enrollment, err := enroller.Enroll(ctx)
+	if err != nil {
+		glog.Fatalf("Couldn't enroll issuers: %v", err)
+	}
+	enrolled := enrollment.EnrolledIDs()
+	glog.Infof("%d of %d issuers enrolled", len(enrolled), len(enrollment.Issuers))
+
+	keys, err := mlbf.LoadKeys(storageDB, remoteCache, startTime, enrolled)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	glog.Infof("Loaded %d revoked and %d unrevoked certificates in %s", len(keys.Revoked), len(keys.Unrevoked),
+		time.Since(startTime))
+
+	cascade, err := mlbf.Build(keys)
+	if err != nil {
+		glog.Fatalf("Couldn't build the filter cascade: %v", err)
+	}
+
+	var buf bytes.Buffer
+	size, err := cascade.WriteTo(&buf)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*outputFlag, buf.Bytes(), 0644); err != nil {
+		glog.Fatal(err)
+	}
+
+	// Check what was written, not just what was built.
+	fd, err := os.Open(*outputFlag)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer fd.Close()
+	written, err := mlbf.ReadCascade(fd)
+	if err != nil {
+		glog.Fatalf("Couldn't read back %s: %v", *outputFlag, err)
+	}
+	if err := written.Verify(keys.Revoked, keys.Unrevoked); err != nil {
+		glog.Fatalf("%s is wrong: %v", *outputFlag, err)
+	}
+
+	glog.Infof("Wrote a %d-layer, %d byte filter cascade to %s in %s", cascade.Layers(), size, *outputFlag,
+		time.Since(startTime))
+
+	if ctconfig.CertPath == nil || len(*ctconfig.CertPath) == 0 {
+		glog.Warning("Not storing a run, which needs certPath")
+		return
+	}
+	runID := *runIDFlag
+	if runID == "" {
+		runID = crlite.NewRunID(startTime)
+	}
+	if _, err := crlite.LoadManifest(ctx, backend, runID); err == nil {
+		glog.Fatalf("Run %s already exists", runID)
+	}
+	if err := backend.StoreRunFile(ctx, runID, crlite.FilterFile, buf.Bytes()); err != nil {
+		glog.Fatal(err)
+	}
+
+	// Each run keeps the version of the enrollment its filter covers
+	encoded, err := json.Marshal(enrollment)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if err := backend.StoreRunFile(ctx, runID, crlite.EnrollmentFile, encoded); err != nil {
+		glog.Fatal(err)
+	}
+
+	generator := crlite.NewGenerator(storageDB, remoteCache, backend)
+	generator.NotBefore = startTime
+	generator.Issuers = enrolled
+	manifest, _, err := generator.Run(ctx, runID)
+	if err != nil {
+		glog.Fatalf("Couldn't store run %s: %v", runID, err)
+	}
+	if manifest.PreviousRunID == "" {
+		glog.Infof("Stored run %s of %d buckets, the first, with no stash", runID, len(manifest.Buckets))
+	} else {
+		glog.Infof("Stored run %s of %d buckets, with a stash of %d serials of %d issuers since run %s", runID,
+			len(manifest.Buckets), manifest.StashSerials, manifest.StashIssuers, manifest.PreviousRunID)
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/google/certificate-transparency-go/jsonclient"
 	"github.com/google/certificate-transparency-go/x509"
 	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/coordinator"
 	"github.com/jcjones/ct-mapreduce/engine"
 	"github.com/jcjones/ct-mapreduce/storage"
 	"github.com/jpillora/backoff"
@@ -41,34 +42,6 @@ var (
 	nobars   = flag.Bool("nobars", false, "disable display of download bars")
 )
 
-func certIsFilteredOut(aCert *x509.Certificate) bool {
-	// Skip unimportant entries, if configured
-
-	if aCert.BasicConstraintsValid && aCert.IsCA {
-		metrics.IncrCounter([]string{"certIsFilteredOut", "CA"}, 1)
-		return true
-	}
-
-	if aCert.NotAfter.Before(time.Now()) && !*ctconfig.LogExpiredEntries {
-		metrics.IncrCounter([]string{"certIsFilteredOut", "expired"}, 1)
-		return true
-	}
-
-	skip := (len(*ctconfig.IssuerCNFilter) != 0)
-	for _, filter := range strings.Split(*ctconfig.IssuerCNFilter, ",") {
-		if strings.HasPrefix(aCert.Issuer.CommonName, filter) {
-			skip = false
-			break
-		}
-	}
-
-	if skip {
-		metrics.IncrCounter([]string{"certIsFilteredOut", "cn-filtered"}, 1)
-		glog.V(4).Infof("Skipping inserting cert issued by %s", aCert.Issuer.CommonName)
-	}
-	return skip
-}
-
 func uint64ToTimestamp(timestamp uint64) *time.Time {
 	t := time.Unix(int64(timestamp/1000), int64(timestamp%1000))
 	return &t
@@ -208,7 +181,7 @@ func (ld *LogSyncEngine) insertCTWorker() {
 			continue
 		}
 
-		if certIsFilteredOut(cert) {
+		if engine.CertIsFilteredOut(cert, ctconfig) {
 			continue
 		}
 
@@ -226,6 +199,11 @@ func (ld *LogSyncEngine) insertCTWorker() {
 		metrics.MeasureSince([]string{"insertCTWorker", "ParseCertificates"}, parseTime)
 
 		storeTime := time.Now()
+		err = ld.database.StoreIssuerCertificate(issuingCert)
+		if err != nil {
+			glog.Errorf("[%s] Problem storing issuing certificate: index: %d error: %s", ep.LogURL, ep.LogEntry.Index, err)
+		}
+
 		err = ld.database.Store(cert, issuingCert, ep.LogURL, ep.LogEntry.Index)
 		if err != nil {
 			glog.Errorf("[%s] Problem inserting certificate: index: %d error: %s", ep.LogURL, ep.LogEntry.Index, err)
@@ -487,12 +465,86 @@ func (lw *LogWorker) downloadCTRangeToChannel(entryChan chan<- CtLogEntry) (uint
 	return index, lastEntryTimestamp, nil
 }
 
+func collectGarbagePeriodically(aDB storage.CertDatabase, aPolicy storage.GCPolicy,
+	aPeriod time.Duration) {
+	ticker := time.NewTicker(aPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := aDB.CollectGarbage(aPolicy)
+		if err != nil {
+			glog.Errorf("Garbage collection failed: %v", err)
+			continue
+		}
+		glog.Infof("Garbage collection removed %d buckets of %d serials, %d issuer keys, "+
+			"%d log states and %d backend expiration dates", report.SerialBuckets, report.Serials,
+			report.IssuerKeys, report.LogStates, report.BackendExpDates)
+	}
+}
+
+// Syncs the log at urlString, then if running forever polls it again until
+// signalled. As part of a fleet, it stops once the log is no longer ours, or
+// releases it between polls when the fleet asks.
+func syncLogUntilStopped(syncEngine *LogSyncEngine, urlString string, pollingDelayMean time.Duration,
+	fleet *coordinator.Fleet) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	defer close(sigChan)
+
+	for {
+		owned := true
+		if fleet != nil {
+			var err error
+			owned, err = fleet.Owns(urlString)
+			if err != nil {
+				// Don't risk syncing a log someone else has
+				glog.Errorf("[%s] Could not check the fleet owns this log: %s", urlString, err)
+			} else if !owned {
+				glog.Infof("[%s] No longer ours. Stopping.", urlString)
+				return
+			}
+		}
+
+		if owned {
+			err := syncEngine.SyncLog(urlString)
+			if err != nil {
+				glog.Errorf("[%s] Could not sync log: %s", urlString, err)
+			}
+		}
+
+		if !*ctconfig.RunForever {
+			return
+		}
+
+		if fleet != nil && fleet.ShouldRelease(urlString) {
+			if err := fleet.Release(urlString); err != nil {
+				glog.Errorf("[%s] Could not release log to the fleet: %s", urlString, err)
+			} else {
+				return
+			}
+		}
+
+		sampledSeconds := rand.NormFloat64() * float64(*ctconfig.PollingDelayStdDev)
+		sleepTime := time.Duration(sampledSeconds)*time.Second + pollingDelayMean
+		glog.Infof("[%s] Stopped. Polling again in %v. stddev=%v", urlString,
+			sleepTime, *ctconfig.PollingDelayStdDev)
+
+		select {
+		case <-sigChan:
+			glog.Infof("[%s] Signal caught. Exiting.", urlString)
+			return
+		case <-time.After(sleepTime):
+			continue
+		}
+	}
+}
+
 func main() {
 	ctconfig.Init()
 	ctx := context.Background()
 	rand.Seed(time.Now().UnixNano())
 
-	storageDB, _, _ := engine.GetConfiguredStorage(ctx, ctconfig)
+	storageDB, remoteCache, _ := engine.GetConfiguredStorage(ctx, ctconfig)
 	defer glog.Flush()
 
 	if ctconfig.IssuerCNFilter != nil && len(*ctconfig.IssuerCNFilter) > 0 {
@@ -524,11 +576,21 @@ func main() {
 		// Start a pool of threads to parse log entries and hand them to the database
 		syncEngine.StartDatabaseThreads()
 
-		// Start one thread per CT log to process the log entries
-		for _, ctLogUrl := range logUrls {
-			urlString := ctLogUrl.String()
-			glog.Infof("[%s] Starting download.", urlString)
+		if len(*ctconfig.FleetName) > 0 {
+			if !*ctconfig.RunForever {
+				glog.Fatalf("fleetName requires runForever")
+			}
+			urlStrings := make([]string, 0, len(logUrls))
+			for _, ctLogUrl := range logUrls {
+				urlStrings = append(urlStrings, ctLogUrl.String())
+			}
+			fleet, err := coordinator.NewFleet(remoteCache, *ctconfig.FleetName, urlStrings)
+			if err != nil {
+				glog.Fatalf("Could not join fleet %s: %v", *ctconfig.FleetName, err)
+			}
 
+			// The fleet decides which logs are ours, and leaves once they've
+			// all stopped.
 			syncEngine.DownloaderWaitGroup.Add(1)
 			go func() {
 				defer syncEngine.DownloaderWaitGroup.Done()
@@ -536,32 +598,38 @@ func main() {
 				sigChan := make(chan os.Signal, 1)
 				signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 				defer signal.Stop(sigChan)
-				defer close(sigChan)
-
-				for {
-					err := syncEngine.SyncLog(urlString)
-					if err != nil {
-						glog.Errorf("[%s] Could not sync log: %s", urlString, err)
-					}
-
-					if !*ctconfig.RunForever {
-						return
-					}
-
-					sampledSeconds := rand.NormFloat64() * float64(*ctconfig.PollingDelayStdDev)
-					sleepTime := time.Duration(sampledSeconds)*time.Second + pollingDelayMean
-					glog.Infof("[%s] Stopped. Polling again in %v. stddev=%v", urlString,
-						sleepTime, *ctconfig.PollingDelayStdDev)
-
-					select {
-					case <-sigChan:
-						glog.Infof("[%s] Signal caught. Exiting.", urlString)
-						return
-					case <-time.After(sleepTime):
-						continue
-					}
-				}
+
+				stopChan := make(chan struct{})
+				go func() {
+					<-sigChan
+					close(stopChan)
+				}()
+
+				fleet.Run(stopChan, func(urlString string) {
+					glog.Infof("[%s] Starting download.", urlString)
+					syncLogUntilStopped(syncEngine, urlString, pollingDelayMean, fleet)
+				})
 			}()
+		} else {
+			// Start one thread per CT log to process the log entries
+			for _, ctLogUrl := range logUrls {
+				urlString := ctLogUrl.String()
+				glog.Infof("[%s] Starting download.", urlString)
+
+				syncEngine.DownloaderWaitGroup.Add(1)
+				go func() {
+					defer syncEngine.DownloaderWaitGroup.Done()
+					syncLogUntilStopped(syncEngine, urlString, pollingDelayMean, nil)
+				}()
+			}
+		}
+
+		if *ctconfig.RunForever && len(*ctconfig.GCPeriod) > 0 {
+			gcPeriod, err := time.ParseDuration(*ctconfig.GCPeriod)
+			if err != nil {
+				glog.Fatalf("Could not parse GCPeriod: %v", err)
+			}
+			go collectGarbagePeriodically(storageDB, engine.GetGCPolicy(ctconfig), gcPeriod)
 		}
 
 		healthHandler := http.NewServeMux()
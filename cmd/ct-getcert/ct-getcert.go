@@ -2,9 +2,10 @@ package main
 
 import (
 	"context"
-	"encoding/pem"
 	"flag"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/golang/glog"
 	"github.com/google/certificate-transparency-go"
@@ -13,15 +14,66 @@ import (
 	"github.com/google/certificate-transparency-go/x509"
 )
 
+const kBatchSize = 256
+
+// Which of a precertificate entry's certificates to output.
+const (
+	kPrecertSubmitted = "submitted"
+	kPrecertTBS       = "tbs"
+	kPrecertBoth      = "both"
+)
+
+type entry struct {
+	rawEntry  *ct.RawLogEntry
+	precert   bool
+	submitted bool
+	tbs       bool
+	chain     bool
+}
+
+func formatNames() string {
+	names := make([]string, 0, len(writers))
+	for name := range writers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 func main() {
-	var index uint64
-	var logURL string
+	var index, start, end int64
+	var logURL, format, precert string
+	var chain bool
 	flag.StringVar(&logURL, "log", "", "log URL")
-	flag.Uint64Var(&index, "index", 0, "index")
+	flag.Int64Var(&index, "index", 0, "index, when -start isn't given")
+	flag.Int64Var(&start, "start", -1, "first index of a range")
+	flag.Int64Var(&end, "end", -1, "last index of a range, inclusive; defaults to -start")
+	flag.BoolVar(&chain, "chain", false, "also output the issuing chain")
+	flag.StringVar(&precert, "precert", kPrecertSubmitted,
+		"for precertificates, output the submitted precertificate, its tbs, or both")
+	flag.StringVar(&format, "format", "pem", "output format: "+formatNames())
 	flag.Parse()
 
 	defer glog.Flush()
 
+	write, ok := writers[format]
+	if !ok {
+		glog.Fatalf("Unknown format %s, expected one of %s", format, formatNames())
+	}
+	if precert != kPrecertSubmitted && precert != kPrecertTBS && precert != kPrecertBoth {
+		glog.Fatalf("Unknown -precert %s, expected %s, %s or %s", precert, kPrecertSubmitted, kPrecertTBS,
+			kPrecertBoth)
+	}
+	if start < 0 {
+		start = index
+	}
+	if end < 0 {
+		end = start
+	}
+	if end < start {
+		glog.Fatalf("-end %d is before -start %d", end, start)
+	}
+
 	ctClient, err := client.New(logURL, nil, jsonclient.Options{})
 	if err != nil {
 		glog.Fatalf("[%s] Unable to construct CT log client: %s", logURL, err)
@@ -29,29 +81,44 @@ func main() {
 
 	ctx := context.Background()
 
-	glog.Infof("[%s] Fetching entry %d... ", logURL, index)
-	resp, err := ctClient.GetRawEntries(ctx, int64(index), int64(index))
-	if err != nil {
-		glog.Fatal(err)
-	}
-
-	for _, entry := range resp.Entries {
-		rawEntry, err := ct.RawLogEntryFromLeaf(int64(index), &entry)
-		if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
-			glog.Warningf("Erroneous certificate: log=%s index=%d err=%v",
-				logURL, index, err)
-			continue
+	for next := start; next <= end; {
+		last := next + kBatchSize - 1
+		if last > end {
+			last = end
 		}
 
-		pemblock := pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: rawEntry.Cert.Data,
+		glog.Infof("[%s] Fetching entries %d to %d... ", logURL, next, last)
+		resp, err := ctClient.GetRawEntries(ctx, next, last)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		if len(resp.Entries) == 0 {
+			glog.Fatalf("[%s] No entries returned at index %d", logURL, next)
 		}
 
-		pemBytes := pem.EncodeToMemory(&pemblock)
-		_, err = os.Stdout.Write(pemBytes)
-		if err != nil {
-			glog.Error(err)
+		for i := range resp.Entries {
+			rawEntry, err := ct.RawLogEntryFromLeaf(next, &resp.Entries[i])
+			next++
+			if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+				glog.Warningf("Erroneous certificate: log=%s index=%d err=%v",
+					logURL, next-1, err)
+				continue
+			}
+
+			e := entry{
+				rawEntry:  rawEntry,
+				precert:   rawEntry.Leaf.TimestampedEntry.EntryType == ct.PrecertLogEntryType,
+				submitted: true,
+				chain:     chain,
+			}
+			if e.precert {
+				e.submitted = precert != kPrecertTBS
+				e.tbs = precert != kPrecertSubmitted
+			}
+
+			if err := write(os.Stdout, e); err != nil {
+				glog.Fatal(err)
+			}
 		}
 	}
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509util"
+)
+
+// PEM type for a precertificate's TBSCertificate, as the log hashed it.
+const kTBSBlockType = "TBS CERTIFICATE"
+
+var writers = map[string]func(io.Writer, entry) error{
+	"pem":  writePEM,
+	"der":  writeDER,
+	"json": writeJSON,
+	"text": writeText,
+}
+
+type part struct {
+	name string
+	der  []byte
+	tbs  bool
+}
+
+// The DER structures to output for e, in order: the leaf, the precert's TBS,
+// then the chain.
+func (e entry) parts() []part {
+	parts := []part{}
+	if e.submitted {
+		parts = append(parts, part{name: "leaf", der: e.rawEntry.Cert.Data})
+	}
+	if e.tbs {
+		parts = append(parts, part{name: "tbs", der: e.rawEntry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate,
+			tbs: true})
+	}
+	if e.chain {
+		for i, cert := range e.rawEntry.Chain {
+			parts = append(parts, part{name: fmt.Sprintf("chain[%d]", i), der: cert.Data})
+		}
+	}
+	return parts
+}
+
+func (p part) parse() (*x509.Certificate, error) {
+	var cert *x509.Certificate
+	var err error
+	if p.tbs {
+		cert, err = x509.ParseTBSCertificate(p.der)
+	} else {
+		cert, err = x509.ParseCertificate(p.der)
+	}
+	if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (e entry) timestamp() time.Time {
+	return ct.TimestampToTime(e.rawEntry.Leaf.TimestampedEntry.Timestamp).UTC()
+}
+
+func (e entry) entryType() string {
+	if e.precert {
+		return "precert"
+	}
+	return "x509"
+}
+
+func writePEM(w io.Writer, e entry) error {
+	for _, p := range e.parts() {
+		block := pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: p.der,
+		}
+		if p.tbs {
+			block.Type = kTBSBlockType
+		}
+		if err := pem.Encode(w, &block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Concatenated DER structures, which are self-delimiting.
+func writeDER(w io.Writer, e entry) error {
+	for _, p := range e.parts() {
+		if _, err := w.Write(p.der); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type extensionJSON struct {
+	ID       string `json:"id"`
+	Critical bool   `json:"critical"`
+	Value    []byte `json:"value"`
+}
+
+type certificateJSON struct {
+	ParseError            string          `json:"parseError,omitempty"`
+	SHA256                string          `json:"sha256"`
+	Serial                string          `json:"serial,omitempty"`
+	Subject               string          `json:"subject,omitempty"`
+	Issuer                string          `json:"issuer,omitempty"`
+	NotBefore             *time.Time      `json:"notBefore,omitempty"`
+	NotAfter              *time.Time      `json:"notAfter,omitempty"`
+	IsCA                  bool            `json:"isCA"`
+	DNSNames              []string        `json:"dnsNames,omitempty"`
+	IPAddresses           []string        `json:"ipAddresses,omitempty"`
+	OCSPServer            []string        `json:"ocspServer,omitempty"`
+	IssuingCertificateURL []string        `json:"issuingCertificateURL,omitempty"`
+	CRLDistributionPoints []string        `json:"crlDistributionPoints,omitempty"`
+	SignatureAlgorithm    string          `json:"signatureAlgorithm,omitempty"`
+	PublicKeyAlgorithm    string          `json:"publicKeyAlgorithm,omitempty"`
+	Extensions            []extensionJSON `json:"extensions,omitempty"`
+}
+
+type entryJSON struct {
+	Index         int64             `json:"index"`
+	Timestamp     time.Time         `json:"timestamp"`
+	EntryType     string            `json:"entryType"`
+	IssuerKeyHash string            `json:"issuerKeyHash,omitempty"`
+	Leaf          *certificateJSON  `json:"leaf,omitempty"`
+	TBS           *certificateJSON  `json:"tbs,omitempty"`
+	Chain         []certificateJSON `json:"chain,omitempty"`
+}
+
+func newCertificateJSON(p part) certificateJSON {
+	digest := sha256.Sum256(p.der)
+	result := certificateJSON{SHA256: hex.EncodeToString(digest[:])}
+
+	cert, err := p.parse()
+	if err != nil {
+		result.ParseError = err.Error()
+		return result
+	}
+
+	result.Serial = hex.EncodeToString(cert.SerialNumber.Bytes())
+	result.Subject = cert.Subject.String()
+	result.Issuer = cert.Issuer.String()
+	notBefore, notAfter := cert.NotBefore.UTC(), cert.NotAfter.UTC()
+	result.NotBefore, result.NotAfter = &notBefore, &notAfter
+	result.IsCA = cert.IsCA
+	result.DNSNames = cert.DNSNames
+	for _, ip := range cert.IPAddresses {
+		result.IPAddresses = append(result.IPAddresses, ip.String())
+	}
+	result.OCSPServer = cert.OCSPServer
+	result.IssuingCertificateURL = cert.IssuingCertificateURL
+	result.CRLDistributionPoints = cert.CRLDistributionPoints
+	if !p.tbs {
+		result.SignatureAlgorithm = cert.SignatureAlgorithm.String()
+	}
+	result.PublicKeyAlgorithm = cert.PublicKeyAlgorithm.String()
+	for _, ext := range cert.Extensions {
+		result.Extensions = append(result.Extensions, extensionJSON{
+			ID:       ext.Id.String(),
+			Critical: ext.Critical,
+			Value:    ext.Value,
+		})
+	}
+	return result
+}
+
+// One JSON object per line, so ranges can be processed as a stream.
+func writeJSON(w io.Writer, e entry) error {
+	result := entryJSON{
+		Index:     e.rawEntry.Index,
+		Timestamp: e.timestamp(),
+		EntryType: e.entryType(),
+	}
+	if e.precert {
+		keyHash := e.rawEntry.Leaf.TimestampedEntry.PrecertEntry.IssuerKeyHash
+		result.IssuerKeyHash = hex.EncodeToString(keyHash[:])
+	}
+
+	for _, p := range e.parts() {
+		cert := newCertificateJSON(p)
+		switch {
+		case p.tbs:
+			result.TBS = &cert
+		case p.name == "leaf":
+			result.Leaf = &cert
+		default:
+			result.Chain = append(result.Chain, cert)
+		}
+	}
+
+	return json.NewEncoder(w).Encode(result)
+}
+
+func writeText(w io.Writer, e entry) error {
+	_, err := fmt.Fprintf(w, "Index: %d\nTimestamp: %s\nEntry type: %s\n", e.rawEntry.Index,
+		e.timestamp().Format(time.RFC3339Nano), e.entryType())
+	if err != nil {
+		return err
+	}
+
+	for _, p := range e.parts() {
+		if _, err := fmt.Fprintf(w, "\n--- %s ---\n", p.name); err != nil {
+			return err
+		}
+		cert, err := p.parse()
+		if err != nil {
+			if _, err := fmt.Fprintf(w, "Couldn't parse: %v\n", err); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintln(w, x509util.CertificateToString(cert)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,47 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/service"
+	"google.golang.org/grpc"
+)
+
+var (
+	ctconfig = config.NewCTConfig()
+)
+
+func main() {
+	ctconfig.Init()
+	ctx := context.Background()
+	storageDB, _, _ := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("ct-grpc-server", ctconfig)
+	defer glog.Flush()
+
+	// The same filtering ct-fetch applies to CT log entries
+	filter := func(aCert *x509.Certificate) bool {
+		return engine.CertIsFilteredOut(aCert, ctconfig)
+	}
+
+	listener, err := net.Listen("tcp", *ctconfig.GRPCAddr)
+	if err != nil {
+		glog.Fatalf("Couldn't listen on %s: %v", *ctconfig.GRPCAddr, err)
+	}
+
+	grpcServer := grpc.NewServer()
+	service.RegisterCertDatabaseServer(grpcServer, service.NewServer(storageDB, filter))
+
+	glog.Infof("Serving gRPC on %s", listener.Addr())
+	if err := grpcServer.Serve(listener); err != nil {
+		glog.Fatalf("gRPC server result: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509util"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var (
+	ctconfig   = config.NewCTConfig()
+	serialFlag = flag.String("serial", "", "hex serial number to look up")
+	issuerFlag = flag.String("issuer", "", "only search this issuer ID")
+	textFlag   = flag.Bool("text", true, "decode and print the certificate's fields")
+	pemFlag    = flag.Bool("pem", true, "print the stored PEM")
+)
+
+// The PEM headers Store records for the CT entry the certificate was first seen in
+var kObservationHeaders = []string{"Log", "Entry-id", "Recorded-at"}
+
+func printCertificate(aPEM []byte) {
+	block, _ := pem.Decode(aPEM)
+	if block == nil {
+		glog.Error("The stored certificate is not PEM")
+		return
+	}
+
+	observed := false
+	for _, header := range kObservationHeaders {
+		if value, ok := block.Headers[header]; ok {
+			fmt.Printf("  Observed %s: %s\n", header, value)
+			observed = true
+		}
+	}
+	if !observed {
+		fmt.Println("  No CT observations were recorded")
+	}
+
+	if *textFlag {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+			glog.Errorf("Couldn't parse the stored certificate: %v", err)
+		} else {
+			fmt.Println(x509util.CertificateToString(cert))
+		}
+	}
+
+	if *pemFlag {
+		if _, err := os.Stdout.Write(aPEM); err != nil {
+			glog.Error(err)
+		}
+	}
+}
+
+func main() {
+	ctconfig.Init()
+	ctx := context.Background()
+	storageDB, _, backend := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("ct-lookup", ctconfig)
+	defer glog.Flush()
+
+	if len(*serialFlag) == 0 {
+		glog.Error("-serial is required")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	serialBytes, err := hex.DecodeString(*serialFlag)
+	if err != nil {
+		glog.Errorf("-serial must be hex: %v", err)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	serial := storage.NewSerialFromBytes(serialBytes)
+
+	var issuer *storage.Issuer
+	if len(*issuerFlag) > 0 {
+		issuerObj := storage.NewIssuerFromString(*issuerFlag)
+		issuer = &issuerObj
+	}
+
+	buckets, err := storageDB.LocateSerial(serial, issuer)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if len(buckets) == 0 {
+		fmt.Printf("Serial %s is not known\n", serial.HexString())
+		glog.Flush()
+		os.Exit(1)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].String() < buckets[j].String()
+	})
+
+	for _, bucket := range buckets {
+		fmt.Printf("Serial %s is known in %s\n", serial.HexString(), bucket.String())
+		fmt.Printf("  Issuer DNs: %v\n", storageDB.GetIssuerMetadata(bucket.Issuer).Issuers())
+
+		pemBytes, err := backend.LoadCertificatePEM(ctx, serial, bucket.ExpDate, bucket.Issuer)
+		if err != nil {
+			fmt.Printf("  The certificate is not stored: %v\n", err)
+			continue
+		}
+		printCertificate(pemBytes)
+	}
+}
@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+)
+
+var (
+	ctconfig        = config.NewCTConfig()
+	jobFlag         = flag.String("job", "", "the job to run, one of: issuer-counts, expiration-counts")
+	threadsFlag     = flag.Int("threads", 4, "buckets to map at once")
+	resetFlag       = flag.Bool("reset", false, "discard the job's checkpoints and queue, and run it from scratch")
+	includeExpFlag  = flag.Bool("includeExpired", false, "include buckets which have already expired")
+	visibilityFlag  = flag.Duration("visibilityTimeout", 5*time.Minute, "requeue buckets not mapped within this long")
+	maxAttemptsFlag = flag.Int("maxAttempts", 5, "fail the job, until it's run again, if a bucket fails to map this many times")
+)
+
+// Counts each bucket's serials, keyed by aKey of the bucket.
+func countSerials(aKey func(*engine.MapInput) string) engine.Mapper {
+	return engine.MapperFunc(func(aInput *engine.MapInput, aEmit engine.EmitFunc) error {
+		count := 0
+		for range aInput.Serials {
+			count++
+		}
+		aEmit(aKey(aInput), strconv.Itoa(count))
+		return nil
+	})
+}
+
+var sumCounts = engine.ReducerFunc(func(aKey string, aValues []string, aEmit engine.EmitFunc) error {
+	var sum int64
+	for _, value := range aValues {
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		sum += count
+	}
+	aEmit(aKey, strconv.FormatInt(sum, 10))
+	return nil
+})
+
+var kJobs = map[string]engine.Job{
+	"issuer-counts": {
+		Mapper: countSerials(func(aInput *engine.MapInput) string {
+			return aInput.Issuer.ID()
+		}),
+		Reducer: sumCounts,
+	},
+	"expiration-counts": {
+		Mapper: countSerials(func(aInput *engine.MapInput) string {
+			return aInput.ExpDate.ID()
+		}),
+		Reducer: sumCounts,
+	},
+}
+
+func main() {
+	ctconfig.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storageDB, remoteCache, backend := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("ct-mapreduce", ctconfig)
+	defer glog.Flush()
+
+	job, ok := kJobs[*jobFlag]
+	if !ok {
+		names := make([]string, 0, len(kJobs))
+		for name := range kJobs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		glog.Errorf("Unknown job %q, choose one of %v", *jobFlag, names)
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+	job.Name = *jobFlag
+	job.Sink = engine.NewTextSink(os.Stdout)
+	if !*includeExpFlag {
+		job.NotBefore = time.Now()
+	}
+
+	runner, err := engine.NewJobRunner(storageDB, remoteCache, backend, job)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	runner.Threads = *threadsFlag
+	runner.Queue().VisibilityTimeout = *visibilityFlag
+	runner.Queue().MaxAttempts = *maxAttemptsFlag
+
+	if *resetFlag {
+		if err := runner.Reset(); err != nil {
+			glog.Fatalf("Couldn't reset job %s: %v", job.Name, err)
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		glog.Infof("Signal caught, stopping after the buckets in progress.")
+		cancel()
+	}()
+
+	if err := runner.Run(ctx); err != nil {
+		glog.Fatalf("Job %s failed: %v", job.Name, err)
+	}
+}
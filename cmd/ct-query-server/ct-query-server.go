@@ -0,0 +1,116 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var (
+	ctconfig   = config.NewCTConfig()
+	refreshAge = flag.Duration("refresh", time.Minute, "re-list the issuers and expiration dates at most this often")
+)
+
+// Listing the issuers scans every serial key, so the listing is shared by
+// requests until it's refreshAge old.
+type issuerIndex struct {
+	expDates  map[string][]storage.ExpDate
+	issuerIDs []string
+	listedAt  time.Time
+}
+
+type server struct {
+	db      storage.CertDatabase
+	logURLs []*url.URL
+	maxAge  time.Duration
+
+	indexMutex *sync.Mutex
+	index      *issuerIndex
+}
+
+func newServer(aDB storage.CertDatabase, aLogURLs []*url.URL, aMaxAge time.Duration) *server {
+	return &server{
+		db:         aDB,
+		logURLs:    aLogURLs,
+		maxAge:     aMaxAge,
+		indexMutex: &sync.Mutex{},
+		index:      nil,
+	}
+}
+
+func (s *server) issuerIndex() (*issuerIndex, error) {
+	s.indexMutex.Lock()
+	defer s.indexMutex.Unlock()
+
+	if s.index != nil && time.Since(s.index.listedAt) < s.maxAge {
+		return s.index, nil
+	}
+
+	issuerList, err := s.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &issuerIndex{
+		expDates:  make(map[string][]storage.ExpDate, len(issuerList)),
+		issuerIDs: make([]string, 0, len(issuerList)),
+		listedAt:  time.Now(),
+	}
+	for _, issuerObj := range issuerList {
+		expDates := issuerObj.ExpDates
+		sort.Slice(expDates, func(i, j int) bool {
+			return expDates[i].ExpireTime().Before(expDates[j].ExpireTime())
+		})
+		index.expDates[issuerObj.Issuer.ID()] = expDates
+		index.issuerIDs = append(index.issuerIDs, issuerObj.Issuer.ID())
+	}
+	sort.Strings(index.issuerIDs)
+
+	glog.V(1).Infof("Listed %d issuers", len(index.issuerIDs))
+	s.index = index
+	return index, nil
+}
+
+func main() {
+	ctconfig.Init()
+	ctx := context.Background()
+	storageDB, _, _ := engine.GetConfiguredStorage(ctx, ctconfig)
+	engine.PrepareTelemetry("ct-query-server", ctconfig)
+	defer glog.Flush()
+
+	logURLs := []*url.URL{}
+	if ctconfig.LogUrlList != nil && len(*ctconfig.LogUrlList) > 5 {
+		for _, part := range strings.Split(*ctconfig.LogUrlList, ",") {
+			ctLogUrl, err := url.Parse(strings.TrimSpace(part))
+			if err != nil {
+				glog.Fatalf("unable to set Certificate Log: %s", err)
+			}
+			logURLs = append(logURLs, ctLogUrl)
+		}
+	}
+
+	srv := newServer(storageDB, logURLs, *refreshAge)
+	httpServer := &http.Server{
+		Handler: srv.routes(),
+		Addr:    *ctconfig.QueryAddr,
+	}
+
+	glog.Infof("Serving queries on %s", httpServer.Addr)
+	if err := httpServer.ListenAndServe(); err != nil {
+		glog.Fatalf("HTTP server result: %v", err)
+	}
+}
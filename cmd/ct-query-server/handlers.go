@@ -0,0 +1,379 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+const (
+	kDefaultPageLimit = 1000
+	kMaxPageLimit     = 10000
+	// Serials streamed between flushes to the client
+	kFlushEvery = 1024
+)
+
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func errorf(aStatus int, aFormat string, args ...interface{}) error {
+	return &httpError{status: aStatus, message: fmt.Sprintf(aFormat, args...)}
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Wraps h so returned errors become JSON error responses.
+func (s *server) handle(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, errorf(http.StatusMethodNotAllowed, "%s is not supported", r.Method))
+			return
+		}
+		if err := h(w, r); err != nil {
+			writeError(w, err)
+		}
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if herr, ok := err.(*httpError); ok {
+		status = herr.status
+	} else {
+		glog.Errorf("Request failed: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); err != nil {
+		glog.Warningf("Couldn't write error response: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, aValue interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(aValue)
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/issuers", s.handle(s.listIssuers))
+	mux.HandleFunc("/issuers/", s.handle(s.issuer))
+	mux.HandleFunc("/serials/", s.handle(s.serial))
+	mux.HandleFunc("/logs", s.handle(s.logs))
+	return mux
+}
+
+type page struct {
+	Offset int  `json:"offset"`
+	Limit  int  `json:"limit"`
+	Total  int  `json:"total"`
+	Next   *int `json:"next,omitempty"`
+}
+
+// Reads the offset and limit query parameters, and bounds them to aTotal.
+// Returns the page and the [start, end) range of items in it.
+func parsePage(r *http.Request, aTotal int) (page, int, int, error) {
+	p := page{Offset: 0, Limit: kDefaultPageLimit, Total: aTotal}
+
+	if str := r.URL.Query().Get("offset"); str != "" {
+		offset, err := strconv.Atoi(str)
+		if err != nil || offset < 0 {
+			return p, 0, 0, errorf(http.StatusBadRequest, "Invalid offset %s", str)
+		}
+		p.Offset = offset
+	}
+	if str := r.URL.Query().Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit < 1 || limit > kMaxPageLimit {
+			return p, 0, 0, errorf(http.StatusBadRequest, "Invalid limit %s, expected 1 to %d", str,
+				kMaxPageLimit)
+		}
+		p.Limit = limit
+	}
+
+	start, end := p.Offset, p.Offset+p.Limit
+	if start > aTotal {
+		start = aTotal
+	}
+	if end >= aTotal {
+		end = aTotal
+	} else {
+		p.Next = &end
+	}
+	return p, start, end, nil
+}
+
+type issuerSummary struct {
+	Issuer   string `json:"issuer"`
+	ExpDates int    `json:"expDates"`
+}
+
+// GET /issuers
+func (s *server) listIssuers(w http.ResponseWriter, r *http.Request) error {
+	index, err := s.issuerIndex()
+	if err != nil {
+		return err
+	}
+
+	p, start, end, err := parsePage(r, len(index.issuerIDs))
+	if err != nil {
+		return err
+	}
+
+	issuers := make([]issuerSummary, 0, end-start)
+	for _, id := range index.issuerIDs[start:end] {
+		issuers = append(issuers, issuerSummary{Issuer: id, ExpDates: len(index.expDates[id])})
+	}
+	return writeJSON(w, struct {
+		page
+		Issuers []issuerSummary `json:"issuers"`
+	}{p, issuers})
+}
+
+// So empty lists are [] rather than null
+func nonNil(aList []string) []string {
+	if aList == nil {
+		return []string{}
+	}
+	return aList
+}
+
+// Dispatches the paths under /issuers/<issuer ID>/
+func (s *server) issuer(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/issuers/"), "/")
+	issuer := storage.NewIssuerFromString(parts[0])
+
+	switch {
+	case len(parts) == 2 && parts[1] == "expdates":
+		return s.listExpDates(w, r, issuer)
+	case len(parts) == 4 && parts[1] == "expdates" && parts[3] == "serials":
+		return s.streamSerials(w, r, issuer, parts[2])
+	case len(parts) == 2 && (parts[1] == "crls" || parts[1] == "dns"):
+		return s.issuerMetadata(w, issuer, parts[1])
+	}
+	return errorf(http.StatusNotFound, "No such resource %s", r.URL.Path)
+}
+
+// GET /issuers/<issuer ID>/crls and /issuers/<issuer ID>/dns
+func (s *server) issuerMetadata(w http.ResponseWriter, aIssuer storage.Issuer, aKind string) error {
+	if _, err := s.expDatesOf(aIssuer); err != nil {
+		return err
+	}
+
+	var list []string
+	var err error
+	meta := s.db.PeekIssuerMetadata(aIssuer)
+	if aKind == "crls" {
+		list, err = meta.ListCRLs()
+	} else {
+		list, err = meta.ListIssuers()
+	}
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, map[string][]string{aKind: nonNil(list)})
+}
+
+func (s *server) expDatesOf(aIssuer storage.Issuer) ([]storage.ExpDate, error) {
+	index, err := s.issuerIndex()
+	if err != nil {
+		return nil, err
+	}
+	expDates, ok := index.expDates[aIssuer.ID()]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Unknown issuer %s", aIssuer.ID())
+	}
+	return expDates, nil
+}
+
+type expDateSummary struct {
+	ExpDate string `json:"expDate"`
+	Serials int64  `json:"serials"`
+}
+
+// GET /issuers/<issuer ID>/expdates
+func (s *server) listExpDates(w http.ResponseWriter, r *http.Request, aIssuer storage.Issuer) error {
+	expDates, err := s.expDatesOf(aIssuer)
+	if err != nil {
+		return err
+	}
+
+	p, start, end, err := parsePage(r, len(expDates))
+	if err != nil {
+		return err
+	}
+
+	buckets := make([]storage.IssuerAndDate, 0, end-start)
+	for _, expDate := range expDates[start:end] {
+		buckets = append(buckets, storage.IssuerAndDate{Issuer: aIssuer, ExpDate: expDate})
+	}
+	counts, err := s.db.CountBuckets(buckets)
+	if err != nil {
+		return err
+	}
+
+	summaries := make([]expDateSummary, 0, len(buckets))
+	for i, bucket := range buckets {
+		summaries = append(summaries, expDateSummary{ExpDate: bucket.ExpDate.ID(), Serials: counts[i]})
+	}
+	return writeJSON(w, struct {
+		page
+		ExpDates []expDateSummary `json:"expDates"`
+	}{p, summaries})
+}
+
+// GET /issuers/<issuer ID>/expdates/<expDate>/serials streams the bucket's
+// serials as hex, one per line, in no particular order.
+func (s *server) streamSerials(w http.ResponseWriter, r *http.Request, aIssuer storage.Issuer,
+	aExpDate string) error {
+	expDate, err := storage.NewExpDate(aExpDate)
+	if err != nil {
+		return errorf(http.StatusBadRequest, "Invalid expiration date %s: %v", aExpDate, err)
+	}
+	expDates, err := s.expDatesOf(aIssuer)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, known := range expDates {
+		if known.ID() == expDate.ID() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errorf(http.StatusNotFound, "No serials for %s expiring %s", aIssuer.ID(), expDate.ID())
+	}
+
+	serialChan := make(chan storage.Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.db.GetKnownCertificates(expDate, aIssuer).StreamKnown(serialChan)
+	}()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, canFlush := w.(http.Flusher)
+
+	var writeErr error
+	count := 0
+	for serial := range serialChan {
+		// Once the client has gone, keep draining so StreamKnown finishes.
+		if writeErr != nil {
+			continue
+		}
+		_, writeErr = fmt.Fprintln(w, serial.HexString())
+		count++
+		if canFlush && count%kFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	if err := <-errChan; err != nil {
+		// The response has begun, so all we can do is cut it short.
+		glog.Errorf("[%s::%s] Streaming serials failed after %d: %v", expDate.ID(), aIssuer.ID(), count, err)
+		panic(http.ErrAbortHandler)
+	}
+	if writeErr != nil {
+		glog.V(1).Infof("[%s::%s] Client went away after %d serials: %v", expDate.ID(), aIssuer.ID(), count,
+			writeErr)
+	}
+	return nil
+}
+
+type bucketJSON struct {
+	Issuer  string `json:"issuer"`
+	ExpDate string `json:"expDate"`
+}
+
+// GET /serials/<hex serial>[?issuer=<issuer ID>[&expDate=<expDate>]]
+func (s *server) serial(w http.ResponseWriter, r *http.Request) error {
+	serialHex := strings.TrimPrefix(r.URL.Path, "/serials/")
+	serialBytes, err := hex.DecodeString(serialHex)
+	if err != nil || len(serialBytes) == 0 {
+		return errorf(http.StatusBadRequest, "Invalid hex serial %s", serialHex)
+	}
+	serial := storage.NewSerialFromBytes(serialBytes)
+
+	var issuer *storage.Issuer
+	if id := r.URL.Query().Get("issuer"); id != "" {
+		obj := storage.NewIssuerFromString(id)
+		issuer = &obj
+	}
+
+	var buckets []storage.IssuerAndDate
+	if str := r.URL.Query().Get("expDate"); str != "" {
+		if issuer == nil {
+			return errorf(http.StatusBadRequest, "expDate requires issuer")
+		}
+		expDate, err := storage.NewExpDate(str)
+		if err != nil {
+			return errorf(http.StatusBadRequest, "Invalid expiration date %s: %v", str, err)
+		}
+		known, err := s.db.GetKnownCertificates(expDate, *issuer).IsKnown(serial)
+		if err != nil {
+			return err
+		}
+		if known {
+			buckets = []storage.IssuerAndDate{{Issuer: *issuer, ExpDate: expDate}}
+		}
+	} else {
+		buckets, err = s.db.LocateSerial(serial, issuer)
+		if err != nil {
+			return err
+		}
+	}
+
+	result := struct {
+		Serial  string       `json:"serial"`
+		Known   bool         `json:"known"`
+		Buckets []bucketJSON `json:"buckets"`
+	}{
+		Serial:  serial.HexString(),
+		Known:   len(buckets) > 0,
+		Buckets: make([]bucketJSON, 0, len(buckets)),
+	}
+	for _, bucket := range buckets {
+		result.Buckets = append(result.Buckets, bucketJSON{Issuer: bucket.Issuer.ID(),
+			ExpDate: bucket.ExpDate.ID()})
+	}
+	return writeJSON(w, result)
+}
+
+// GET /logs[?url=<log URL>], by default the state of each log in logList
+func (s *server) logs(w http.ResponseWriter, r *http.Request) error {
+	logURLs := s.logURLs
+	if str := r.URL.Query().Get("url"); str != "" {
+		logURL, err := url.Parse(str)
+		if err != nil {
+			return errorf(http.StatusBadRequest, "Invalid log URL %s: %v", str, err)
+		}
+		logURLs = []*url.URL{logURL}
+	}
+
+	states := make([]*storage.CertificateLog, 0, len(logURLs))
+	for _, logURL := range logURLs {
+		state, err := s.db.GetLogState(logURL)
+		if err != nil {
+			return err
+		}
+		states = append(states, state)
+	}
+	return writeJSON(w, map[string][]*storage.CertificateLog{"logs": states})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+func makeCert(t *testing.T, aCN string, aSerial int64, aNotAfter time.Time, aCRLs []string) *ctx509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(aSerial),
+		Subject:               pkix.Name{CommonName: aCN},
+		NotBefore:             aNotAfter.AddDate(-1, 0, 0),
+		NotAfter:              aNotAfter,
+		CRLDistributionPoints: aCRLs,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ctx509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// Serves a database holding serials 0A and 0B expiring 2050-01-01, and 0C
+// expiring 2050-01-02, all from one issuer, whose ID is returned.
+func makeTestServer(t *testing.T) (http.Handler, string) {
+	return makeTestServerWithCache(t, storage.NewMockRemoteCache())
+}
+
+func makeTestServerWithCache(t *testing.T, cache storage.RemoteCache) (http.Handler, string) {
+	db, err := storage.NewFilesystemDatabase(storage.NewMockBackend(), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuerCert := makeCert(t, "issuer", 1, time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	crls := []string{"http://crl.example/issuer.crl"}
+	for _, c := range []struct {
+		serial int64
+		day    int
+	}{{0x0a, 1}, {0x0b, 1}, {0x0c, 2}} {
+		cert := makeCert(t, "leaf", c.serial, time.Date(2050, 1, c.day, 0, 0, 0, 0, time.UTC), crls)
+		if err := db.Store(cert, issuerCert, "log.example/2050", c.serial); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cache.StoreLogState(&storage.CertificateLog{ShortURL: "log.example/2050", MaxEntry: 12}); err != nil {
+		t.Fatal(err)
+	}
+	logURL, err := url.Parse("https://log.example/2050")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := storage.NewIssuer(issuerCert)
+	return newServer(db, []*url.URL{logURL}, time.Minute).routes(), issuer.ID()
+}
+
+func get(t *testing.T, aHandler http.Handler, aPath string, aStatus int) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	aHandler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, aPath, nil))
+	if recorder.Code != aStatus {
+		t.Errorf("GET %s: expected status %d, got %d: %s", aPath, aStatus, recorder.Code, recorder.Body)
+	}
+	return recorder
+}
+
+func decode(t *testing.T, aRecorder *httptest.ResponseRecorder, aValue interface{}) {
+	if err := json.NewDecoder(aRecorder.Body).Decode(aValue); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_ListIssuersAndExpDates(t *testing.T) {
+	handler, issuerID := makeTestServer(t)
+
+	var issuers struct {
+		page
+		Issuers []issuerSummary `json:"issuers"`
+	}
+	decode(t, get(t, handler, "/issuers", http.StatusOK), &issuers)
+	if issuers.Total != 1 || len(issuers.Issuers) != 1 || issuers.Issuers[0].Issuer != issuerID ||
+		issuers.Issuers[0].ExpDates != 2 {
+		t.Errorf("Expected the one issuer with two expiration dates, got %+v", issuers)
+	}
+
+	var expDates struct {
+		page
+		ExpDates []expDateSummary `json:"expDates"`
+	}
+	decode(t, get(t, handler, "/issuers/"+issuerID+"/expdates?limit=1", http.StatusOK), &expDates)
+	if expDates.Total != 2 || expDates.Next == nil || *expDates.Next != 1 || len(expDates.ExpDates) != 1 ||
+		expDates.ExpDates[0].ExpDate != "2050-01-01-00" || expDates.ExpDates[0].Serials != 2 {
+		t.Errorf("Expected the first page of expiration dates, got %+v", expDates)
+	}
+
+	var metadata map[string][]string
+	decode(t, get(t, handler, "/issuers/"+issuerID+"/crls", http.StatusOK), &metadata)
+	if len(metadata["crls"]) != 1 || metadata["crls"][0] != "http://crl.example/issuer.crl" {
+		t.Errorf("Expected the issuer's CRL, got %+v", metadata)
+	}
+	decode(t, get(t, handler, "/issuers/"+issuerID+"/dns", http.StatusOK), &metadata)
+	if len(metadata["dns"]) != 1 || metadata["dns"][0] != "CN=leaf" {
+		t.Errorf("Expected the issuer's DN, got %+v", metadata)
+	}
+}
+
+func Test_StreamSerials(t *testing.T) {
+	handler, issuerID := makeTestServer(t)
+
+	recorder := get(t, handler, "/issuers/"+issuerID+"/expdates/2050-01-01-00/serials", http.StatusOK)
+	serials := strings.Fields(recorder.Body.String())
+	sort.Strings(serials)
+	if len(serials) != 2 || serials[0] != "0a" || serials[1] != "0b" {
+		t.Errorf("Expected serials 0a and 0b, got %v", serials)
+	}
+}
+
+func Test_LookupSerial(t *testing.T) {
+	handler, issuerID := makeTestServer(t)
+
+	var result struct {
+		Serial  string       `json:"serial"`
+		Known   bool         `json:"known"`
+		Buckets []bucketJSON `json:"buckets"`
+	}
+	decode(t, get(t, handler, "/serials/0C", http.StatusOK), &result)
+	if !result.Known || len(result.Buckets) != 1 || result.Buckets[0].Issuer != issuerID ||
+		result.Buckets[0].ExpDate != "2050-01-02-00" {
+		t.Errorf("Expected 0c in the 2050-01-02 bucket, got %+v", result)
+	}
+
+	path := "/serials/0a?issuer=" + issuerID + "&expDate=2050-01-01-00"
+	decode(t, get(t, handler, path, http.StatusOK), &result)
+	if !result.Known || len(result.Buckets) != 1 {
+		t.Errorf("Expected 0a in the given bucket, got %+v", result)
+	}
+
+	// Unknown serials aren't errors
+	decode(t, get(t, handler, "/serials/0d", http.StatusOK), &result)
+	if result.Known || len(result.Buckets) != 0 || result.Serial != "0d" {
+		t.Errorf("Expected 0d unknown, got %+v", result)
+	}
+}
+
+func Test_LogStates(t *testing.T) {
+	handler, _ := makeTestServer(t)
+
+	var logs map[string][]*storage.CertificateLog
+	decode(t, get(t, handler, "/logs", http.StatusOK), &logs)
+	if len(logs["logs"]) != 1 || logs["logs"][0].ShortURL != "log.example/2050" || logs["logs"][0].MaxEntry != 12 {
+		t.Errorf("Expected the configured log's state, got %+v", logs)
+	}
+}
+
+func Test_HandlerErrors(t *testing.T) {
+	handler, issuerID := makeTestServer(t)
+	unknownID := storage.NewIssuerFromString("unknown")
+
+	for _, c := range []struct {
+		path   string
+		status int
+	}{
+		{"/issuers/" + unknownID.ID() + "/expdates", http.StatusNotFound},
+		{"/issuers/" + unknownID.ID() + "/expdates/2050-01-01-00/serials", http.StatusNotFound},
+		{"/issuers/" + unknownID.ID() + "/crls", http.StatusNotFound},
+		{"/issuers/" + unknownID.ID() + "/dns", http.StatusNotFound},
+		{"/issuers/" + issuerID + "/expdates/2050-01-03-00/serials", http.StatusNotFound},
+		{"/issuers/" + issuerID + "/bogus", http.StatusNotFound},
+		{"/issuers/" + issuerID + "/expdates/tomorrow/serials", http.StatusBadRequest},
+		{"/issuers?offset=-1", http.StatusBadRequest},
+		{"/issuers?limit=0", http.StatusBadRequest},
+		{"/serials/zz", http.StatusBadRequest},
+		{"/serials/", http.StatusBadRequest},
+		{"/serials/0a?expDate=2050-01-01-00", http.StatusBadRequest},
+		{"/serials/0a?issuer=" + issuerID + "&expDate=tomorrow", http.StatusBadRequest},
+		{"/logs?url=%25zz", http.StatusBadRequest},
+	} {
+		var body map[string]string
+		decode(t, get(t, handler, c.path, c.status), &body)
+		if body["error"] == "" {
+			t.Errorf("GET %s: expected an error message, got %+v", c.path, body)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/issuers", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to be refused, got %d", recorder.Code)
+	}
+}
+
+// Fails SetList while failing is set
+type failingCache struct {
+	*storage.MockRemoteCache
+	failing bool
+}
+
+func (c *failingCache) SetList(key string) ([]string, error) {
+	if c.failing {
+		return nil, fmt.Errorf("SetList failed")
+	}
+	return c.MockRemoteCache.SetList(key)
+}
+
+func Test_IssuerMetadataCacheErrors(t *testing.T) {
+	cache := &failingCache{MockRemoteCache: storage.NewMockRemoteCache()}
+	handler, issuerID := makeTestServerWithCache(t, cache)
+
+	cache.failing = true
+	for _, kind := range []string{"crls", "dns"} {
+		var body map[string]string
+		decode(t, get(t, handler, "/issuers/"+issuerID+"/"+kind, http.StatusInternalServerError), &body)
+		if body["error"] == "" {
+			t.Errorf("Expected an error message for %s, got %+v", kind, body)
+		}
+	}
+}
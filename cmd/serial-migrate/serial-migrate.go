@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var (
+	ctconfig = config.NewCTConfig()
+	fromFlag = flag.String("from", "set", "serial encoding to migrate from, set or packed")
+	toFlag   = flag.String("to", "packed", "serial encoding to migrate to, set or packed")
+)
+
+func main() {
+	ctconfig.Init()
+	_, remoteCache, backend := engine.GetConfiguredStorage(context.Background(), ctconfig)
+	engine.PrepareTelemetry("serial-migrate", ctconfig)
+	defer glog.Flush()
+
+	from, err := storage.ParseSerialEncoding(*fromFlag)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	to, err := storage.ParseSerialEncoding(*toFlag)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if from == to {
+		glog.Error("Nothing to do, -from and -to are the same encoding")
+		ctconfig.Usage()
+		os.Exit(2)
+	}
+
+	sourceDB, err := storage.NewFilesystemDatabase(backend, remoteCache)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	sourceDB.SetSerialEncoding(from)
+
+	issuerList, err := sourceDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	bucketChan := make(chan storage.IssuerAndDate, 1024)
+	go func() {
+		defer close(bucketChan)
+		for _, issuerObj := range issuerList {
+			for _, expDate := range issuerObj.ExpDates {
+				bucketChan <- storage.IssuerAndDate{
+					ExpDate: expDate,
+					Issuer:  issuerObj.Issuer,
+				}
+			}
+		}
+	}()
+
+	var totalSerials, totalBuckets, failedBuckets int64
+	wg := sync.WaitGroup{}
+	for t := 0; t < *ctconfig.NumThreads; t++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bucket := range bucketChan {
+				count, err := storage.MigrateSerialEncoding(remoteCache, bucket, from, to)
+				if err != nil {
+					glog.Errorf("[%s] Migration failed after %d serials: %v", bucket.String(), count, err)
+					atomic.AddInt64(&failedBuckets, 1)
+					continue
+				}
+				glog.V(1).Infof("[%s] Migrated %d serials", bucket.String(), count)
+				atomic.AddInt64(&totalSerials, count)
+				atomic.AddInt64(&totalBuckets, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	glog.Infof("overall totals: migrated %d serials in %d buckets from %s to %s, %d buckets failed",
+		totalSerials, totalBuckets, from, to, failedBuckets)
+	if failedBuckets > 0 {
+		glog.Flush()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var writers = map[string]func(io.Writer, *storage.StatisticsReport) error{
+	"json": writeJSON,
+	"csv":  writeCSV,
+	"text": writeText,
+}
+
+func writeJSON(w io.Writer, aReport *storage.StatisticsReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(aReport)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// One row per bucket, issuer, overall total and log, distinguished by the
+// first column. Columns which don't apply to a row are empty.
+var csvHeader = []string{"kind", "issuer", "expDate", "issuers", "expDates", "serials", "crls", "crlURLs",
+	"issuerDNs", "certificates", "precertificates", "mustStaple", "firstIssued", "lastIssued", "log", "maxEntry",
+	"lastEntryTime", "lastUpdateTime"}
+
+func statisticsColumns(aStats *storage.IssuerStatistics) []string {
+	if aStats == nil {
+		return []string{"", "", "", "", ""}
+	}
+	return []string{
+		strconv.FormatInt(aStats.Certificates, 10),
+		strconv.FormatInt(aStats.Precertificates, 10),
+		strconv.FormatInt(aStats.MustStaple, 10),
+		formatTime(aStats.FirstIssued),
+		formatTime(aStats.LastIssued),
+	}
+}
+
+func writeCSV(w io.Writer, aReport *storage.StatisticsReport) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	noLog := []string{"", "", "", ""}
+	for _, issuer := range aReport.Issuers {
+		for _, expDate := range issuer.ExpDates {
+			row := []string{"bucket", issuer.Issuer, expDate.ExpDate, "", "",
+				strconv.FormatInt(expDate.Serials, 10), "", "", ""}
+			row = append(row, statisticsColumns(nil)...)
+			if err := cw.Write(append(row, noLog...)); err != nil {
+				return err
+			}
+		}
+
+		row := []string{"issuer", issuer.Issuer, "", "", strconv.Itoa(len(issuer.ExpDates)),
+			strconv.FormatInt(issuer.Serials, 10), strconv.Itoa(len(issuer.CRLs)),
+			strings.Join(issuer.CRLs, " "), strings.Join(issuer.IssuerDNs, "|")}
+		row = append(row, statisticsColumns(issuer.Statistics)...)
+		if err := cw.Write(append(row, noLog...)); err != nil {
+			return err
+		}
+	}
+
+	totals := aReport.Totals
+	row := []string{"total", "", "", strconv.Itoa(totals.Issuers), strconv.Itoa(totals.ExpDates),
+		strconv.FormatInt(totals.Serials, 10), strconv.Itoa(totals.CRLs), "", ""}
+	row = append(row, statisticsColumns(totals.Statistics)...)
+	if err := cw.Write(append(row, noLog...)); err != nil {
+		return err
+	}
+
+	for _, log := range aReport.Logs {
+		row := make([]string, len(csvHeader)-4, len(csvHeader))
+		row[0] = "log"
+		row = append(row, log.ShortURL, strconv.FormatInt(log.MaxEntry, 10), formatTime(log.LastEntryTime),
+			formatTime(log.LastUpdateTime))
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeTextStatistics(w io.Writer, aStats *storage.IssuerStatistics) {
+	if aStats == nil {
+		return
+	}
+	fmt.Fprintf(w, "  %d certificates issued %s to %s, %.1f%% precertificates, %d must-staple\n",
+		aStats.Certificates, formatTime(aStats.FirstIssued), formatTime(aStats.LastIssued),
+		100*aStats.PrecertRatio(), aStats.MustStaple)
+	fmt.Fprintf(w, "  keys: %v\n", aStats.KeyTypes)
+	fmt.Fprintf(w, "  validity: %v\n", aStats.ValidityPeriods)
+	fmt.Fprintf(w, "  SANs: %v\n", aStats.SANCounts)
+}
+
+func writeText(w io.Writer, aReport *storage.StatisticsReport) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, issuer := range aReport.Issuers {
+		fmt.Fprintf(tw, "Issuer: %s (%v)\n", issuer.Issuer, issuer.IssuerDNs)
+		for _, expDate := range issuer.ExpDates {
+			fmt.Fprintf(tw, "- %s\t%d serials\n", expDate.ExpDate, expDate.Serials)
+		}
+		fmt.Fprintf(tw, "  %d expiration dates, %d serials known, %d crls known, %d issuerDNs known\n",
+			len(issuer.ExpDates), issuer.Serials, len(issuer.CRLs), len(issuer.IssuerDNs))
+		writeTextStatistics(tw, issuer.Statistics)
+	}
+
+	totals := aReport.Totals
+	fmt.Fprintf(tw, "\noverall totals: %d issuers, %d expiration dates, %d serials, %d crls\n",
+		totals.Issuers, totals.ExpDates, totals.Serials, totals.CRLs)
+	writeTextStatistics(tw, totals.Statistics)
+
+	if len(aReport.Logs) > 0 {
+		fmt.Fprintf(tw, "\nLog status:\n")
+		for _, log := range aReport.Logs {
+			fmt.Fprintln(tw, log.String())
+		}
+	}
+	return tw.Flush()
+}
@@ -6,63 +6,93 @@ package main
 
 import (
 	"context"
+	"flag"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/jcjones/ct-mapreduce/config"
 	"github.com/jcjones/ct-mapreduce/engine"
+	"github.com/jcjones/ct-mapreduce/storage"
 )
 
 var (
-	ctconfig = config.NewCTConfig()
+	ctconfig      = config.NewCTConfig()
+	format        = flag.String("format", "text", "output format to stdout: json, csv or text")
+	issuerFlag    = flag.String("issuers", "", "only report on these issuer IDs, comma delimited")
+	notBeforeFlag = flag.String("notBefore", "", "only report on buckets unexpired at this date, e.g. 2020-01-31")
+	notAfterFlag  = flag.String("notAfter", "", "only report on buckets starting before this date, e.g. 2021-01-31")
 )
 
+const kProgressPeriod = 5 * time.Second
+
+func parseDateFlag(aValue string) time.Time {
+	if len(aValue) == 0 {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", aValue)
+	if err != nil {
+		glog.Fatalf("Could not parse date %s: %v", aValue, err)
+	}
+	return t
+}
+
 func main() {
 	ctconfig.Init()
 	storageDB, _, backend := engine.GetConfiguredStorage(context.Background(), ctconfig)
 	engine.PrepareTelemetry("storage-statistics", ctconfig)
 	defer glog.Flush()
 
-	issuerList, err := storageDB.GetIssuerAndDatesFromCache()
-	if err != nil {
-		glog.Fatal(err)
+	writer, ok := writers[*format]
+	if !ok {
+		glog.Errorf("Unknown format %s", *format)
+		ctconfig.Usage()
+		os.Exit(2)
 	}
 
-	var totalSerials int64
-	var totalCRLs int
-
-	for _, issuerObj := range issuerList {
-		issuerMetadata := storageDB.GetIssuerMetadata(issuerObj.Issuer)
-
-		crlList := issuerMetadata.CRLs()
-		totalCRLs = totalCRLs + len(crlList)
-
-		issuerDNList := issuerMetadata.Issuers()
-
-		var countIssuerSerials int64
-
-		glog.Infof("Issuer: %s (%v)", issuerObj.Issuer.ID(), issuerDNList)
+	collector := storage.NewStatisticsCollector(storageDB)
+	collector.NumWorkers = *ctconfig.NumThreads
+	collector.NotBefore = parseDateFlag(*notBeforeFlag)
+	collector.NotAfter = parseDateFlag(*notAfterFlag)
+	for _, issuerID := range strings.Split(*issuerFlag, ",") {
+		if issuerID = strings.TrimSpace(issuerID); len(issuerID) > 0 {
+			collector.Issuers[issuerID] = struct{}{}
+		}
+	}
 
-		for _, expDate := range issuerObj.ExpDates {
-			knownCerts := storageDB.GetKnownCertificates(expDate, issuerObj.Issuer)
-			countSerials := knownCerts.Count()
+	startTime := time.Now()
+	lastProgress := startTime
+	collector.Progress = func(aDone int, aTotal int) {
+		if time.Since(lastProgress) >= kProgressPeriod || aDone == aTotal {
+			lastProgress = time.Now()
+			glog.Infof("Collected %d of %d issuers in %s", aDone, aTotal, time.Since(startTime))
+		}
+	}
 
-			countIssuerSerials = countIssuerSerials + countSerials
-			totalSerials = totalSerials + countSerials
+	report, err := collector.Collect()
+	if err != nil {
+		glog.Fatal(err)
+	}
 
-			glog.V(1).Infof("- %s (%d serials)", expDate.ID(), countSerials)
-			if glog.V(2) {
-				knownList := knownCerts.Known()
-				glog.Infof("  Serials: %v", knownList)
+	if glog.V(2) {
+		for _, issuerReport := range report.Issuers {
+			issuer := storage.NewIssuerFromString(issuerReport.Issuer)
+			for _, expDateReport := range issuerReport.ExpDates {
+				expDate, err := storage.NewExpDate(expDateReport.ExpDate)
+				if err != nil {
+					glog.Fatal(err)
+				}
+				knownList := storageDB.GetKnownCertificates(expDate, issuer).Known()
+				glog.Infof("[%s/%s] Serials: %v", expDate.ID(), issuer.ID(), knownList)
 
-				if glog.V(3) {
+				if glog.V(3) && *format == "text" {
 					for _, serial := range knownList {
 						glog.Infof("Certificate serial={%s} / {%s} / {%s}", serial.HexString(), serial.ID(),
 							serial.BinaryString())
 
-						pemBytes, err := backend.LoadCertificatePEM(context.TODO(), serial, expDate, issuerObj.Issuer)
+						pemBytes, err := backend.LoadCertificatePEM(context.TODO(), serial, expDate, issuer)
 						if err != nil {
 							glog.Error(err)
 						}
@@ -75,14 +105,8 @@ func main() {
 				}
 			}
 		}
-		glog.Infof(" --> %d hours, %d serials known, %d crls known, %d issuerDNs known", len(issuerObj.ExpDates),
-			countIssuerSerials, len(crlList), len(issuerDNList))
 	}
 
-	glog.Infof("overall totals: %d issuers, %d serials, %d crls", len(issuerList), totalSerials, totalCRLs)
-	glog.Infof("")
-	glog.Infof("Log status:")
-
 	if ctconfig.LogUrlList != nil && len(*ctconfig.LogUrlList) > 5 {
 		for _, part := range strings.Split(*ctconfig.LogUrlList, ",") {
 			ctLogUrl, err := url.Parse(strings.TrimSpace(part))
@@ -94,7 +118,11 @@ func main() {
 			if err != nil {
 				glog.Fatalf("unable to GetLogState: %s %v", ctLogUrl, err)
 			}
-			glog.Info(state.String())
+			report.Logs = append(report.Logs, state)
 		}
 	}
+
+	if err := writer(os.Stdout, report); err != nil {
+		glog.Fatal(err)
+	}
 }
@@ -36,6 +36,19 @@ type CTConfig struct {
 	StatsDHost          *string
 	StatsDPort          *int
 	HealthAddr          *string
+	ChangeFeedMaxLen    *int
+	SerialEncoding      *string
+	SerialFilterRate    *float64
+	GCPeriod            *string
+	GCGracePeriod       *string
+	LogStateRetention   *string
+	BucketGranularity   *string
+	QueryAddr           *string
+	GRPCAddr            *string
+	FleetName           *string
+	CRLPath             *string
+	EnrollAllow         *string
+	EnrollDeny          *string
 }
 
 func confInt(p *int, section *ini.Section, key string, def int) {
@@ -108,6 +121,28 @@ func confBool(p *bool, section *ini.Section, key string, def bool) {
 
 }
 
+func confFloat64(p *float64, section *ini.Section, key string, def float64) {
+	val, ok := os.LookupEnv(key)
+	if ok {
+		f, err := strconv.ParseFloat(val, 64)
+		if err == nil {
+			*p = f
+			return
+		}
+	}
+
+	*p = def
+	if section != nil {
+		k := section.Key(key)
+		if k != nil {
+			v, err := k.Float64()
+			if err == nil {
+				*p = v
+			}
+		}
+	}
+}
+
 func confString(p *string, section *ini.Section, key string, def string) {
 	*p = def
 	if section != nil {
@@ -143,6 +178,19 @@ func NewCTConfig() *CTConfig {
 		StatsRefreshPeriod:  new(string),
 		PollingDelayMean:    new(string),
 		PollingDelayStdDev:  new(int),
+		ChangeFeedMaxLen:    new(int),
+		SerialEncoding:      new(string),
+		SerialFilterRate:    new(float64),
+		GCPeriod:            new(string),
+		GCGracePeriod:       new(string),
+		LogStateRetention:   new(string),
+		BucketGranularity:   new(string),
+		QueryAddr:           new(string),
+		GRPCAddr:            new(string),
+		FleetName:           new(string),
+		CRLPath:             new(string),
+		EnrollAllow:         new(string),
+		EnrollDeny:          new(string),
 	}
 }
 
@@ -200,6 +248,19 @@ func (c *CTConfig) Init() {
 	confString(c.StatsDHost, section, "statsdHost", "")
 	confInt(c.StatsDPort, section, "statsdPort", 0)
 	confString(c.HealthAddr, section, "healthAddr", ":8080")
+	confInt(c.ChangeFeedMaxLen, section, "changeFeedMaxLen", 0)
+	confString(c.SerialEncoding, section, "serialEncoding", "set")
+	confFloat64(c.SerialFilterRate, section, "serialFilterRate", 0)
+	confString(c.GCPeriod, section, "gcPeriod", "")
+	confString(c.GCGracePeriod, section, "gcGracePeriod", "24h")
+	confString(c.LogStateRetention, section, "logStateRetention", "")
+	confString(c.BucketGranularity, section, "bucketGranularity", "hour")
+	confString(c.QueryAddr, section, "queryAddr", ":8081")
+	confString(c.GRPCAddr, section, "grpcAddr", ":8082")
+	confString(c.FleetName, section, "fleetName", "")
+	confString(c.CRLPath, section, "crlPath", "")
+	confString(c.EnrollAllow, section, "enrollAllow", "")
+	confString(c.EnrollDeny, section, "enrollDeny", "")
 
 	// Finally, CLI flags override
 	if flagOffset > 0 {
@@ -241,4 +302,17 @@ func (c *CTConfig) Usage() {
 	fmt.Println("statsdPort = port for StatsD information")
 	fmt.Println("redisTimeout = Timeout for operations from Redis, e.g. 10s")
 	fmt.Println("healthAddr = Address to host the /health information http endpoint, e.g. localhost:8080")
+	fmt.Println("serialEncoding = How serials are kept in Redis, set or packed")
+	fmt.Println("serialFilterRate = False positive rate of an in-process filter of known serials, which spares the change feed lookups of new ones, e.g. 1e-9, 0 to disable")
+	fmt.Println("changeFeedMaxLen = Publish newly-known certificates to a change feed of about this many entries, 0 to disable")
+	fmt.Println("gcPeriod = Remove expired data this often while running forever, e.g. 24h, empty to disable")
+	fmt.Println("gcGracePeriod = Keep expired data this long past its expiration, e.g. 24h")
+	fmt.Println("logStateRetention = Remove the state of logs not updated in this long, e.g. 2160h, empty to keep")
+	fmt.Println("bucketGranularity = Group certificates expiring within the same hour, day or week")
+	fmt.Println("queryAddr = Address for ct-query-server to serve its HTTP API on, e.g. localhost:8081")
+	fmt.Println("grpcAddr = Address for ct-grpc-server to serve the CertDatabase gRPC service on, e.g. localhost:8082")
+	fmt.Println("fleetName = Share logList among all ct-fetch instances running forever with this name, empty to fetch every log")
+	fmt.Println("crlPath = Path under which crl-fetch keeps the CRLs and issuer certificates it downloads")
+	fmt.Println("enrollAllow = Issuer IDs to enroll, comma delimited, empty to enroll every issuer that can be")
+	fmt.Println("enrollDeny = Issuer IDs never to enroll, comma delimited")
 }
@@ -31,6 +31,12 @@ func Test_Defaults(t *testing.T) {
 		t.Errorf("Expected default of false")
 	}
 
+	var f float64
+	confFloat64(&f, section, "var", 0.25)
+	if f != 0.25 {
+		t.Errorf("Expected the default of 0.25, got %f", f)
+	}
+
 	var s string
 	confString(&s, section, "var", "hotdog")
 	if s != "hotdog" {
@@ -68,6 +74,14 @@ func Test_SectionOverride(t *testing.T) {
 		t.Error("Expected false")
 	}
 
+	_, _ = section.NewKey("float", "1e-9")
+
+	var f float64
+	confFloat64(&f, section, "float", 0.5)
+	if f != 1e-9 {
+		t.Errorf("Expected the config value of 1e-9, got %v", f)
+	}
+
 	_, _ = section.NewKey("string", "sandwich")
 
 	var s string
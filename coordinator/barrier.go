@@ -0,0 +1,212 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+const kBarrierArrivedKey string = "barrier-arrived-"
+const kBarrierOutcomeKey string = "barrier-outcome-"
+const kBarrierWakeKey string = "barrier-wake-"
+const kBarrierWokenKey string = "barrier-woken-"
+
+const kBarrierReleased string = "released"
+const kBarrierFailedPrefix string = "failed:"
+
+var ErrBarrierTimeout = fmt.Errorf("Timed out waiting at barrier")
+
+// Returned by Await when any participant failed the barrier, or timed out.
+type BarrierFailedError struct {
+	Reason string
+}
+
+func (e *BarrierFailedError) Error() string {
+	return fmt.Sprintf("Barrier failed: %s", e.Reason)
+}
+
+// A Barrier holds each participant in Await until the expected number have
+// arrived, or any of them fails it. Barriers are single use, so a multi-phase
+// job names one per phase, e.g. "job-enumerated", "job-aggregated".
+//
+// The participant completing the barrier, or failing it, records the outcome
+// and pushes a wake-up onto each waiting participant's list, on which they're
+// blocked popping.
+type Barrier struct {
+	cache        storage.RemoteCache
+	name         string
+	identifier   string
+	participants int
+
+	// How long Await waits before failing the barrier for everyone
+	Timeout time.Duration
+	// How long the barrier's keys outlive its last use
+	KeyLife time.Duration
+	// The longest single blocking pop, bounding how late Await notices ctx
+	// is done
+	WaitSlice time.Duration
+}
+
+func NewBarrier(aCache storage.RemoteCache, aName string, aParticipants int) (*Barrier, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	randomSource := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return &Barrier{
+		cache:        aCache,
+		name:         aName,
+		identifier:   fmt.Sprintf("%s-%X", hostname, randomSource.Int63()),
+		participants: aParticipants,
+		Timeout:      time.Hour,
+		KeyLife:      24 * time.Hour,
+		WaitSlice:    5 * time.Second,
+	}, nil
+}
+
+func (b *Barrier) Identifier() string {
+	return b.identifier
+}
+
+func (b *Barrier) arrivedKey() string {
+	return kBarrierArrivedKey + b.name
+}
+
+func (b *Barrier) outcomeKey() string {
+	return kBarrierOutcomeKey + b.name
+}
+
+func (b *Barrier) wakeKey(aParticipant string) string {
+	return kBarrierWakeKey + b.name + "-" + aParticipant
+}
+
+func (b *Barrier) wokenKey(aParticipant string) string {
+	return kBarrierWokenKey + b.name + "-" + aParticipant
+}
+
+func outcomeError(aOutcome string) error {
+	if aOutcome == kBarrierReleased {
+		return nil
+	}
+	return &BarrierFailedError{Reason: strings.TrimPrefix(aOutcome, kBarrierFailedPrefix)}
+}
+
+// Records aOutcome unless the barrier already has one, then wakes everyone
+// who's arrived. Returns the outcome which stands.
+func (b *Barrier) settle(aOutcome string) (string, error) {
+	outcome, err := b.cache.TrySet(b.outcomeKey(), aOutcome, b.KeyLife)
+	if err != nil {
+		return "", err
+	}
+	if outcome != aOutcome {
+		// Someone else settled it, and wakes everyone.
+		return outcome, nil
+	}
+
+	arrived, err := b.cache.SetList(b.arrivedKey())
+	if err != nil {
+		return outcome, err
+	}
+	for _, participant := range arrived {
+		if participant == b.identifier {
+			continue
+		}
+		if _, err := b.cache.Queue(b.wakeKey(participant), outcome); err != nil {
+			return outcome, err
+		}
+		if err := b.cache.ExpireIn(b.wakeKey(participant), b.KeyLife); err != nil {
+			return outcome, err
+		}
+	}
+	return outcome, nil
+}
+
+// Fails the barrier for every participant, present or yet to arrive.
+func (b *Barrier) Fail(aReason string) error {
+	glog.Warningf("[%s] Failing barrier: %s", b.name, aReason)
+	_, err := b.settle(kBarrierFailedPrefix + aReason)
+	return err
+}
+
+// Arrives at the barrier and waits for the rest. Returns a
+// *BarrierFailedError if the barrier is failed, ErrBarrierTimeout if it
+// timed out waiting, failing the barrier for the rest, or ctx's error.
+func (b *Barrier) Await(ctx context.Context) error {
+	deadline := time.Now().Add(b.Timeout)
+
+	if _, err := b.cache.SetInsert(b.arrivedKey(), b.identifier); err != nil {
+		return err
+	}
+	if err := b.cache.ExpireIn(b.arrivedKey(), b.KeyLife); err != nil {
+		return err
+	}
+
+	// Checked after arriving: anyone settling it later will wake us.
+	outcome, err := b.cache.Get(b.outcomeKey())
+	if err != nil {
+		return err
+	}
+	if len(outcome) > 0 {
+		return outcomeError(outcome)
+	}
+
+	count, err := b.cache.SetCardinality(b.arrivedKey())
+	if err != nil {
+		return err
+	}
+	glog.V(1).Infof("[%s] %d of %d participants have arrived", b.name, count, b.participants)
+	if count >= b.participants {
+		outcome, err := b.settle(kBarrierReleased)
+		if err != nil {
+			return err
+		}
+		return outcomeError(outcome)
+	}
+
+	defer func() {
+		if err := b.cache.Delete(b.wokenKey(b.identifier)); err != nil {
+			glog.Warningf("[%s] Couldn't clean up: %v", b.name, err)
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			timedOut := fmt.Sprintf("%s%s timed out after %v", kBarrierFailedPrefix, b.identifier, b.Timeout)
+			outcome, err := b.settle(timedOut)
+			if err != nil {
+				return err
+			}
+			if outcome == timedOut {
+				return ErrBarrierTimeout
+			}
+			return outcomeError(outcome)
+		}
+		if remaining > b.WaitSlice {
+			remaining = b.WaitSlice
+		}
+
+		outcome, err := b.cache.BlockingPopCopy(b.wakeKey(b.identifier), b.wokenKey(b.identifier), remaining)
+		if err != nil {
+			if err.Error() == storage.EMPTY_QUEUE {
+				continue
+			}
+			return err
+		}
+		return outcomeError(outcome)
+	}
+}
@@ -0,0 +1,124 @@
+package coordinator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+func newTestBarrier(t *testing.T, r storage.RemoteCache, name string, participants int) *Barrier {
+	b, err := NewBarrier(r, name, participants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Timeout = time.Second
+	b.WaitSlice = 50 * time.Millisecond
+	return b
+}
+
+// Awaits each barrier in its own goroutine, returning their results.
+func awaitAll(barriers ...*Barrier) <-chan error {
+	results := make(chan error, len(barriers))
+	for _, b := range barriers {
+		go func(b *Barrier) {
+			results <- b.Await(context.Background())
+		}(b)
+	}
+	return results
+}
+
+func Test_BarrierReleases(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+	name := "Test_BarrierReleases"
+
+	first := newTestBarrier(t, r, name, 3)
+	second := newTestBarrier(t, r, name, 3)
+	results := awaitAll(first, second)
+
+	select {
+	case err := <-results:
+		t.Fatalf("Shouldn't be released before everyone arrives: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	last := awaitAll(newTestBarrier(t, r, name, 3))
+	for i := 0; i < 3; i++ {
+		var err error
+		select {
+		case err = <-results:
+		case err = <-last:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected everyone released")
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := newTestBarrier(t, r, name, 3).Await(context.Background()); err != nil {
+		t.Errorf("Arriving after release should pass straight through: %v", err)
+	}
+}
+
+func Test_BarrierFailurePropagates(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+	name := "Test_BarrierFailurePropagates"
+
+	waiting := newTestBarrier(t, r, name, 3)
+	results := awaitAll(waiting)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := newTestBarrier(t, r, name, 3).Fail("enumeration failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := <-results
+	failed, ok := err.(*BarrierFailedError)
+	if !ok || failed.Reason != "enumeration failed" {
+		t.Errorf("Expected the failure to propagate, got %v", err)
+	}
+
+	err = newTestBarrier(t, r, name, 3).Await(context.Background())
+	if _, ok := err.(*BarrierFailedError); !ok {
+		t.Errorf("Arriving after failure should fail, got %v", err)
+	}
+}
+
+func Test_BarrierTimeout(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+	name := "Test_BarrierTimeout"
+
+	impatient := newTestBarrier(t, r, name, 3)
+	impatient.Timeout = 100 * time.Millisecond
+	patient := newTestBarrier(t, r, name, 3)
+
+	patientResult := awaitAll(patient)
+	if err := impatient.Await(context.Background()); err != ErrBarrierTimeout {
+		t.Errorf("Expected ErrBarrierTimeout, got %v", err)
+	}
+
+	err := <-patientResult
+	if _, ok := err.(*BarrierFailedError); !ok {
+		t.Errorf("Expected the timeout to fail the barrier for everyone, got %v", err)
+	}
+}
+
+func Test_BarrierContextCancel(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := newTestBarrier(t, r, "Test_BarrierContextCancel", 2)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if err := b.Await(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
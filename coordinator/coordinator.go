@@ -5,9 +5,11 @@
 package coordinator
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -15,13 +17,24 @@ import (
 )
 
 const kLeaderKey string = "leader-"
+const kLeaderTokenKey string = "leader-token-"
 const kStartedKey string = "started-"
 
+// A Coordinator elects one leader among the processes sharing its name. The
+// leader holds a lease, renewing it every RenewalPeriod for KeyLifeRenewal,
+// and each new leader gets a fencing token greater than any before it, which
+// it can pass along so stale leaders' work can be told apart.
 type Coordinator struct {
 	cache            storage.RemoteCache
 	name             string
 	isLeader         bool
 	identifier       string
+	token            int64
+	started          bool
+	lost             chan struct{}
+	cancel           context.CancelFunc
+	resignErr        error
+	mutex            *sync.Mutex
 	KeyLifeInitial   time.Duration
 	KeyLifeRenewal   time.Duration
 	RenewalPeriod    time.Duration
@@ -34,6 +47,7 @@ func NewCoordinator(cache storage.RemoteCache, name string) Coordinator {
 		name:             name,
 		isLeader:         false,
 		identifier:       "",
+		mutex:            &sync.Mutex{},
 		KeyLifeInitial:   5 * time.Minute,
 		KeyLifeRenewal:   2 * time.Minute,
 		RenewalPeriod:    time.Minute,
@@ -42,8 +56,20 @@ func NewCoordinator(cache storage.RemoteCache, name string) Coordinator {
 }
 
 func (c *Coordinator) AwaitLeader() (bool, error) {
+	return c.AwaitLeaderContext(context.Background())
+}
+
+// Tries once to become leader. If we do, we lead until ctx is done, Resign is
+// called, or a renewal finds the lease lost; then the Lost channel closes.
+func (c *Coordinator) AwaitLeaderContext(ctx context.Context) (bool, error) {
 	glog.Infof("Awaiting leader")
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.isLeader {
+		return true, nil
+	}
+
 	randomSource := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	hostname, err := os.Hostname()
@@ -53,47 +79,154 @@ func (c *Coordinator) AwaitLeader() (bool, error) {
 	ourIdentifier := fmt.Sprintf("%s-%X", hostname, randomSource.Int63())
 	glog.V(1).Infof("Our identifier is %s", ourIdentifier)
 
-	leaderKey := kLeaderKey + c.name
-	result, err := c.cache.TrySet(leaderKey, ourIdentifier, c.KeyLifeInitial)
+	acquired := time.Now()
+	result, token, err := c.cache.LeaseAcquire(kLeaderKey+c.name, kLeaderTokenKey+c.name, ourIdentifier,
+		c.KeyLifeInitial)
 	if err != nil {
 		return false, err
 	}
 
 	c.identifier = result
+	c.token = token
 	c.isLeader = c.identifier == ourIdentifier
 
 	if c.isLeader {
-		glog.Infof("We've been elected leader, our name is %s", c.identifier)
+		glog.Infof("We've been elected leader, our name is %s, our token is %d", c.identifier, c.token)
 		started, err := c.cache.Exists(kStartedKey + c.identifier)
-		if err != nil && started {
+		if err != nil {
+			c.isLeader = false
+			return false, err
+		}
+		if started {
 			glog.Fatalf("Apparently already started, but we're the leader. Aborting.")
 		}
-		go func() {
-			for {
-				err := c.cache.ExpireIn(leaderKey, c.KeyLifeRenewal)
-				if err != nil {
-					glog.Warningf("Failed to update our leadership expiration: %s", err)
-				}
-				time.Sleep(c.RenewalPeriod)
-				glog.V(1).Infof("Re-announcing our leadership.")
-			}
-		}()
+
+		if c.cancel != nil {
+			c.cancel()
+		}
+		leadCtx, cancel := context.WithCancel(ctx)
+		c.cancel = cancel
+		c.started = false
+		c.resignErr = nil
+		c.lost = make(chan struct{})
+		go c.renew(leadCtx, c.identifier, c.lost, acquired.Add(c.KeyLifeInitial))
 	}
 
 	glog.V(1).Infof("Leader=%v Identifier=%s", c.isLeader, c.identifier)
 	return c.isLeader, nil
 }
 
-func (c Coordinator) AwaitStart() error {
-	if len(c.identifier) == 0 {
+// Renews our leadership, and our start if sent, until ctx is done or the lease
+// is lost. If renewals fail, the lease is assumed lost once it would have
+// expired.
+func (c *Coordinator) renew(ctx context.Context, aIdentifier string, aLost chan<- struct{},
+	aExpires time.Time) {
+	defer close(aLost)
+
+	leaderKey := kLeaderKey + c.name
+	ticker := time.NewTicker(c.RenewalPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_, err := c.cache.LeaseRelease(leaderKey, aIdentifier)
+			if err != nil {
+				glog.Warningf("Failed to give up our leadership: %s", err)
+			}
+			c.mutex.Lock()
+			c.isLeader = false
+			c.resignErr = err
+			c.mutex.Unlock()
+			glog.Infof("Resigned leadership.")
+			return
+		case <-ticker.C:
+		}
+
+		glog.V(1).Infof("Re-announcing our leadership.")
+		attempted := time.Now()
+		held, err := c.cache.LeaseRenew(leaderKey, aIdentifier, c.KeyLifeRenewal)
+		if err != nil {
+			glog.Warningf("Failed to update our leadership expiration: %s", err)
+			if time.Now().Before(aExpires) {
+				continue
+			}
+			held = false
+		}
+		if !held {
+			glog.Warningf("Lost our leadership.")
+			c.mutex.Lock()
+			c.isLeader = false
+			c.mutex.Unlock()
+			return
+		}
+		aExpires = attempted.Add(c.KeyLifeRenewal)
+
+		c.mutex.Lock()
+		started := c.started
+		c.mutex.Unlock()
+		if started {
+			glog.V(1).Infof("Re-announcing our start.")
+			_, err := c.cache.LeaseRenew(kStartedKey+aIdentifier, aIdentifier, c.KeyLifeRenewal)
+			if err != nil {
+				glog.Warningf("Failed to update our start expiration: %s", err)
+			}
+		}
+	}
+}
+
+func (c *Coordinator) IsLeader() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.isLeader
+}
+
+// The fencing token of the current leader, as of AwaitLeader.
+func (c *Coordinator) Token() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.token
+}
+
+// Closes when we stop leading, for any reason. Nil unless we've led.
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lost
+}
+
+// Stops leading, releasing the lease so another can be elected at once. Does
+// nothing if we aren't leader.
+func (c *Coordinator) Resign() error {
+	c.mutex.Lock()
+	cancel, lost := c.cancel, c.lost
+	c.mutex.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	<-lost
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.resignErr
+}
+
+func (c *Coordinator) AwaitStart() error {
+	c.mutex.Lock()
+	identifier, isLeader := c.identifier, c.isLeader
+	c.mutex.Unlock()
+
+	if len(identifier) == 0 {
 		return fmt.Errorf("Must not call before AwaitLeader completes")
 	}
-	if c.isLeader {
+	if isLeader {
 		return fmt.Errorf("Must not call unless we're a follower")
 	}
 
 	for {
-		started, err := c.cache.Exists(kStartedKey + c.identifier)
+		started, err := c.cache.Exists(kStartedKey + identifier)
 		if err != nil {
 			return err
 		}
@@ -105,7 +238,12 @@ func (c Coordinator) AwaitStart() error {
 	}
 }
 
-func (c Coordinator) SendStart() error {
+// Signals followers to start. The start is renewed along with our leadership,
+// and lapses once we stop leading.
+func (c *Coordinator) SendStart() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if len(c.identifier) == 0 {
 		return fmt.Errorf("Must not call before AwaitLeader completes")
 	}
@@ -121,17 +259,7 @@ func (c Coordinator) SendStart() error {
 	if result != c.identifier {
 		glog.Fatalf("Redis error: TrySet should have succeeded, put %s got %s", c.identifier, result)
 	}
-
-	go func() {
-		for {
-			err := c.cache.ExpireIn(startedKey, c.KeyLifeRenewal)
-			if err != nil {
-				glog.Warningf("Failed to update our start expiration: %s", err)
-			}
-			time.Sleep(c.RenewalPeriod)
-			glog.V(1).Infof("Re-announcing our start.")
-		}
-	}()
+	c.started = true
 
 	glog.Infof("Sent start.")
 	return nil
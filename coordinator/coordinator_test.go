@@ -1,6 +1,7 @@
 package coordinator
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
@@ -11,26 +12,24 @@ import (
 
 var kRedisHost = "RedisHost"
 
-func getRedisCache(tb testing.TB) (*storage.RedisCache, bool) {
+// Returns the Redis instance at RedisHost if it's set, and otherwise a
+// MockRemoteCache.
+func getRemoteCache(tb testing.TB) storage.RemoteCache {
 	setting, ok := os.LookupEnv(kRedisHost)
 	if !ok {
-		tb.Skipf("%s is not set, unable to run %s. Skipping.", kRedisHost, tb.Name())
-		return nil, true
+		return storage.NewMockRemoteCache()
 	}
 	tb.Logf("Connecting to Redis instance at %s", setting)
 
 	rc, err := storage.NewRedisCache(setting, time.Second)
 	if err != nil {
-		tb.Errorf("Couldn't construct RedisCache: %v", err)
+		tb.Fatalf("Couldn't construct RedisCache: %v", err)
 	}
-	return rc, false
+	return rc
 }
 
 func Test_LeaderElectionSolo(t *testing.T) {
-	r, exit := getRedisCache(t)
-	if exit {
-		return
-	}
+	r := getRemoteCache(t)
 	c := NewCoordinator(r, "Test_LeaderElection")
 	c.KeyLifeInitial = time.Second
 	c.KeyLifeRenewal = time.Second
@@ -44,7 +43,7 @@ func Test_LeaderElectionSolo(t *testing.T) {
 	}
 }
 
-func tryLeaderElection(t *testing.T, r *storage.RedisCache, name string,
+func tryLeaderElection(t *testing.T, r storage.RemoteCache, name string,
 	resultChan chan<- bool) {
 	c := NewCoordinator(r, name)
 	c.KeyLifeInitial = time.Second
@@ -61,10 +60,7 @@ func tryLeaderElection(t *testing.T, r *storage.RedisCache, name string,
 func Test_LeaderElectionPair(t *testing.T) {
 	t.Parallel()
 	c := make(chan bool)
-	r, exit := getRedisCache(t)
-	if exit {
-		return
-	}
+	r := getRemoteCache(t)
 
 	go tryLeaderElection(t, r, "Test_LeaderElectionPair", c)
 	go tryLeaderElection(t, r, "Test_LeaderElectionPair", c)
@@ -79,10 +75,7 @@ func Test_LeaderElectionPair(t *testing.T) {
 
 func Test_LeaderElectionFourty(t *testing.T) {
 	t.Parallel()
-	r, exit := getRedisCache(t)
-	if exit {
-		return
-	}
+	r := getRemoteCache(t)
 	c := make(chan bool)
 
 	max := 40
@@ -105,10 +98,7 @@ func Test_LeaderElectionFourty(t *testing.T) {
 
 func Test_StartPreconditions(t *testing.T) {
 	t.Parallel()
-	r, exit := getRedisCache(t)
-	if exit {
-		return
-	}
+	r := getRemoteCache(t)
 	c := NewCoordinator(r, "Test_StartPreconditions")
 
 	err := c.AwaitStart()
@@ -136,10 +126,7 @@ func Test_StartPreconditions(t *testing.T) {
 
 func Test_Start(t *testing.T) {
 	t.Parallel()
-	r, exit := getRedisCache(t)
-	if exit {
-		return
-	}
+	r := getRemoteCache(t)
 
 	wg := sync.WaitGroup{}
 
@@ -179,10 +166,7 @@ func Test_Start(t *testing.T) {
 func Test_LeaderExtension(t *testing.T) {
 	t.Parallel()
 
-	r, exit := getRedisCache(t)
-	if exit {
-		return
-	}
+	r := getRemoteCache(t)
 	c := NewCoordinator(r, "Test_LeaderExtension")
 	c.KeyLifeInitial = time.Second
 	c.KeyLifeRenewal = time.Second
@@ -218,3 +202,177 @@ func Test_LeaderExtension(t *testing.T) {
 		t.Error("Expected started to still exist")
 	}
 }
+
+func Test_LeaderFencingTokens(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+
+	a := NewCoordinator(r, "Test_LeaderFencingTokens")
+	lead, err := a.AwaitLeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lead {
+		t.Fatal("Should have trivially been the leader")
+	}
+	first := a.Token()
+
+	b := NewCoordinator(r, "Test_LeaderFencingTokens")
+	lead, err = b.AwaitLeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lead {
+		t.Error("Should not have been elected while a leads")
+	}
+	if b.Token() != first {
+		t.Errorf("Followers should see the leader's token %d, got %d", first, b.Token())
+	}
+	if err := b.Resign(); err != nil {
+		t.Errorf("Resigning as a follower should do nothing: %v", err)
+	}
+
+	if err := a.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	if a.IsLeader() {
+		t.Error("Should no longer lead after resigning")
+	}
+	select {
+	case <-a.Lost():
+	default:
+		t.Error("Resigning should close the Lost channel")
+	}
+
+	lead, err = b.AwaitLeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lead {
+		t.Fatal("Should have been elected once a resigned")
+	}
+	if b.Token() <= first {
+		t.Errorf("Expected a token greater than %d, got %d", first, b.Token())
+	}
+	if err := b.Resign(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_LeaderLost(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+
+	c := NewCoordinator(r, "Test_LeaderLost")
+	c.KeyLifeInitial = time.Second
+	c.KeyLifeRenewal = time.Second
+	c.RenewalPeriod = 50 * time.Millisecond
+
+	lead, err := c.AwaitLeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lead {
+		t.Fatal("Should have trivially been the leader")
+	}
+
+	// Someone else takes the lease, as if ours had lapsed
+	if err := r.Delete(kLeaderKey + c.name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.TrySet(kLeaderKey+c.name, "usurper", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-c.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Should have noticed the lease was lost")
+	}
+	if c.IsLeader() {
+		t.Error("Should no longer lead")
+	}
+
+	if err := c.Resign(); err != nil {
+		t.Error(err)
+	}
+	holder, _, err := r.LeaseAcquire(kLeaderKey+c.name, kLeaderTokenKey+c.name, "other", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "usurper" {
+		t.Errorf("Resigning a lost lease mustn't release someone else's, holder is %s", holder)
+	}
+}
+
+func Test_LeaderContextCancel(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := NewCoordinator(r, "Test_LeaderContextCancel")
+	lead, err := c.AwaitLeaderContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lead {
+		t.Fatal("Should have trivially been the leader")
+	}
+	cancel()
+
+	select {
+	case <-c.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Cancelling should end our leadership")
+	}
+	leader, err := r.Exists(kLeaderKey + c.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader {
+		t.Error("Cancelling should release the lease")
+	}
+}
+
+func Test_StartRenewalStops(t *testing.T) {
+	t.Parallel()
+	r := getRemoteCache(t)
+
+	c := NewCoordinator(r, "Test_StartRenewalStops")
+	c.KeyLifeInitial = 200 * time.Millisecond
+	c.KeyLifeRenewal = 200 * time.Millisecond
+	c.RenewalPeriod = 50 * time.Millisecond
+
+	lead, err := c.AwaitLeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lead {
+		t.Fatal("Should have trivially been the leader")
+	}
+	if err := c.SendStart(); err != nil {
+		t.Fatal(err)
+	}
+	identifier := c.identifier
+
+	time.Sleep(400 * time.Millisecond)
+	started, err := r.Exists(kStartedKey + identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !started {
+		t.Error("Expected start to be renewed while leading")
+	}
+
+	if err := c.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	started, err = r.Exists(kStartedKey + identifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started {
+		t.Error("Expected start to lapse after resigning")
+	}
+}
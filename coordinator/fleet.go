@@ -0,0 +1,393 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package coordinator
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+const kFleetMembersKey string = "fleet-members-"
+const kFleetAliveKey string = "fleet-alive-"
+const kFleetWorkKey string = "fleet-work-"
+const kFleetOwnedKey string = "fleet-owned-"
+
+// A Fleet shares a fixed set of work items, such as CT log URLs, among the
+// processes which join it under the same name. Each item is owned by at most
+// one member at a time, as it moves atomically between the fleet's queue of
+// unowned work and the members' owned lists.
+//
+// Members heartbeat, and claim items from the queue up to their fair share.
+// Members with more than their share release the excess once its work
+// reaches a stopping point. The leader, elected through a Coordinator, puts
+// items no one has into the queue, and returns the items of members whose
+// heartbeat has lapsed.
+type Fleet struct {
+	cache       storage.RemoteCache
+	name        string
+	identifier  string
+	items       []string
+	coordinator Coordinator
+
+	mutex     *sync.Mutex
+	running   map[string]bool
+	releasing map[string]bool
+	workers   *sync.WaitGroup
+
+	HeartbeatLife   time.Duration
+	HeartbeatPeriod time.Duration
+	ClaimTimeout    time.Duration
+}
+
+func NewFleet(aCache storage.RemoteCache, aName string, aItems []string) (*Fleet, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	randomSource := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return &Fleet{
+		cache:       aCache,
+		name:        aName,
+		identifier:  fmt.Sprintf("%s-%X", hostname, randomSource.Int63()),
+		items:       aItems,
+		coordinator: NewCoordinator(aCache, "fleet-"+aName),
+		mutex:       &sync.Mutex{},
+		running:     make(map[string]bool),
+		releasing:   make(map[string]bool),
+		workers:     &sync.WaitGroup{},
+
+		HeartbeatLife:   90 * time.Second,
+		HeartbeatPeriod: 30 * time.Second,
+		ClaimTimeout:    time.Second,
+	}, nil
+}
+
+func (f *Fleet) Identifier() string {
+	return f.identifier
+}
+
+func (f *Fleet) membersKey() string {
+	return kFleetMembersKey + f.name
+}
+
+func (f *Fleet) aliveKey(aMember string) string {
+	return kFleetAliveKey + f.name + "-" + aMember
+}
+
+func (f *Fleet) workKey() string {
+	return kFleetWorkKey + f.name
+}
+
+func (f *Fleet) ownedKey(aMember string) string {
+	return kFleetOwnedKey + f.name + "-" + aMember
+}
+
+// Announces that we're alive for another HeartbeatLife.
+func (f *Fleet) Heartbeat() error {
+	if _, err := f.cache.SetInsert(f.membersKey(), f.identifier); err != nil {
+		return err
+	}
+	if _, err := f.cache.TrySet(f.aliveKey(f.identifier), f.identifier, f.HeartbeatLife); err != nil {
+		return err
+	}
+	return f.cache.ExpireIn(f.aliveKey(f.identifier), f.HeartbeatLife)
+}
+
+func (f *Fleet) Owned() ([]string, error) {
+	return f.cache.QueueList(f.ownedKey(f.identifier))
+}
+
+func (f *Fleet) Owns(aItem string) (bool, error) {
+	owned, err := f.Owned()
+	if err != nil {
+		return false, err
+	}
+	for _, item := range owned {
+		if item == aItem {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// How many items each member should own, rounded up.
+func (f *Fleet) Share() (int, error) {
+	members, err := f.cache.SetCardinality(f.membersKey())
+	if err != nil {
+		return 0, err
+	}
+	if members < 1 {
+		members = 1
+	}
+	return (len(f.items) + members - 1) / members, nil
+}
+
+// Takes ownership of an item from the queue, waiting up to ClaimTimeout for
+// one. Returns false if there was none.
+func (f *Fleet) Claim() (string, bool, error) {
+	item, err := f.cache.BlockingPopCopy(f.workKey(), f.ownedKey(f.identifier), f.ClaimTimeout)
+	if err != nil {
+		if err.Error() == storage.EMPTY_QUEUE {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	metrics.IncrCounter([]string{"Fleet", "claimed"}, 1)
+	glog.Infof("[%s] Claimed %s", f.name, item)
+	return item, true, nil
+}
+
+// Returns aItem to the queue. It's queued before being disowned, so that it's
+// never briefly in neither.
+func (f *Fleet) Release(aItem string) error {
+	if _, err := f.cache.Queue(f.workKey(), aItem); err != nil {
+		return err
+	}
+	if err := f.cache.ListRemove(f.ownedKey(f.identifier), aItem); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	delete(f.releasing, aItem)
+	f.mutex.Unlock()
+
+	metrics.IncrCounter([]string{"Fleet", "released"}, 1)
+	glog.Infof("[%s] Released %s", f.name, aItem)
+	return nil
+}
+
+// Whether we own more than our share, and aItem's work should Release it at
+// its next stopping point.
+func (f *Fleet) ShouldRelease(aItem string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.releasing[aItem]
+}
+
+// Releases all our items and leaves the fleet.
+func (f *Fleet) Leave() error {
+	owned, err := f.Owned()
+	if err != nil {
+		return err
+	}
+	for _, item := range owned {
+		if err := f.Release(item); err != nil {
+			return err
+		}
+	}
+	if _, err := f.cache.SetRemove(f.membersKey(), f.identifier); err != nil {
+		return err
+	}
+	// Let another member take over without waiting for the lease to lapse.
+	if err := f.coordinator.Resign(); err != nil {
+		return err
+	}
+	glog.Infof("[%s] Left the fleet", f.name)
+	return f.cache.Delete(f.aliveKey(f.identifier))
+}
+
+// The leader's duties: return the items of members whose heartbeat has lapsed
+// to the queue, remove duplicated and unknown items, and queue items no one
+// has.
+func (f *Fleet) Reconcile() error {
+	members, err := f.cache.SetList(f.membersKey())
+	if err != nil {
+		return err
+	}
+
+	live := []string{}
+	for _, member := range members {
+		alive, err := f.cache.Exists(f.aliveKey(member))
+		if err != nil {
+			return err
+		}
+		if alive {
+			live = append(live, member)
+			continue
+		}
+
+		owned, err := f.cache.QueueList(f.ownedKey(member))
+		if err != nil {
+			return err
+		}
+		glog.Warningf("[%s] Member %s has stopped, returning its %d items", f.name, member, len(owned))
+		for _, item := range owned {
+			if _, err := f.cache.Queue(f.workKey(), item); err != nil {
+				return err
+			}
+		}
+		if err := f.cache.Delete(f.ownedKey(member)); err != nil {
+			return err
+		}
+		if _, err := f.cache.SetRemove(f.membersKey(), member); err != nil {
+			return err
+		}
+		metrics.IncrCounter([]string{"Fleet", "lostMembers"}, 1)
+	}
+
+	known := make(map[string]bool, len(f.items))
+	for _, item := range f.items {
+		known[item] = true
+	}
+	queued := make(map[string]bool, len(f.items))
+
+	// Read the queue before the owned lists: an item claimed in between is
+	// then seen in its owner's list, rather than missed. Any mistakes from
+	// racing a Release are put right next time.
+	queue, err := f.cache.QueueList(f.workKey())
+	if err != nil {
+		return err
+	}
+	for _, item := range queue {
+		if !known[item] || queued[item] {
+			glog.Infof("[%s] Removing unknown or duplicated %s from the queue", f.name, item)
+			if err := f.cache.ListRemove(f.workKey(), item); err != nil {
+				return err
+			}
+			continue
+		}
+		queued[item] = true
+	}
+
+	owners := make(map[string]string)
+	for _, member := range live {
+		owned, err := f.cache.QueueList(f.ownedKey(member))
+		if err != nil {
+			return err
+		}
+		for _, item := range owned {
+			owner, duplicated := owners[item]
+			if !known[item] || duplicated {
+				glog.Warningf("[%s] Removing %s from %s, it's unknown or owned by %s", f.name, item, member,
+					owner)
+				if err := f.cache.ListRemove(f.ownedKey(member), item); err != nil {
+					return err
+				}
+				continue
+			}
+			owners[item] = member
+			if queued[item] {
+				glog.Warningf("[%s] Removing %s from the queue, it's owned by %s", f.name, item, member)
+				if err := f.cache.ListRemove(f.workKey(), item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, item := range f.items {
+		if _, owned := owners[item]; owned || queued[item] {
+			continue
+		}
+		glog.Infof("[%s] Queueing %s", f.name, item)
+		if _, err := f.cache.Queue(f.workKey(), item); err != nil {
+			return err
+		}
+	}
+
+	if depth, err := f.cache.QueueLength(f.workKey()); err == nil {
+		metrics.SetGauge([]string{"Fleet", "queued"}, float32(depth))
+	}
+	return nil
+}
+
+// One round: heartbeat, lead if we can, then claim up to our share, or mark
+// the excess for release. Owned items without running work are started with
+// aWork in a new goroutine.
+func (f *Fleet) step(aWork func(string)) error {
+	if err := f.Heartbeat(); err != nil {
+		return err
+	}
+
+	lead := f.coordinator.IsLeader()
+	if !lead {
+		var err error
+		lead, err = f.coordinator.AwaitLeader()
+		if err != nil {
+			return err
+		}
+	}
+	if lead {
+		if err := f.Reconcile(); err != nil {
+			return err
+		}
+	}
+
+	share, err := f.Share()
+	if err != nil {
+		return err
+	}
+	owned, err := f.Owned()
+	if err != nil {
+		return err
+	}
+	for len(owned) < share {
+		item, ok, err := f.Claim()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		owned = append(owned, item)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.releasing = make(map[string]bool)
+	for i, item := range owned {
+		if i >= share {
+			f.releasing[item] = true
+		}
+		if f.running[item] {
+			continue
+		}
+		f.running[item] = true
+		f.workers.Add(1)
+		go func(item string) {
+			defer f.workers.Done()
+			aWork(item)
+			f.mutex.Lock()
+			delete(f.running, item)
+			f.mutex.Unlock()
+		}(item)
+	}
+	metrics.SetGauge([]string{"Fleet", "owned"}, float32(len(owned)))
+	return nil
+}
+
+// Takes part in the fleet until aStop is closed, calling aWork for each item
+// we come to own. aWork should return once the item is no longer Owned, or
+// after Releasing it if ShouldRelease. Once stopped, Run waits for aWork to
+// return for every item, then leaves the fleet.
+func (f *Fleet) Run(aStop <-chan struct{}, aWork func(string)) {
+	glog.Infof("[%s] Joining the fleet as %s", f.name, f.identifier)
+	ticker := time.NewTicker(f.HeartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		if err := f.step(aWork); err != nil {
+			glog.Warningf("[%s] Fleet maintenance failed: %v", f.name, err)
+		}
+
+		select {
+		case <-aStop:
+			f.workers.Wait()
+			if err := f.Leave(); err != nil {
+				glog.Warningf("[%s] Couldn't leave the fleet cleanly: %v", f.name, err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
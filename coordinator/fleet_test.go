@@ -0,0 +1,205 @@
+package coordinator
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+var kFleetItems = []string{"one", "two", "three", "four"}
+
+func newTestFleet(t *testing.T, mc storage.RemoteCache, name string) *Fleet {
+	f, err := NewFleet(mc, name, kFleetItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ClaimTimeout = 10 * time.Millisecond
+	return f
+}
+
+func noWork(string) {}
+
+func step(t *testing.T, f *Fleet) {
+	if err := f.step(noWork); err != nil {
+		t.Fatal(err)
+	}
+	f.workers.Wait()
+}
+
+func releaseExcess(t *testing.T, f *Fleet) {
+	owned, err := f.Owned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range owned {
+		if f.ShouldRelease(item) {
+			if err := f.Release(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func owned(t *testing.T, f *Fleet) []string {
+	items, err := f.Owned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(items)
+	return items
+}
+
+// Checks that every item is owned by exactly one of fleets.
+func expectAllOwnedOnce(t *testing.T, fleets ...*Fleet) {
+	owners := make(map[string]int)
+	for _, f := range fleets {
+		for _, item := range owned(t, f) {
+			owners[item]++
+		}
+	}
+	for _, item := range kFleetItems {
+		if owners[item] != 1 {
+			t.Errorf("Expected %s to have one owner, it has %d", item, owners[item])
+		}
+	}
+	if len(owners) != len(kFleetItems) {
+		t.Errorf("Unexpected items owned: %v", owners)
+	}
+}
+
+func Test_FleetSolo(t *testing.T) {
+	mc := storage.NewMockRemoteCache()
+	a := newTestFleet(t, mc, "Test_FleetSolo")
+
+	started := make(chan string, len(kFleetItems))
+	if err := a.step(func(item string) { started <- item }); err != nil {
+		t.Fatal(err)
+	}
+	a.workers.Wait()
+	close(started)
+
+	if !a.coordinator.IsLeader() {
+		t.Error("Should have trivially been the leader")
+	}
+	expectAllOwnedOnce(t, a)
+	count := 0
+	for range started {
+		count++
+	}
+	if count != len(kFleetItems) {
+		t.Errorf("Expected work started for each item, got %d", count)
+	}
+
+	if err := a.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if items := owned(t, a); len(items) != 0 {
+		t.Errorf("Expected to own nothing after leaving, got %v", items)
+	}
+	queued, err := mc.QueueLength(a.workKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != int64(len(kFleetItems)) {
+		t.Errorf("Expected everything requeued, got %d", queued)
+	}
+	leader, err := mc.Exists(kLeaderKey + a.coordinator.name)
+	if err != nil || leader {
+		t.Errorf("Leaving should give up leadership: %v %v", leader, err)
+	}
+}
+
+func Test_FleetShares(t *testing.T) {
+	mc := storage.NewMockRemoteCache()
+	a := newTestFleet(t, mc, "Test_FleetShares")
+	b := newTestFleet(t, mc, "Test_FleetShares")
+
+	step(t, a)
+	step(t, b)
+	if len(owned(t, a)) != 4 || len(owned(t, b)) != 0 {
+		t.Fatalf("The first member should have claimed everything: %v %v", owned(t, a), owned(t, b))
+	}
+
+	step(t, a)
+	releaseExcess(t, a)
+	step(t, b)
+
+	if b.coordinator.IsLeader() {
+		t.Error("Should only have one leader")
+	}
+	if len(owned(t, a)) != 2 || len(owned(t, b)) != 2 {
+		t.Errorf("Expected an even split: %v %v", owned(t, a), owned(t, b))
+	}
+	expectAllOwnedOnce(t, a, b)
+}
+
+func Test_FleetReassignsLostMembers(t *testing.T) {
+	mc := storage.NewMockRemoteCache()
+	a := newTestFleet(t, mc, "Test_FleetReassignsLostMembers")
+	b := newTestFleet(t, mc, "Test_FleetReassignsLostMembers")
+	b.HeartbeatLife = 50 * time.Millisecond
+
+	step(t, a)
+	step(t, b)
+	step(t, a)
+	releaseExcess(t, a)
+	step(t, b)
+	expectAllOwnedOnce(t, a, b)
+
+	// b stops heartbeating
+	time.Sleep(100 * time.Millisecond)
+	step(t, a)
+
+	if len(owned(t, b)) != 0 {
+		t.Errorf("The lost member's items should have been taken: %v", owned(t, b))
+	}
+	expectAllOwnedOnce(t, a)
+
+	members, err := mc.SetList(a.membersKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != a.Identifier() {
+		t.Errorf("Expected only %s to remain, got %v", a.Identifier(), members)
+	}
+}
+
+func Test_FleetReconcile(t *testing.T) {
+	mc := storage.NewMockRemoteCache()
+	a := newTestFleet(t, mc, "Test_FleetReconcile")
+	step(t, a)
+
+	// An item both owned and queued, one queued twice, and one unknown
+	for _, item := range []string{"one", "unknown"} {
+		if _, err := mc.Queue(a.workKey(), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Release("two"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.Queue(a.workKey(), "two"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.Queue(a.ownedKey(a.Identifier()), "stale"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := mc.QueueList(a.workKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0] != "two" {
+		t.Errorf("Expected only two to be queued, got %v", queue)
+	}
+	items := owned(t, a)
+	if len(items) != 3 {
+		t.Errorf("Expected the stale item removed, got %v", items)
+	}
+}
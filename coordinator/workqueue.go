@@ -0,0 +1,494 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package coordinator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+const kWorkQueuePendingKey string = "workqueue-pending-"
+const kWorkQueueProcessingKey string = "workqueue-processing-"
+const kWorkQueueWorkersKey string = "workqueue-workers-"
+const kWorkQueueLeaseKey string = "workqueue-lease-"
+const kWorkQueueDeadKey string = "workqueue-dead-"
+const kWorkQueueHeartbeatKey string = "workqueue-heartbeat-"
+const kWorkQueueUnleasedKey string = "workqueue-unleased-"
+
+var ErrLeaseLost = fmt.Errorf("Work item lease lost")
+
+// A WorkItem is a payload as queued, with an ID distinguishing it from others
+// with the same payload.
+type WorkItem struct {
+	ID      string `json:"id"`
+	Payload string `json:"payload"`
+	// How many times processing has failed or stalled
+	Attempts int `json:"attempts"`
+	// Its encoding in the lists
+	raw string
+}
+
+type WorkQueueDepths struct {
+	Pending    int64
+	Processing int64
+	Dead       int64
+}
+
+// A WorkQueue hands each enqueued payload to workers at least once. Leasing an
+// item moves it atomically into the worker's own processing list, where it
+// stays until acknowledged. Items whose worker doesn't Ack or Nack them within
+// VisibilityTimeout, or Extend their lease, are returned to the queue by
+// RequeueStalled, which any worker may call. Items which fail MaxAttempts
+// times are moved aside to a dead letter list.
+//
+// An item whose worker crashes is requeued once it has been seen unleased for
+// a further VisibilityTimeout, so about 2×VisibilityTimeout after its lease was
+// last renewed. Workers which haven't leased for VisibilityTimeout and hold
+// nothing are forgotten.
+type WorkQueue struct {
+	cache      storage.RemoteCache
+	name       string
+	identifier string
+	randSource *rand.Rand
+
+	mutex *sync.Mutex
+
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+	LeaseWait         time.Duration
+}
+
+func NewWorkQueue(aCache storage.RemoteCache, aName string) (*WorkQueue, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	randomSource := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return &WorkQueue{
+		cache:      aCache,
+		name:       aName,
+		identifier: fmt.Sprintf("%s-%X", hostname, randomSource.Int63()),
+		randSource: randomSource,
+		mutex:      &sync.Mutex{},
+
+		VisibilityTimeout: 5 * time.Minute,
+		MaxAttempts:       5,
+		LeaseWait:         time.Second,
+	}, nil
+}
+
+func (wq *WorkQueue) Identifier() string {
+	return wq.identifier
+}
+
+func (wq *WorkQueue) pendingKey() string {
+	return kWorkQueuePendingKey + wq.name
+}
+
+func (wq *WorkQueue) processingKey(aWorker string) string {
+	return kWorkQueueProcessingKey + wq.name + "-" + aWorker
+}
+
+func (wq *WorkQueue) workersKey() string {
+	return kWorkQueueWorkersKey + wq.name
+}
+
+func (wq *WorkQueue) leaseKey(aID string) string {
+	return kWorkQueueLeaseKey + wq.name + "-" + aID
+}
+
+func (wq *WorkQueue) deadKey() string {
+	return kWorkQueueDeadKey + wq.name
+}
+
+func (wq *WorkQueue) heartbeatKey(aWorker string) string {
+	return kWorkQueueHeartbeatKey + wq.name + "-" + aWorker
+}
+
+// Keyed by attempt as well, so an item requeued and leased again isn't judged
+// by when its last attempt was first seen unleased.
+func (wq *WorkQueue) unleasedKey(aItem *WorkItem) string {
+	return fmt.Sprintf("%s%s-%s-%d", kWorkQueueUnleasedKey, wq.name, aItem.ID, aItem.Attempts)
+}
+
+func decodeWorkItem(aRaw string) (*WorkItem, error) {
+	item := &WorkItem{raw: aRaw}
+	if err := json.Unmarshal([]byte(aRaw), item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (item *WorkItem) encode() error {
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	item.raw = string(encoded)
+	return nil
+}
+
+func (wq *WorkQueue) Enqueue(aPayload string) error {
+	wq.mutex.Lock()
+	id := fmt.Sprintf("%016X", wq.randSource.Int63())
+	wq.mutex.Unlock()
+
+	item := &WorkItem{ID: id, Payload: aPayload}
+	if err := item.encode(); err != nil {
+		return err
+	}
+	// Leases take from the tail, so this is first in, first out.
+	_, err := wq.cache.QueueHead(wq.pendingKey(), item.raw)
+	return err
+}
+
+// Waits up to LeaseWait for an item, returning nil if there was none. The
+// lease lasts VisibilityTimeout unless Extended.
+func (wq *WorkQueue) Lease() (*WorkItem, error) {
+	// Register first, so RequeueStalled finds our processing list, and
+	// heartbeat before that, so it doesn't forget us in between.
+	heartbeatKey := wq.heartbeatKey(wq.identifier)
+	if _, err := wq.cache.TrySet(heartbeatKey, wq.identifier, wq.VisibilityTimeout); err != nil {
+		return nil, err
+	}
+	if err := wq.cache.ExpireIn(heartbeatKey, wq.VisibilityTimeout); err != nil {
+		return nil, err
+	}
+	if _, err := wq.cache.SetInsert(wq.workersKey(), wq.identifier); err != nil {
+		return nil, err
+	}
+
+	raw, err := wq.cache.BlockingPopCopy(wq.pendingKey(), wq.processingKey(wq.identifier), wq.LeaseWait)
+	if err != nil {
+		if err.Error() == storage.EMPTY_QUEUE {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	item, err := decodeWorkItem(raw)
+	if err != nil {
+		glog.Errorf("[%s] Dead-lettering undecodable work item %q: %v", wq.name, raw, err)
+		return nil, wq.moveRaw(wq.processingKey(wq.identifier), wq.deadKey(), raw)
+	}
+
+	holder, err := wq.cache.TrySet(wq.leaseKey(item.ID), wq.identifier, wq.VisibilityTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if holder != wq.identifier {
+		// Its last worker hasn't let go yet; Extend will tell us if it's theirs.
+		glog.Warningf("[%s] Work item %s is still leased by %s", wq.name, item.ID, holder)
+	}
+	metrics.IncrCounter([]string{"WorkQueue", wq.name, "leased"}, 1)
+	return item, nil
+}
+
+// Renews our lease on aItem for another VisibilityTimeout. Returns
+// ErrLeaseLost if it's lapsed, in which case the item may be processed
+// elsewhere.
+func (wq *WorkQueue) Extend(aItem *WorkItem) error {
+	renewed, err := wq.cache.LeaseRenew(wq.leaseKey(aItem.ID), wq.identifier, wq.VisibilityTimeout)
+	if err != nil {
+		return err
+	}
+	if !renewed {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Marks aItem processed. Returns ErrLeaseLost if our lease had lapsed, in
+// which case it may be processed again.
+func (wq *WorkQueue) Ack(aItem *WorkItem) error {
+	if err := wq.cache.ListRemove(wq.processingKey(wq.identifier), aItem.raw); err != nil {
+		return err
+	}
+	released, err := wq.cache.LeaseRelease(wq.leaseKey(aItem.ID), wq.identifier)
+	if err != nil {
+		return err
+	}
+	metrics.IncrCounter([]string{"WorkQueue", wq.name, "acked"}, 1)
+	if !released {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Marks aItem failed, returning it to the queue or, once it has failed
+// MaxAttempts times, to the dead letters.
+func (wq *WorkQueue) Nack(aItem *WorkItem) error {
+	if err := wq.retry(wq.processingKey(wq.identifier), aItem); err != nil {
+		return err
+	}
+	metrics.IncrCounter([]string{"WorkQueue", wq.name, "nacked"}, 1)
+	return nil
+}
+
+// Adds before removing, so a crash between the two duplicates rather than
+// loses the item.
+func (wq *WorkQueue) moveRaw(aFrom string, aTo string, aRaw string) error {
+	if _, err := wq.cache.QueueHead(aTo, aRaw); err != nil {
+		return err
+	}
+	return wq.cache.ListRemove(aFrom, aRaw)
+}
+
+// Moves aItem out of aProcessingKey, with one more attempt counted, to the
+// back of the queue or to the dead letters.
+func (wq *WorkQueue) retry(aProcessingKey string, aItem *WorkItem) error {
+	retried := &WorkItem{ID: aItem.ID, Payload: aItem.Payload, Attempts: aItem.Attempts + 1}
+	if err := retried.encode(); err != nil {
+		return err
+	}
+
+	dest := wq.pendingKey()
+	if wq.MaxAttempts > 0 && retried.Attempts >= wq.MaxAttempts {
+		glog.Warningf("[%s] Work item %s failed %d times, dead-lettering", wq.name, aItem.ID, retried.Attempts)
+		dest = wq.deadKey()
+		metrics.IncrCounter([]string{"WorkQueue", wq.name, "dead"}, 1)
+	}
+
+	if _, err := wq.cache.QueueHead(dest, retried.raw); err != nil {
+		return err
+	}
+	if err := wq.cache.ListRemove(aProcessingKey, aItem.raw); err != nil {
+		return err
+	}
+	// Stalled items have no lease left to release.
+	_, err := wq.cache.LeaseRelease(wq.leaseKey(aItem.ID), wq.identifier)
+	return err
+}
+
+// Returns items whose lease has lapsed to the queue, counting an attempt.
+// Items just leased get VisibilityTimeout to have their lease recorded, so
+// they aren't mistaken for stalled; when each was first seen unleased is kept
+// in the cache, so any worker's call may requeue it. Also forgets workers
+// which hold nothing and haven't leased lately. Returns how many were
+// requeued.
+func (wq *WorkQueue) RequeueStalled() (int, error) {
+	workers, err := wq.cache.SetList(wq.workersKey())
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	requeued := 0
+	for _, worker := range workers {
+		processingKey := wq.processingKey(worker)
+		raws, err := wq.cache.QueueList(processingKey)
+		if err != nil {
+			return requeued, err
+		}
+		if len(raws) == 0 {
+			if err := wq.forgetIdle(worker); err != nil {
+				return requeued, err
+			}
+			continue
+		}
+
+		for _, raw := range raws {
+			item, err := decodeWorkItem(raw)
+			if err != nil {
+				glog.Errorf("[%s] Dead-lettering undecodable work item %q: %v", wq.name, raw, err)
+				if err := wq.moveRaw(processingKey, wq.deadKey(), raw); err != nil {
+					return requeued, err
+				}
+				continue
+			}
+
+			leased, err := wq.cache.Exists(wq.leaseKey(item.ID))
+			if err != nil {
+				return requeued, err
+			}
+			if leased {
+				continue
+			}
+
+			// Outlives the grace period, so it's still there to compare with.
+			firstStr, err := wq.cache.TrySet(wq.unleasedKey(item), strconv.FormatInt(now.UnixNano(), 10),
+				2*wq.VisibilityTimeout)
+			if err != nil {
+				return requeued, err
+			}
+			first, err := strconv.ParseInt(firstStr, 10, 64)
+			if err != nil {
+				return requeued, err
+			}
+			if now.Sub(time.Unix(0, first)) < wq.VisibilityTimeout {
+				continue
+			}
+
+			glog.Warningf("[%s] Work item %s stalled at %s, requeueing", wq.name, item.ID, worker)
+			if err := wq.retry(processingKey, item); err != nil {
+				return requeued, err
+			}
+			requeued++
+			metrics.IncrCounter([]string{"WorkQueue", wq.name, "stalled"}, 1)
+		}
+	}
+	return requeued, nil
+}
+
+// Removes aWorker from the workers, whose processing list was just found
+// empty, unless it has leased lately.
+func (wq *WorkQueue) forgetIdle(aWorker string) error {
+	alive, err := wq.cache.Exists(wq.heartbeatKey(aWorker))
+	if err != nil || alive {
+		return err
+	}
+	if _, err := wq.cache.SetRemove(wq.workersKey(), aWorker); err != nil {
+		return err
+	}
+	// It may have leased again since we looked, so restore it if so.
+	count, err := wq.cache.QueueLength(wq.processingKey(aWorker))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		_, err = wq.cache.SetInsert(wq.workersKey(), aWorker)
+		return err
+	}
+	glog.V(1).Infof("[%s] Forgot idle worker %s", wq.name, aWorker)
+	return nil
+}
+
+// Reports and returns the queue's depths.
+func (wq *WorkQueue) Depths() (WorkQueueDepths, error) {
+	var depths WorkQueueDepths
+	var err error
+
+	depths.Pending, err = wq.cache.QueueLength(wq.pendingKey())
+	if err != nil {
+		return depths, err
+	}
+	depths.Dead, err = wq.cache.QueueLength(wq.deadKey())
+	if err != nil {
+		return depths, err
+	}
+
+	workers, err := wq.cache.SetList(wq.workersKey())
+	if err != nil {
+		return depths, err
+	}
+	for _, worker := range workers {
+		count, err := wq.cache.QueueLength(wq.processingKey(worker))
+		if err != nil {
+			return depths, err
+		}
+		depths.Processing += count
+	}
+
+	metrics.SetGauge([]string{"WorkQueue", wq.name, "pending"}, float32(depths.Pending))
+	metrics.SetGauge([]string{"WorkQueue", wq.name, "processing"}, float32(depths.Processing))
+	metrics.SetGauge([]string{"WorkQueue", wq.name, "dead"}, float32(depths.Dead))
+	return depths, nil
+}
+
+// The payloads of every item pending or being processed, so planners can tell
+// what's already queued.
+func (wq *WorkQueue) Payloads() ([]string, error) {
+	keys := []string{wq.pendingKey()}
+	workers, err := wq.cache.SetList(wq.workersKey())
+	if err != nil {
+		return nil, err
+	}
+	for _, worker := range workers {
+		keys = append(keys, wq.processingKey(worker))
+	}
+
+	payloads := []string{}
+	for _, key := range keys {
+		raws, err := wq.cache.QueueList(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range raws {
+			if item, err := decodeWorkItem(raw); err == nil {
+				payloads = append(payloads, item.Payload)
+			}
+		}
+	}
+	return payloads, nil
+}
+
+func (wq *WorkQueue) DeadLetters() ([]*WorkItem, error) {
+	raws, err := wq.cache.QueueList(wq.deadKey())
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*WorkItem, 0, len(raws))
+	for _, raw := range raws {
+		item, err := decodeWorkItem(raw)
+		if err != nil {
+			item = &WorkItem{Payload: raw, raw: raw}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Moves every dead letter back to the queue with its attempts forgotten,
+// returning how many. Those which can't be decoded can't be retried, and are
+// dropped.
+func (wq *WorkQueue) RequeueDead() (int, error) {
+	raws, err := wq.cache.QueueList(wq.deadKey())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, raw := range raws {
+		item, err := decodeWorkItem(raw)
+		if err != nil {
+			glog.Warningf("[%s] Dropping undecodable dead letter %q: %v", wq.name, raw, err)
+			if err := wq.cache.ListRemove(wq.deadKey(), raw); err != nil {
+				return count, err
+			}
+			continue
+		}
+
+		revived := &WorkItem{ID: item.ID, Payload: item.Payload}
+		if err := revived.encode(); err != nil {
+			return count, err
+		}
+		if _, err := wq.cache.QueueHead(wq.pendingKey(), revived.raw); err != nil {
+			return count, err
+		}
+		if err := wq.cache.ListRemove(wq.deadKey(), raw); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Discards every item pending, being processed or dead.
+func (wq *WorkQueue) Clear() error {
+	workers, err := wq.cache.SetList(wq.workersKey())
+	if err != nil {
+		return err
+	}
+	keys := []string{wq.pendingKey(), wq.deadKey()}
+	for _, worker := range workers {
+		keys = append(keys, wq.processingKey(worker))
+	}
+	for _, key := range keys {
+		if err := wq.cache.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
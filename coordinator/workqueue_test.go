@@ -0,0 +1,259 @@
+package coordinator
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+func newTestWorkQueue(t *testing.T, r storage.RemoteCache, name string) *WorkQueue {
+	wq, err := NewWorkQueue(r, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wq.LeaseWait = 10 * time.Millisecond
+	return wq
+}
+
+func lease(t *testing.T, wq *WorkQueue) *WorkItem {
+	item, err := wq.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item == nil {
+		t.Fatal("Expected an item to lease")
+	}
+	return item
+}
+
+func expectDepths(t *testing.T, wq *WorkQueue, expected WorkQueueDepths) {
+	depths, err := wq.Depths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depths != expected {
+		t.Errorf("Expected depths %+v, got %+v", expected, depths)
+	}
+}
+
+func Test_WorkQueueAck(t *testing.T) {
+	r := storage.NewMockRemoteCache()
+	wq := newTestWorkQueue(t, r, "Test_WorkQueueAck")
+
+	for _, payload := range []string{"one", "two", "one"} {
+		if err := wq.Enqueue(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectDepths(t, wq, WorkQueueDepths{Pending: 3})
+
+	first := lease(t, wq)
+	second := lease(t, wq)
+	third := lease(t, wq)
+	if first.Payload != "one" || second.Payload != "two" || third.Payload != "one" {
+		t.Errorf("Expected first in, first out, got %s %s %s", first.Payload, second.Payload, third.Payload)
+	}
+	if first.ID == third.ID {
+		t.Error("Items with the same payload should have different IDs")
+	}
+	expectDepths(t, wq, WorkQueueDepths{Processing: 3})
+
+	if err := wq.Enqueue("three"); err != nil {
+		t.Fatal(err)
+	}
+	payloads, err := wq.Payloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(payloads)
+	if !reflect.DeepEqual(payloads, []string{"one", "one", "three", "two"}) {
+		t.Errorf("Expected every pending and processing payload, got %v", payloads)
+	}
+	fourth := lease(t, wq)
+
+	item, err := wq.Lease()
+	if err != nil || item != nil {
+		t.Errorf("Expected nothing to lease, got %+v %v", item, err)
+	}
+
+	if err := wq.Extend(first); err != nil {
+		t.Error(err)
+	}
+	for _, item := range []*WorkItem{first, second, third, fourth} {
+		if err := wq.Ack(item); err != nil {
+			t.Error(err)
+		}
+	}
+	expectDepths(t, wq, WorkQueueDepths{})
+}
+
+func Test_WorkQueueNackDeadLetters(t *testing.T) {
+	r := storage.NewMockRemoteCache()
+	wq := newTestWorkQueue(t, r, "Test_WorkQueueNackDeadLetters")
+	wq.MaxAttempts = 2
+
+	if err := wq.Enqueue("poison"); err != nil {
+		t.Fatal(err)
+	}
+
+	item := lease(t, wq)
+	if err := wq.Nack(item); err != nil {
+		t.Fatal(err)
+	}
+	expectDepths(t, wq, WorkQueueDepths{Pending: 1})
+
+	item = lease(t, wq)
+	if item.Attempts != 1 {
+		t.Errorf("Expected one failed attempt, got %d", item.Attempts)
+	}
+	if err := wq.Nack(item); err != nil {
+		t.Fatal(err)
+	}
+	expectDepths(t, wq, WorkQueueDepths{Dead: 1})
+
+	dead, err := wq.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Payload != "poison" || dead[0].Attempts != 2 {
+		t.Errorf("Expected the poison item dead after 2 attempts, got %+v", dead)
+	}
+
+	count, err := wq.RequeueDead()
+	if err != nil || count != 1 {
+		t.Fatalf("Expected to requeue the dead item, got %d %v", count, err)
+	}
+	expectDepths(t, wq, WorkQueueDepths{Pending: 1})
+	item = lease(t, wq)
+	if item.Payload != "poison" || item.Attempts != 0 {
+		t.Errorf("Expected the poison item with no attempts, got %+v", item)
+	}
+}
+
+func Test_WorkQueueClear(t *testing.T) {
+	r := storage.NewMockRemoteCache()
+	wq := newTestWorkQueue(t, r, "Test_WorkQueueClear")
+	wq.MaxAttempts = 1
+
+	for _, payload := range []string{"dead", "processing", "pending"} {
+		if err := wq.Enqueue(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wq.Nack(lease(t, wq)); err != nil {
+		t.Fatal(err)
+	}
+	lease(t, wq)
+	expectDepths(t, wq, WorkQueueDepths{Pending: 1, Processing: 1, Dead: 1})
+
+	if err := wq.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	expectDepths(t, wq, WorkQueueDepths{})
+}
+
+func Test_WorkQueueRequeueStalled(t *testing.T) {
+	r := storage.NewMockRemoteCache()
+	worker := newTestWorkQueue(t, r, "Test_WorkQueueRequeueStalled")
+	worker.VisibilityTimeout = 50 * time.Millisecond
+	reaper := newTestWorkQueue(t, r, "Test_WorkQueueRequeueStalled")
+	reaper.VisibilityTimeout = 50 * time.Millisecond
+
+	if err := worker.Enqueue("slow"); err != nil {
+		t.Fatal(err)
+	}
+	item := lease(t, worker)
+
+	requeued, err := reaper.RequeueStalled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued != 0 {
+		t.Errorf("Leased items shouldn't be requeued, but %d were", requeued)
+	}
+
+	// The lease lapses, and it needs to be seen lapsed for a whole
+	// VisibilityTimeout, in case it had only just been leased. Any worker may
+	// see it lapse.
+	time.Sleep(60 * time.Millisecond)
+	if _, err := reaper.RequeueStalled(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	otherReaper := newTestWorkQueue(t, r, "Test_WorkQueueRequeueStalled")
+	otherReaper.VisibilityTimeout = 50 * time.Millisecond
+	requeued, err = otherReaper.RequeueStalled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued != 1 {
+		t.Errorf("Expected the stalled item requeued, got %d", requeued)
+	}
+
+	if err := worker.Extend(item); err != ErrLeaseLost {
+		t.Errorf("Expected ErrLeaseLost extending a stalled item, got %v", err)
+	}
+
+	retried := lease(t, reaper)
+	if retried.ID != item.ID || retried.Attempts != 1 {
+		t.Errorf("Expected %s retried once, got %+v", item.ID, retried)
+	}
+
+	if err := worker.Ack(item); err != ErrLeaseLost {
+		t.Errorf("Expected ErrLeaseLost acking a stalled item, got %v", err)
+	}
+	if err := reaper.Ack(retried); err != nil {
+		t.Error(err)
+	}
+	expectDepths(t, reaper, WorkQueueDepths{})
+}
+
+func Test_WorkQueueForgetsIdleWorkers(t *testing.T) {
+	r := storage.NewMockRemoteCache()
+	idle := newTestWorkQueue(t, r, "Test_WorkQueueForgetsIdleWorkers")
+	idle.VisibilityTimeout = 50 * time.Millisecond
+	busy := newTestWorkQueue(t, r, "Test_WorkQueueForgetsIdleWorkers")
+	busy.VisibilityTimeout = time.Minute
+
+	if err := idle.Enqueue("quick"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idle.Ack(lease(t, idle)); err != nil {
+		t.Fatal(err)
+	}
+	if err := busy.Enqueue("slow"); err != nil {
+		t.Fatal(err)
+	}
+	lease(t, busy)
+
+	// The idle worker is remembered until its heartbeat lapses
+	if _, err := busy.RequeueStalled(); err != nil {
+		t.Fatal(err)
+	}
+	workers, err := r.SetList(busy.workersKey())
+	if err != nil || len(workers) != 2 {
+		t.Errorf("Expected both workers registered, got %v %v", workers, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := busy.RequeueStalled(); err != nil {
+		t.Fatal(err)
+	}
+	workers, err = r.SetList(busy.workersKey())
+	if err != nil || !reflect.DeepEqual(workers, []string{busy.Identifier()}) {
+		t.Errorf("Expected only the busy worker registered, got %v %v", workers, err)
+	}
+	expectDepths(t, busy, WorkQueueDepths{Processing: 1})
+
+	// Leasing again registers it again
+	if _, err := idle.Lease(); err != nil {
+		t.Fatal(err)
+	}
+	workers, err = r.SetList(busy.workersKey())
+	if err != nil || len(workers) != 2 {
+		t.Errorf("Expected the idle worker registered again, got %v %v", workers, err)
+	}
+}
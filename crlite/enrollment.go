@@ -0,0 +1,243 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package crlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+// The name under which a run's enrollment is stored, if it has one.
+const EnrollmentFile = "enrolled.json"
+
+// Why an issuer isn't enrolled
+const (
+	ReasonDenied         = "denied"
+	ReasonNotAllowed     = "not allowed"
+	ReasonNoCRLs         = "no CRLs"
+	ReasonNoCertificates = "no unexpired certificates"
+	ReasonNoRevocations  = "no revocations stored"
+	ReasonNoPEM          = "issuer certificate not stored"
+)
+
+type EnrolledIssuer struct {
+	IssuerID  string   `json:"issuerId"`
+	IssuerDNs []string `json:"issuerDNs"`
+	CRLs      []string `json:"crls"`
+	// Unexpired known certificates
+	Certificates int64  `json:"certificates"`
+	PEM          string `json:"pem,omitempty"`
+	Enrolled     bool   `json:"enrolled"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// An Enrollment lists every issuer in the cache, and whether it's enrolled:
+// worth covering with filters, and able to be.
+type Enrollment struct {
+	Generated time.Time        `json:"generated"`
+	Issuers   []EnrolledIssuer `json:"issuers"`
+}
+
+// The enrolled issuers' IDs.
+func (e *Enrollment) EnrolledIDs() []string {
+	ids := []string{}
+	for _, issuer := range e.Issuers {
+		if issuer.Enrolled {
+			ids = append(ids, issuer.IssuerID)
+		}
+	}
+	return ids
+}
+
+// The issuer IDs enrolled now but not in aPrevious, and those no longer
+// enrolled.
+func (e *Enrollment) Changes(aPrevious *Enrollment) ([]string, []string) {
+	current := toSet(e.EnrolledIDs())
+	previous := toSet(aPrevious.EnrolledIDs())
+	added, removed := []string{}, []string{}
+	for id := range current {
+		if _, ok := previous[id]; !ok {
+			added = append(added, id)
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func LoadEnrollment(ctx context.Context, aBackend storage.StorageBackend, aRunID string) (*Enrollment, error) {
+	data, err := aBackend.LoadRunFile(ctx, aRunID, EnrollmentFile)
+	if err != nil {
+		return nil, err
+	}
+	var enrollment Enrollment
+	if err := json.Unmarshal(data, &enrollment); err != nil {
+		return nil, fmt.Errorf("Run %s has a corrupt enrollment: %v", aRunID, err)
+	}
+	return &enrollment, nil
+}
+
+// The enrollment of the latest complete run which stored one, and that run's
+// ID, or nil if there's none.
+func LatestEnrollment(ctx context.Context, aBackend storage.StorageBackend) (*Enrollment, string, error) {
+	runs, err := aBackend.ListRuns(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(runs)
+	for i := len(runs) - 1; i >= 0; i-- {
+		if _, err := LoadManifest(ctx, aBackend, runs[i]); err != nil {
+			continue
+		}
+		enrollment, err := LoadEnrollment(ctx, aBackend, runs[i])
+		if err != nil {
+			glog.V(1).Infof("Run %s has no enrollment: %v", runs[i], err)
+			continue
+		}
+		return enrollment, runs[i], nil
+	}
+	return nil, "", nil
+}
+
+// Enroller decides which issuers are enrolled. An issuer is enrolled if its
+// certificates name CRLs, it has unexpired certificates, crl-fetch has
+// stored its revocations and its own certificate is stored, unless it's
+// denied or there's an allow list it isn't on. Filters should only cover
+// the enrolled issuers.
+type Enroller struct {
+	db      storage.CertDatabase
+	cache   storage.RemoteCache
+	backend storage.StorageBackend
+	// Issuer IDs to enroll, or all if empty
+	Allow []string
+	// Issuer IDs never to enroll
+	Deny []string
+	// Only certificates unexpired at NotBefore are counted
+	NotBefore time.Time
+}
+
+func NewEnroller(aDB storage.CertDatabase, aCache storage.RemoteCache,
+	aBackend storage.StorageBackend) *Enroller {
+	return &Enroller{
+		db:      aDB,
+		cache:   aCache,
+		backend: aBackend,
+	}
+}
+
+// Whether crl-fetch has stored the revocations of any of aBuckets.
+func (e *Enroller) hasRevocations(aBuckets []storage.IssuerAndDate) (bool, error) {
+	for _, bucket := range aBuckets {
+		revoked, unrevoked, err := storage.NewRevocations(bucket.ExpDate, bucket.Issuer, e.cache).Counts()
+		if err != nil {
+			return false, err
+		}
+		if revoked+unrevoked > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func toSet(aList []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(aList))
+	for _, item := range aList {
+		set[item] = struct{}{}
+	}
+	return set
+}
+
+func (e *Enroller) Enroll(ctx context.Context) (*Enrollment, error) {
+	allow := toSet(e.Allow)
+	deny := toSet(e.Deny)
+
+	issuerList, err := e.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment := &Enrollment{
+		Generated: time.Now().UTC(),
+		Issuers:   []EnrolledIssuer{},
+	}
+	for _, issuerObj := range issuerList {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		buckets := []storage.IssuerAndDate{}
+		for _, expDate := range issuerObj.ExpDates {
+			if !expDate.IsExpiredAt(e.NotBefore) {
+				buckets = append(buckets, storage.IssuerAndDate{ExpDate: expDate, Issuer: issuerObj.Issuer})
+			}
+		}
+		counts, err := e.db.CountBuckets(buckets)
+		if err != nil {
+			return nil, err
+		}
+
+		id := issuerObj.Issuer.ID()
+		meta := e.db.GetIssuerMetadata(issuerObj.Issuer)
+		entry := EnrolledIssuer{
+			IssuerID:  id,
+			IssuerDNs: meta.Issuers(),
+			CRLs:      meta.CRLs(),
+		}
+		sort.Strings(entry.IssuerDNs)
+		sort.Strings(entry.CRLs)
+		for _, count := range counts {
+			entry.Certificates += count
+		}
+
+		_, allowed := allow[id]
+		_, denied := deny[id]
+		switch {
+		case denied:
+			entry.Reason = ReasonDenied
+		case len(allow) > 0 && !allowed:
+			entry.Reason = ReasonNotAllowed
+		case len(entry.CRLs) == 0:
+			entry.Reason = ReasonNoCRLs
+		case entry.Certificates == 0:
+			entry.Reason = ReasonNoCertificates
+		default:
+			partitioned, err := e.hasRevocations(buckets)
+			if err != nil {
+				return nil, err
+			}
+			if !partitioned {
+				entry.Reason = ReasonNoRevocations
+				break
+			}
+			pem, err := e.backend.LoadIssuerPEM(ctx, issuerObj.Issuer)
+			if err != nil {
+				glog.V(1).Infof("[%s] No issuer certificate: %v", id, err)
+				entry.Reason = ReasonNoPEM
+				break
+			}
+			entry.PEM = string(pem)
+			entry.Enrolled = true
+		}
+		enrollment.Issuers = append(enrollment.Issuers, entry)
+	}
+
+	sort.Slice(enrollment.Issuers, func(i, j int) bool {
+		return enrollment.Issuers[i].IssuerID < enrollment.Issuers[j].IssuerID
+	})
+	return enrollment, nil
+}
@@ -0,0 +1,184 @@
+package crlite
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+func makeCert(t *testing.T, aCN string, aNotAfter time.Time, aCRLs []string, aIsCA bool) *ctx509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: aCN},
+		NotBefore:             aNotAfter.AddDate(-1, 0, 0),
+		NotAfter:              aNotAfter,
+		CRLDistributionPoints: aCRLs,
+		IsCA:                  aIsCA,
+		BasicConstraintsValid: aIsCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ctx509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func Test_Enroller(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMockBackend()
+	cache := storage.NewMockRemoteCache()
+	db, err := storage.NewFilesystemDatabase(backend, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	expired := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	crl := []string{"http://crl.example/ca.crl"}
+	issuers := map[string]*ctx509.Certificate{}
+	for _, s := range []struct {
+		name     string
+		notAfter time.Time
+		crls     []string
+		storePEM bool
+		fetched  bool
+	}{
+		{"enrolled", live, crl, true, true},
+		{"noCRLs", live, nil, true, false},
+		{"denied", live, crl, true, true},
+		{"expired", expired, crl, true, true},
+		{"noPEM", live, crl, false, true},
+		{"noRevocations", live, crl, true, false},
+	} {
+		issuers[s.name] = makeCert(t, s.name, live, nil, true)
+		if s.storePEM {
+			if err := db.StoreIssuerCertificate(issuers[s.name]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Leaves are self-signed, so name themselves as their issuer's DN
+		leaf := makeCert(t, "leaf", s.notAfter, s.crls, false)
+		if err := db.Store(leaf, issuers[s.name], "log", 0); err != nil {
+			t.Fatal(err)
+		}
+		if s.fetched {
+			expDate := storage.NewExpDateFromTime(leaf.NotAfter)
+			err := storage.NewRevocations(expDate, storage.NewIssuer(issuers[s.name]), cache).Store(
+				[]storage.Serial{}, []storage.Serial{storage.NewSerial(leaf)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	id := func(aName string) string {
+		issuer := storage.NewIssuer(issuers[aName])
+		return issuer.ID()
+	}
+
+	enroller := NewEnroller(db, cache, backend)
+	enroller.Deny = []string{id("denied")}
+	enroller.NotBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	enrollment, err := enroller.Enroll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		id("enrolled"):      "",
+		id("noCRLs"):        ReasonNoCRLs,
+		id("denied"):        ReasonDenied,
+		id("expired"):       ReasonNoCertificates,
+		id("noPEM"):         ReasonNoPEM,
+		id("noRevocations"): ReasonNoRevocations,
+	}
+	reasons := map[string]string{}
+	for _, issuer := range enrollment.Issuers {
+		reasons[issuer.IssuerID] = issuer.Reason
+		if issuer.Enrolled != (issuer.Reason == "") {
+			t.Errorf("Enrolled should mean no reason: %+v", issuer)
+		}
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Expected reasons %+v, got %+v", expected, reasons)
+	}
+
+	if !reflect.DeepEqual(enrollment.EnrolledIDs(), []string{id("enrolled")}) {
+		t.Fatalf("Expected only one issuer enrolled, got %+v", enrollment.EnrolledIDs())
+	}
+	for _, issuer := range enrollment.Issuers {
+		if !issuer.Enrolled {
+			continue
+		}
+		if !strings.HasPrefix(issuer.PEM, "-----BEGIN CERTIFICATE-----") || issuer.Certificates != 1 ||
+			!reflect.DeepEqual(issuer.CRLs, crl) || !reflect.DeepEqual(issuer.IssuerDNs, []string{"CN=leaf"}) {
+			t.Errorf("Unexpected enrolled issuer %+v", issuer)
+		}
+	}
+
+	// Keep this version in a run, and one in an incomplete run
+	latest, _, err := LatestEnrollment(ctx, backend)
+	if err != nil || latest != nil {
+		t.Fatalf("Expected no enrollment stored yet: %+v %v", latest, err)
+	}
+	encoded, err := json.Marshal(enrollment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{EnrollmentFile, kManifestFile} {
+		if err := backend.StoreRunFile(ctx, "20200101-000000", file, encoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.StoreRunFile(ctx, "20200102-000000", EnrollmentFile, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	previous, runID, err := LatestEnrollment(ctx, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runID != "20200101-000000" || !reflect.DeepEqual(previous.EnrolledIDs(), enrollment.EnrolledIDs()) {
+		t.Errorf("Expected the complete run's enrollment, got %s %+v", runID, previous)
+	}
+
+	enroller.Allow = []string{id("noCRLs"), id("noPEM"), id("denied")}
+	enroller.Deny = []string{id("denied")}
+	if err := backend.StoreIssuerPEM(ctx, storage.NewIssuer(issuers["noPEM"]), []byte("pem")); err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err = enroller.Enroll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(enrollment.EnrolledIDs(), []string{id("noPEM")}) {
+		t.Errorf("Expected only the allowed issuer with CRLs enrolled, got %+v", enrollment.EnrolledIDs())
+	}
+	added, removed := enrollment.Changes(previous)
+	if !reflect.DeepEqual(added, []string{id("noPEM")}) || !reflect.DeepEqual(removed, []string{id("enrolled")}) {
+		t.Errorf("Unexpected changes, added %+v and removed %+v", added, removed)
+	}
+	for _, issuer := range enrollment.Issuers {
+		if issuer.IssuerID == id("enrolled") && issuer.Reason != ReasonNotAllowed {
+			t.Errorf("Expected an issuer not on the allow list not to be allowed: %+v", issuer)
+		}
+	}
+}
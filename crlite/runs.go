@@ -0,0 +1,317 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Package crlite keeps a snapshot of every bucket's known and revoked serials
+// at each run, and the delta and stash of what changed since the run before,
+// so clients can update a filter without downloading a new one.
+package crlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+// The name under which a run's filter is stored, if it has one.
+const FilterFile = "filter"
+
+const (
+	kManifestFile = "manifest.json"
+	kDeltaFile    = "delta.json"
+	kStashFile    = "stash"
+	kSnapshotDir  = "snapshot"
+)
+
+// Run IDs are the UTC time the run started, so they sort in the order runs
+// were made.
+func NewRunID(aTime time.Time) string {
+	return aTime.UTC().Format("20060102-150405")
+}
+
+type BucketSummary struct {
+	Bucket  string `json:"bucket"`
+	Known   int    `json:"known"`
+	Revoked int    `json:"revoked"`
+}
+
+// A Manifest lists the buckets a run snapshot. It's written last, so a run
+// without one is incomplete and ignored.
+type Manifest struct {
+	RunID         string          `json:"runId"`
+	PreviousRunID string          `json:"previousRunId,omitempty"`
+	Created       time.Time       `json:"created"`
+	Buckets       []BucketSummary `json:"buckets"`
+	// The size of the run's stash, if there was a previous run
+	StashIssuers int `json:"stashIssuers"`
+	StashSerials int `json:"stashSerials"`
+}
+
+// BucketDelta is how one bucket changed since the previous run.
+type BucketDelta struct {
+	Bucket         string           `json:"bucket"`
+	KnownAdded     []storage.Serial `json:"knownAdded"`
+	KnownRemoved   []storage.Serial `json:"knownRemoved"`
+	RevokedAdded   []storage.Serial `json:"revokedAdded"`
+	RevokedRemoved []storage.Serial `json:"revokedRemoved"`
+}
+
+func (d *BucketDelta) empty() bool {
+	return len(d.KnownAdded) == 0 && len(d.KnownRemoved) == 0 && len(d.RevokedAdded) == 0 &&
+		len(d.RevokedRemoved) == 0
+}
+
+// A Delta lists the buckets which changed since the previous run, and those
+// no longer snapshot at all, usually as they've expired.
+type Delta struct {
+	RunID          string        `json:"runId"`
+	PreviousRunID  string        `json:"previousRunId"`
+	Buckets        []BucketDelta `json:"buckets"`
+	RemovedBuckets []string      `json:"removedBuckets"`
+}
+
+func snapshotFile(aBucket string) string {
+	return kSnapshotDir + "/" + aBucket
+}
+
+func LoadManifest(ctx context.Context, aBackend storage.StorageBackend, aRunID string) (*Manifest, error) {
+	data, err := aBackend.LoadRunFile(ctx, aRunID, kManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("Run %s has a corrupt manifest: %v", aRunID, err)
+	}
+	return &manifest, nil
+}
+
+func LoadDelta(ctx context.Context, aBackend storage.StorageBackend, aRunID string) (*Delta, error) {
+	data, err := aBackend.LoadRunFile(ctx, aRunID, kDeltaFile)
+	if err != nil {
+		return nil, err
+	}
+	var delta Delta
+	if err := json.Unmarshal(data, &delta); err != nil {
+		return nil, fmt.Errorf("Run %s has a corrupt delta: %v", aRunID, err)
+	}
+	return &delta, nil
+}
+
+func LoadStash(ctx context.Context, aBackend storage.StorageBackend, aRunID string) (Stash, error) {
+	data, err := aBackend.LoadRunFile(ctx, aRunID, kStashFile)
+	if err != nil {
+		return nil, err
+	}
+	return ReadStash(bytes.NewReader(data))
+}
+
+// The latest complete run before aRunID, or nil if there's none.
+func previousManifest(ctx context.Context, aBackend storage.StorageBackend,
+	aRunID string) (*Manifest, error) {
+	runs, err := aBackend.ListRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(runs)
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i] >= aRunID {
+			continue
+		}
+		manifest, err := LoadManifest(ctx, aBackend, runs[i])
+		if err != nil {
+			glog.Warningf("Ignoring incomplete run %s: %v", runs[i], err)
+			continue
+		}
+		return manifest, nil
+	}
+	return nil, nil
+}
+
+// Generator stores runs in the StorageBackend: a snapshot of every bucket's
+// revocations, as stored by crl-fetch, and for every run after the first,
+// the delta and stash since the last.
+type Generator struct {
+	db      storage.CertDatabase
+	cache   storage.RemoteCache
+	backend storage.StorageBackend
+	// Only buckets unexpired at NotBefore are snapshot, when set
+	NotBefore time.Time
+	// Only these issuer IDs are snapshot, when not nil
+	Issuers []string
+}
+
+func NewGenerator(aDB storage.CertDatabase, aCache storage.RemoteCache,
+	aBackend storage.StorageBackend) *Generator {
+	return &Generator{
+		db:      aDB,
+		cache:   aCache,
+		backend: aBackend,
+	}
+}
+
+// Stores run aRunID, which must sort after every earlier run. Other files
+// may be stored in the run beforehand, such as its filter. The Delta is nil
+// for the first run, which has nothing to compare with.
+func (g *Generator) Run(ctx context.Context, aRunID string) (*Manifest, *Delta, error) {
+	if aRunID == "" || strings.Contains(aRunID, "/") {
+		return nil, nil, fmt.Errorf("Invalid run ID %q", aRunID)
+	}
+	if _, err := LoadManifest(ctx, g.backend, aRunID); err == nil {
+		return nil, nil, fmt.Errorf("Run %s already exists", aRunID)
+	}
+	previous, err := previousManifest(ctx, g.backend, aRunID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest := &Manifest{
+		RunID:   aRunID,
+		Created: time.Now().UTC(),
+		Buckets: []BucketSummary{},
+	}
+	var delta *Delta
+	previousBuckets := make(map[string]struct{})
+	if previous != nil {
+		manifest.PreviousRunID = previous.RunID
+		delta = &Delta{
+			RunID:          aRunID,
+			PreviousRunID:  previous.RunID,
+			Buckets:        []BucketDelta{},
+			RemovedBuckets: []string{},
+		}
+		for _, summary := range previous.Buckets {
+			previousBuckets[summary.Bucket] = struct{}{}
+		}
+	}
+	stash := Stash{}
+
+	issuerList, err := g.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	issuers := toSet(g.Issuers)
+	for _, issuerObj := range issuerList {
+		if _, ok := issuers[issuerObj.Issuer.ID()]; g.Issuers != nil && !ok {
+			continue
+		}
+		for _, expDate := range issuerObj.ExpDates {
+			if expDate.IsExpiredAt(g.NotBefore) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			default:
+			}
+
+			bucket := storage.IssuerAndDate{ExpDate: expDate, Issuer: issuerObj.Issuer}
+			id := bucket.String()
+			snapshot, err := loadBucketSnapshot(g.cache, bucket)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(snapshot.known) == 0 {
+				// Never partitioned by crl-fetch, so not in the filter either
+				continue
+			}
+			encoded, err := snapshot.encode()
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := g.backend.StoreRunFile(ctx, aRunID, snapshotFile(id), encoded); err != nil {
+				return nil, nil, err
+			}
+			manifest.Buckets = append(manifest.Buckets, BucketSummary{
+				Bucket:  id,
+				Known:   len(snapshot.known),
+				Revoked: len(snapshot.revoked),
+			})
+
+			if delta == nil {
+				continue
+			}
+			before := newBucketSnapshot()
+			if _, ok := previousBuckets[id]; ok {
+				data, err := g.backend.LoadRunFile(ctx, previous.RunID, snapshotFile(id))
+				if err != nil {
+					return nil, nil, err
+				}
+				before, err = decodeBucketSnapshot(data)
+				if err != nil {
+					return nil, nil, fmt.Errorf("Run %s has a corrupt snapshot of %s: %v", previous.RunID,
+						id, err)
+				}
+				delete(previousBuckets, id)
+			}
+
+			bucketDelta := BucketDelta{
+				Bucket:         id,
+				KnownAdded:     difference(snapshot.known, before.known),
+				KnownRemoved:   difference(before.known, snapshot.known),
+				RevokedAdded:   difference(snapshot.revoked, before.revoked),
+				RevokedRemoved: difference(before.revoked, snapshot.revoked),
+			}
+			if bucketDelta.empty() {
+				continue
+			}
+			glog.V(1).Infof("[%s] %d known and %d revoked added, %d known and %d revoked removed", id,
+				len(bucketDelta.KnownAdded), len(bucketDelta.RevokedAdded), len(bucketDelta.KnownRemoved),
+				len(bucketDelta.RevokedRemoved))
+			delta.Buckets = append(delta.Buckets, bucketDelta)
+			issuerID := bucket.Issuer.ID()
+			stash[issuerID] = append(stash[issuerID], bucketDelta.RevokedAdded...)
+		}
+	}
+	sort.Slice(manifest.Buckets, func(i, j int) bool {
+		return manifest.Buckets[i].Bucket < manifest.Buckets[j].Bucket
+	})
+
+	if delta != nil {
+		for id := range previousBuckets {
+			delta.RemovedBuckets = append(delta.RemovedBuckets, id)
+		}
+		sort.Strings(delta.RemovedBuckets)
+		sort.Slice(delta.Buckets, func(i, j int) bool {
+			return delta.Buckets[i].Bucket < delta.Buckets[j].Bucket
+		})
+
+		var stashBuf bytes.Buffer
+		if _, err := stash.WriteTo(&stashBuf); err != nil {
+			return nil, nil, err
+		}
+		if err := g.backend.StoreRunFile(ctx, aRunID, kStashFile, stashBuf.Bytes()); err != nil {
+			return nil, nil, err
+		}
+		for _, serials := range stash {
+			if len(serials) > 0 {
+				manifest.StashIssuers++
+			}
+		}
+		manifest.StashSerials = stash.Serials()
+
+		encoded, err := json.Marshal(delta)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := g.backend.StoreRunFile(ctx, aRunID, kDeltaFile, encoded); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := g.backend.StoreRunFile(ctx, aRunID, kManifestFile, encoded); err != nil {
+		return nil, nil, err
+	}
+	return manifest, delta, nil
+}
@@ -0,0 +1,209 @@
+package crlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+type runHarness struct {
+	t       *testing.T
+	cache   *storage.MockRemoteCache
+	db      storage.CertDatabase
+	backend *storage.MockBackend
+	issuer  storage.Issuer
+}
+
+func makeRunHarness(t *testing.T) *runHarness {
+	cache := storage.NewMockRemoteCache()
+	backend := storage.NewMockBackend()
+	db, err := storage.NewFilesystemDatabase(backend, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("issuer"))
+	issuer := storage.NewIssuerFromString(base64.URLEncoding.EncodeToString(digest[:]))
+	return &runHarness{t, cache, db, backend, issuer}
+}
+
+func (h *runHarness) expDate(aDate string) storage.ExpDate {
+	expDate, err := storage.NewExpDate(aDate)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return expDate
+}
+
+// Stores the bucket's partitions as crl-fetch would, after learning all of
+// their serials.
+func (h *runHarness) bucket(aDate string, aUnrevoked []string, aRevoked []string) {
+	expDate := h.expDate(aDate)
+	known := h.db.GetKnownCertificates(expDate, h.issuer)
+	for _, hex := range append(aUnrevoked, aRevoked...) {
+		if _, err := known.WasUnknown(storage.NewSerialFromHex(hex)); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	err := storage.NewRevocations(expDate, h.issuer, h.cache).Store(serials(aRevoked...), serials(aUnrevoked...))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *runHarness) bucketID(aDate string) string {
+	bucket := storage.IssuerAndDate{ExpDate: h.expDate(aDate), Issuer: h.issuer}
+	return bucket.String()
+}
+
+func serials(aHexes ...string) []storage.Serial {
+	result := []storage.Serial{}
+	for _, hex := range aHexes {
+		result = append(result, storage.NewSerialFromHex(hex))
+	}
+	return result
+}
+
+func Test_GeneratorRuns(t *testing.T) {
+	h := makeRunHarness(t)
+	ctx := context.Background()
+	generator := NewGenerator(h.db, h.cache, h.backend)
+	generator.NotBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	h.bucket("2050-01-01", []string{"02"}, []string{"01"})
+	h.bucket("2040-01-01", []string{"10"}, []string{})
+	h.bucket("2001-01-01", []string{}, []string{"ff"})
+
+	// Serials learned since crl-fetch aren't in the run, and neither are
+	// buckets it never partitioned.
+	if _, err := h.db.GetKnownCertificates(h.expDate("2050-01-01"), h.issuer).WasUnknown(
+		storage.NewSerialFromHex("04")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.db.GetKnownCertificates(h.expDate("2045-01-01"), h.issuer).WasUnknown(
+		storage.NewSerialFromHex("05")); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, delta, err := generator.Run(ctx, "20200101-000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta != nil {
+		t.Errorf("Expected no delta for the first run: %+v", delta)
+	}
+	expectedBuckets := []BucketSummary{
+		{Bucket: h.bucketID("2040-01-01"), Known: 1, Revoked: 0},
+		{Bucket: h.bucketID("2050-01-01"), Known: 2, Revoked: 1},
+	}
+	if !reflect.DeepEqual(manifest.Buckets, expectedBuckets) {
+		t.Errorf("Expected buckets %+v, got %+v", expectedBuckets, manifest.Buckets)
+	}
+	if _, err := h.backend.LoadRunFile(ctx, "20200101-000000", kStashFile); err == nil {
+		t.Error("Expected no stash for the first run")
+	}
+	if _, _, err := generator.Run(ctx, "20200101-000000"); err == nil {
+		t.Error("Expected re-running an existing run to fail")
+	}
+
+	// Serial 03 is learned and revoked along with 02; 01 is no longer
+	// revoked; the second bucket expires.
+	h.bucket("2050-01-01", []string{"01"}, []string{"02", "03"})
+	generator.NotBefore = time.Date(2045, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	manifest, delta, err = generator.Run(ctx, "20200102-000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.PreviousRunID != "20200101-000000" || delta.PreviousRunID != "20200101-000000" {
+		t.Errorf("Expected the first run to be previous: %+v %+v", manifest, delta)
+	}
+	expectedDelta := []BucketDelta{{
+		Bucket:         h.bucketID("2050-01-01"),
+		KnownAdded:     serials("03"),
+		KnownRemoved:   serials(),
+		RevokedAdded:   serials("02", "03"),
+		RevokedRemoved: serials("01"),
+	}}
+	if !reflect.DeepEqual(delta.Buckets, expectedDelta) {
+		t.Errorf("Expected delta %+v, got %+v", expectedDelta, delta.Buckets)
+	}
+	if !reflect.DeepEqual(delta.RemovedBuckets, []string{h.bucketID("2040-01-01")}) {
+		t.Errorf("Expected the expired bucket removed, got %+v", delta.RemovedBuckets)
+	}
+	if manifest.StashIssuers != 1 || manifest.StashSerials != 2 {
+		t.Errorf("Expected a stash of 2 serials of 1 issuer: %+v", manifest)
+	}
+
+	stash, err := LoadStash(ctx, h.backend, "20200102-000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stash, Stash{h.issuer.ID(): serials("02", "03")}) {
+		t.Errorf("Unexpected stash %+v", stash)
+	}
+	stored, err := LoadDelta(ctx, h.backend, "20200102-000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, delta) {
+		t.Errorf("Expected the stored delta %+v, got %+v", delta, stored)
+	}
+
+	// An incomplete run is skipped over, and nothing has changed since
+	if err := h.backend.StoreRunFile(ctx, "20200103-000000", kStashFile, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, delta, err = generator.Run(ctx, "20200104-000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.PreviousRunID != "20200102-000000" {
+		t.Errorf("Expected the last complete run to be previous, got %s", manifest.PreviousRunID)
+	}
+	if len(delta.Buckets) != 0 || len(delta.RemovedBuckets) != 0 || manifest.StashSerials != 0 {
+		t.Errorf("Expected nothing changed: %+v %+v", manifest, delta)
+	}
+
+	// With no issuers enrolled, every bucket is removed
+	generator.Issuers = []string{}
+	manifest, delta, err = generator.Run(ctx, "20200105-000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Buckets) != 0 || !reflect.DeepEqual(delta.RemovedBuckets, []string{h.bucketID("2050-01-01")}) {
+		t.Errorf("Expected only issuers listed to be snapshot: %+v %+v", manifest, delta)
+	}
+}
+
+func Test_BucketSnapshotEncoding(t *testing.T) {
+	snapshot := newBucketSnapshot()
+	for _, serial := range []string{"\x01", "\x00\x80", "\x02"} {
+		snapshot.known[serial] = struct{}{}
+	}
+	snapshot.revoked["\x00\x80"] = struct{}{}
+
+	encoded, err := snapshot.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{kSnapshotVersion, 1, 2, 0x00, 0x80, 0, 1, 0x01, 0, 1, 0x02}
+	if !reflect.DeepEqual(encoded, expected) {
+		t.Errorf("Expected %x, got %x", expected, encoded)
+	}
+
+	decoded, err := decodeBucketSnapshot(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, snapshot) {
+		t.Errorf("Expected %+v decoded, got %+v", snapshot, decoded)
+	}
+	if _, err := decodeBucketSnapshot(encoded[:len(encoded)-1]); err == nil {
+		t.Error("Expected a truncated snapshot to fail")
+	}
+}
@@ -0,0 +1,141 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package crlite
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+const (
+	kSnapshotVersion byte = 1
+
+	kFlagKnown   byte = 0
+	kFlagRevoked byte = 1
+)
+
+type serialSet map[string]struct{}
+
+// A bucket's serials as of a run, and which of them were revoked. The sets
+// hold each serial's BinaryString.
+type bucketSnapshot struct {
+	known   serialSet
+	revoked serialSet
+}
+
+func newBucketSnapshot() *bucketSnapshot {
+	return &bucketSnapshot{
+		known:   serialSet{},
+		revoked: serialSet{},
+	}
+}
+
+func collectSerials(aStream func(chan<- storage.Serial) error) (serialSet, error) {
+	serialChan := make(chan storage.Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- aStream(serialChan)
+	}()
+
+	set := serialSet{}
+	for serial := range serialChan {
+		set[serial.BinaryString()] = struct{}{}
+	}
+	return set, <-errChan
+}
+
+// Reads the revocations crl-fetch last stored for the bucket, the same
+// partitions a filter is built from, so its serials are those revoked or
+// unrevoked then.
+func loadBucketSnapshot(aCache storage.RemoteCache, aBucket storage.IssuerAndDate) (*bucketSnapshot, error) {
+	revocations := storage.NewRevocations(aBucket.ExpDate, aBucket.Issuer, aCache)
+	known, err := collectSerials(revocations.StreamUnrevoked)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := collectSerials(revocations.StreamRevoked)
+	if err != nil {
+		return nil, err
+	}
+	for serial := range revoked {
+		known[serial] = struct{}{}
+	}
+	return &bucketSnapshot{known: known, revoked: revoked}, nil
+}
+
+// A version byte, then for each known serial in order a flag byte, which is
+// 1 if it's revoked, its length byte and its bytes.
+func (s *bucketSnapshot) encode() ([]byte, error) {
+	serials := make([]string, 0, len(s.known))
+	size := 1
+	for serial := range s.known {
+		if len(serial) > 255 {
+			return nil, fmt.Errorf("Serial %x is too long to snapshot", serial)
+		}
+		serials = append(serials, serial)
+		size += 2 + len(serial)
+	}
+	sort.Strings(serials)
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, kSnapshotVersion)
+	for _, serial := range serials {
+		flag := kFlagKnown
+		if _, ok := s.revoked[serial]; ok {
+			flag = kFlagRevoked
+		}
+		buf = append(buf, flag, byte(len(serial)))
+		buf = append(buf, serial...)
+	}
+	return buf, nil
+}
+
+func decodeBucketSnapshot(aData []byte) (*bucketSnapshot, error) {
+	if len(aData) == 0 || aData[0] != kSnapshotVersion {
+		return nil, fmt.Errorf("Unknown snapshot version")
+	}
+	s := newBucketSnapshot()
+	for pos := 1; pos < len(aData); {
+		if pos+2 > len(aData) {
+			return nil, fmt.Errorf("Snapshot truncated at byte %d", pos)
+		}
+		flag, length := aData[pos], int(aData[pos+1])
+		pos += 2
+		if pos+length > len(aData) {
+			return nil, fmt.Errorf("Snapshot truncated at byte %d", pos)
+		}
+		serial := string(aData[pos : pos+length])
+		pos += length
+
+		s.known[serial] = struct{}{}
+		switch flag {
+		case kFlagKnown:
+		case kFlagRevoked:
+			s.revoked[serial] = struct{}{}
+		default:
+			return nil, fmt.Errorf("Unknown snapshot flag %d", flag)
+		}
+	}
+	return s, nil
+}
+
+// The serials of aSet missing from aOther, in order.
+func difference(aSet serialSet, aOther serialSet) []storage.Serial {
+	serials := []string{}
+	for serial := range aSet {
+		if _, ok := aOther[serial]; !ok {
+			serials = append(serials, serial)
+		}
+	}
+	sort.Strings(serials)
+
+	result := make([]storage.Serial, len(serials))
+	for i, serial := range serials {
+		result[i] = storage.NewSerialFromBytes([]byte(serial))
+	}
+	return result
+}
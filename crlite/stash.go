@@ -0,0 +1,114 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package crlite
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+// A Stash is the serials newly revoked since the previous run, keyed by
+// issuer ID. Clients apply it on top of that run's filter.
+type Stash map[string][]storage.Serial
+
+// The number of serials in the stash.
+func (s Stash) Serials() int {
+	count := 0
+	for _, serials := range s {
+		count += len(serials)
+	}
+	return count
+}
+
+// Writes the stash in the format CRLite clients read: for each issuer, a
+// little-endian uint32 number of serials, a uint8 key length and the SHA-256
+// digest of its SPKI, then each serial's uint8 length and bytes.
+func (s Stash) WriteTo(aWriter io.Writer) (int64, error) {
+	issuers := make([]string, 0, len(s))
+	for issuer, serials := range s {
+		if len(serials) > 0 {
+			issuers = append(issuers, issuer)
+		}
+	}
+	sort.Strings(issuers)
+
+	w := bufio.NewWriter(aWriter)
+	var written int64
+	write := func(aData []byte) error {
+		n, err := w.Write(aData)
+		written += int64(n)
+		return err
+	}
+
+	for _, issuer := range issuers {
+		key, err := base64.URLEncoding.DecodeString(issuer)
+		if err != nil {
+			return written, fmt.Errorf("Issuer ID %s isn't a digest: %v", issuer, err)
+		}
+		serials := make([]string, len(s[issuer]))
+		for i, serial := range s[issuer] {
+			serials[i] = serial.BinaryString()
+		}
+		sort.Strings(serials)
+
+		header := make([]byte, 5, 5+len(key))
+		binary.LittleEndian.PutUint32(header, uint32(len(serials)))
+		header[4] = byte(len(key))
+		if err := write(append(header, key...)); err != nil {
+			return written, err
+		}
+		for _, serial := range serials {
+			if len(serial) > 255 {
+				return written, fmt.Errorf("Serial %x is too long to stash", serial)
+			}
+			if err := write(append([]byte{byte(len(serial))}, serial...)); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, w.Flush()
+}
+
+// Reads a stash written by WriteTo.
+func ReadStash(aReader io.Reader) (Stash, error) {
+	r := bufio.NewReader(aReader)
+	stash := Stash{}
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return stash, nil
+			}
+			return nil, err
+		}
+		count := binary.LittleEndian.Uint32(header)
+		key := make([]byte, header[4])
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, err
+		}
+		issuer := base64.URLEncoding.EncodeToString(key)
+
+		// count isn't trusted to size anything
+		serials := []storage.Serial{}
+		for i := uint32(0); i < count; i++ {
+			length, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			serial := make([]byte, length)
+			if _, err := io.ReadFull(r, serial); err != nil {
+				return nil, err
+			}
+			serials = append(serials, storage.NewSerialFromBytes(serial))
+		}
+		stash[issuer] = append(stash[issuer], serials...)
+	}
+}
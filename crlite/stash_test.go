@@ -0,0 +1,56 @@
+package crlite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+func Test_StashFormat(t *testing.T) {
+	digest := sha256.Sum256([]byte("issuer"))
+	issuer := base64.URLEncoding.EncodeToString(digest[:])
+	stash := Stash{
+		issuer: []storage.Serial{storage.NewSerialFromHex("00ff"), storage.NewSerialFromHex("01")},
+		"none": []storage.Serial{},
+	}
+
+	var buf bytes.Buffer
+	written, err := stash.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(buf.Len()) {
+		t.Errorf("Reported %d bytes written, but wrote %d", written, buf.Len())
+	}
+
+	expected := []byte{2, 0, 0, 0, 32}
+	expected = append(expected, digest[:]...)
+	expected = append(expected, 2, 0x00, 0xff, 1, 0x01)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Expected %x, got %x", expected, buf.Bytes())
+	}
+
+	read, err := ReadStash(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, Stash{issuer: stash[issuer]}) {
+		t.Errorf("Expected %+v read back, got %+v", stash, read)
+	}
+
+	if _, err := ReadStash(bytes.NewReader(expected[:len(expected)-1])); err == nil {
+		t.Error("Expected a truncated stash to fail")
+	}
+	// A corrupt count mustn't be trusted
+	huge := append([]byte{0xff, 0xff, 0xff, 0xff, 32}, digest[:]...)
+	if _, err := ReadStash(bytes.NewReader(huge)); err == nil {
+		t.Error("Expected a stash missing its serials to fail")
+	}
+	if _, err := (Stash{"not a digest!": stash[issuer]}).WriteTo(&buf); err == nil {
+		t.Error("Expected an issuer ID which isn't a digest to fail")
+	}
+}
@@ -0,0 +1,155 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package crls
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+)
+
+// What's remembered of each download, to make the next one conditional.
+type cachedResponse struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"lastModified"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// Fetcher downloads URLs into a directory, and downloads them again only if
+// the server says they've changed.
+type Fetcher struct {
+	dir    string
+	Client *http.Client
+}
+
+func NewFetcher(aDir string) (*Fetcher, error) {
+	if err := os.MkdirAll(aDir, 0755); err != nil {
+		return nil, err
+	}
+	return &Fetcher{
+		dir:    aDir,
+		Client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (f *Fetcher) path(aURL string) string {
+	digest := sha256.Sum256([]byte(aURL))
+	return filepath.Join(f.dir, hex.EncodeToString(digest[:]))
+}
+
+func (f *Fetcher) loadCached(aURL string) (*cachedResponse, []byte) {
+	metaBytes, err := ioutil.ReadFile(f.path(aURL) + ".json")
+	if err != nil {
+		return nil, nil
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(metaBytes, &cached); err != nil || cached.URL != aURL {
+		return nil, nil
+	}
+	body, err := ioutil.ReadFile(f.path(aURL))
+	if err != nil {
+		return nil, nil
+	}
+	return &cached, body
+}
+
+// Writes to a temporary file renamed into place, so a failed download
+// leaves the last good one.
+func (f *Fetcher) writeFile(aPath string, aWrite func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(f.dir, filepath.Base(aPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := aWrite(tmp); err != nil {
+		tmp.Close()           // ignore error
+		os.Remove(tmp.Name()) // ignore error
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name()) // ignore error
+		return err
+	}
+	return os.Rename(tmp.Name(), aPath)
+}
+
+// Returns aURL's content, and whether it was downloaded rather than found
+// unchanged in the directory.
+func (f *Fetcher) Fetch(ctx context.Context, aURL string) ([]byte, bool, error) {
+	defer metrics.MeasureSince([]string{"Fetcher", "Fetch"}, time.Now())
+
+	req, err := http.NewRequest("GET", aURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req = req.WithContext(ctx)
+
+	cached, body := f.loadCached(aURL)
+	if cached != nil {
+		if len(cached.ETag) > 0 {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if len(cached.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		metrics.IncrCounter([]string{"Fetcher", "Fetch", "error"}, 1)
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		glog.V(1).Infof("[%s] Not modified since %s", aURL, cached.Fetched)
+		metrics.IncrCounter([]string{"Fetcher", "Fetch", "notModified"}, 1)
+		return body, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		metrics.IncrCounter([]string{"Fetcher", "Fetch", "error"}, 1)
+		return nil, false, fmt.Errorf("Fetching %s: %s", aURL, resp.Status)
+	}
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		metrics.IncrCounter([]string{"Fetcher", "Fetch", "error"}, 1)
+		return nil, false, err
+	}
+
+	err = f.writeFile(f.path(aURL), func(w io.Writer) error {
+		_, err := w.Write(body)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	err = f.writeFile(f.path(aURL)+".json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(cachedResponse{
+			URL:          aURL,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Fetched:      time.Now().UTC(),
+		})
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	glog.V(1).Infof("[%s] Downloaded %d bytes", aURL, len(body))
+	metrics.IncrCounter([]string{"Fetcher", "Fetch", "downloaded"}, 1)
+	return body, true, nil
+}
@@ -0,0 +1,101 @@
+package crls
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func newTestFetcher(t *testing.T) (*Fetcher, func()) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher, err := NewFetcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetcher, func() { os.RemoveAll(dir) }
+}
+
+func Test_FetcherConditional(t *testing.T) {
+	fetcher, cleanup := newTestFetcher(t)
+	defer cleanup()
+
+	content := "first"
+	lastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	var requests, conditional int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	expect := func(aContent string, aDownloaded bool) {
+		body, downloaded, err := fetcher.Fetch(context.Background(), server.URL+"/crl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != aContent || downloaded != aDownloaded {
+			t.Errorf("Expected %q downloaded=%v, got %q downloaded=%v", aContent, aDownloaded, body, downloaded)
+		}
+	}
+
+	expect("first", true)
+	expect("first", false)
+	if requests != 2 || conditional != 1 {
+		t.Errorf("Expected the second request to be conditional, got %d of %d", conditional, requests)
+	}
+
+	content = "second"
+	lastModified = "Tue, 03 Jan 2006 15:04:05 GMT"
+	expect("second", true)
+	expect("second", false)
+}
+
+func Test_FetcherError(t *testing.T) {
+	fetcher, cleanup := newTestFetcher(t)
+	defer cleanup()
+
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("crl"))
+	}))
+	defer server.Close()
+
+	if _, _, err := fetcher.Fetch(context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+	failing = true
+	if _, _, err := fetcher.Fetch(context.Background(), server.URL); err == nil {
+		t.Error("Expected an error when the server fails")
+	}
+
+	// The last good download is still cached
+	failing = false
+	body, downloaded, err := fetcher.Fetch(context.Background(), server.URL)
+	if err != nil || string(body) != "crl" {
+		t.Errorf("Expected the cached CRL, got %q: %v", body, err)
+	}
+	if downloaded {
+		t.Error("Expected the cached CRL to be current")
+	}
+}
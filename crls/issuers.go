@@ -0,0 +1,73 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package crls
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+// IssuerSource finds an issuer's certificate, to check its CRLs' signatures.
+type IssuerSource interface {
+	Certificate(ctx context.Context, aIssuer storage.Issuer) (*x509.Certificate, error)
+}
+
+func parseCertificate(aBytes []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(aBytes); block != nil {
+		aBytes = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(aBytes)
+	if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// AIAIssuers downloads issuer certificates from the AIA caIssuers URLs named
+// by their certificates, most named first, taking the first with the
+// issuer's key.
+type AIAIssuers struct {
+	db      storage.CertDatabase
+	fetcher *Fetcher
+}
+
+func NewAIAIssuers(aDB storage.CertDatabase, aFetcher *Fetcher) *AIAIssuers {
+	return &AIAIssuers{
+		db:      aDB,
+		fetcher: aFetcher,
+	}
+}
+
+func (a *AIAIssuers) Certificate(ctx context.Context, aIssuer storage.Issuer) (*x509.Certificate, error) {
+	sightings := a.db.GetIssuerMetadata(aIssuer).IssuerURLs()
+	sort.Slice(sightings, func(i, j int) bool {
+		return sightings[i].Count > sightings[j].Count
+	})
+
+	for _, sighting := range sightings {
+		body, _, err := a.fetcher.Fetch(ctx, sighting.URL)
+		if err != nil {
+			glog.Warningf("[%s] Couldn't fetch issuer from %s: %v", aIssuer.ID(), sighting.URL, err)
+			continue
+		}
+		cert, err := parseCertificate(body)
+		if err != nil {
+			glog.Warningf("[%s] Couldn't parse issuer from %s: %v", aIssuer.ID(), sighting.URL, err)
+			continue
+		}
+		if candidate := storage.NewIssuer(cert); candidate.ID() != aIssuer.ID() {
+			glog.Warningf("[%s] %s is a different issuer", aIssuer.ID(), sighting.URL)
+			continue
+		}
+		return cert, nil
+	}
+	return nil, fmt.Errorf("No certificate for issuer %s among %d AIA URLs", aIssuer.ID(), len(sightings))
+}
@@ -0,0 +1,255 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package crls
+
+import (
+	"context"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+// Parsing the CRL's serials as integers would lose any leading zeroes they
+// were encoded with, but they must match the serials as stored.
+type revokedWithRawSerial struct {
+	SerialNumber asn1.RawValue
+}
+
+type tbsCertListWithRawSerials struct {
+	Raw                 asn1.RawContent
+	Version             int `asn1:"optional,default:0"`
+	Signature           asn1.RawValue
+	Issuer              asn1.RawValue
+	ThisUpdate          time.Time
+	NextUpdate          time.Time              `asn1:"optional"`
+	RevokedCertificates []revokedWithRawSerial `asn1:"optional"`
+}
+
+func revokedSerials(aCRL *pkix.CertificateList) ([]storage.Serial, error) {
+	var tbs tbsCertListWithRawSerials
+	if _, err := asn1.Unmarshal(aCRL.TBSCertList.Raw, &tbs); err != nil {
+		return nil, err
+	}
+	serials := make([]storage.Serial, 0, len(tbs.RevokedCertificates))
+	for _, revoked := range tbs.RevokedCertificates {
+		serials = append(serials, storage.NewSerialFromBytes(revoked.SerialNumber.Bytes))
+	}
+	return serials, nil
+}
+
+type IssuerResult struct {
+	Issuer string `json:"issuer"`
+	CRLs   int    `json:"crls"`
+	// CRLs which changed since last fetched
+	Downloaded int `json:"downloaded"`
+	// Entries on the CRLs, and how many known serials are and aren't among
+	// them
+	CRLEntries int64 `json:"crlEntries"`
+	Revoked    int64 `json:"revoked"`
+	Unrevoked  int64 `json:"unrevoked"`
+	Buckets    int   `json:"buckets"`
+	// Why this issuer's revocations weren't updated, if they weren't
+	Error string `json:"error,omitempty"`
+}
+
+type UpdateReport struct {
+	Issuers []IssuerResult `json:"issuers"`
+	// Issuers without any CRLs, which are left alone
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// Updater fetches each issuer's CRLs, checks they're signed by the issuer,
+// and stores the partition of each of its buckets' known serials into those
+// the CRLs revoke and those they don't. An issuer's revocations are only
+// updated when every one of its CRLs is fetched and valid. NumWorkers
+// issuers are updated at once.
+type Updater struct {
+	db      storage.CertDatabase
+	cache   storage.RemoteCache
+	fetcher *Fetcher
+	issuers IssuerSource
+
+	NumWorkers int
+	// Only buckets unexpired at NotBefore are partitioned, when set
+	NotBefore time.Time
+}
+
+func NewUpdater(aDB storage.CertDatabase, aCache storage.RemoteCache, aFetcher *Fetcher,
+	aIssuers IssuerSource) *Updater {
+	return &Updater{
+		db:         aDB,
+		cache:      aCache,
+		fetcher:    aFetcher,
+		issuers:    aIssuers,
+		NumWorkers: 1,
+	}
+}
+
+func (u *Updater) fetchCRL(ctx context.Context, aURL string, aIssuerCert *x509.Certificate,
+	aResult *IssuerResult) ([]storage.Serial, error) {
+	body, downloaded, err := u.fetcher.Fetch(ctx, aURL)
+	if err != nil {
+		return nil, err
+	}
+	if downloaded {
+		aResult.Downloaded++
+	}
+
+	crl, err := x509.ParseCRL(body)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse CRL %s: %v", aURL, err)
+	}
+	if err := aIssuerCert.CheckCRLSignature(crl); err != nil {
+		return nil, fmt.Errorf("CRL %s isn't signed by its issuer: %v", aURL, err)
+	}
+	if crl.HasExpired(time.Now()) {
+		return nil, fmt.Errorf("CRL %s expired at %s", aURL, crl.TBSCertList.NextUpdate)
+	}
+	return revokedSerials(crl)
+}
+
+func (u *Updater) partition(aBucket storage.IssuerAndDate, aRevoked map[string]struct{},
+	aResult *IssuerResult) error {
+	revoked := []storage.Serial{}
+	unrevoked := []storage.Serial{}
+
+	serialChan := make(chan storage.Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- u.db.GetKnownCertificates(aBucket.ExpDate, aBucket.Issuer).StreamKnown(serialChan)
+	}()
+	for serial := range serialChan {
+		if _, ok := aRevoked[serial.BinaryString()]; ok {
+			revoked = append(revoked, serial)
+		} else {
+			unrevoked = append(unrevoked, serial)
+		}
+	}
+	if err := <-errChan; err != nil {
+		return err
+	}
+
+	err := storage.NewRevocations(aBucket.ExpDate, aBucket.Issuer, u.cache).Store(revoked, unrevoked)
+	if err != nil {
+		return err
+	}
+	aResult.Revoked += int64(len(revoked))
+	aResult.Unrevoked += int64(len(unrevoked))
+	aResult.Buckets++
+	return nil
+}
+
+func (u *Updater) update(ctx context.Context, aIssuerObj storage.IssuerDate, aResult *IssuerResult) error {
+	crlURLs := u.db.GetIssuerMetadata(aIssuerObj.Issuer).CRLs()
+	aResult.CRLs = len(crlURLs)
+	if len(crlURLs) == 0 {
+		return nil
+	}
+
+	issuerCert, err := u.issuers.Certificate(ctx, aIssuerObj.Issuer)
+	if err != nil {
+		return err
+	}
+
+	revoked := make(map[string]struct{})
+	for _, crlURL := range crlURLs {
+		serials, err := u.fetchCRL(ctx, crlURL, issuerCert, aResult)
+		if err != nil {
+			return err
+		}
+		for _, serial := range serials {
+			revoked[serial.BinaryString()] = struct{}{}
+		}
+	}
+	aResult.CRLEntries = int64(len(revoked))
+
+	for _, expDate := range aIssuerObj.ExpDates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !u.NotBefore.IsZero() && expDate.IsExpiredAt(u.NotBefore) {
+			continue
+		}
+		bucket := storage.IssuerAndDate{ExpDate: expDate, Issuer: aIssuerObj.Issuer}
+		if err := u.partition(bucket, revoked, aResult); err != nil {
+			return fmt.Errorf("Couldn't partition %s: %v", bucket.String(), err)
+		}
+	}
+	return nil
+}
+
+func (u *Updater) updateIssuer(ctx context.Context, aIssuerObj storage.IssuerDate) IssuerResult {
+	defer metrics.MeasureSince([]string{"Updater", "updateIssuer"}, time.Now())
+	result := IssuerResult{Issuer: aIssuerObj.Issuer.ID()}
+	if err := u.update(ctx, aIssuerObj, &result); err != nil {
+		glog.Warningf("[%s] Revocations not updated: %v", result.Issuer, err)
+		metrics.IncrCounter([]string{"Updater", "updateIssuer", "error"}, 1)
+		result.Error = err.Error()
+		return result
+	}
+	glog.V(1).Infof("[%s] %d of %d known serials revoked by %d CRLs", result.Issuer, result.Revoked,
+		result.Revoked+result.Unrevoked, result.CRLs)
+	return result
+}
+
+// Updates every issuer's revocations, reporting on each. Failing issuers
+// don't stop the rest.
+func (u *Updater) Update(ctx context.Context) (*UpdateReport, error) {
+	issuerList, err := u.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+
+	issuerChan := make(chan storage.IssuerDate, len(issuerList))
+	for _, issuerObj := range issuerList {
+		issuerChan <- issuerObj
+	}
+	close(issuerChan)
+
+	resultChan := make(chan IssuerResult)
+	workers := u.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for issuerObj := range issuerChan {
+				resultChan <- u.updateIssuer(ctx, issuerObj)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	report := &UpdateReport{
+		Issuers: make([]IssuerResult, 0, len(issuerList)),
+	}
+	for result := range resultChan {
+		if len(result.Error) > 0 {
+			report.Failed++
+		} else if result.CRLs == 0 {
+			report.Skipped++
+		}
+		report.Issuers = append(report.Issuers, result)
+	}
+	sort.Slice(report.Issuers, func(i, j int) bool {
+		return report.Issuers[i].Issuer < report.Issuers[j].Issuer
+	})
+	return report, ctx.Err()
+}
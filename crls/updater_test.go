@@ -0,0 +1,217 @@
+package crls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	ctpkix "github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+type testCA struct {
+	cert *ctx509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, aName string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: aName},
+		NotBefore:             time.Now().AddDate(-1, 0, 0),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ctx509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert, key}
+}
+
+func (ca *testCA) issue(t *testing.T, aSerial int64, aNotAfter time.Time, aCRL string,
+	aAIA string) *ctx509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(aSerial),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		NotBefore:             time.Now().AddDate(-1, 0, 0),
+		NotAfter:              aNotAfter,
+		IssuingCertificateURL: []string{aAIA},
+	}
+	if len(aCRL) > 0 {
+		template.CRLDistributionPoints = []string{aCRL}
+	}
+	caCert, err := x509.ParseCertificate(ca.cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ctx509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (ca *testCA) crl(t *testing.T, aSerials ...int64) []byte {
+	revoked := []ctpkix.RevokedCertificate{}
+	for _, serial := range aSerials {
+		revoked = append(revoked, ctpkix.RevokedCertificate{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := ca.cert.CreateCRL(rand.Reader, ca.key, revoked, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func expectCounts(t *testing.T, aCache storage.RemoteCache, aBucket storage.IssuerAndDate,
+	aRevoked int64, aUnrevoked int64) {
+	revoked, unrevoked, err := storage.NewRevocations(aBucket.ExpDate, aBucket.Issuer, aCache).Counts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != aRevoked || unrevoked != aUnrevoked {
+		t.Errorf("[%s] Expected %d revoked and %d unrevoked, got %d and %d", aBucket.String(), aRevoked,
+			aUnrevoked, revoked, unrevoked)
+	}
+}
+
+func Test_Updater(t *testing.T) {
+	ca := newTestCA(t, "Revoking CA")
+	crlBytes := ca.crl(t, 0x80, 3, 99)
+
+	var crlRequests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ca.crt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(ca.cert.Raw)
+	})
+	mux.HandleFunc("/ca.crl", func(w http.ResponseWriter, r *http.Request) {
+		crlRequests++
+		w.Header().Set("ETag", `"crl"`)
+		if r.Header.Get("If-None-Match") == `"crl"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(crlBytes)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cache := storage.NewMockRemoteCache()
+	storageDB, err := storage.NewFilesystemDatabase(storage.NewMockBackend(), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	soon := time.Now().AddDate(0, 1, 0)
+	later := time.Now().AddDate(0, 6, 0)
+	// 0x80 is encoded with a leading zero, which must survive the CRL
+	for _, leaf := range []struct {
+		serial   int64
+		notAfter time.Time
+	}{{1, soon}, {0x80, soon}, {3, later}} {
+		cert := ca.issue(t, leaf.serial, leaf.notAfter, server.URL+"/ca.crl", server.URL+"/ca.crt")
+		if err := storageDB.Store(cert, ca.cert, "log.ct", leaf.serial); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	noCRLs := newTestCA(t, "CRL-less CA")
+	cert := noCRLs.issue(t, 1, soon, "", server.URL+"/nothing.crt")
+	if err := storageDB.Store(cert, noCRLs.cert, "log.ct", 4); err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher, cleanup := newTestFetcher(t)
+	defer cleanup()
+	updater := NewUpdater(storageDB, cache, fetcher, NewAIAIssuers(storageDB, fetcher))
+	updater.NumWorkers = 2
+
+	report, err := updater.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issuers) != 2 || report.Skipped != 1 || report.Failed != 0 {
+		t.Fatalf("Expected one issuer updated and one skipped: %+v", report)
+	}
+
+	issuer := storage.NewIssuer(ca.cert)
+	var result IssuerResult
+	for _, r := range report.Issuers {
+		if r.Issuer == issuer.ID() {
+			result = r
+		}
+	}
+	if result.CRLs != 1 || result.Downloaded != 1 || result.CRLEntries != 3 || result.Revoked != 2 ||
+		result.Unrevoked != 1 || result.Buckets != 2 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	bucketOf := func(aNotAfter time.Time) storage.IssuerAndDate {
+		return storage.IssuerAndDate{
+			ExpDate: storage.NewExpDateFromTime(aNotAfter),
+			Issuer:  issuer,
+		}
+	}
+	expectCounts(t, cache, bucketOf(soon), 1, 1)
+	expectCounts(t, cache, bucketOf(later), 1, 0)
+
+	// Unchanged CRLs aren't downloaded again
+	report, err = updater.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crlRequests != 2 || report.Failed != 0 {
+		t.Errorf("Expected a second, conditional request: %d requests, %+v", crlRequests, report)
+	}
+	for _, r := range report.Issuers {
+		if r.Downloaded != 0 {
+			t.Errorf("Expected nothing downloaded: %+v", r)
+		}
+	}
+
+	// A CRL signed by someone else is rejected, leaving the partitions be
+	crlBytes = newTestCA(t, "Impostor").crl(t, 1)
+	server.CloseClientConnections()
+	fetcher, cleanupImpostor := newTestFetcher(t)
+	defer cleanupImpostor()
+	report, err = NewUpdater(storageDB, cache, fetcher, NewAIAIssuers(storageDB, fetcher)).Update(
+		context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 1 {
+		t.Errorf("Expected the forged CRL to fail: %+v", report)
+	}
+	expectCounts(t, cache, bucketOf(soon), 1, 1)
+}
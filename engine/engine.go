@@ -7,11 +7,14 @@ package engine
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/armon/go-metrics"
 	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
 	"github.com/jcjones/ct-mapreduce/config"
+	"github.com/jcjones/ct-mapreduce/crlite"
 	"github.com/jcjones/ct-mapreduce/storage"
 	"github.com/jcjones/ct-mapreduce/telemetry"
 )
@@ -39,14 +42,100 @@ func GetConfiguredStorage(ctx context.Context, ctconfig *config.CTConfig) (stora
 		backend = storage.NewNoopBackend()
 	}
 
-	storageDB, err = storage.NewFilesystemDatabase(backend, remoteCache)
+	fsDB, err := storage.NewFilesystemDatabase(backend, remoteCache)
 	if err != nil {
 		glog.Fatalf("Unable to construct cache-only DB: %v", err)
 	}
+	serialEncoding, err := storage.ParseSerialEncoding(*ctconfig.SerialEncoding)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	fsDB.SetSerialEncoding(serialEncoding)
+	granularity, err := storage.ParseBucketGranularity(*ctconfig.BucketGranularity)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	fsDB.SetBucketGranularity(granularity)
+	if *ctconfig.SerialFilterRate > 0 {
+		glog.Infof("Filtering known serials with a false positive rate of %g", *ctconfig.SerialFilterRate)
+		fsDB.EnableSerialFilter(*ctconfig.SerialFilterRate)
+		if *ctconfig.ChangeFeedMaxLen <= 0 {
+			glog.Warningf("serialFilterRate only saves lookups for the change feed, which is disabled")
+		}
+	}
+	if *ctconfig.ChangeFeedMaxLen > 0 {
+		glog.Infof("Publishing new certificates to a change feed of about %d entries",
+			*ctconfig.ChangeFeedMaxLen)
+		fsDB.EnableChangeFeed(int64(*ctconfig.ChangeFeedMaxLen))
+	}
+	storageDB = fsDB
 
 	return storageDB, remoteCache, backend
 }
 
+func GetGCPolicy(ctconfig *config.CTConfig) storage.GCPolicy {
+	grace, err := time.ParseDuration(*ctconfig.GCGracePeriod)
+	if err != nil {
+		glog.Fatalf("Could not parse GCGracePeriod: %v", err)
+	}
+
+	policy := storage.GCPolicy{Grace: grace}
+	if len(*ctconfig.LogStateRetention) > 0 {
+		policy.LogRetention, err = time.ParseDuration(*ctconfig.LogStateRetention)
+		if err != nil {
+			glog.Fatalf("Could not parse LogStateRetention: %v", err)
+		}
+	}
+	return policy
+}
+
+func splitIssuerIDs(aList string) []string {
+	ids := []string{}
+	for _, id := range strings.Split(aList, ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// An Enroller using the enrollAllow and enrollDeny lists.
+func GetEnroller(aDB storage.CertDatabase, aCache storage.RemoteCache, aBackend storage.StorageBackend,
+	ctconfig *config.CTConfig) *crlite.Enroller {
+	enroller := crlite.NewEnroller(aDB, aCache, aBackend)
+	enroller.Allow = splitIssuerIDs(*ctconfig.EnrollAllow)
+	enroller.Deny = splitIssuerIDs(*ctconfig.EnrollDeny)
+	return enroller
+}
+
+// Whether aCert should be skipped: CA certificates, expired certificates
+// unless logExpiredEntries, and those not matching issuerCNFilter.
+func CertIsFilteredOut(aCert *x509.Certificate, ctconfig *config.CTConfig) bool {
+	if aCert.BasicConstraintsValid && aCert.IsCA {
+		metrics.IncrCounter([]string{"certIsFilteredOut", "CA"}, 1)
+		return true
+	}
+
+	if aCert.NotAfter.Before(time.Now()) && !*ctconfig.LogExpiredEntries {
+		metrics.IncrCounter([]string{"certIsFilteredOut", "expired"}, 1)
+		return true
+	}
+
+	skip := (len(*ctconfig.IssuerCNFilter) != 0)
+	for _, filter := range strings.Split(*ctconfig.IssuerCNFilter, ",") {
+		if strings.HasPrefix(aCert.Issuer.CommonName, filter) {
+			skip = false
+			break
+		}
+	}
+
+	if skip {
+		metrics.IncrCounter([]string{"certIsFilteredOut", "cn-filtered"}, 1)
+		glog.V(4).Infof("Skipping inserting cert issued by %s", aCert.Issuer.CommonName)
+	}
+	return skip
+}
+
 func PrepareTelemetry(utilName string, ctconfig *config.CTConfig) {
 	metricsConf := metrics.DefaultConfig(utilName)
 	metricsConf.EnableRuntimeMetrics = false
@@ -0,0 +1,475 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/coordinator"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+const kJobDoneKey string = "mapreduce-done-"
+const kJobOutputKey string = "mapreduce-output-"
+
+// What a Mapper sees of one bucket: its issuer and expiration date, and each of
+// its known serials, once.
+type MapInput struct {
+	storage.IssuerAndDate
+	Serials <-chan storage.Serial
+	ctx     context.Context
+	backend storage.StorageBackend
+}
+
+// Loads a serial's certificate from the storage backend, for mappers that need
+// more than serials. Fails unless certPath is configured.
+func (in *MapInput) Certificate(aSerial storage.Serial) (*x509.Certificate, error) {
+	pemBytes, err := in.backend.LoadCertificatePEM(in.ctx, aSerial, in.ExpDate, in.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM block for serial %s", aSerial.HexString())
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+type EmitFunc func(aKey string, aValue string)
+
+type Mapper interface {
+	// Emits any number of key/value pairs for aInput's bucket. It needn't
+	// read all of aInput.Serials.
+	Map(aInput *MapInput, aEmit EmitFunc) error
+}
+
+type MapperFunc func(*MapInput, EmitFunc) error
+
+func (f MapperFunc) Map(aInput *MapInput, aEmit EmitFunc) error {
+	return f(aInput, aEmit)
+}
+
+type Reducer interface {
+	// Emits the results for one key, given every value mapped to it.
+	Reduce(aKey string, aValues []string, aEmit EmitFunc) error
+}
+
+type ReducerFunc func(string, []string, EmitFunc) error
+
+func (f ReducerFunc) Reduce(aKey string, aValues []string, aEmit EmitFunc) error {
+	return f(aKey, aValues, aEmit)
+}
+
+// A Sink receives a job's results, in key order.
+type Sink interface {
+	Write(aKey string, aValue string) error
+	Close() error
+}
+
+// Writes results as tab-separated lines.
+type TextSink struct {
+	writer *bufio.Writer
+}
+
+func NewTextSink(aWriter io.Writer) *TextSink {
+	return &TextSink{bufio.NewWriter(aWriter)}
+}
+
+func (s *TextSink) Write(aKey string, aValue string) error {
+	_, err := fmt.Fprintf(s.writer, "%s\t%s\n", aKey, aValue)
+	return err
+}
+
+func (s *TextSink) Close() error {
+	return s.writer.Flush()
+}
+
+type Job struct {
+	// Identifies the job's queue and checkpoints in Redis
+	Name    string
+	Mapper  Mapper
+	Reducer Reducer
+	Sink    Sink
+	// Only buckets expiring at or after this are mapped
+	NotBefore time.Time
+}
+
+type mappedPair struct {
+	Key   string `json:"k"`
+	Value string `json:"v"`
+}
+
+// A JobRunner takes part in running a Job alongside any other processes
+// running the same job. One is elected leader, queues every bucket not yet
+// mapped, and once all are mapped, reduces their output into the job's Sink.
+// The rest, and the leader, lease buckets from the queue and map them.
+//
+// Each bucket's output is checkpointed in Redis, so a job that's interrupted
+// resumes where it left off when run again, and a finished job run again only
+// reduces. Reset discards the checkpoints.
+type JobRunner struct {
+	db          storage.CertDatabase
+	cache       storage.RemoteCache
+	backend     storage.StorageBackend
+	job         Job
+	coordinator coordinator.Coordinator
+	queue       *coordinator.WorkQueue
+
+	// Buckets mapped concurrently by this process
+	Threads int
+}
+
+func NewJobRunner(aDB storage.CertDatabase, aCache storage.RemoteCache, aBackend storage.StorageBackend,
+	aJob Job) (*JobRunner, error) {
+	if aJob.Mapper == nil || aJob.Sink == nil {
+		return nil, fmt.Errorf("Job %s needs a Mapper and a Sink", aJob.Name)
+	}
+	queue, err := coordinator.NewWorkQueue(aCache, "mapreduce-"+aJob.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &JobRunner{
+		db:          aDB,
+		cache:       aCache,
+		backend:     aBackend,
+		job:         aJob,
+		coordinator: coordinator.NewCoordinator(aCache, "mapreduce-"+aJob.Name),
+		queue:       queue,
+		Threads:     1,
+	}, nil
+}
+
+// The job's queue, to tune its timeouts.
+func (jr *JobRunner) Queue() *coordinator.WorkQueue {
+	return jr.queue
+}
+
+func (jr *JobRunner) doneKey() string {
+	return kJobDoneKey + jr.job.Name
+}
+
+func (jr *JobRunner) outputKey(aBucket string) string {
+	return kJobOutputKey + jr.job.Name + "-" + aBucket
+}
+
+// Discards the job's checkpoints and queue, so it next runs from scratch.
+func (jr *JobRunner) Reset() error {
+	if err := jr.queue.Clear(); err != nil {
+		return err
+	}
+	buckets, err := jr.cache.SetList(jr.doneKey())
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		if err := jr.cache.Delete(jr.outputKey(bucket)); err != nil {
+			return err
+		}
+	}
+	return jr.cache.Delete(jr.doneKey())
+}
+
+// Runs the job to completion. Only the leader's Run writes to the Sink.
+func (jr *JobRunner) Run(ctx context.Context) error {
+	lead, err := jr.coordinator.AwaitLeaderContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if lead {
+		defer func() {
+			if err := jr.coordinator.Resign(); err != nil {
+				glog.Warningf("[%s] Couldn't resign leadership: %v", jr.job.Name, err)
+			}
+		}()
+		if err := jr.plan(); err != nil {
+			return err
+		}
+		if err := jr.coordinator.SendStart(); err != nil {
+			return err
+		}
+	} else {
+		glog.Infof("[%s] Waiting for the leader to queue the job", jr.job.Name)
+		if err := jr.coordinator.AwaitStart(); err != nil {
+			return err
+		}
+	}
+
+	if err := jr.mapAll(ctx); err != nil {
+		return err
+	}
+	if !lead {
+		return nil
+	}
+
+	if !jr.coordinator.IsLeader() {
+		return fmt.Errorf("Lost leadership of job %s before reducing", jr.job.Name)
+	}
+
+	// Rather than write partial results; the next run retries them.
+	depths, err := jr.queue.Depths()
+	if err != nil {
+		return err
+	}
+	if depths.Dead > 0 {
+		return fmt.Errorf("Job %s omits %d buckets which repeatedly failed to map", jr.job.Name, depths.Dead)
+	}
+	return jr.reduce()
+}
+
+// Queues each bucket which isn't already mapped or queued, giving those which
+// failed to map in earlier runs another chance.
+func (jr *JobRunner) plan() error {
+	revived, err := jr.queue.RequeueDead()
+	if err != nil {
+		return err
+	}
+	if revived > 0 {
+		glog.Infof("[%s] Requeued %d buckets which failed to map before", jr.job.Name, revived)
+	}
+
+	issuerDates, err := jr.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return err
+	}
+	done, err := jr.cache.SetList(jr.doneKey())
+	if err != nil {
+		return err
+	}
+	queued, err := jr.queue.Payloads()
+	if err != nil {
+		return err
+	}
+
+	skip := make(map[string]bool, len(done)+len(queued))
+	for _, bucket := range append(done, queued...) {
+		skip[bucket] = true
+	}
+
+	count := 0
+	for _, issuerDate := range issuerDates {
+		for _, expDate := range issuerDate.ExpDates {
+			if expDate.ExpireTime().Before(jr.job.NotBefore) {
+				continue
+			}
+			bucket := storage.IssuerAndDate{ExpDate: expDate, Issuer: issuerDate.Issuer}
+			id := bucket.String()
+			if skip[id] {
+				continue
+			}
+			if err := jr.queue.Enqueue(id); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+	glog.Infof("[%s] Queued %d buckets, %d already mapped, %d already queued", jr.job.Name, count,
+		len(done), len(queued))
+	return nil
+}
+
+// Maps buckets from the queue until it's empty and nothing is in progress.
+func (jr *JobRunner) mapAll(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, jr.Threads)
+	for i := 0; i < jr.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- jr.mapWorker(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errChan)
+
+	for err := range errChan {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (jr *JobRunner) mapWorker(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		item, err := jr.queue.Lease()
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			depths, err := jr.queue.Depths()
+			if err != nil {
+				return err
+			}
+			if depths.Pending+depths.Processing == 0 {
+				return nil
+			}
+			if _, err := jr.queue.RequeueStalled(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := jr.mapItem(ctx, item); err != nil {
+			glog.Warningf("[%s] Failed to map bucket %s: %v", jr.job.Name, item.Payload, err)
+			if err := jr.queue.Nack(item); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := jr.queue.Ack(item); err != nil {
+			if err != coordinator.ErrLeaseLost {
+				return err
+			}
+			glog.Warningf("[%s] Bucket %s took too long, and may be mapped again", jr.job.Name, item.Payload)
+		}
+	}
+}
+
+// Maps one bucket, extending its lease until done, then checkpoints the
+// output.
+func (jr *JobRunner) mapItem(ctx context.Context, aItem *coordinator.WorkItem) error {
+	defer metrics.MeasureSince([]string{"MapReduce", jr.job.Name, "map"}, time.Now())
+	bucket, err := storage.ParseIssuerAndDate(aItem.Payload)
+	if err != nil {
+		return err
+	}
+
+	stopExtending := make(chan struct{})
+	defer close(stopExtending)
+	go func() {
+		ticker := time.NewTicker(jr.queue.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopExtending:
+				return
+			case <-ticker.C:
+				if err := jr.queue.Extend(aItem); err != nil {
+					glog.Warningf("[%s] Couldn't extend our lease on %s: %v", jr.job.Name, aItem.Payload, err)
+				}
+			}
+		}
+	}()
+
+	serialChan := make(chan storage.Serial)
+	streamErrChan := make(chan error, 1)
+	go func() {
+		streamErrChan <- jr.db.GetKnownCertificates(bucket.ExpDate, bucket.Issuer).StreamKnown(serialChan)
+	}()
+
+	pairs := []mappedPair{}
+	input := &MapInput{
+		IssuerAndDate: bucket,
+		Serials:       serialChan,
+		ctx:           ctx,
+		backend:       jr.backend,
+	}
+	mapErr := jr.job.Mapper.Map(input, func(aKey string, aValue string) {
+		pairs = append(pairs, mappedPair{aKey, aValue})
+	})
+
+	// Let StreamKnown finish, whatever the mapper read.
+	for range serialChan {
+	}
+	if err := <-streamErrChan; err != nil {
+		return err
+	}
+	if mapErr != nil {
+		return mapErr
+	}
+
+	encoded, err := json.Marshal(pairs)
+	if err != nil {
+		return err
+	}
+	// Replaced whole, so mapping a bucket twice leaves one copy.
+	if err := jr.cache.Delete(jr.outputKey(aItem.Payload)); err != nil {
+		return err
+	}
+	if _, err := jr.cache.Queue(jr.outputKey(aItem.Payload), string(encoded)); err != nil {
+		return err
+	}
+	_, err = jr.cache.SetInsert(jr.doneKey(), aItem.Payload)
+	metrics.IncrCounter([]string{"MapReduce", jr.job.Name, "mapped"}, 1)
+	return err
+}
+
+// Groups every mapped bucket's output by key, and reduces each key into the
+// Sink, in key order. Without a Reducer, values are written as mapped.
+func (jr *JobRunner) reduce() error {
+	defer metrics.MeasureSince([]string{"MapReduce", jr.job.Name, "reduce"}, time.Now())
+	buckets, err := jr.cache.SetList(jr.doneKey())
+	if err != nil {
+		return err
+	}
+
+	grouped := make(map[string][]string)
+	for _, bucket := range buckets {
+		outputs, err := jr.cache.QueueList(jr.outputKey(bucket))
+		if err != nil {
+			return err
+		}
+		if len(outputs) == 0 {
+			glog.Warningf("[%s] Bucket %s is marked done, but has no output", jr.job.Name, bucket)
+			continue
+		}
+		// Concurrent remappings may have left more than one copy.
+		var pairs []mappedPair
+		if err := json.Unmarshal([]byte(outputs[0]), &pairs); err != nil {
+			return fmt.Errorf("Couldn't decode output of bucket %s: %v", bucket, err)
+		}
+		for _, pair := range pairs {
+			grouped[pair.Key] = append(grouped[pair.Key], pair.Value)
+		}
+	}
+
+	keys := make([]string, 0, len(grouped))
+	for key := range grouped {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var writeErr error
+	emit := func(aKey string, aValue string) {
+		if writeErr == nil {
+			writeErr = jr.job.Sink.Write(aKey, aValue)
+		}
+	}
+	for _, key := range keys {
+		if jr.job.Reducer == nil {
+			for _, value := range grouped[key] {
+				emit(key, value)
+			}
+		} else if err := jr.job.Reducer.Reduce(key, grouped[key], emit); err != nil {
+			return fmt.Errorf("Couldn't reduce key %s: %v", key, err)
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+
+	glog.Infof("[%s] Reduced %d keys from %d buckets", jr.job.Name, len(keys), len(buckets))
+	return jr.job.Sink.Close()
+}
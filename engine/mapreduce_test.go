@@ -0,0 +1,350 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+type mapReduceHarness struct {
+	t       *testing.T
+	db      storage.CertDatabase
+	cache   *storage.MockRemoteCache
+	backend *storage.MockBackend
+	issuers map[string]*ctx509.Certificate
+	serial  int64
+}
+
+func newMapReduceHarness(t *testing.T) *mapReduceHarness {
+	backend := storage.NewMockBackend()
+	cache := storage.NewMockRemoteCache()
+	db, err := storage.NewFilesystemDatabase(backend, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mapReduceHarness{t, db, cache, backend, make(map[string]*ctx509.Certificate), 0}
+}
+
+func (h *mapReduceHarness) makeCert(aCN string, aNotAfter time.Time) *ctx509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.serial++
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(h.serial),
+		Subject:      pkix.Name{CommonName: aCN},
+		NotBefore:    aNotAfter.AddDate(-1, 0, 0),
+		NotAfter:     aNotAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	cert, err := ctx509.ParseCertificate(der)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return cert
+}
+
+// Stores aCount certificates from the named issuer, expiring on aDate.
+func (h *mapReduceHarness) store(aIssuer string, aDate string, aCount int) {
+	issuer, ok := h.issuers[aIssuer]
+	if !ok {
+		issuer = h.makeCert(aIssuer, time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC))
+		h.issuers[aIssuer] = issuer
+	}
+	notAfter, err := time.Parse("2006-01-02", aDate)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	for i := 0; i < aCount; i++ {
+		if err := h.db.Store(h.makeCert("leaf", notAfter), issuer, "log", h.serial); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+}
+
+func (h *mapReduceHarness) runner(aJob Job) *JobRunner {
+	runner, err := NewJobRunner(h.db, h.cache, h.backend, aJob)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	runner.Queue().LeaseWait = 10 * time.Millisecond
+	return runner
+}
+
+type memorySink struct {
+	mutex  sync.Mutex
+	lines  []string
+	closed bool
+}
+
+func (s *memorySink) Write(aKey string, aValue string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lines = append(s.lines, aKey+"="+aValue)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memorySink) written() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.lines...)
+}
+
+// Emits each bucket's serial count under its expiration date.
+func countSerials(aInput *MapInput, aEmit EmitFunc) error {
+	count := 0
+	for range aInput.Serials {
+		count++
+	}
+	aEmit(aInput.ExpDate.ID()[:10], strconv.Itoa(count))
+	return nil
+}
+
+var sumCounts = ReducerFunc(func(aKey string, aValues []string, aEmit EmitFunc) error {
+	sum := 0
+	for _, value := range aValues {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		sum += count
+	}
+	aEmit(aKey, strconv.Itoa(sum))
+	return nil
+})
+
+func Test_MapReduceBuckets(t *testing.T) {
+	h := newMapReduceHarness(t)
+	h.store("First", "2050-01-01", 2)
+	h.store("First", "2050-01-02", 1)
+	h.store("Second", "2050-01-01", 3)
+	h.store("Second", "2001-01-01", 4)
+
+	sink := &memorySink{}
+	runner := h.runner(Job{
+		Name:      "buckets",
+		Mapper:    MapperFunc(countSerials),
+		Reducer:   sumCounts,
+		Sink:      sink,
+		NotBefore: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	runner.Threads = 2
+	if err := runner.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"2050-01-01=5", "2050-01-02=1"}
+	if !reflect.DeepEqual(sink.written(), expected) || !sink.closed {
+		t.Errorf("Expected %v, got %v (closed %v)", expected, sink.written(), sink.closed)
+	}
+}
+
+func Test_MapReduceResumes(t *testing.T) {
+	h := newMapReduceHarness(t)
+	h.store("First", "2050-01-01", 2)
+	h.store("Second", "2050-01-01", 3)
+
+	job := Job{Name: "resume", Mapper: MapperFunc(countSerials), Reducer: sumCounts, Sink: &memorySink{}}
+	if err := h.runner(job).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the bucket added since is mapped again
+	h.store("Third", "2050-01-01", 4)
+	var mutex sync.Mutex
+	mapped := []string{}
+	sink := &memorySink{}
+	job.Sink = sink
+	job.Mapper = MapperFunc(func(aInput *MapInput, aEmit EmitFunc) error {
+		mutex.Lock()
+		mapped = append(mapped, aInput.Issuer.ID())
+		mutex.Unlock()
+		return countSerials(aInput, aEmit)
+	})
+	if err := h.runner(job).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	third := storage.NewIssuer(h.issuers["Third"])
+	if len(mapped) != 1 || mapped[0] != third.ID() {
+		t.Errorf("Expected only the new bucket mapped, got %v", mapped)
+	}
+	if expected := []string{"2050-01-01=9"}; !reflect.DeepEqual(sink.written(), expected) {
+		t.Errorf("Expected %v, got %v", expected, sink.written())
+	}
+
+	// After a reset, everything is mapped again
+	mapped = []string{}
+	runner := h.runner(job)
+	if err := runner.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(mapped) != 3 {
+		t.Errorf("Expected every bucket mapped after a reset, got %v", mapped)
+	}
+}
+
+func Test_MapReduceLeaderAwaitsFollowers(t *testing.T) {
+	h := newMapReduceHarness(t)
+	h.store("First", "2050-01-01", 1)
+	h.store("Second", "2050-01-01", 2)
+
+	leaderMapping := make(chan struct{})
+	followerMapping := make(chan struct{})
+	releaseFollower := make(chan struct{})
+
+	sink := &memorySink{}
+	var leaderOnce sync.Once
+	leader := h.runner(Job{
+		Name: "fleet",
+		Mapper: MapperFunc(func(aInput *MapInput, aEmit EmitFunc) error {
+			leaderOnce.Do(func() {
+				close(leaderMapping)
+				<-followerMapping
+			})
+			return countSerials(aInput, aEmit)
+		}),
+		Reducer: sumCounts,
+		Sink:    sink,
+	})
+	follower := h.runner(Job{
+		Name: "fleet",
+		Mapper: MapperFunc(func(aInput *MapInput, aEmit EmitFunc) error {
+			close(followerMapping)
+			<-releaseFollower
+			return countSerials(aInput, aEmit)
+		}),
+		Reducer: sumCounts,
+		Sink:    &memorySink{},
+	})
+
+	leaderErr := make(chan error, 1)
+	go func() {
+		leaderErr <- leader.Run(context.Background())
+	}()
+	<-leaderMapping
+
+	followerErr := make(chan error, 1)
+	go func() {
+		followerErr <- follower.Run(context.Background())
+	}()
+	<-followerMapping
+
+	select {
+	case err := <-leaderErr:
+		t.Fatalf("Expected the leader to wait for the follower's bucket, but it finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if len(sink.written()) != 0 {
+		t.Errorf("Expected nothing reduced while the follower maps, got %v", sink.written())
+	}
+
+	close(releaseFollower)
+	if err := <-followerErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-leaderErr; err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"2050-01-01=3"}; !reflect.DeepEqual(sink.written(), expected) {
+		t.Errorf("Expected %v, got %v", expected, sink.written())
+	}
+}
+
+func Test_MapReduceDeadLetters(t *testing.T) {
+	h := newMapReduceHarness(t)
+	h.store("Good", "2050-01-01", 2)
+	h.store("Bad", "2050-01-01", 3)
+	bad := storage.NewIssuer(h.issuers["Bad"])
+
+	failing := true
+	sink := &memorySink{}
+	job := Job{
+		Name: "dead",
+		Mapper: MapperFunc(func(aInput *MapInput, aEmit EmitFunc) error {
+			if failing && aInput.Issuer.ID() == bad.ID() {
+				return fmt.Errorf("Can't map %s", aInput.String())
+			}
+			return countSerials(aInput, aEmit)
+		}),
+		Reducer: sumCounts,
+		Sink:    sink,
+	}
+	runner := h.runner(job)
+	runner.Queue().MaxAttempts = 2
+
+	err := runner.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "omits 1 buckets") {
+		t.Errorf("Expected the dead bucket reported, got %v", err)
+	}
+	if len(sink.written()) != 0 || sink.closed {
+		t.Errorf("Expected nothing reduced without the dead bucket, got %v (closed %v)", sink.written(),
+			sink.closed)
+	}
+
+	dead, err := runner.Queue().DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || !strings.Contains(dead[0].Payload, bad.ID()) || dead[0].Attempts != 2 {
+		t.Errorf("Expected the bad bucket dead-lettered after 2 attempts, got %+v", dead)
+	}
+
+	// Once the mapper recovers, the next run retries the dead bucket
+	failing = false
+	sink = &memorySink{}
+	job.Sink = sink
+	if err := h.runner(job).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"2050-01-01=5"}; !reflect.DeepEqual(sink.written(), expected) || !sink.closed {
+		t.Errorf("Expected %v, got %v (closed %v)", expected, sink.written(), sink.closed)
+	}
+	if dead, err := runner.Queue().DeadLetters(); err != nil || len(dead) != 0 {
+		t.Errorf("Expected no dead letters left, got %+v %v", dead, err)
+	}
+
+	// A reset discards dead letters along with the checkpoints
+	failing = true
+	runner = h.runner(job)
+	runner.Queue().MaxAttempts = 1
+	if err := runner.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Run(context.Background()); err == nil {
+		t.Fatal("Expected the bad bucket to fail again")
+	}
+	if err := runner.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if depths, err := runner.Queue().Depths(); err != nil || depths.Dead+depths.Pending != 0 {
+		t.Errorf("Expected an empty queue after a reset, got %+v %v", depths, err)
+	}
+}
@@ -0,0 +1,227 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Package mlbf builds multi-level Bloom filter cascades, which answer whether
+// a certificate is revoked with no false results for any certificate they
+// were built from, and serializes them in the format of Mozilla's
+// filter-cascade library, as CRLite clients read.
+package mlbf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const kFormatVersion uint16 = 1
+const kHashMurmur3 uint8 = 1
+
+// Layers this deep mean the filters aren't converging.
+const kMaxLayers = 64
+
+type layer struct {
+	level   uint8
+	size    uint32
+	nHashes uint32
+	bits    []byte
+}
+
+// Sized for aElements at aFalsePositiveRate, as filter-cascade does.
+func newLayer(aLevel uint8, aElements int, aFalsePositiveRate float64) *layer {
+	nHashes := math.Ceil(math.Log2(1.0 / aFalsePositiveRate))
+	if nHashes < 1 {
+		nHashes = 1
+	}
+	size := math.Ceil(1.0 - (nHashes*(float64(aElements)+0.5))/
+		math.Log(1.0-math.Pow(aFalsePositiveRate, 1/nHashes)))
+	if size < 8 {
+		size = 8
+	}
+	return &layer{
+		level:   aLevel,
+		size:    uint32(size),
+		nHashes: uint32(nHashes),
+		bits:    make([]byte, (uint64(size)+7)/8),
+	}
+}
+
+func (l *layer) index(aKey []byte, aHash uint32) uint32 {
+	return murmur3(aKey, (aHash<<16)+uint32(l.level)) % l.size
+}
+
+func (l *layer) add(aKey []byte) {
+	for i := uint32(0); i < l.nHashes; i++ {
+		idx := l.index(aKey, i)
+		l.bits[idx/8] |= 1 << (idx % 8)
+	}
+}
+
+func (l *layer) has(aKey []byte) bool {
+	for i := uint32(0); i < l.nHashes; i++ {
+		idx := l.index(aKey, i)
+		if l.bits[idx/8]&(1<<(idx%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// A Cascade holds a set of included keys, distinguishing them from a set of
+// excluded keys. Each layer holds the keys the layer above wrongly claims:
+// the first holds the included keys, the second the excluded keys the first
+// holds, and so on, until a layer makes no mistakes.
+type Cascade struct {
+	layers []*layer
+}
+
+// Builds a cascade including aInclude and excluding aExclude, which mustn't
+// overlap. The first layer has aFalsePositiveRates[0], the next
+// aFalsePositiveRates[1], and the rest the last rate.
+func NewCascade(aInclude [][]byte, aExclude [][]byte, aFalsePositiveRates []float64) (*Cascade, error) {
+	if len(aFalsePositiveRates) == 0 {
+		return nil, fmt.Errorf("No false positive rates given")
+	}
+	for _, rate := range aFalsePositiveRates {
+		if rate <= 0 || rate >= 1 {
+			return nil, fmt.Errorf("False positive rate %v is out of range", rate)
+		}
+	}
+
+	c := &Cascade{}
+	include, exclude := aInclude, aExclude
+	for {
+		level := len(c.layers) + 1
+		if level > kMaxLayers {
+			return nil, fmt.Errorf("Cascade didn't converge in %d layers; do the sets overlap?", kMaxLayers)
+		}
+		rate := aFalsePositiveRates[len(aFalsePositiveRates)-1]
+		if level <= len(aFalsePositiveRates) {
+			rate = aFalsePositiveRates[level-1]
+		}
+
+		l := newLayer(uint8(level), len(include), rate)
+		for _, key := range include {
+			l.add(key)
+		}
+		c.layers = append(c.layers, l)
+
+		falsePositives := [][]byte{}
+		for _, key := range exclude {
+			if l.has(key) {
+				falsePositives = append(falsePositives, key)
+			}
+		}
+		if len(falsePositives) == 0 {
+			return c, nil
+		}
+		include, exclude = falsePositives, include
+	}
+}
+
+// Whether aKey is included. Only certain for keys the cascade was built from.
+func (c *Cascade) Has(aKey []byte) bool {
+	for i, l := range c.layers {
+		if !l.has(aKey) {
+			// Missing from an odd layer means excluded
+			return i%2 == 1
+		}
+	}
+	return len(c.layers)%2 == 1
+}
+
+func (c *Cascade) Layers() int {
+	return len(c.layers)
+}
+
+// Checks the cascade includes each of aInclude and none of aExclude.
+func (c *Cascade) Verify(aInclude [][]byte, aExclude [][]byte) error {
+	for _, key := range aInclude {
+		if !c.Has(key) {
+			return fmt.Errorf("False negative for %x", key)
+		}
+	}
+	for _, key := range aExclude {
+		if c.Has(key) {
+			return fmt.Errorf("False positive for %x", key)
+		}
+	}
+	return nil
+}
+
+// Writes the cascade as a little-endian version number, followed by each
+// layer's hash algorithm, size in bits, number of hashes, level and bits.
+func (c *Cascade) WriteTo(aWriter io.Writer) (int64, error) {
+	w := bufio.NewWriter(aWriter)
+	var written int64
+	write := func(aValue interface{}) error {
+		if err := binary.Write(w, binary.LittleEndian, aValue); err != nil {
+			return err
+		}
+		written += int64(binary.Size(aValue))
+		return nil
+	}
+
+	if err := write(kFormatVersion); err != nil {
+		return written, err
+	}
+	for _, l := range c.layers {
+		for _, value := range []interface{}{kHashMurmur3, l.size, l.nHashes, l.level, l.bits} {
+			if err := write(value); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, w.Flush()
+}
+
+// Reads a cascade written by WriteTo.
+func ReadCascade(aReader io.Reader) (*Cascade, error) {
+	r := bufio.NewReader(aReader)
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != kFormatVersion {
+		return nil, fmt.Errorf("Unsupported MLBF version %d", version)
+	}
+
+	c := &Cascade{}
+	for {
+		var header struct {
+			HashAlg uint8
+			Size    uint32
+			NHashes uint32
+			Level   uint8
+		}
+		err := binary.Read(r, binary.LittleEndian, &header)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.HashAlg != kHashMurmur3 {
+			return nil, fmt.Errorf("Unsupported hash algorithm %d", header.HashAlg)
+		}
+		if header.Size == 0 {
+			return nil, fmt.Errorf("Layer %d is empty", header.Level)
+		}
+		l := &layer{
+			level:   header.Level,
+			size:    header.Size,
+			nHashes: header.NHashes,
+			bits:    make([]byte, (uint64(header.Size)+7)/8),
+		}
+		if _, err := io.ReadFull(r, l.bits); err != nil {
+			return nil, err
+		}
+		c.layers = append(c.layers, l)
+	}
+	if len(c.layers) == 0 {
+		return nil, fmt.Errorf("No layers")
+	}
+	return c, nil
+}
@@ -0,0 +1,113 @@
+package mlbf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func Test_Murmur3(t *testing.T) {
+	for _, tc := range []struct {
+		key      string
+		seed     uint32
+		expected uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"hello", 0, 0x248bfa47},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+	} {
+		if h := murmur3([]byte(tc.key), tc.seed); h != tc.expected {
+			t.Errorf("murmur3(%q, %d) = %08x, expected %08x", tc.key, tc.seed, h, tc.expected)
+		}
+	}
+}
+
+func makeKeys(aPrefix string, aCount int) [][]byte {
+	keys := make([][]byte, aCount)
+	for i := range keys {
+		digest := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", aPrefix, i)))
+		keys[i] = digest[:]
+	}
+	return keys
+}
+
+func Test_CascadeNoFalseResults(t *testing.T) {
+	revoked := makeKeys("revoked", 1000)
+	unrevoked := makeKeys("unrevoked", 50000)
+
+	cascade, err := Build(&Keys{Revoked: revoked, Unrevoked: unrevoked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cascade.Layers() < 2 {
+		t.Errorf("Expected false positives to need more layers, got %d", cascade.Layers())
+	}
+
+	var buf bytes.Buffer
+	written, err := cascade.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(buf.Len()) {
+		t.Errorf("Reported %d bytes written, but wrote %d", written, buf.Len())
+	}
+
+	read, err := ReadCascade(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Layers() != cascade.Layers() {
+		t.Errorf("Expected %d layers read, got %d", cascade.Layers(), read.Layers())
+	}
+	if err := read.Verify(revoked, unrevoked); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_CascadeFormat(t *testing.T) {
+	cascade, err := NewCascade([][]byte{[]byte("a")}, [][]byte{}, []float64{0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := cascade.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	l := cascade.layers[0]
+	expected := []byte{1, 0, kHashMurmur3}
+	expected = append(expected, make([]byte, 8)...)
+	binary.LittleEndian.PutUint32(expected[3:], l.size)
+	binary.LittleEndian.PutUint32(expected[7:], l.nHashes)
+	expected = append(expected, 1)
+	expected = append(expected, l.bits...)
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Expected %x, got %x", expected, buf.Bytes())
+	}
+}
+
+func Test_CascadeEdges(t *testing.T) {
+	unrevoked := makeKeys("unrevoked", 100)
+
+	// Nothing revoked still needs a layer, which holds nothing
+	cascade, err := Build(&Keys{Revoked: [][]byte{}, Unrevoked: unrevoked})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cascade.Layers() != 1 {
+		t.Errorf("Expected one layer, got %d", cascade.Layers())
+	}
+
+	if _, err := NewCascade(unrevoked, unrevoked, []float64{0.5}); err == nil {
+		t.Error("Expected overlapping sets to fail")
+	}
+	if _, err := NewCascade(unrevoked, nil, []float64{1.5}); err == nil {
+		t.Error("Expected an invalid rate to fail")
+	}
+	if _, err := ReadCascade(bytes.NewReader([]byte{2, 0})); err == nil {
+		t.Error("Expected an unknown version to fail")
+	}
+}
@@ -0,0 +1,130 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package mlbf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+// A certificate's key in the cascade is the SHA-256 digest of its issuer's
+// SPKI, followed by its serial.
+func Key(aId storage.UniqueCertIdentifier) ([]byte, error) {
+	digest, err := base64.URLEncoding.DecodeString(aId.Issuer.ID())
+	if err != nil {
+		return nil, fmt.Errorf("Issuer ID %s isn't a digest: %v", aId.Issuer.ID(), err)
+	}
+	return append(digest, aId.SerialNum.BinaryString()...), nil
+}
+
+// The keys of revoked and unrevoked certificates to build a cascade from.
+type Keys struct {
+	Revoked   [][]byte
+	Unrevoked [][]byte
+}
+
+func streamKeys(aBucket storage.IssuerAndDate, aStream func(chan<- storage.Serial) error) ([][]byte, error) {
+	serialChan := make(chan storage.Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- aStream(serialChan)
+	}()
+
+	keys := [][]byte{}
+	var keyErr error
+	for serial := range serialChan {
+		key, err := Key(storage.UniqueCertIdentifier{
+			Issuer:    aBucket.Issuer,
+			ExpDate:   aBucket.ExpDate,
+			SerialNum: serial,
+		})
+		if err != nil {
+			keyErr = err
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if err := <-errChan; err != nil {
+		return keys, err
+	}
+	return keys, keyErr
+}
+
+// Loads the revocations crl-fetch stored for every bucket of aIssuerIDs
+// unexpired at aNotBefore. Buckets whose revocations were never stored are
+// left out.
+func LoadKeys(aDB storage.CertDatabase, aCache storage.RemoteCache, aNotBefore time.Time,
+	aIssuerIDs []string) (*Keys, error) {
+	issuerList, err := aDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+	issuers := make(map[string]struct{}, len(aIssuerIDs))
+	for _, id := range aIssuerIDs {
+		issuers[id] = struct{}{}
+	}
+
+	keys := &Keys{
+		Revoked:   [][]byte{},
+		Unrevoked: [][]byte{},
+	}
+	for _, issuerObj := range issuerList {
+		if _, ok := issuers[issuerObj.Issuer.ID()]; !ok {
+			continue
+		}
+		for _, expDate := range issuerObj.ExpDates {
+			if expDate.IsExpiredAt(aNotBefore) {
+				continue
+			}
+			bucket := storage.IssuerAndDate{ExpDate: expDate, Issuer: issuerObj.Issuer}
+			revocations := storage.NewRevocations(expDate, issuerObj.Issuer, aCache)
+
+			revoked, err := streamKeys(bucket, revocations.StreamRevoked)
+			if err != nil {
+				return nil, err
+			}
+			unrevoked, err := streamKeys(bucket, revocations.StreamUnrevoked)
+			if err != nil {
+				return nil, err
+			}
+			glog.V(1).Infof("[%s] %d revoked, %d unrevoked", bucket.String(), len(revoked), len(unrevoked))
+			keys.Revoked = append(keys.Revoked, revoked...)
+			keys.Unrevoked = append(keys.Unrevoked, unrevoked...)
+		}
+	}
+	return keys, nil
+}
+
+// The first layer's rate makes the first two layers about as small as they
+// can be, as CRLite chooses it; the rest are 1/2.
+func FalsePositiveRates(aRevoked int, aUnrevoked int) []float64 {
+	if aRevoked == 0 || aUnrevoked == 0 {
+		return []float64{0.5}
+	}
+	first := float64(aRevoked) / (math.Sqrt2 * float64(aUnrevoked))
+	if first > 0.5 {
+		first = 0.5
+	}
+	return []float64{first, 0.5}
+}
+
+// Builds a cascade of aKeys' revoked certificates, and checks it has no false
+// results for any of them.
+func Build(aKeys *Keys) (*Cascade, error) {
+	cascade, err := NewCascade(aKeys.Revoked, aKeys.Unrevoked,
+		FalsePositiveRates(len(aKeys.Revoked), len(aKeys.Unrevoked)))
+	if err != nil {
+		return nil, err
+	}
+	if err := cascade.Verify(aKeys.Revoked, aKeys.Unrevoked); err != nil {
+		return nil, err
+	}
+	return cascade, nil
+}
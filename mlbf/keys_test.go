@@ -0,0 +1,74 @@
+package mlbf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/jcjones/ct-mapreduce/storage"
+)
+
+func Test_LoadKeys(t *testing.T) {
+	cache := storage.NewMockRemoteCache()
+	storageDB, err := storage.NewFilesystemDatabase(storage.NewMockBackend(), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("issuer"))
+	issuer := storage.NewIssuerFromString(base64.URLEncoding.EncodeToString(digest[:]))
+	live, err := storage.NewExpDate("2050-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := storage.NewExpDate("2001-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherDigest := sha256.Sum256([]byte("other issuer"))
+	other := storage.NewIssuerFromString(base64.URLEncoding.EncodeToString(otherDigest[:]))
+
+	for _, bucket := range []storage.IssuerAndDate{
+		{ExpDate: live, Issuer: issuer},
+		{ExpDate: expired, Issuer: issuer},
+		{ExpDate: live, Issuer: other},
+	} {
+		known := storageDB.GetKnownCertificates(bucket.ExpDate, bucket.Issuer)
+		for _, hex := range []string{"01", "02", "03"} {
+			if _, err := known.WasUnknown(storage.NewSerialFromHex(hex)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := storage.NewRevocations(bucket.ExpDate, bucket.Issuer, cache).Store(
+			[]storage.Serial{storage.NewSerialFromHex("01")},
+			[]storage.Serial{storage.NewSerialFromHex("02"), storage.NewSerialFromHex("03")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the listed issuer is loaded
+	keys, err := LoadKeys(storageDB, cache, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), []string{issuer.ID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Revoked) != 1 || len(keys.Unrevoked) != 2 {
+		t.Fatalf("Expected the live bucket's 1 revoked and 2 unrevoked, got %d and %d", len(keys.Revoked),
+			len(keys.Unrevoked))
+	}
+	expected := append(digest[:], 0x01)
+	if !bytes.Equal(keys.Revoked[0], expected) {
+		t.Errorf("Expected key %x, got %x", expected, keys.Revoked[0])
+	}
+
+	cascade, err := Build(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cascade.Has(expected) {
+		t.Error("Expected the revoked serial in the cascade")
+	}
+}
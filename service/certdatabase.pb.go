@@ -0,0 +1,1251 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: certdatabase.proto
+
+package service
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type StoreResult_Outcome int32
+
+const (
+	StoreResult_STORED   StoreResult_Outcome = 0
+	StoreResult_FILTERED StoreResult_Outcome = 1
+	StoreResult_FAILED   StoreResult_Outcome = 2
+)
+
+var StoreResult_Outcome_name = map[int32]string{
+	0: "STORED",
+	1: "FILTERED",
+	2: "FAILED",
+}
+
+var StoreResult_Outcome_value = map[string]int32{
+	"STORED":   0,
+	"FILTERED": 1,
+	"FAILED":   2,
+}
+
+func (x StoreResult_Outcome) String() string {
+	return proto.EnumName(StoreResult_Outcome_name, int32(x))
+}
+
+func (StoreResult_Outcome) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{1, 0}
+}
+
+type StoreRequest struct {
+	// DER-encoded leaf certificate or precertificate
+	Leaf []byte `protobuf:"bytes,1,opt,name=leaf,proto3" json:"leaf,omitempty"`
+	// DER-encoded certificate of the leaf's issuer
+	Issuer []byte `protobuf:"bytes,2,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	// Where the certificate was found, e.g. a CT log or crawl URL
+	Source string `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`
+	// The entry's position within source, if it has one
+	EntryId              int64    `protobuf:"varint,4,opt,name=entry_id,json=entryId,proto3" json:"entry_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StoreRequest) Reset()         { *m = StoreRequest{} }
+func (m *StoreRequest) String() string { return proto.CompactTextString(m) }
+func (*StoreRequest) ProtoMessage()    {}
+func (*StoreRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{0}
+}
+
+func (m *StoreRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StoreRequest.Unmarshal(m, b)
+}
+func (m *StoreRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StoreRequest.Marshal(b, m, deterministic)
+}
+func (m *StoreRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StoreRequest.Merge(m, src)
+}
+func (m *StoreRequest) XXX_Size() int {
+	return xxx_messageInfo_StoreRequest.Size(m)
+}
+func (m *StoreRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StoreRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StoreRequest proto.InternalMessageInfo
+
+func (m *StoreRequest) GetLeaf() []byte {
+	if m != nil {
+		return m.Leaf
+	}
+	return nil
+}
+
+func (m *StoreRequest) GetIssuer() []byte {
+	if m != nil {
+		return m.Issuer
+	}
+	return nil
+}
+
+func (m *StoreRequest) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *StoreRequest) GetEntryId() int64 {
+	if m != nil {
+		return m.EntryId
+	}
+	return 0
+}
+
+type StoreResult struct {
+	Outcome StoreResult_Outcome `protobuf:"varint,1,opt,name=outcome,proto3,enum=ctmapreduce.StoreResult_Outcome" json:"outcome,omitempty"`
+	// Why the entry FAILED, in batches
+	Error                string   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StoreResult) Reset()         { *m = StoreResult{} }
+func (m *StoreResult) String() string { return proto.CompactTextString(m) }
+func (*StoreResult) ProtoMessage()    {}
+func (*StoreResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{1}
+}
+
+func (m *StoreResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StoreResult.Unmarshal(m, b)
+}
+func (m *StoreResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StoreResult.Marshal(b, m, deterministic)
+}
+func (m *StoreResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StoreResult.Merge(m, src)
+}
+func (m *StoreResult) XXX_Size() int {
+	return xxx_messageInfo_StoreResult.Size(m)
+}
+func (m *StoreResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_StoreResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StoreResult proto.InternalMessageInfo
+
+func (m *StoreResult) GetOutcome() StoreResult_Outcome {
+	if m != nil {
+		return m.Outcome
+	}
+	return StoreResult_STORED
+}
+
+func (m *StoreResult) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type StoreBatchRequest struct {
+	Entries              []*StoreRequest `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *StoreBatchRequest) Reset()         { *m = StoreBatchRequest{} }
+func (m *StoreBatchRequest) String() string { return proto.CompactTextString(m) }
+func (*StoreBatchRequest) ProtoMessage()    {}
+func (*StoreBatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{2}
+}
+
+func (m *StoreBatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StoreBatchRequest.Unmarshal(m, b)
+}
+func (m *StoreBatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StoreBatchRequest.Marshal(b, m, deterministic)
+}
+func (m *StoreBatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StoreBatchRequest.Merge(m, src)
+}
+func (m *StoreBatchRequest) XXX_Size() int {
+	return xxx_messageInfo_StoreBatchRequest.Size(m)
+}
+func (m *StoreBatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StoreBatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StoreBatchRequest proto.InternalMessageInfo
+
+func (m *StoreBatchRequest) GetEntries() []*StoreRequest {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type StoreBatchResponse struct {
+	// One result per entry, in order
+	Results              []*StoreResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *StoreBatchResponse) Reset()         { *m = StoreBatchResponse{} }
+func (m *StoreBatchResponse) String() string { return proto.CompactTextString(m) }
+func (*StoreBatchResponse) ProtoMessage()    {}
+func (*StoreBatchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{3}
+}
+
+func (m *StoreBatchResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StoreBatchResponse.Unmarshal(m, b)
+}
+func (m *StoreBatchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StoreBatchResponse.Marshal(b, m, deterministic)
+}
+func (m *StoreBatchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StoreBatchResponse.Merge(m, src)
+}
+func (m *StoreBatchResponse) XXX_Size() int {
+	return xxx_messageInfo_StoreBatchResponse.Size(m)
+}
+func (m *StoreBatchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_StoreBatchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StoreBatchResponse proto.InternalMessageInfo
+
+func (m *StoreBatchResponse) GetResults() []*StoreResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+type ListIssuersRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListIssuersRequest) Reset()         { *m = ListIssuersRequest{} }
+func (m *ListIssuersRequest) String() string { return proto.CompactTextString(m) }
+func (*ListIssuersRequest) ProtoMessage()    {}
+func (*ListIssuersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{4}
+}
+
+func (m *ListIssuersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListIssuersRequest.Unmarshal(m, b)
+}
+func (m *ListIssuersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListIssuersRequest.Marshal(b, m, deterministic)
+}
+func (m *ListIssuersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListIssuersRequest.Merge(m, src)
+}
+func (m *ListIssuersRequest) XXX_Size() int {
+	return xxx_messageInfo_ListIssuersRequest.Size(m)
+}
+func (m *ListIssuersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListIssuersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListIssuersRequest proto.InternalMessageInfo
+
+type IssuerDates struct {
+	Issuer               string   `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	ExpDates             []string `protobuf:"bytes,2,rep,name=exp_dates,json=expDates,proto3" json:"exp_dates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IssuerDates) Reset()         { *m = IssuerDates{} }
+func (m *IssuerDates) String() string { return proto.CompactTextString(m) }
+func (*IssuerDates) ProtoMessage()    {}
+func (*IssuerDates) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{5}
+}
+
+func (m *IssuerDates) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IssuerDates.Unmarshal(m, b)
+}
+func (m *IssuerDates) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IssuerDates.Marshal(b, m, deterministic)
+}
+func (m *IssuerDates) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IssuerDates.Merge(m, src)
+}
+func (m *IssuerDates) XXX_Size() int {
+	return xxx_messageInfo_IssuerDates.Size(m)
+}
+func (m *IssuerDates) XXX_DiscardUnknown() {
+	xxx_messageInfo_IssuerDates.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IssuerDates proto.InternalMessageInfo
+
+func (m *IssuerDates) GetIssuer() string {
+	if m != nil {
+		return m.Issuer
+	}
+	return ""
+}
+
+func (m *IssuerDates) GetExpDates() []string {
+	if m != nil {
+		return m.ExpDates
+	}
+	return nil
+}
+
+type ListIssuersResponse struct {
+	Issuers              []*IssuerDates `protobuf:"bytes,1,rep,name=issuers,proto3" json:"issuers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *ListIssuersResponse) Reset()         { *m = ListIssuersResponse{} }
+func (m *ListIssuersResponse) String() string { return proto.CompactTextString(m) }
+func (*ListIssuersResponse) ProtoMessage()    {}
+func (*ListIssuersResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{6}
+}
+
+func (m *ListIssuersResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListIssuersResponse.Unmarshal(m, b)
+}
+func (m *ListIssuersResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListIssuersResponse.Marshal(b, m, deterministic)
+}
+func (m *ListIssuersResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListIssuersResponse.Merge(m, src)
+}
+func (m *ListIssuersResponse) XXX_Size() int {
+	return xxx_messageInfo_ListIssuersResponse.Size(m)
+}
+func (m *ListIssuersResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListIssuersResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListIssuersResponse proto.InternalMessageInfo
+
+func (m *ListIssuersResponse) GetIssuers() []*IssuerDates {
+	if m != nil {
+		return m.Issuers
+	}
+	return nil
+}
+
+type ListExpirationDatesRequest struct {
+	// Only dates expiring at or after this Unix time
+	NotBefore            int64    `protobuf:"varint,1,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListExpirationDatesRequest) Reset()         { *m = ListExpirationDatesRequest{} }
+func (m *ListExpirationDatesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListExpirationDatesRequest) ProtoMessage()    {}
+func (*ListExpirationDatesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{7}
+}
+
+func (m *ListExpirationDatesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListExpirationDatesRequest.Unmarshal(m, b)
+}
+func (m *ListExpirationDatesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListExpirationDatesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListExpirationDatesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListExpirationDatesRequest.Merge(m, src)
+}
+func (m *ListExpirationDatesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListExpirationDatesRequest.Size(m)
+}
+func (m *ListExpirationDatesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListExpirationDatesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListExpirationDatesRequest proto.InternalMessageInfo
+
+func (m *ListExpirationDatesRequest) GetNotBefore() int64 {
+	if m != nil {
+		return m.NotBefore
+	}
+	return 0
+}
+
+type ListExpirationDatesResponse struct {
+	ExpDates             []string `protobuf:"bytes,1,rep,name=exp_dates,json=expDates,proto3" json:"exp_dates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListExpirationDatesResponse) Reset()         { *m = ListExpirationDatesResponse{} }
+func (m *ListExpirationDatesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListExpirationDatesResponse) ProtoMessage()    {}
+func (*ListExpirationDatesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{8}
+}
+
+func (m *ListExpirationDatesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListExpirationDatesResponse.Unmarshal(m, b)
+}
+func (m *ListExpirationDatesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListExpirationDatesResponse.Marshal(b, m, deterministic)
+}
+func (m *ListExpirationDatesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListExpirationDatesResponse.Merge(m, src)
+}
+func (m *ListExpirationDatesResponse) XXX_Size() int {
+	return xxx_messageInfo_ListExpirationDatesResponse.Size(m)
+}
+func (m *ListExpirationDatesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListExpirationDatesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListExpirationDatesResponse proto.InternalMessageInfo
+
+func (m *ListExpirationDatesResponse) GetExpDates() []string {
+	if m != nil {
+		return m.ExpDates
+	}
+	return nil
+}
+
+type Bucket struct {
+	Issuer               string   `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	ExpDate              string   `protobuf:"bytes,2,opt,name=exp_date,json=expDate,proto3" json:"exp_date,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Bucket) Reset()         { *m = Bucket{} }
+func (m *Bucket) String() string { return proto.CompactTextString(m) }
+func (*Bucket) ProtoMessage()    {}
+func (*Bucket) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{9}
+}
+
+func (m *Bucket) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Bucket.Unmarshal(m, b)
+}
+func (m *Bucket) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Bucket.Marshal(b, m, deterministic)
+}
+func (m *Bucket) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Bucket.Merge(m, src)
+}
+func (m *Bucket) XXX_Size() int {
+	return xxx_messageInfo_Bucket.Size(m)
+}
+func (m *Bucket) XXX_DiscardUnknown() {
+	xxx_messageInfo_Bucket.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Bucket proto.InternalMessageInfo
+
+func (m *Bucket) GetIssuer() string {
+	if m != nil {
+		return m.Issuer
+	}
+	return ""
+}
+
+func (m *Bucket) GetExpDate() string {
+	if m != nil {
+		return m.ExpDate
+	}
+	return ""
+}
+
+type SerialEntry struct {
+	Serial               []byte   `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SerialEntry) Reset()         { *m = SerialEntry{} }
+func (m *SerialEntry) String() string { return proto.CompactTextString(m) }
+func (*SerialEntry) ProtoMessage()    {}
+func (*SerialEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{10}
+}
+
+func (m *SerialEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SerialEntry.Unmarshal(m, b)
+}
+func (m *SerialEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SerialEntry.Marshal(b, m, deterministic)
+}
+func (m *SerialEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SerialEntry.Merge(m, src)
+}
+func (m *SerialEntry) XXX_Size() int {
+	return xxx_messageInfo_SerialEntry.Size(m)
+}
+func (m *SerialEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_SerialEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SerialEntry proto.InternalMessageInfo
+
+func (m *SerialEntry) GetSerial() []byte {
+	if m != nil {
+		return m.Serial
+	}
+	return nil
+}
+
+type LocateSerialRequest struct {
+	Serial []byte `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	// Only search this issuer, if set
+	Issuer               string   `protobuf:"bytes,2,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LocateSerialRequest) Reset()         { *m = LocateSerialRequest{} }
+func (m *LocateSerialRequest) String() string { return proto.CompactTextString(m) }
+func (*LocateSerialRequest) ProtoMessage()    {}
+func (*LocateSerialRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{11}
+}
+
+func (m *LocateSerialRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LocateSerialRequest.Unmarshal(m, b)
+}
+func (m *LocateSerialRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LocateSerialRequest.Marshal(b, m, deterministic)
+}
+func (m *LocateSerialRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LocateSerialRequest.Merge(m, src)
+}
+func (m *LocateSerialRequest) XXX_Size() int {
+	return xxx_messageInfo_LocateSerialRequest.Size(m)
+}
+func (m *LocateSerialRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LocateSerialRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LocateSerialRequest proto.InternalMessageInfo
+
+func (m *LocateSerialRequest) GetSerial() []byte {
+	if m != nil {
+		return m.Serial
+	}
+	return nil
+}
+
+func (m *LocateSerialRequest) GetIssuer() string {
+	if m != nil {
+		return m.Issuer
+	}
+	return ""
+}
+
+type LocateSerialResponse struct {
+	Buckets              []*Bucket `protobuf:"bytes,1,rep,name=buckets,proto3" json:"buckets,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *LocateSerialResponse) Reset()         { *m = LocateSerialResponse{} }
+func (m *LocateSerialResponse) String() string { return proto.CompactTextString(m) }
+func (*LocateSerialResponse) ProtoMessage()    {}
+func (*LocateSerialResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{12}
+}
+
+func (m *LocateSerialResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LocateSerialResponse.Unmarshal(m, b)
+}
+func (m *LocateSerialResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LocateSerialResponse.Marshal(b, m, deterministic)
+}
+func (m *LocateSerialResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LocateSerialResponse.Merge(m, src)
+}
+func (m *LocateSerialResponse) XXX_Size() int {
+	return xxx_messageInfo_LocateSerialResponse.Size(m)
+}
+func (m *LocateSerialResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LocateSerialResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LocateSerialResponse proto.InternalMessageInfo
+
+func (m *LocateSerialResponse) GetBuckets() []*Bucket {
+	if m != nil {
+		return m.Buckets
+	}
+	return nil
+}
+
+type IssuerRequest struct {
+	Issuer               string   `protobuf:"bytes,1,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IssuerRequest) Reset()         { *m = IssuerRequest{} }
+func (m *IssuerRequest) String() string { return proto.CompactTextString(m) }
+func (*IssuerRequest) ProtoMessage()    {}
+func (*IssuerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{13}
+}
+
+func (m *IssuerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IssuerRequest.Unmarshal(m, b)
+}
+func (m *IssuerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IssuerRequest.Marshal(b, m, deterministic)
+}
+func (m *IssuerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IssuerRequest.Merge(m, src)
+}
+func (m *IssuerRequest) XXX_Size() int {
+	return xxx_messageInfo_IssuerRequest.Size(m)
+}
+func (m *IssuerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_IssuerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IssuerRequest proto.InternalMessageInfo
+
+func (m *IssuerRequest) GetIssuer() string {
+	if m != nil {
+		return m.Issuer
+	}
+	return ""
+}
+
+type IssuerMetadataResponse struct {
+	Crls                 []string `protobuf:"bytes,1,rep,name=crls,proto3" json:"crls,omitempty"`
+	Dns                  []string `protobuf:"bytes,2,rep,name=dns,proto3" json:"dns,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IssuerMetadataResponse) Reset()         { *m = IssuerMetadataResponse{} }
+func (m *IssuerMetadataResponse) String() string { return proto.CompactTextString(m) }
+func (*IssuerMetadataResponse) ProtoMessage()    {}
+func (*IssuerMetadataResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{14}
+}
+
+func (m *IssuerMetadataResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IssuerMetadataResponse.Unmarshal(m, b)
+}
+func (m *IssuerMetadataResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IssuerMetadataResponse.Marshal(b, m, deterministic)
+}
+func (m *IssuerMetadataResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IssuerMetadataResponse.Merge(m, src)
+}
+func (m *IssuerMetadataResponse) XXX_Size() int {
+	return xxx_messageInfo_IssuerMetadataResponse.Size(m)
+}
+func (m *IssuerMetadataResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_IssuerMetadataResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IssuerMetadataResponse proto.InternalMessageInfo
+
+func (m *IssuerMetadataResponse) GetCrls() []string {
+	if m != nil {
+		return m.Crls
+	}
+	return nil
+}
+
+func (m *IssuerMetadataResponse) GetDns() []string {
+	if m != nil {
+		return m.Dns
+	}
+	return nil
+}
+
+type LogStateRequest struct {
+	Url                  string   `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LogStateRequest) Reset()         { *m = LogStateRequest{} }
+func (m *LogStateRequest) String() string { return proto.CompactTextString(m) }
+func (*LogStateRequest) ProtoMessage()    {}
+func (*LogStateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{15}
+}
+
+func (m *LogStateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LogStateRequest.Unmarshal(m, b)
+}
+func (m *LogStateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LogStateRequest.Marshal(b, m, deterministic)
+}
+func (m *LogStateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LogStateRequest.Merge(m, src)
+}
+func (m *LogStateRequest) XXX_Size() int {
+	return xxx_messageInfo_LogStateRequest.Size(m)
+}
+func (m *LogStateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LogStateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LogStateRequest proto.InternalMessageInfo
+
+func (m *LogStateRequest) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+type LogState struct {
+	ShortUrl string `protobuf:"bytes,1,opt,name=short_url,json=shortUrl,proto3" json:"short_url,omitempty"`
+	MaxEntry int64  `protobuf:"varint,2,opt,name=max_entry,json=maxEntry,proto3" json:"max_entry,omitempty"`
+	// Unix times
+	LastEntryTime        int64    `protobuf:"varint,3,opt,name=last_entry_time,json=lastEntryTime,proto3" json:"last_entry_time,omitempty"`
+	LastUpdateTime       int64    `protobuf:"varint,4,opt,name=last_update_time,json=lastUpdateTime,proto3" json:"last_update_time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LogState) Reset()         { *m = LogState{} }
+func (m *LogState) String() string { return proto.CompactTextString(m) }
+func (*LogState) ProtoMessage()    {}
+func (*LogState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_bac63a1838587318, []int{16}
+}
+
+func (m *LogState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LogState.Unmarshal(m, b)
+}
+func (m *LogState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LogState.Marshal(b, m, deterministic)
+}
+func (m *LogState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LogState.Merge(m, src)
+}
+func (m *LogState) XXX_Size() int {
+	return xxx_messageInfo_LogState.Size(m)
+}
+func (m *LogState) XXX_DiscardUnknown() {
+	xxx_messageInfo_LogState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LogState proto.InternalMessageInfo
+
+func (m *LogState) GetShortUrl() string {
+	if m != nil {
+		return m.ShortUrl
+	}
+	return ""
+}
+
+func (m *LogState) GetMaxEntry() int64 {
+	if m != nil {
+		return m.MaxEntry
+	}
+	return 0
+}
+
+func (m *LogState) GetLastEntryTime() int64 {
+	if m != nil {
+		return m.LastEntryTime
+	}
+	return 0
+}
+
+func (m *LogState) GetLastUpdateTime() int64 {
+	if m != nil {
+		return m.LastUpdateTime
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("ctmapreduce.StoreResult_Outcome", StoreResult_Outcome_name, StoreResult_Outcome_value)
+	proto.RegisterType((*StoreRequest)(nil), "ctmapreduce.StoreRequest")
+	proto.RegisterType((*StoreResult)(nil), "ctmapreduce.StoreResult")
+	proto.RegisterType((*StoreBatchRequest)(nil), "ctmapreduce.StoreBatchRequest")
+	proto.RegisterType((*StoreBatchResponse)(nil), "ctmapreduce.StoreBatchResponse")
+	proto.RegisterType((*ListIssuersRequest)(nil), "ctmapreduce.ListIssuersRequest")
+	proto.RegisterType((*IssuerDates)(nil), "ctmapreduce.IssuerDates")
+	proto.RegisterType((*ListIssuersResponse)(nil), "ctmapreduce.ListIssuersResponse")
+	proto.RegisterType((*ListExpirationDatesRequest)(nil), "ctmapreduce.ListExpirationDatesRequest")
+	proto.RegisterType((*ListExpirationDatesResponse)(nil), "ctmapreduce.ListExpirationDatesResponse")
+	proto.RegisterType((*Bucket)(nil), "ctmapreduce.Bucket")
+	proto.RegisterType((*SerialEntry)(nil), "ctmapreduce.SerialEntry")
+	proto.RegisterType((*LocateSerialRequest)(nil), "ctmapreduce.LocateSerialRequest")
+	proto.RegisterType((*LocateSerialResponse)(nil), "ctmapreduce.LocateSerialResponse")
+	proto.RegisterType((*IssuerRequest)(nil), "ctmapreduce.IssuerRequest")
+	proto.RegisterType((*IssuerMetadataResponse)(nil), "ctmapreduce.IssuerMetadataResponse")
+	proto.RegisterType((*LogStateRequest)(nil), "ctmapreduce.LogStateRequest")
+	proto.RegisterType((*LogState)(nil), "ctmapreduce.LogState")
+}
+
+func init() { proto.RegisterFile("certdatabase.proto", fileDescriptor_bac63a1838587318) }
+
+var fileDescriptor_bac63a1838587318 = []byte{
+	// 770 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x55, 0x4b, 0x6f, 0x1a, 0x49,
+	0x10, 0xde, 0xf1, 0xd8, 0x3c, 0x0a, 0x6c, 0xe3, 0xc6, 0x6b, 0xe1, 0xf1, 0x3e, 0xd8, 0xb6, 0x76,
+	0xcd, 0x65, 0xd9, 0x15, 0xbe, 0xd9, 0x91, 0xa5, 0x10, 0xb0, 0x8d, 0x84, 0xe5, 0x68, 0xc0, 0x97,
+	0x5c, 0x50, 0x33, 0xb4, 0xe3, 0x51, 0x80, 0x26, 0xdd, 0x3d, 0x11, 0xf9, 0x03, 0x39, 0xe7, 0x94,
+	0xdf, 0x1b, 0xf5, 0x63, 0x60, 0x80, 0x21, 0xb9, 0x4d, 0x55, 0x7d, 0xf5, 0xec, 0xfa, 0x6a, 0x00,
+	0x05, 0x94, 0xcb, 0x11, 0x91, 0x64, 0x48, 0x04, 0xad, 0xcf, 0x38, 0x93, 0x0c, 0x15, 0x02, 0x39,
+	0x21, 0x33, 0x4e, 0x47, 0x51, 0x40, 0xf1, 0x04, 0x8a, 0x3d, 0xc9, 0x38, 0xf5, 0xe9, 0xc7, 0x88,
+	0x0a, 0x89, 0x10, 0xec, 0x8e, 0x29, 0x79, 0xae, 0x38, 0x55, 0xa7, 0x56, 0xf4, 0xf5, 0x37, 0x3a,
+	0x81, 0x4c, 0x28, 0x44, 0x44, 0x79, 0x65, 0x47, 0x6b, 0xad, 0xa4, 0xf4, 0x82, 0x45, 0x3c, 0xa0,
+	0x15, 0xb7, 0xea, 0xd4, 0xf2, 0xbe, 0x95, 0xd0, 0x29, 0xe4, 0xe8, 0x54, 0xf2, 0xcf, 0x83, 0x70,
+	0x54, 0xd9, 0xad, 0x3a, 0x35, 0xd7, 0xcf, 0x6a, 0xb9, 0x33, 0xc2, 0x5f, 0x1d, 0x28, 0xd8, 0x7c,
+	0x22, 0x1a, 0x4b, 0x74, 0x05, 0x59, 0x16, 0xc9, 0x80, 0x4d, 0xa8, 0xce, 0x78, 0xd0, 0xa8, 0xd6,
+	0x13, 0xd5, 0xd5, 0x13, 0xd0, 0xfa, 0xa3, 0xc1, 0xf9, 0xb1, 0x03, 0x3a, 0x86, 0x3d, 0xca, 0x39,
+	0x33, 0x55, 0xe5, 0x7d, 0x23, 0xe0, 0xff, 0x20, 0x6b, 0x91, 0x08, 0x20, 0xd3, 0xeb, 0x3f, 0xfa,
+	0xed, 0x56, 0xe9, 0x17, 0x54, 0x84, 0xdc, 0x6d, 0xa7, 0xdb, 0x6f, 0x2b, 0xc9, 0x51, 0x96, 0xdb,
+	0xd7, 0x9d, 0x6e, 0xbb, 0x55, 0xda, 0xc1, 0xf7, 0x70, 0xa4, 0xd3, 0x34, 0x89, 0x0c, 0x5e, 0xe2,
+	0x31, 0x5c, 0x82, 0x2e, 0x39, 0xa4, 0xa2, 0xe2, 0x54, 0xdd, 0x5a, 0xa1, 0x71, 0x9a, 0x56, 0x97,
+	0xc6, 0xfa, 0x31, 0x12, 0xdf, 0x03, 0x4a, 0x46, 0x12, 0x33, 0x36, 0x15, 0x14, 0x35, 0x20, 0xcb,
+	0x75, 0x07, 0x71, 0xa8, 0xca, 0xb6, 0x16, 0xfd, 0x18, 0x88, 0x8f, 0x01, 0x75, 0x43, 0x21, 0x3b,
+	0x7a, 0xce, 0xc2, 0x26, 0xc2, 0x4d, 0x28, 0x18, 0x4d, 0x8b, 0x48, 0x2a, 0x12, 0xcf, 0xe2, 0x98,
+	0xf1, 0x1b, 0x09, 0x9d, 0x41, 0x9e, 0xce, 0x67, 0x83, 0x91, 0x02, 0x55, 0x76, 0xaa, 0x6e, 0x2d,
+	0xef, 0xe7, 0xe8, 0x7c, 0xa6, 0x9d, 0x70, 0x07, 0xca, 0x2b, 0x91, 0x97, 0x45, 0x1a, 0xef, 0xf4,
+	0x22, 0x13, 0x69, 0xfd, 0x18, 0x88, 0xaf, 0xc1, 0x53, 0xa1, 0xda, 0xf3, 0x59, 0xc8, 0x89, 0x0c,
+	0xd9, 0xd4, 0xd8, 0xed, 0x04, 0x7f, 0x07, 0x98, 0x32, 0x39, 0x18, 0xd2, 0x67, 0xc6, 0xcd, 0xe3,
+	0xba, 0x7e, 0x7e, 0xca, 0x64, 0x53, 0x2b, 0xf0, 0x15, 0x9c, 0xa5, 0x3a, 0xdb, 0x7a, 0x56, 0x7a,
+	0x70, 0xd6, 0x7a, 0xb8, 0x86, 0x4c, 0x33, 0x0a, 0x3e, 0x50, 0xb9, 0x75, 0x04, 0x6a, 0x03, 0xad,
+	0xbb, 0xdd, 0x8e, 0xac, 0xf5, 0xc6, 0x7f, 0x43, 0xa1, 0x47, 0x79, 0x48, 0xc6, 0x6d, 0xb5, 0x92,
+	0x7a, 0x87, 0xb5, 0x68, 0x37, 0xde, 0x4a, 0xb8, 0x0d, 0xe5, 0x2e, 0x0b, 0x88, 0xa4, 0x06, 0x1c,
+	0x77, 0xb5, 0x05, 0xbe, 0x46, 0x91, 0x45, 0x21, 0xb8, 0x0d, 0xc7, 0xab, 0x61, 0x6c, 0x7f, 0xff,
+	0x42, 0x76, 0xa8, 0x5b, 0x88, 0xe7, 0x5d, 0x5e, 0x99, 0xb7, 0x69, 0xcf, 0x8f, 0x31, 0xf8, 0x02,
+	0xf6, 0xcd, 0x13, 0x24, 0xea, 0x48, 0x6b, 0x1c, 0xdf, 0xc0, 0x89, 0x01, 0x3e, 0x50, 0x49, 0x14,
+	0xef, 0x17, 0x19, 0x11, 0xec, 0x06, 0x7c, 0x1c, 0x0f, 0x53, 0x7f, 0xa3, 0x12, 0xb8, 0xa3, 0x69,
+	0xbc, 0x23, 0xea, 0x13, 0x9f, 0xc3, 0x61, 0x97, 0xbd, 0xef, 0x49, 0x22, 0x17, 0x17, 0xa1, 0x04,
+	0x6e, 0xc4, 0xc7, 0x36, 0x8f, 0xfa, 0xc4, 0xdf, 0x1c, 0xc8, 0xc5, 0x28, 0xf5, 0x52, 0xe2, 0x85,
+	0x71, 0x39, 0x58, 0x82, 0x72, 0x5a, 0xf1, 0xc4, 0xc7, 0xca, 0x38, 0x21, 0xf3, 0x81, 0x66, 0xbf,
+	0x9e, 0x8c, 0xeb, 0xe7, 0x26, 0x64, 0x6e, 0x46, 0xff, 0x0f, 0x1c, 0x8e, 0x89, 0x90, 0xc6, 0x3a,
+	0x90, 0xe1, 0xc4, 0xdc, 0x11, 0xd7, 0xdf, 0x57, 0x6a, 0x8d, 0xe9, 0x87, 0x13, 0x8a, 0x6a, 0x50,
+	0xd2, 0xb8, 0x68, 0xa6, 0xde, 0xd3, 0x00, 0xcd, 0x59, 0x39, 0x50, 0xfa, 0x27, 0xad, 0x56, 0xc8,
+	0xc6, 0x97, 0x3d, 0x28, 0xbe, 0xa1, 0x5c, 0xb6, 0xec, 0xc1, 0x43, 0xaf, 0x60, 0x4f, 0xf3, 0x0b,
+	0x6d, 0xa7, 0xaf, 0xb7, 0x95, 0x8e, 0xe8, 0x01, 0x60, 0xc9, 0x67, 0xf4, 0xc7, 0x26, 0x2e, 0x79,
+	0x32, 0xbc, 0x3f, 0xb7, 0xda, 0xed, 0x0b, 0xbc, 0x85, 0x42, 0x82, 0x7a, 0x68, 0x15, 0xbf, 0x49,
+	0x77, 0xaf, 0xba, 0x1d, 0x60, 0x23, 0xbe, 0x40, 0x39, 0x85, 0x44, 0xe8, 0x62, 0xc3, 0x31, 0x9d,
+	0xa3, 0x5e, 0xed, 0xe7, 0x40, 0x9b, 0xe9, 0xc6, 0xd4, 0x6e, 0xb6, 0x58, 0xa0, 0xb4, 0x6d, 0x5d,
+	0x1f, 0xe4, 0x92, 0x64, 0xff, 0x3b, 0xa8, 0x07, 0xc5, 0x24, 0x0f, 0xd0, 0x5a, 0x6f, 0x9b, 0x4c,
+	0xf3, 0xfe, 0xfa, 0x01, 0xc2, 0x16, 0xd5, 0x87, 0xa3, 0x3b, 0x2a, 0x57, 0xf7, 0x1d, 0x79, 0x29,
+	0x87, 0x2b, 0x8e, 0x79, 0x9e, 0x62, 0xdb, 0x20, 0x4a, 0x13, 0x0a, 0x77, 0x54, 0x2e, 0xf6, 0xfb,
+	0xb7, 0xb5, 0x3a, 0x56, 0xc8, 0xe1, 0xfd, 0x9a, 0x6a, 0x6d, 0xe6, 0xdf, 0x65, 0x05, 0xe5, 0x9f,
+	0xc2, 0x80, 0x0e, 0x33, 0xfa, 0xa7, 0x7b, 0xf9, 0x7d, 0x00, 0x90, 0x86, 0x40, 0x2c, 0x8a, 0x07,
+	0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// CertDatabaseClient is the client API for CertDatabase service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type CertDatabaseClient interface {
+	// Stores one certificate, if it isn't filtered out. Storing a certificate
+	// which is already known is a no-op. Fails with INVALID_ARGUMENT if leaf or
+	// issuer can't be parsed, or issuer didn't sign leaf.
+	Store(ctx context.Context, in *StoreRequest, opts ...grpc.CallOption) (*StoreResult, error)
+	// Stores each certificate in turn; one entry failing doesn't stop the rest.
+	StoreBatch(ctx context.Context, in *StoreBatchRequest, opts ...grpc.CallOption) (*StoreBatchResponse, error)
+	ListIssuers(ctx context.Context, in *ListIssuersRequest, opts ...grpc.CallOption) (*ListIssuersResponse, error)
+	ListExpirationDates(ctx context.Context, in *ListExpirationDatesRequest, opts ...grpc.CallOption) (*ListExpirationDatesResponse, error)
+	// Streams the known serials of one bucket, in no particular order.
+	ListSerials(ctx context.Context, in *Bucket, opts ...grpc.CallOption) (CertDatabase_ListSerialsClient, error)
+	LocateSerial(ctx context.Context, in *LocateSerialRequest, opts ...grpc.CallOption) (*LocateSerialResponse, error)
+	GetIssuerMetadata(ctx context.Context, in *IssuerRequest, opts ...grpc.CallOption) (*IssuerMetadataResponse, error)
+	GetLogState(ctx context.Context, in *LogStateRequest, opts ...grpc.CallOption) (*LogState, error)
+}
+
+type certDatabaseClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCertDatabaseClient(cc *grpc.ClientConn) CertDatabaseClient {
+	return &certDatabaseClient{cc}
+}
+
+func (c *certDatabaseClient) Store(ctx context.Context, in *StoreRequest, opts ...grpc.CallOption) (*StoreResult, error) {
+	out := new(StoreResult)
+	err := c.cc.Invoke(ctx, "/ctmapreduce.CertDatabase/Store", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certDatabaseClient) StoreBatch(ctx context.Context, in *StoreBatchRequest, opts ...grpc.CallOption) (*StoreBatchResponse, error) {
+	out := new(StoreBatchResponse)
+	err := c.cc.Invoke(ctx, "/ctmapreduce.CertDatabase/StoreBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certDatabaseClient) ListIssuers(ctx context.Context, in *ListIssuersRequest, opts ...grpc.CallOption) (*ListIssuersResponse, error) {
+	out := new(ListIssuersResponse)
+	err := c.cc.Invoke(ctx, "/ctmapreduce.CertDatabase/ListIssuers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certDatabaseClient) ListExpirationDates(ctx context.Context, in *ListExpirationDatesRequest, opts ...grpc.CallOption) (*ListExpirationDatesResponse, error) {
+	out := new(ListExpirationDatesResponse)
+	err := c.cc.Invoke(ctx, "/ctmapreduce.CertDatabase/ListExpirationDates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certDatabaseClient) ListSerials(ctx context.Context, in *Bucket, opts ...grpc.CallOption) (CertDatabase_ListSerialsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CertDatabase_serviceDesc.Streams[0], "/ctmapreduce.CertDatabase/ListSerials", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &certDatabaseListSerialsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CertDatabase_ListSerialsClient interface {
+	Recv() (*SerialEntry, error)
+	grpc.ClientStream
+}
+
+type certDatabaseListSerialsClient struct {
+	grpc.ClientStream
+}
+
+func (x *certDatabaseListSerialsClient) Recv() (*SerialEntry, error) {
+	m := new(SerialEntry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *certDatabaseClient) LocateSerial(ctx context.Context, in *LocateSerialRequest, opts ...grpc.CallOption) (*LocateSerialResponse, error) {
+	out := new(LocateSerialResponse)
+	err := c.cc.Invoke(ctx, "/ctmapreduce.CertDatabase/LocateSerial", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certDatabaseClient) GetIssuerMetadata(ctx context.Context, in *IssuerRequest, opts ...grpc.CallOption) (*IssuerMetadataResponse, error) {
+	out := new(IssuerMetadataResponse)
+	err := c.cc.Invoke(ctx, "/ctmapreduce.CertDatabase/GetIssuerMetadata", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certDatabaseClient) GetLogState(ctx context.Context, in *LogStateRequest, opts ...grpc.CallOption) (*LogState, error) {
+	out := new(LogState)
+	err := c.cc.Invoke(ctx, "/ctmapreduce.CertDatabase/GetLogState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CertDatabaseServer is the server API for CertDatabase service.
+type CertDatabaseServer interface {
+	// Stores one certificate, if it isn't filtered out. Storing a certificate
+	// which is already known is a no-op. Fails with INVALID_ARGUMENT if leaf or
+	// issuer can't be parsed, or issuer didn't sign leaf.
+	Store(context.Context, *StoreRequest) (*StoreResult, error)
+	// Stores each certificate in turn; one entry failing doesn't stop the rest.
+	StoreBatch(context.Context, *StoreBatchRequest) (*StoreBatchResponse, error)
+	ListIssuers(context.Context, *ListIssuersRequest) (*ListIssuersResponse, error)
+	ListExpirationDates(context.Context, *ListExpirationDatesRequest) (*ListExpirationDatesResponse, error)
+	// Streams the known serials of one bucket, in no particular order.
+	ListSerials(*Bucket, CertDatabase_ListSerialsServer) error
+	LocateSerial(context.Context, *LocateSerialRequest) (*LocateSerialResponse, error)
+	GetIssuerMetadata(context.Context, *IssuerRequest) (*IssuerMetadataResponse, error)
+	GetLogState(context.Context, *LogStateRequest) (*LogState, error)
+}
+
+// UnimplementedCertDatabaseServer can be embedded to have forward compatible implementations.
+type UnimplementedCertDatabaseServer struct {
+}
+
+func (*UnimplementedCertDatabaseServer) Store(ctx context.Context, req *StoreRequest) (*StoreResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Store not implemented")
+}
+func (*UnimplementedCertDatabaseServer) StoreBatch(ctx context.Context, req *StoreBatchRequest) (*StoreBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StoreBatch not implemented")
+}
+func (*UnimplementedCertDatabaseServer) ListIssuers(ctx context.Context, req *ListIssuersRequest) (*ListIssuersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListIssuers not implemented")
+}
+func (*UnimplementedCertDatabaseServer) ListExpirationDates(ctx context.Context, req *ListExpirationDatesRequest) (*ListExpirationDatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListExpirationDates not implemented")
+}
+func (*UnimplementedCertDatabaseServer) ListSerials(req *Bucket, srv CertDatabase_ListSerialsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListSerials not implemented")
+}
+func (*UnimplementedCertDatabaseServer) LocateSerial(ctx context.Context, req *LocateSerialRequest) (*LocateSerialResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LocateSerial not implemented")
+}
+func (*UnimplementedCertDatabaseServer) GetIssuerMetadata(ctx context.Context, req *IssuerRequest) (*IssuerMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetIssuerMetadata not implemented")
+}
+func (*UnimplementedCertDatabaseServer) GetLogState(ctx context.Context, req *LogStateRequest) (*LogState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLogState not implemented")
+}
+
+func RegisterCertDatabaseServer(s *grpc.Server, srv CertDatabaseServer) {
+	s.RegisterService(&_CertDatabase_serviceDesc, srv)
+}
+
+func _CertDatabase_Store_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertDatabaseServer).Store(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ctmapreduce.CertDatabase/Store",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertDatabaseServer).Store(ctx, req.(*StoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertDatabase_StoreBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StoreBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertDatabaseServer).StoreBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ctmapreduce.CertDatabase/StoreBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertDatabaseServer).StoreBatch(ctx, req.(*StoreBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertDatabase_ListIssuers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListIssuersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertDatabaseServer).ListIssuers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ctmapreduce.CertDatabase/ListIssuers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertDatabaseServer).ListIssuers(ctx, req.(*ListIssuersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertDatabase_ListExpirationDates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListExpirationDatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertDatabaseServer).ListExpirationDates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ctmapreduce.CertDatabase/ListExpirationDates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertDatabaseServer).ListExpirationDates(ctx, req.(*ListExpirationDatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertDatabase_ListSerials_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Bucket)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CertDatabaseServer).ListSerials(m, &certDatabaseListSerialsServer{stream})
+}
+
+type CertDatabase_ListSerialsServer interface {
+	Send(*SerialEntry) error
+	grpc.ServerStream
+}
+
+type certDatabaseListSerialsServer struct {
+	grpc.ServerStream
+}
+
+func (x *certDatabaseListSerialsServer) Send(m *SerialEntry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CertDatabase_LocateSerial_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LocateSerialRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertDatabaseServer).LocateSerial(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ctmapreduce.CertDatabase/LocateSerial",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertDatabaseServer).LocateSerial(ctx, req.(*LocateSerialRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertDatabase_GetIssuerMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IssuerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertDatabaseServer).GetIssuerMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ctmapreduce.CertDatabase/GetIssuerMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertDatabaseServer).GetIssuerMetadata(ctx, req.(*IssuerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertDatabase_GetLogState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertDatabaseServer).GetLogState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ctmapreduce.CertDatabase/GetLogState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertDatabaseServer).GetLogState(ctx, req.(*LogStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _CertDatabase_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ctmapreduce.CertDatabase",
+	HandlerType: (*CertDatabaseServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Store",
+			Handler:    _CertDatabase_Store_Handler,
+		},
+		{
+			MethodName: "StoreBatch",
+			Handler:    _CertDatabase_StoreBatch_Handler,
+		},
+		{
+			MethodName: "ListIssuers",
+			Handler:    _CertDatabase_ListIssuers_Handler,
+		},
+		{
+			MethodName: "ListExpirationDates",
+			Handler:    _CertDatabase_ListExpirationDates_Handler,
+		},
+		{
+			MethodName: "LocateSerial",
+			Handler:    _CertDatabase_LocateSerial_Handler,
+		},
+		{
+			MethodName: "GetIssuerMetadata",
+			Handler:    _CertDatabase_GetIssuerMetadata_Handler,
+		},
+		{
+			MethodName: "GetLogState",
+			Handler:    _CertDatabase_GetLogState_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListSerials",
+			Handler:       _CertDatabase_ListSerials_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "certdatabase.proto",
+}
@@ -0,0 +1,266 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+//go:generate protoc --go_out=plugins=grpc:. certdatabase.proto
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements CertDatabaseServer over a CertDatabase. Certificates are
+// stored just as ct-fetch stores those from CT logs, so producers share its
+// de-duplication.
+type Server struct {
+	db storage.CertDatabase
+	// Returns true for certificates which shouldn't be stored
+	filter func(*x509.Certificate) bool
+}
+
+func NewServer(aDB storage.CertDatabase, aFilter func(*x509.Certificate) bool) *Server {
+	return &Server{
+		db:     aDB,
+		filter: aFilter,
+	}
+}
+
+func parseCertificate(aDER []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(aDER)
+	if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Parse failures, and leaves which issuer didn't sign, are returned as
+// InvalidArgument errors, and storage failures as Internal ones.
+func (s *Server) store(aReq *StoreRequest) (StoreResult_Outcome, error) {
+	if len(aReq.Leaf) == 0 || len(aReq.Issuer) == 0 {
+		return StoreResult_FAILED, status.Error(codes.InvalidArgument, "leaf and issuer are required")
+	}
+	cert, err := parseCertificate(aReq.Leaf)
+	if err != nil {
+		return StoreResult_FAILED, status.Errorf(codes.InvalidArgument, "Couldn't parse leaf: %v", err)
+	}
+	issuer, err := parseCertificate(aReq.Issuer)
+	if err != nil {
+		return StoreResult_FAILED, status.Errorf(codes.InvalidArgument, "Couldn't parse issuer: %v", err)
+	}
+	if err := cert.CheckSignatureFrom(issuer); err != nil {
+		return StoreResult_FAILED, status.Errorf(codes.InvalidArgument, "Leaf isn't signed by issuer: %v", err)
+	}
+
+	if s.filter != nil && s.filter(cert) {
+		metrics.IncrCounter([]string{"service", "Store", "filtered"}, 1)
+		return StoreResult_FILTERED, nil
+	}
+
+	if err := s.db.StoreIssuerCertificate(issuer); err != nil {
+		glog.Errorf("[%s] Problem storing issuing certificate: index: %d error: %s", aReq.Source, aReq.EntryId, err)
+		metrics.IncrCounter([]string{"service", "Store", "failed"}, 1)
+		return StoreResult_FAILED, status.Errorf(codes.Internal, "Couldn't store issuer: %v", err)
+	}
+	if err := s.db.Store(cert, issuer, aReq.Source, aReq.EntryId); err != nil {
+		glog.Errorf("[%s] Problem inserting certificate: index: %d error: %s", aReq.Source, aReq.EntryId, err)
+		metrics.IncrCounter([]string{"service", "Store", "failed"}, 1)
+		return StoreResult_FAILED, status.Errorf(codes.Internal, "Couldn't store: %v", err)
+	}
+	metrics.IncrCounter([]string{"service", "Store", "stored"}, 1)
+	return StoreResult_STORED, nil
+}
+
+func (s *Server) Store(_ context.Context, aReq *StoreRequest) (*StoreResult, error) {
+	defer metrics.MeasureSince([]string{"service", "Store"}, time.Now())
+	outcome, err := s.store(aReq)
+	if err != nil {
+		return nil, err
+	}
+	return &StoreResult{Outcome: outcome}, nil
+}
+
+func (s *Server) StoreBatch(ctx context.Context, aReq *StoreBatchRequest) (*StoreBatchResponse, error) {
+	defer metrics.MeasureSince([]string{"service", "StoreBatch"}, time.Now())
+	resp := &StoreBatchResponse{
+		Results: make([]*StoreResult, 0, len(aReq.Entries)),
+	}
+	for _, entry := range aReq.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		outcome, err := s.store(entry)
+		result := &StoreResult{Outcome: outcome}
+		if err != nil {
+			result.Error = status.Convert(err).Message()
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+func (s *Server) ListIssuers(_ context.Context, _ *ListIssuersRequest) (*ListIssuersResponse, error) {
+	issuerList, err := s.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &ListIssuersResponse{
+		Issuers: make([]*IssuerDates, 0, len(issuerList)),
+	}
+	for _, issuerObj := range issuerList {
+		issuerDates := &IssuerDates{
+			Issuer:   issuerObj.Issuer.ID(),
+			ExpDates: make([]string, 0, len(issuerObj.ExpDates)),
+		}
+		for _, expDate := range issuerObj.ExpDates {
+			issuerDates.ExpDates = append(issuerDates.ExpDates, expDate.ID())
+		}
+		resp.Issuers = append(resp.Issuers, issuerDates)
+	}
+	return resp, nil
+}
+
+func (s *Server) ListExpirationDates(_ context.Context,
+	aReq *ListExpirationDatesRequest) (*ListExpirationDatesResponse, error) {
+	expDates, err := s.db.ListExpirationDates(time.Unix(aReq.NotBefore, 0))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &ListExpirationDatesResponse{
+		ExpDates: make([]string, 0, len(expDates)),
+	}
+	for _, expDate := range expDates {
+		resp.ExpDates = append(resp.ExpDates, expDate.ID())
+	}
+	return resp, nil
+}
+
+func (s *Server) ListSerials(aBucket *Bucket, aStream CertDatabase_ListSerialsServer) error {
+	if len(aBucket.Issuer) == 0 {
+		return status.Error(codes.InvalidArgument, "issuer is required")
+	}
+	expDate, err := storage.NewExpDate(aBucket.ExpDate)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid expiration date %s: %v", aBucket.ExpDate, err)
+	}
+	issuer := storage.NewIssuerFromString(aBucket.Issuer)
+
+	serialChan := make(chan storage.Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.db.GetKnownCertificates(expDate, issuer).StreamKnown(serialChan)
+	}()
+
+	var sendErr error
+	for serial := range serialChan {
+		// Once the client has gone, keep draining so StreamKnown finishes.
+		if sendErr != nil {
+			continue
+		}
+		sendErr = aStream.Send(&SerialEntry{Serial: []byte(serial.BinaryString())})
+	}
+
+	if err := <-errChan; err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return sendErr
+}
+
+func (s *Server) LocateSerial(_ context.Context, aReq *LocateSerialRequest) (*LocateSerialResponse, error) {
+	if len(aReq.Serial) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "serial is required")
+	}
+
+	var issuer *storage.Issuer
+	if len(aReq.Issuer) > 0 {
+		obj := storage.NewIssuerFromString(aReq.Issuer)
+		issuer = &obj
+	}
+
+	buckets, err := s.db.LocateSerial(storage.NewSerialFromBytes(aReq.Serial), issuer)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &LocateSerialResponse{
+		Buckets: make([]*Bucket, 0, len(buckets)),
+	}
+	for _, bucket := range buckets {
+		resp.Buckets = append(resp.Buckets, &Bucket{Issuer: bucket.Issuer.ID(), ExpDate: bucket.ExpDate.ID()})
+	}
+	return resp, nil
+}
+
+func (s *Server) GetIssuerMetadata(_ context.Context, aReq *IssuerRequest) (*IssuerMetadataResponse, error) {
+	if len(aReq.Issuer) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "issuer is required")
+	}
+	issuer := storage.NewIssuerFromString(aReq.Issuer)
+
+	// Only issuers with stored certificates have metadata
+	issuerList, err := s.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	known := false
+	for _, issuerObj := range issuerList {
+		if issuerObj.Issuer.ID() == issuer.ID() {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, status.Errorf(codes.NotFound, "Unknown issuer %s", aReq.Issuer)
+	}
+
+	im := s.db.PeekIssuerMetadata(issuer)
+	crls, err := im.ListCRLs()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	dns, err := im.ListIssuers()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &IssuerMetadataResponse{
+		Crls: crls,
+		Dns:  dns,
+	}, nil
+}
+
+func (s *Server) GetLogState(_ context.Context, aReq *LogStateRequest) (*LogState, error) {
+	logURL, err := url.Parse(aReq.Url)
+	if err != nil || len(aReq.Url) == 0 {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid log URL %s", aReq.Url))
+	}
+
+	log, err := s.db.GetLogState(logURL)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &LogState{
+		ShortUrl:       log.ShortURL,
+		MaxEntry:       log.MaxEntry,
+		LastEntryTime:  unixOrZero(log.LastEntryTime),
+		LastUpdateTime: unixOrZero(log.LastUpdateTime),
+	}, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
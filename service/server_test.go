@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/jcjones/ct-mapreduce/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testIssuer struct {
+	template *x509.Certificate
+	key      *ecdsa.PrivateKey
+	der      []byte
+}
+
+func newTestIssuer(t *testing.T, aCN string) *testIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: aCN},
+		NotBefore:             time.Now().AddDate(-1, 0, 0),
+		NotAfter:              time.Now().AddDate(20, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIssuer{template, key, der}
+}
+
+func (ti *testIssuer) issue(t *testing.T, aCN string, aSerial int64, aNotAfter time.Time) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(aSerial),
+		Subject:      pkix.Name{CommonName: aCN},
+		NotBefore:    aNotAfter.AddDate(-1, 0, 0),
+		NotAfter:     aNotAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ti.template, ti.key.Public(), ti.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// Serves a Server over a local listener, returning a client of it.
+func startServer(t *testing.T, aDB storage.CertDatabase,
+	aFilter func(*ctx509.Certificate) bool) (CertDatabaseClient, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grpcServer := grpc.NewServer()
+	RegisterCertDatabaseServer(grpcServer, NewServer(aDB, aFilter))
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCertDatabaseClient(conn), func() {
+		conn.Close()
+		grpcServer.Stop()
+	}
+}
+
+func Test_StoreAndQuery(t *testing.T) {
+	db, err := storage.NewFilesystemDatabase(storage.NewMockBackend(), storage.NewMockRemoteCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := func(aCert *ctx509.Certificate) bool {
+		return aCert.Subject.CommonName == "filtered"
+	}
+	client, stop := startServer(t, db, filter)
+	defer stop()
+	ctx := context.Background()
+
+	notAfter := time.Date(2031, 1, 2, 3, 0, 0, 0, time.UTC)
+	issuer := newTestIssuer(t, "issuer")
+	issuerDER := issuer.der
+	leafDER := issuer.issue(t, "leaf", 0x0a, notAfter)
+
+	result, err := client.Store(ctx, &StoreRequest{Leaf: leafDER, Issuer: issuerDER, Source: "crawl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != StoreResult_STORED {
+		t.Errorf("Expected STORED, got %s", result.Outcome)
+	}
+
+	_, err = client.Store(ctx, &StoreRequest{Leaf: []byte("junk"), Issuer: issuerDER})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for junk, got %v", err)
+	}
+
+	forger := newTestIssuer(t, "issuer")
+	_, err = client.Store(ctx, &StoreRequest{Leaf: forger.issue(t, "forged", 0x0d, notAfter), Issuer: issuerDER})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a leaf issuer didn't sign, got %v", err)
+	}
+
+	batch, err := client.StoreBatch(ctx, &StoreBatchRequest{Entries: []*StoreRequest{
+		{Leaf: leafDER, Issuer: issuerDER},
+		{Leaf: issuer.issue(t, "leaf", 0x0b, notAfter), Issuer: issuerDER},
+		{Leaf: issuer.issue(t, "filtered", 0x0c, notAfter), Issuer: issuerDER},
+		{Leaf: []byte("junk"), Issuer: issuerDER},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StoreResult_Outcome{StoreResult_STORED, StoreResult_STORED, StoreResult_FILTERED,
+		StoreResult_FAILED}
+	if len(batch.Results) != len(expected) {
+		t.Fatalf("Expected %d results, got %+v", len(expected), batch.Results)
+	}
+	for i, result := range batch.Results {
+		if result.Outcome != expected[i] {
+			t.Errorf("Entry %d: expected %s, got %s", i, expected[i], result.Outcome)
+		}
+	}
+	if len(batch.Results[3].Error) == 0 {
+		t.Error("The junk entry should say why it failed")
+	}
+
+	issuers, err := client.ListIssuers(ctx, &ListIssuersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuers.Issuers) != 1 || len(issuers.Issuers[0].ExpDates) != 1 {
+		t.Fatalf("Expected one issuer with one expiration date, got %+v", issuers.Issuers)
+	}
+	bucket := &Bucket{Issuer: issuers.Issuers[0].Issuer, ExpDate: issuers.Issuers[0].ExpDates[0]}
+
+	stream, err := client.ListSerials(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serials := map[string]bool{}
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials[storage.NewSerialFromBytes(entry.Serial).HexString()] = true
+	}
+	if len(serials) != 2 || !serials["0a"] || !serials["0b"] {
+		t.Errorf("Expected serials 0a and 0b, got %v", serials)
+	}
+
+	located, err := client.LocateSerial(ctx, &LocateSerialRequest{Serial: []byte{0x0b}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(located.Buckets) != 1 || located.Buckets[0].ExpDate != bucket.ExpDate {
+		t.Errorf("Expected 0b in %+v, got %+v", bucket, located.Buckets)
+	}
+
+	located, err = client.LocateSerial(ctx, &LocateSerialRequest{Serial: []byte{0x0c}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(located.Buckets) != 0 {
+		t.Errorf("The filtered serial shouldn't be known, got %+v", located.Buckets)
+	}
+
+	metadata, err := client.GetIssuerMetadata(ctx, &IssuerRequest{Issuer: bucket.Issuer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Dns) != 1 || metadata.Dns[0] != "CN=issuer" {
+		t.Errorf("Expected the issuer's DN, got %+v", metadata.Dns)
+	}
+}
+
+// Fails SetList and KeysToChan while the respective flag is set
+type failingCache struct {
+	*storage.MockRemoteCache
+	failSets bool
+	failKeys bool
+}
+
+func (c *failingCache) SetList(key string) ([]string, error) {
+	if c.failSets {
+		return nil, fmt.Errorf("SetList failed")
+	}
+	return c.MockRemoteCache.SetList(key)
+}
+
+func (c *failingCache) KeysToChan(pattern string, ch chan<- string) error {
+	if c.failKeys {
+		close(ch)
+		return fmt.Errorf("KeysToChan failed")
+	}
+	return c.MockRemoteCache.KeysToChan(pattern, ch)
+}
+
+func Test_GetIssuerMetadataErrors(t *testing.T) {
+	cache := &failingCache{MockRemoteCache: storage.NewMockRemoteCache()}
+	db, err := storage.NewFilesystemDatabase(storage.NewMockBackend(), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, stop := startServer(t, db, nil)
+	defer stop()
+	ctx := context.Background()
+
+	issuer := newTestIssuer(t, "issuer")
+	leafDER := issuer.issue(t, "leaf", 0x0a, time.Date(2031, 1, 2, 3, 0, 0, 0, time.UTC))
+	if _, err := client.Store(ctx, &StoreRequest{Leaf: leafDER, Issuer: issuer.der}); err != nil {
+		t.Fatal(err)
+	}
+	issuerCert, err := ctx509.ParseCertificate(issuer.der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerObj := storage.NewIssuer(issuerCert)
+	issuerID := issuerObj.ID()
+
+	_, err = client.GetIssuerMetadata(ctx, &IssuerRequest{Issuer: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for an unknown issuer, got %v", err)
+	}
+
+	cache.failSets = true
+	_, err = client.GetIssuerMetadata(ctx, &IssuerRequest{Issuer: issuerID})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected Internal when the sets can't be read, got %v", err)
+	}
+	cache.failSets = false
+
+	cache.failKeys = true
+	_, err = client.GetIssuerMetadata(ctx, &IssuerRequest{Issuer: issuerID})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected Internal when the issuers can't be listed, got %v", err)
+	}
+	cache.failKeys = false
+
+	metadata, err := client.GetIssuerMetadata(ctx, &IssuerRequest{Issuer: issuerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Dns) != 1 || metadata.Dns[0] != "CN=issuer" {
+		t.Errorf("Expected the issuer's DN once the cache recovers, got %+v", metadata.Dns)
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BucketGranularity is the span of expiration times grouped into one
+// ExpDate bucket.
+type BucketGranularity string
+
+const (
+	HourGranularity BucketGranularity = "hour"
+	DayGranularity  BucketGranularity = "day"
+	WeekGranularity BucketGranularity = "week"
+)
+
+func ParseBucketGranularity(s string) (BucketGranularity, error) {
+	switch BucketGranularity(s) {
+	case HourGranularity, DayGranularity, WeekGranularity:
+		return BucketGranularity(s), nil
+	}
+	return HourGranularity, fmt.Errorf("Unknown bucket granularity: %s", s)
+}
+
+func (g BucketGranularity) duration() time.Duration {
+	switch g {
+	case DayGranularity:
+		return 24 * time.Hour
+	case WeekGranularity:
+		return 7 * 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// True if buckets of g hold whole buckets of aOther.
+func (g BucketGranularity) CoarserThan(aOther BucketGranularity) bool {
+	return g.duration() > aOther.duration()
+}
+
+// Weeks start on Monday, as ISO weeks do.
+func (g BucketGranularity) truncate(t time.Time) time.Time {
+	t = t.UTC()
+	switch g {
+	case DayGranularity:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case WeekGranularity:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return t.Truncate(time.Hour)
+}
+
+func (g BucketGranularity) end(aStart time.Time) time.Time {
+	return aStart.Add(g.duration())
+}
+
+// Parses an ISO week, like 2004-W04, into the Monday starting it.
+func parseISOWeek(s string) (time.Time, error) {
+	var year, week int
+	if _, err := fmt.Sscanf(s, "%04d-W%02d", &year, &week); err != nil {
+		return time.Time{}, fmt.Errorf("Not an ISO week: %s", s)
+	}
+	if week < 1 || week > 53 {
+		return time.Time{}, fmt.Errorf("Not an ISO week: %s", s)
+	}
+	// The 4th of January is always in week 1
+	firstWeek := WeekGranularity.truncate(time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC))
+	monday := firstWeek.AddDate(0, 0, 7*(week-1))
+	if y, w := monday.ISOWeek(); y != year || w != week {
+		return time.Time{}, fmt.Errorf("Not an ISO week: %s", s)
+	}
+	return monday, nil
+}
+
+// Moves one bucket's serials in the cache into the bucket of aTo holding
+// it, returning that bucket. As with MigrateSerialEncoding, writers must be
+// stopped.
+func MergeCachedBucket(aCache RemoteCache, aEncoding SerialEncoding, aBucket IssuerAndDate,
+	aTo BucketGranularity) (IssuerAndDate, int64, error) {
+	target := IssuerAndDate{
+		ExpDate: NewExpDateFromTimeWithGranularity(aBucket.ExpDate.date, aTo),
+		Issuer:  aBucket.Issuer,
+	}
+	if !aTo.CoarserThan(aBucket.ExpDate.Granularity()) {
+		return target, 0, fmt.Errorf("Can't merge %s into the coarser %s buckets", aBucket.String(), aTo)
+	}
+
+	from := NewKnownCertificatesWithEncoding(aBucket.ExpDate, aBucket.Issuer, aCache, aEncoding)
+	to := NewKnownCertificatesWithEncoding(target.ExpDate, target.Issuer, aCache, aEncoding)
+
+	merged, err := copyKnown(from, to)
+	if err != nil {
+		return target, merged, fmt.Errorf("Merging %s into %s failed: %v", aBucket.String(),
+			target.String(), err)
+	}
+
+	return target, merged, aCache.Delete(from.serialId())
+}
+
+// Moves every certificate stored for aExpDate into the buckets of aTo
+// holding them, marking those dirty.
+func MergeBackendExpDate(ctx context.Context, aBackend StorageBackend, aExpDate ExpDate,
+	aTo BucketGranularity) (int64, error) {
+	if !aTo.CoarserThan(aExpDate.Granularity()) {
+		return 0, fmt.Errorf("Can't merge %s into the coarser %s buckets", aExpDate.ID(), aTo)
+	}
+	targetDate := NewExpDateFromTimeWithGranularity(aExpDate.date, aTo)
+
+	issuers, err := aBackend.ListIssuersForExpirationDate(ctx, aExpDate)
+	if err != nil {
+		return 0, err
+	}
+
+	var merged int64
+	for _, issuer := range issuers {
+		if err := aBackend.AllocateExpDateAndIssuer(ctx, targetDate, issuer); err != nil {
+			return merged, err
+		}
+
+		serials, err := aBackend.ListSerialsForExpirationDateAndIssuer(ctx, aExpDate, issuer)
+		if err != nil {
+			return merged, err
+		}
+		for _, serial := range serials {
+			pemBytes, err := aBackend.LoadCertificatePEM(ctx, serial, aExpDate, issuer)
+			if err != nil {
+				return merged, err
+			}
+			err = aBackend.StoreCertificatePEM(ctx, serial, targetDate, issuer, pemBytes)
+			if err != nil {
+				return merged, err
+			}
+			merged++
+		}
+
+		err = aBackend.MarkDirty(ctx, IssuerAndDate{ExpDate: targetDate, Issuer: issuer})
+		if err != nil {
+			return merged, err
+		}
+	}
+
+	return merged, aBackend.DeleteExpirationDate(ctx, aExpDate)
+}
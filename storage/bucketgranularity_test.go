@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_BucketGranularityIDs(t *testing.T) {
+	notAfter := time.Date(2004, 01, 22, 4, 22, 19, 44, time.UTC)
+	for granularity, expectedID := range map[BucketGranularity]string{
+		HourGranularity: "2004-01-22-04",
+		DayGranularity:  "2004-01-22",
+		WeekGranularity: "2004-W04",
+	} {
+		expDate := NewExpDateFromTimeWithGranularity(notAfter, granularity)
+		if expDate.ID() != expectedID {
+			t.Errorf("[%s] Expected %s, got %s", granularity, expectedID, expDate.ID())
+		}
+
+		parsed, err := NewExpDate(expDate.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.ID() != expectedID || parsed.Granularity() != granularity {
+			t.Errorf("[%s] Parsed %s as %s of %s", granularity, expectedID, parsed.ID(), parsed.Granularity())
+		}
+		// However it was built, a bucket expires at its end
+		if !parsed.ExpireTime().Equal(expDate.ExpireTime()) ||
+			parsed.IsExpiredAt(notAfter) != expDate.IsExpiredAt(notAfter) {
+			t.Errorf("[%s] Expected %s to expire at %s whether built or parsed, not %s", granularity,
+				expectedID, expDate.ExpireTime(), parsed.ExpireTime())
+		}
+		if expDate.IsExpiredAt(notAfter) || !expDate.IsExpiredAt(expDate.ExpireTime()) {
+			t.Errorf("[%s] Expected %s live until %s", granularity, expectedID, expDate.ExpireTime())
+		}
+	}
+
+	week := NewExpDateFromTimeWithGranularity(notAfter, WeekGranularity)
+	if week.IsExpiredAt(time.Date(2004, 01, 25, 23, 59, 59, 0, time.UTC)) {
+		t.Error("The week should last through Sunday")
+	}
+	if !week.IsExpiredAt(time.Date(2004, 01, 26, 0, 0, 0, 0, time.UTC)) {
+		t.Error("The week should be expired on Monday")
+	}
+	if week.ExpireTime() != time.Date(2004, 01, 26, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("Unexpected expiration time %s", week.ExpireTime())
+	}
+
+	// ISO week 1 of 2005 began in 2004
+	newYear := NewExpDateFromTimeWithGranularity(time.Date(2005, 01, 01, 0, 0, 0, 0, time.UTC), WeekGranularity)
+	if newYear.ID() != "2004-W53" {
+		t.Errorf("Expected 2004-W53, got %s", newYear.ID())
+	}
+	if _, err := NewExpDate("2005-W53"); err == nil {
+		t.Error("2005 has no 53rd week")
+	}
+	if _, err := ParseBucketGranularity("fortnight"); err == nil {
+		t.Error("Expected an error for an unknown granularity")
+	}
+}
+
+func Test_StoreWithGranularity(t *testing.T) {
+	storageDB, err := NewFilesystemDatabase(NewMockBackend(), NewMockRemoteCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageDB.SetBucketGranularity(DayGranularity)
+
+	issuerCert := makeCert(t, "Daily Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	for i := 0; i < 3; i++ {
+		cert := makeCert(t, "Leaf", "2050-01-01", NewSerialFromBytes([]byte{byte(i + 1)}))
+		cert.NotAfter = cert.NotAfter.Add(time.Duration(i*5) * time.Hour)
+		if err := storageDB.Store(cert, issuerCert, "log.ct", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issuers, err := storageDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuers) != 1 || len(issuers[0].ExpDates) != 1 || issuers[0].ExpDates[0].ID() != "2050-01-01" {
+		t.Errorf("Expected a single daily bucket: %+v", issuers)
+	}
+
+	dirty, err := storageDB.ListDirtyBuckets(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 1 || dirty[0].ExpDate.ID() != "2050-01-01" {
+		t.Errorf("Expected the daily bucket to be dirty: %+v", dirty)
+	}
+}
+
+func Test_MergeBuckets(t *testing.T) {
+	_, cache, storageDB := getTestHarness(t)
+	backend := storageDB.(*FilesystemDatabase).backend
+
+	issuerCert := makeCert(t, "Hourly Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	issuer := NewIssuer(issuerCert)
+	for i := 0; i < 3; i++ {
+		cert := makeCert(t, "Leaf", "2050-01-01", NewSerialFromBytes([]byte{byte(i + 1)}))
+		cert.NotAfter = cert.NotAfter.Add(time.Duration(i*5) * time.Hour)
+		if err := storageDB.Store(cert, issuerCert, "log.ct", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hourly, err := storageDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hourly) != 1 || len(hourly[0].ExpDates) != 3 {
+		t.Fatalf("Expected three hourly buckets: %+v", hourly)
+	}
+
+	var merged int64
+	for _, expDate := range hourly[0].ExpDates {
+		bucket := IssuerAndDate{ExpDate: expDate, Issuer: issuer}
+		target, count, err := MergeCachedBucket(cache, SetSerialEncoding, bucket, DayGranularity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target.ExpDate.ID() != "2050-01-01" {
+			t.Errorf("Expected to merge into 2050-01-01, not %s", target.ExpDate.ID())
+		}
+		merged += count
+	}
+	if merged != 3 {
+		t.Errorf("Expected 3 serials merged, got %d", merged)
+	}
+
+	daily, err := storageDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 1 || len(daily[0].ExpDates) != 1 || daily[0].ExpDates[0].ID() != "2050-01-01" {
+		t.Errorf("Expected a single daily bucket: %+v", daily)
+	}
+
+	expDates, err := backend.ListExpirationDates(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged = 0
+	for _, expDate := range expDates {
+		count, err := MergeBackendExpDate(context.TODO(), backend, expDate, DayGranularity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		merged += count
+	}
+	if merged != 3 {
+		t.Errorf("Expected 3 certificates merged, got %d", merged)
+	}
+
+	expDates, err = backend.ListExpirationDates(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expDatesAndStringsEqual(t, []string{"2050-01-01"}, expDates)
+
+	serials, err := backend.ListSerialsForExpirationDateAndIssuer(context.TODO(), expDates[0], issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serials) != 3 {
+		t.Errorf("Expected 3 serials in the daily bucket, got %d", len(serials))
+	}
+
+	_, _, err = MergeCachedBucket(cache, SetSerialEncoding, IssuerAndDate{ExpDate: expDates[0], Issuer: issuer},
+		HourGranularity)
+	if err == nil {
+		t.Error("Shouldn't be able to merge into finer buckets")
+	}
+}
+
+func Test_MergeBucketChecksEachSerial(t *testing.T) {
+	cache := &droppingCache{MockRemoteCache: NewMockRemoteCache(), drop: map[string]bool{}}
+	issuer := NewIssuerFromString("test issuer")
+	bucket := IssuerAndDate{ExpDate: mkExpDate("2050-01-01-05"), Issuer: issuer}
+
+	source := NewKnownCertificatesWithEncoding(bucket.ExpDate, issuer, cache, PackedSerialEncoding)
+	if _, err := source.WasUnknown(NewSerialFromHex("01")); err != nil {
+		t.Fatal(err)
+	}
+	// Enough already in the target to make up for the lost serial
+	target := NewKnownCertificatesWithEncoding(mkExpDate("2050-01-01"), issuer, cache, PackedSerialEncoding)
+	if _, err := target.WasUnknown(NewSerialFromHex("02")); err != nil {
+		t.Fatal(err)
+	}
+	cache.drop[NewSerialFromHex("01").BinaryString()] = true
+
+	if _, _, err := MergeCachedBucket(cache, PackedSerialEncoding, bucket, DayGranularity); err == nil {
+		t.Fatal("Expected the lost serial to fail the merge")
+	}
+	if source.Count() != 1 {
+		t.Errorf("Expected the hourly bucket kept after a failed merge, got %d serials", source.Count())
+	}
+}
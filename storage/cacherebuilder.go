@@ -0,0 +1,430 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+// RebuildCheckpoint records the IssuerAndDate buckets a CacheRebuilder has
+// finished, one per line, so that an interrupted rebuild can resume.
+type RebuildCheckpoint struct {
+	mutex     *sync.Mutex
+	completed map[string]struct{}
+	fd        *os.File
+}
+
+func NewRebuildCheckpoint(aPath string) (*RebuildCheckpoint, error) {
+	cp := &RebuildCheckpoint{
+		mutex:     &sync.Mutex{},
+		completed: make(map[string]struct{}),
+	}
+
+	fd, err := os.OpenFile(aPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 {
+			cp.completed[line] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fd.Close() // ignore error
+		return nil, err
+	}
+
+	cp.fd = fd
+	return cp, nil
+}
+
+func (cp *RebuildCheckpoint) IsComplete(aBucket IssuerAndDate) bool {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	_, ok := cp.completed[aBucket.String()]
+	return ok
+}
+
+func (cp *RebuildCheckpoint) MarkComplete(aBucket IssuerAndDate) error {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	id := aBucket.String()
+	if _, ok := cp.completed[id]; ok {
+		return nil
+	}
+	cp.completed[id] = struct{}{}
+	_, err := fmt.Fprintln(cp.fd, id)
+	return err
+}
+
+func (cp *RebuildCheckpoint) Count() int {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	return len(cp.completed)
+}
+
+func (cp *RebuildCheckpoint) Close() error {
+	return cp.fd.Close()
+}
+
+type RebuildBucketResult struct {
+	Bucket         string `json:"bucket"`
+	SerialsStored  int64  `json:"serialsStored"`
+	SerialsMissing int64  `json:"serialsMissing"`
+	Errors         int64  `json:"errors"`
+}
+
+type RebuildIssuerResult struct {
+	Issuer      string   `json:"issuer"`
+	MissingCRLs []string `json:"missingCRLs"`
+	MissingDNs  []string `json:"missingDNs"`
+}
+
+type RebuildLogResult struct {
+	ShortURL string `json:"shortUrl"`
+	MaxEntry int64  `json:"maxEntry"`
+	Missing  bool   `json:"missing"`
+}
+
+// RebuildReport is the difference between the StorageBackend and the
+// RemoteCache found by a CacheRebuilder. Unless DryRun was set, everything
+// listed as missing has since been restored.
+type RebuildReport struct {
+	DryRun         bool                  `json:"dryRun"`
+	SkippedBuckets int64                 `json:"skippedBuckets"`
+	TotalSerials   int64                 `json:"totalSerials"`
+	TotalMissing   int64                 `json:"totalMissing"`
+	TotalErrors    int64                 `json:"totalErrors"`
+	Buckets        []RebuildBucketResult `json:"buckets"`
+	Issuers        []RebuildIssuerResult `json:"issuers"`
+	Logs           []RebuildLogResult    `json:"logs"`
+}
+
+type issuerRebuildState struct {
+	mutex     *sync.Mutex
+	cacheCRLs map[string]struct{}
+	cacheDNs  map[string]struct{}
+	diskCRLs  map[string]struct{}
+	diskDNs   map[string]struct{}
+}
+
+func (s *issuerRebuildState) observe(aCert *x509.Certificate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, dp := range aCert.CRLDistributionPoints {
+		if crl, ok := normalizeURL(dp); ok {
+			s.diskCRLs[crl] = struct{}{}
+		}
+	}
+	s.diskDNs[aCert.Issuer.String()] = struct{}{}
+}
+
+func missingFrom(aFound map[string]struct{}, aKnown map[string]struct{}) []string {
+	missing := []string{}
+	for k := range aFound {
+		if _, ok := aKnown[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func toSet(aList []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(aList))
+	for _, v := range aList {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// CacheRebuilder repopulates the RemoteCache's known serials, issuer metadata
+// and log states from the certificates held in a StorageBackend.
+type CacheRebuilder struct {
+	backend    StorageBackend
+	db         CertDatabase
+	cache      RemoteCache
+	DryRun     bool
+	NumWorkers int
+	Checkpoint *RebuildCheckpoint
+
+	issuerMutex *sync.Mutex
+	issuers     map[string]*issuerRebuildState
+}
+
+func NewCacheRebuilder(aBackend StorageBackend, aDB CertDatabase, aCache RemoteCache) *CacheRebuilder {
+	return &CacheRebuilder{
+		backend:     aBackend,
+		db:          aDB,
+		cache:       aCache,
+		DryRun:      false,
+		NumWorkers:  1,
+		Checkpoint:  nil,
+		issuerMutex: &sync.Mutex{},
+		issuers:     make(map[string]*issuerRebuildState),
+	}
+}
+
+// The cache's view of an issuer must be captured before any of its
+// certificates are accumulated, or nothing would appear to be missing.
+func (rb *CacheRebuilder) issuerState(aIssuer Issuer) *issuerRebuildState {
+	rb.issuerMutex.Lock()
+	defer rb.issuerMutex.Unlock()
+
+	state, ok := rb.issuers[aIssuer.ID()]
+	if !ok {
+		meta := rb.db.GetIssuerMetadata(aIssuer)
+		state = &issuerRebuildState{
+			mutex:     &sync.Mutex{},
+			cacheCRLs: toSet(meta.CRLs()),
+			cacheDNs:  toSet(meta.Issuers()),
+			diskCRLs:  make(map[string]struct{}),
+			diskDNs:   make(map[string]struct{}),
+		}
+		rb.issuers[aIssuer.ID()] = state
+	}
+	return state
+}
+
+func loadCertificate(ctx context.Context, aBackend StorageBackend, aId UniqueCertIdentifier) (*x509.Certificate, error) {
+	pemBytes, err := aBackend.LoadCertificatePEM(ctx, aId.SerialNum, aId.ExpDate, aId.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found for %s", aId.String())
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if _, ok := err.(x509.NonFatalErrors); !ok && err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (rb *CacheRebuilder) rebuildBucket(ctx context.Context, aBucket IssuerAndDate) (RebuildBucketResult, error) {
+	defer metrics.MeasureSince([]string{"CacheRebuilder", "rebuildBucket"}, time.Now())
+
+	result := RebuildBucketResult{
+		Bucket: aBucket.String(),
+	}
+
+	knownCerts := rb.db.GetKnownCertificates(aBucket.ExpDate, aBucket.Issuer)
+	issuerMeta := rb.db.GetIssuerMetadata(aBucket.Issuer)
+	state := rb.issuerState(aBucket.Issuer)
+
+	serialChan := make(chan UniqueCertIdentifier, 1024)
+	quitChan := make(chan struct{})
+	defer close(quitChan)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- rb.backend.StreamSerialsForExpirationDateAndIssuer(ctx, aBucket.ExpDate,
+			aBucket.Issuer, quitChan, serialChan)
+		close(serialChan)
+	}()
+
+	for id := range serialChan {
+		result.SerialsStored++
+
+		known, err := knownCerts.IsKnown(id.SerialNum)
+		if err != nil {
+			return result, err
+		}
+		if !known {
+			result.SerialsMissing++
+			if !rb.DryRun {
+				if _, err := knownCerts.WasUnknown(id.SerialNum); err != nil {
+					return result, err
+				}
+			}
+		}
+
+		cert, err := loadCertificate(ctx, rb.backend, id)
+		if err != nil {
+			glog.Warningf("[%s] Couldn't load certificate %s: %v", result.Bucket, id.SerialNum, err)
+			metrics.IncrCounter([]string{"CacheRebuilder", "loadCertificate", "error"}, 1)
+			result.Errors++
+			continue
+		}
+
+		state.observe(cert)
+		if rb.DryRun {
+			continue
+		}
+		// Only certificates the cache lacked are counted again, so rebuilding
+		// doesn't inflate the tallies and statistics.
+		if known {
+			_, err = issuerMeta.accumulateIdempotent(cert)
+		} else {
+			_, err = issuerMeta.Accumulate(cert)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, <-errChan
+}
+
+func (rb *CacheRebuilder) rebuildLogState(ctx context.Context, aShortURL string) (RebuildLogResult, error) {
+	result := RebuildLogResult{
+		ShortURL: aShortURL,
+	}
+
+	diskState, err := rb.backend.LoadLogState(ctx, aShortURL)
+	if err != nil {
+		return result, err
+	}
+	result.MaxEntry = diskState.MaxEntry
+
+	cacheState, err := rb.cache.LoadLogState(aShortURL)
+	if err == nil && cacheState != nil && cacheState.MaxEntry >= diskState.MaxEntry {
+		return result, nil
+	}
+
+	result.Missing = true
+	if rb.DryRun || diskState.MaxEntry == 0 {
+		return result, nil
+	}
+	return result, rb.cache.StoreLogState(diskState)
+}
+
+// Rebuild walks every bucket in the StorageBackend not expired at aNotBefore,
+// restoring whatever the cache lacks unless DryRun is set. Log states are
+// restored for each of aLogShortURLs.
+func (rb *CacheRebuilder) Rebuild(ctx context.Context, aNotBefore time.Time,
+	aLogShortURLs []string) (*RebuildReport, error) {
+	report := &RebuildReport{
+		DryRun:  rb.DryRun,
+		Buckets: []RebuildBucketResult{},
+		Issuers: []RebuildIssuerResult{},
+		Logs:    []RebuildLogResult{},
+	}
+
+	expDates, err := rb.backend.ListExpirationDates(ctx, aNotBefore)
+	if err != nil {
+		return report, err
+	}
+
+	buckets := []IssuerAndDate{}
+	for _, expDate := range expDates {
+		issuers, err := rb.backend.ListIssuersForExpirationDate(ctx, expDate)
+		if err != nil {
+			return report, err
+		}
+		for _, issuer := range issuers {
+			bucket := IssuerAndDate{
+				ExpDate: expDate,
+				Issuer:  issuer,
+			}
+			if rb.Checkpoint != nil && rb.Checkpoint.IsComplete(bucket) {
+				report.SkippedBuckets++
+				continue
+			}
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	glog.Infof("Rebuilding %d buckets (%d already complete) with %d workers, dryRun=%v",
+		len(buckets), report.SkippedBuckets, rb.NumWorkers, rb.DryRun)
+
+	workers := rb.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	bucketChan := make(chan IssuerAndDate, len(buckets))
+	for _, bucket := range buckets {
+		bucketChan <- bucket
+	}
+	close(bucketChan)
+
+	resultChan := make(chan RebuildBucketResult, len(buckets))
+	errChan := make(chan error, workers)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bucket := range bucketChan {
+				if ctx.Err() != nil {
+					return
+				}
+				result, err := rb.rebuildBucket(ctx, bucket)
+				resultChan <- result
+				if err != nil {
+					errChan <- fmt.Errorf("Failed rebuilding %s: %v", bucket.String(), err)
+					return
+				}
+				if rb.Checkpoint != nil && !rb.DryRun {
+					if err := rb.Checkpoint.MarkComplete(bucket); err != nil {
+						errChan <- err
+						return
+					}
+				}
+				glog.V(1).Infof("[%s] %d serials stored, %d missing from cache", result.Bucket,
+					result.SerialsStored, result.SerialsMissing)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(resultChan)
+	close(errChan)
+
+	for result := range resultChan {
+		report.Buckets = append(report.Buckets, result)
+		report.TotalSerials += result.SerialsStored
+		report.TotalMissing += result.SerialsMissing
+		report.TotalErrors += result.Errors
+	}
+	sort.Slice(report.Buckets, func(i, j int) bool {
+		return report.Buckets[i].Bucket < report.Buckets[j].Bucket
+	})
+
+	for id, state := range rb.issuers {
+		state.mutex.Lock()
+		result := RebuildIssuerResult{
+			Issuer:      id,
+			MissingCRLs: missingFrom(state.diskCRLs, state.cacheCRLs),
+			MissingDNs:  missingFrom(state.diskDNs, state.cacheDNs),
+		}
+		state.mutex.Unlock()
+		if len(result.MissingCRLs) > 0 || len(result.MissingDNs) > 0 {
+			report.Issuers = append(report.Issuers, result)
+		}
+	}
+	sort.Slice(report.Issuers, func(i, j int) bool {
+		return report.Issuers[i].Issuer < report.Issuers[j].Issuer
+	})
+
+	if err := <-errChan; err != nil {
+		return report, err
+	}
+
+	for _, shortURL := range aLogShortURLs {
+		result, err := rb.rebuildLogState(ctx, shortURL)
+		if err != nil {
+			return report, err
+		}
+		report.Logs = append(report.Logs, result)
+	}
+
+	return report, nil
+}
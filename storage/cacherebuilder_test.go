@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeRebuildHarness(t *testing.T) (string, StorageBackend) {
+	rootFolder, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := NewLocalDiskBackend(0644, rootFolder)
+
+	// Populate the backend through a database whose cache we then discard
+	db, err := NewFilesystemDatabase(backend, NewMockRemoteCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuerCert := makeCert(t, "Rebuild Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	for i, date := range []string{"2050-01-01", "2050-01-01", "2050-01-02"} {
+		cert := makeCert(t, "Rebuild Issuer", date, NewSerialFromBytes([]byte{0x01, byte(i)}))
+		if err := db.Store(cert, issuerCert, "log.ct/2050", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = backend.StoreLogState(context.TODO(), &CertificateLog{ShortURL: "log.ct/2050", MaxEntry: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rootFolder, backend
+}
+
+func Test_CacheRebuild(t *testing.T) {
+	root, backend := makeRebuildHarness(t)
+	defer os.RemoveAll(root)
+
+	cache := NewMockRemoteCache()
+	db, err := NewFilesystemDatabase(backend, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilder := NewCacheRebuilder(backend, db, cache)
+	report, err := rebuilder.Rebuild(context.TODO(), time.Time{}, []string{"log.ct/2050"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Buckets) != 2 {
+		t.Errorf("Expected two buckets: %+v", report.Buckets)
+	}
+	if report.TotalSerials != 3 || report.TotalMissing != 3 || report.TotalErrors != 0 {
+		t.Errorf("Expected 3 serials all missing: %+v", report)
+	}
+	if len(report.Issuers) != 1 || len(report.Issuers[0].MissingDNs) != 1 {
+		t.Errorf("Expected one issuer with a missing DN: %+v", report.Issuers)
+	}
+
+	issuerList, err := db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuerList) != 1 || len(issuerList[0].ExpDates) != 2 {
+		t.Fatalf("Expected one issuer with two dates in the cache: %+v", issuerList)
+	}
+	var count int64
+	for _, expDate := range issuerList[0].ExpDates {
+		count += db.GetKnownCertificates(expDate, issuerList[0].Issuer).Count()
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 serials restored, got %d", count)
+	}
+	if len(db.GetIssuerMetadata(issuerList[0].Issuer).Issuers()) != 1 {
+		t.Error("Expected the issuer DN to be restored")
+	}
+
+	log, err := cache.LoadLogState("log.ct/2050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.MaxEntry != 3 {
+		t.Errorf("Expected the log state to be restored: %s", log)
+	}
+
+	// A second pass finds nothing missing
+	report, err = NewCacheRebuilder(backend, db, cache).Rebuild(context.TODO(), time.Time{},
+		[]string{"log.ct/2050"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalSerials != 3 || report.TotalMissing != 0 || len(report.Issuers) != 0 {
+		t.Errorf("Expected nothing missing: %+v", report)
+	}
+	if len(report.Logs) != 1 || report.Logs[0].Missing {
+		t.Errorf("Expected the log state to be current: %+v", report.Logs)
+	}
+	stats, err := db.GetIssuerMetadata(issuerList[0].Issuer).Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Certificates != 3 {
+		t.Errorf("Expected rebuilding again not to count certificates twice, got %d", stats.Certificates)
+	}
+}
+
+func Test_CacheRebuildDryRun(t *testing.T) {
+	root, backend := makeRebuildHarness(t)
+	defer os.RemoveAll(root)
+
+	cache := NewMockRemoteCache()
+	db, err := NewFilesystemDatabase(backend, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilder := NewCacheRebuilder(backend, db, cache)
+	rebuilder.DryRun = true
+	report, err := rebuilder.Rebuild(context.TODO(), time.Time{}, []string{"log.ct/2050"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.TotalMissing != 3 {
+		t.Errorf("Expected 3 missing serials: %+v", report)
+	}
+	if len(report.Logs) != 1 || !report.Logs[0].Missing {
+		t.Errorf("Expected the log state to be missing: %+v", report.Logs)
+	}
+
+	for key, val := range cache.Data {
+		if len(val) > 0 {
+			t.Errorf("Dry run should not have written %s", key)
+		}
+	}
+}
+
+func Test_CacheRebuildResume(t *testing.T) {
+	root, backend := makeRebuildHarness(t)
+	defer os.RemoveAll(root)
+
+	checkpointPath := filepath.Join(root, "checkpoint")
+
+	cache := NewMockRemoteCache()
+	db, err := NewFilesystemDatabase(backend, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := NewRebuildCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilder := NewCacheRebuilder(backend, db, cache)
+	rebuilder.Checkpoint = checkpoint
+	if _, err = rebuilder.Rebuild(context.TODO(), time.Time{}, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = checkpoint.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err = NewRebuildCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+	if checkpoint.Count() != 2 {
+		t.Errorf("Expected two completed buckets, got %d", checkpoint.Count())
+	}
+
+	rebuilder = NewCacheRebuilder(backend, db, cache)
+	rebuilder.Checkpoint = checkpoint
+	report, err := rebuilder.Rebuild(context.TODO(), time.Time{}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SkippedBuckets != 2 || len(report.Buckets) != 0 {
+		t.Errorf("Expected every bucket to be skipped: %+v", report)
+	}
+}
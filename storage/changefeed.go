@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+)
+
+const kChangeFeed = "changes"
+
+// Position from which to read the entire retained change feed
+const ChangeFeedBeginning = "0"
+
+type CertificateChange struct {
+	// Position of this change in the feed; read after it to resume.
+	Position string
+	UniqueCertIdentifier
+}
+
+// ChangeFeed is a bounded, durable stream of certificates as they become
+// known. Consumers track their own positions. Each change is published just
+// before its certificate is recorded, so a consumer may see it before the
+// certificate is stored, and concurrent Stores of one certificate may publish
+// it more than once.
+type ChangeFeed struct {
+	cache       RemoteCache
+	maxLen      int64
+	BatchSize   int64
+	BlockPeriod time.Duration
+}
+
+func NewChangeFeed(aCache RemoteCache, aMaxLen int64) *ChangeFeed {
+	return &ChangeFeed{
+		cache:       aCache,
+		maxLen:      aMaxLen,
+		BatchSize:   1024,
+		BlockPeriod: 5 * time.Second,
+	}
+}
+
+func (cf *ChangeFeed) Publish(aId UniqueCertIdentifier) error {
+	_, err := cf.cache.StreamAdd(kChangeFeed, aId.String(), cf.maxLen)
+	if err != nil {
+		metrics.IncrCounter([]string{"ChangeFeed", "Publish", "error"}, 1)
+		return err
+	}
+	metrics.IncrCounter([]string{"ChangeFeed", "Publish"}, 1)
+	return nil
+}
+
+func (cf *ChangeFeed) Length() (int64, error) {
+	return cf.cache.StreamLength(kChangeFeed)
+}
+
+// Returns up to BatchSize changes after aPosition, without waiting, and the
+// position to read after next. That position passes any malformed entries,
+// so it may be past the last change returned.
+func (cf *ChangeFeed) Read(aPosition string) ([]CertificateChange, string, error) {
+	return cf.read(aPosition, 0)
+}
+
+func (cf *ChangeFeed) read(aPosition string, aBlock time.Duration) ([]CertificateChange, string, error) {
+	entries, err := cf.cache.StreamRead(kChangeFeed, aPosition, cf.BatchSize, aBlock)
+	if err != nil {
+		return nil, aPosition, err
+	}
+
+	next := aPosition
+	changes := make([]CertificateChange, 0, len(entries))
+	for _, entry := range entries {
+		next = entry.ID
+		id, err := ParseUniqueCertIdentifier(entry.Value)
+		if err != nil {
+			glog.Warningf("Skipping malformed change %s: %s %v", entry.ID, entry.Value, err)
+			continue
+		}
+		changes = append(changes, CertificateChange{
+			Position:             entry.ID,
+			UniqueCertIdentifier: id,
+		})
+	}
+	return changes, next, nil
+}
+
+// Subscribe sends every change after aPosition to c until ctx is done,
+// then closes c.
+func (cf *ChangeFeed) Subscribe(ctx context.Context, aPosition string,
+	c chan<- CertificateChange) error {
+	defer close(c)
+
+	position := aPosition
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		changes, next, err := cf.read(position, cf.BlockPeriod)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			select {
+			case <-ctx.Done():
+				return nil
+			case c <- change:
+				position = change.Position
+			}
+		}
+		position = next
+	}
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_ChangeFeedFromStore(t *testing.T) {
+	_, cache, storageDB := getTestHarness(t)
+	feed := storageDB.(*FilesystemDatabase).EnableChangeFeed(2)
+
+	issuerCert := makeCert(t, "Feed Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	for i, serial := range []string{"01", "02", "01", "03"} {
+		cert := makeCert(t, "Feed Issuer", "2050-01-01", NewSerialFromHex(serial))
+		if err := storageDB.Store(cert, issuerCert, "log.ct", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	length, err := feed.Length()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 2 {
+		t.Errorf("Expected retention to bound the feed to 2 entries, got %d", length)
+	}
+
+	changes, _, err := NewChangeFeed(cache, 0).Read(ChangeFeedBeginning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expected two retained changes: %+v", changes)
+	}
+	if changes[0].SerialNum.HexString() != "02" || changes[1].SerialNum.HexString() != "03" {
+		t.Errorf("Expected serials 02 and 03: %+v", changes)
+	}
+	expectedIssuer := NewIssuer(issuerCert)
+	if changes[0].Issuer.ID() != expectedIssuer.ID() || changes[0].ExpDate.ID() != "2050-01-01-00" {
+		t.Errorf("Unexpected change %s", changes[0].String())
+	}
+
+	resumed, _, err := feed.Read(changes[0].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed) != 1 || resumed[0].SerialNum.HexString() != "03" {
+		t.Errorf("Expected to resume after the first change: %+v", resumed)
+	}
+}
+
+// Fails StreamAdd while failing is set
+type failingFeedCache struct {
+	*MockRemoteCache
+	failing bool
+}
+
+func (c *failingFeedCache) StreamAdd(key string, value string, maxLen int64) (string, error) {
+	if c.failing {
+		return "", fmt.Errorf("StreamAdd failed")
+	}
+	return c.MockRemoteCache.StreamAdd(key, value, maxLen)
+}
+
+func Test_ChangeFeedPublishRetried(t *testing.T) {
+	cache := &failingFeedCache{MockRemoteCache: NewMockRemoteCache(), failing: true}
+	storageDB, err := NewFilesystemDatabase(NewMockBackend(), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := storageDB.EnableChangeFeed(0)
+
+	issuerCert := makeCert(t, "Feed Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	cert := makeCert(t, "Feed Issuer", "2050-01-01", NewSerialFromHex("01"))
+	if err := storageDB.Store(cert, issuerCert, "log.ct", 1); err == nil {
+		t.Fatal("Expected the failed publish to fail the Store")
+	}
+
+	cache.failing = false
+	if err := storageDB.Store(cert, issuerCert, "log.ct", 1); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err := feed.Read(ChangeFeedBeginning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].SerialNum.HexString() != "01" {
+		t.Errorf("Expected the retried Store to publish 01, got %+v", changes)
+	}
+}
+
+func Test_ChangeFeedSubscribe(t *testing.T) {
+	cache := NewMockRemoteCache()
+	feed := NewChangeFeed(cache, 0)
+	feed.BlockPeriod = time.Millisecond
+
+	issuer := NewIssuerFromString("issuer")
+	for _, serial := range []string{"01", "02", "03"} {
+		err := feed.Publish(UniqueCertIdentifier{
+			ExpDate:   mkExpDate("2050-01-01-05"),
+			Issuer:    issuer,
+			SerialNum: NewSerialFromHex(serial),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan CertificateChange)
+	go func() {
+		if err := feed.Subscribe(ctx, ChangeFeedBeginning, c); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, expected := range []string{"01", "02", "03"} {
+		change := <-c
+		if change.SerialNum.HexString() != expected {
+			t.Errorf("Expected %s, got %s", expected, change.SerialNum.HexString())
+		}
+	}
+	cancel()
+
+	for range c {
+		// drain until closed
+	}
+}
+
+func Test_ChangeFeedSkipsMalformed(t *testing.T) {
+	cache := NewMockRemoteCache()
+	feed := NewChangeFeed(cache, 0)
+	feed.BlockPeriod = time.Millisecond
+
+	publish := func(aSerial string) {
+		err := feed.Publish(UniqueCertIdentifier{
+			ExpDate:   mkExpDate("2050-01-01-05"),
+			Issuer:    NewIssuerFromString("issuer"),
+			SerialNum: NewSerialFromHex(aSerial),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("01")
+	if _, err := cache.StreamAdd(kChangeFeed, "junk", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, next, err := feed.Read(ChangeFeedBeginning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || next == changes[0].Position {
+		t.Errorf("Expected to read past the malformed entry, got %+v next %s", changes, next)
+	}
+	changes, again, err := feed.Read(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 || again != next {
+		t.Errorf("Expected nothing after the malformed entry, got %+v next %s", changes, again)
+	}
+
+	// A subscriber whose batch ends in, or is only, malformed entries moves on
+	feed.BatchSize = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan CertificateChange)
+	done := make(chan error, 1)
+	go func() {
+		done <- feed.Subscribe(ctx, ChangeFeedBeginning, c)
+	}()
+
+	if change := <-c; change.SerialNum.HexString() != "01" {
+		t.Errorf("Expected 01, got %s", change.SerialNum.HexString())
+	}
+	publish("02")
+	select {
+	case change := <-c:
+		if change.SerialNum.HexString() != "02" {
+			t.Errorf("Expected 02, got %s", change.SerialNum.HexString())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscriber stuck at the malformed entry")
+	}
+	cancel()
+	for range c {
+		// drain until closed
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
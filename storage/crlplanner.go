@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// CRLPlanEntry is one CRL distribution point to crawl, and the issuers whose
+// certificates name it.
+type CRLPlanEntry struct {
+	URL     string   `json:"url"`
+	Issuers []string `json:"issuers"`
+	// Known certificates naming this DP
+	Certificates int64 `json:"certificates"`
+	// Set when some of its issuers' certificates name other DPs instead, so
+	// this CRL alone doesn't cover them
+	Partitioned bool `json:"partitioned"`
+}
+
+type CRLPlanIssuer struct {
+	Issuer    string   `json:"issuer"`
+	IssuerDNs []string `json:"issuerDNs"`
+	CRLs      []string `json:"crls"`
+	// Known certificates examined, of which Uncovered name no usable DP
+	Certificates int64 `json:"certificates"`
+	Uncovered    int64 `json:"uncovered"`
+	// Known certificates which couldn't be loaded from storage
+	Errors      int64 `json:"errors"`
+	Partitioned bool  `json:"partitioned"`
+}
+
+// CRLPlan is every CRL to fetch, keyed back to the issuers it covers.
+type CRLPlan struct {
+	Generated time.Time       `json:"generated"`
+	CRLs      []CRLPlanEntry  `json:"crls"`
+	Issuers   []CRLPlanIssuer `json:"issuers"`
+}
+
+// CRLPlanner builds a CRLPlan from the issuers in the cache, loading each
+// known certificate from the StorageBackend to count the DPs it names.
+// NumWorkers issuers are planned at once.
+type CRLPlanner struct {
+	db         CertDatabase
+	backend    StorageBackend
+	NumWorkers int
+	// Only buckets unexpired at NotBefore are examined, when set
+	NotBefore time.Time
+	// Called after each issuer is complete
+	Progress func(aIssuersDone int, aIssuersTotal int)
+}
+
+func NewCRLPlanner(aDB CertDatabase, aBackend StorageBackend) *CRLPlanner {
+	return &CRLPlanner{
+		db:         aDB,
+		backend:    aBackend,
+		NumWorkers: 1,
+	}
+}
+
+type issuerCRLs struct {
+	report CRLPlanIssuer
+	// Known certificates naming each DP, including the issuer's DPs named
+	// only by certificates no longer known
+	counts map[string]int64
+	err    error
+}
+
+func (cp *CRLPlanner) planBucket(ctx context.Context, aBucket IssuerAndDate, aResult *issuerCRLs) error {
+	serialChan := make(chan Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- cp.db.GetKnownCertificates(aBucket.ExpDate, aBucket.Issuer).StreamKnown(serialChan)
+	}()
+
+	for serial := range serialChan {
+		if ctx.Err() != nil {
+			continue
+		}
+		cert, err := loadCertificate(ctx, cp.backend, UniqueCertIdentifier{
+			Issuer:    aBucket.Issuer,
+			ExpDate:   aBucket.ExpDate,
+			SerialNum: serial,
+		})
+		if err != nil {
+			glog.Warningf("[%s] Couldn't load certificate %s: %v", aBucket.String(), serial.HexString(), err)
+			aResult.report.Errors++
+			continue
+		}
+
+		aResult.report.Certificates++
+		named := make(map[string]struct{}, len(cert.CRLDistributionPoints))
+		for _, dp := range cert.CRLDistributionPoints {
+			if crl, ok := normalizeURL(dp); ok {
+				named[crl] = struct{}{}
+			}
+		}
+		if len(named) == 0 {
+			aResult.report.Uncovered++
+		}
+		for crl := range named {
+			aResult.counts[crl]++
+		}
+	}
+
+	if err := <-errChan; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (cp *CRLPlanner) planIssuer(ctx context.Context, aIssuerObj IssuerDate) *issuerCRLs {
+	meta := cp.db.GetIssuerMetadata(aIssuerObj.Issuer)
+	result := &issuerCRLs{
+		report: CRLPlanIssuer{
+			Issuer:    aIssuerObj.Issuer.ID(),
+			IssuerDNs: meta.Issuers(),
+		},
+		counts: make(map[string]int64),
+	}
+	for _, crl := range meta.CRLs() {
+		result.counts[crl] = 0
+	}
+
+	for _, expDate := range aIssuerObj.ExpDates {
+		if !cp.NotBefore.IsZero() && expDate.IsExpiredAt(cp.NotBefore) {
+			continue
+		}
+		bucket := IssuerAndDate{ExpDate: expDate, Issuer: aIssuerObj.Issuer}
+		if err := cp.planBucket(ctx, bucket, result); err != nil {
+			result.err = err
+			return result
+		}
+	}
+
+	// Partitioned unless some one DP is named by every covered certificate.
+	covered := result.report.Certificates - result.report.Uncovered
+	result.report.Partitioned = covered > 0
+	result.report.CRLs = make([]string, 0, len(result.counts))
+	for crl, count := range result.counts {
+		result.report.CRLs = append(result.report.CRLs, crl)
+		if count == covered {
+			result.report.Partitioned = false
+		}
+	}
+	sort.Strings(result.report.CRLs)
+	return result
+}
+
+// Plans every issuer, merging DPs shared between issuers.
+func (cp *CRLPlanner) Plan(ctx context.Context) (*CRLPlan, error) {
+	issuerList, err := cp.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+
+	issuerChan := make(chan IssuerDate, len(issuerList))
+	for _, issuerObj := range issuerList {
+		issuerChan <- issuerObj
+	}
+	close(issuerChan)
+
+	resultChan := make(chan *issuerCRLs)
+	workers := cp.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for issuerObj := range issuerChan {
+				resultChan <- cp.planIssuer(ctx, issuerObj)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	plan := &CRLPlan{
+		Generated: time.Now().UTC(),
+		CRLs:      []CRLPlanEntry{},
+		Issuers:   make([]CRLPlanIssuer, 0, len(issuerList)),
+	}
+	entries := make(map[string]*CRLPlanEntry)
+	var firstErr error
+	done := 0
+	for result := range resultChan {
+		done++
+		if result.err != nil && firstErr == nil {
+			firstErr = result.err
+		}
+
+		covered := result.report.Certificates - result.report.Uncovered
+		for crl, count := range result.counts {
+			entry, ok := entries[crl]
+			if !ok {
+				entry = &CRLPlanEntry{URL: crl, Issuers: []string{}}
+				entries[crl] = entry
+			}
+			entry.Issuers = append(entry.Issuers, result.report.Issuer)
+			entry.Certificates += count
+			if count > 0 && count < covered {
+				entry.Partitioned = true
+			}
+		}
+		plan.Issuers = append(plan.Issuers, result.report)
+
+		if cp.Progress != nil {
+			cp.Progress(done, len(issuerList))
+		}
+	}
+
+	for _, entry := range entries {
+		sort.Strings(entry.Issuers)
+		plan.CRLs = append(plan.CRLs, *entry)
+	}
+	sort.Slice(plan.CRLs, func(i, j int) bool {
+		return plan.CRLs[i].URL < plan.CRLs[j].URL
+	})
+	sort.Slice(plan.Issuers, func(i, j int) bool {
+		return plan.Issuers[i].Issuer < plan.Issuers[j].Issuer
+	})
+	return plan, firstErr
+}
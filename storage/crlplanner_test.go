@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	newx509 "github.com/google/certificate-transparency-go/x509"
+)
+
+func Test_CRLPlanner(t *testing.T) {
+	backend := NewMockBackend()
+	storageDB, err := NewFilesystemDatabase(backend, NewMockRemoteCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuerCerts := map[string]*newx509.Certificate{
+		"Whole":   makeCert(t, "Whole", "2060-01-01", NewSerialFromHex("FF")),
+		"Sharded": makeCert(t, "Sharded", "2060-01-01", NewSerialFromHex("FF")),
+	}
+	store := func(aIssuerCN string, aDate string, aSerial byte, aCRLs ...string) {
+		issuerCert := issuerCerts[aIssuerCN]
+		cert := makeCertWithCRLs(t, aIssuerCN, aDate, NewSerialFromBytes([]byte{aSerial}), aCRLs)
+		if err := storageDB.Store(cert, issuerCert, "log.ct", int64(aSerial)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every certificate names the CRL and its mirror
+	store("Whole", "2050-01-01", 1, "http://whole.example/crl", "http://mirror.example/crl")
+	store("Whole", "2050-06-01", 2, "http://whole.example/crl", "http://mirror.example/crl",
+		"ldap://whole.example/crl")
+	// Each certificate names one shard, and one names none
+	store("Sharded", "2050-01-01", 3, "http://sharded.example/1.crl")
+	store("Sharded", "2050-01-01", 4, "http://sharded.example/2.crl")
+	store("Sharded", "2050-06-01", 5, "http://sharded.example/2.crl")
+	store("Sharded", "2050-06-01", 6)
+
+	planner := NewCRLPlanner(storageDB, backend)
+	planner.NumWorkers = 2
+	plan, err := planner.Plan(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Issuers) != 2 {
+		t.Fatalf("Expected two issuers: %+v", plan.Issuers)
+	}
+	issuers := make(map[string]CRLPlanIssuer)
+	for _, issuer := range plan.Issuers {
+		issuers[issuer.IssuerDNs[0]] = issuer
+	}
+	whole, sharded := issuers["CN=Whole"], issuers["CN=Sharded"]
+	if whole.Certificates != 2 || whole.Uncovered != 0 || whole.Partitioned || len(whole.CRLs) != 2 {
+		t.Errorf("Expected a whole CRL and its mirror: %+v", whole)
+	}
+	if sharded.Certificates != 4 || sharded.Uncovered != 1 || !sharded.Partitioned || len(sharded.CRLs) != 2 {
+		t.Errorf("Expected two shards and an uncovered certificate: %+v", sharded)
+	}
+
+	expected := []CRLPlanEntry{
+		{"http://mirror.example/crl", []string{whole.Issuer}, 2, false},
+		{"http://sharded.example/1.crl", []string{sharded.Issuer}, 1, true},
+		{"http://sharded.example/2.crl", []string{sharded.Issuer}, 2, true},
+		{"http://whole.example/crl", []string{whole.Issuer}, 2, false},
+	}
+	if !reflect.DeepEqual(plan.CRLs, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, plan.CRLs)
+	}
+
+	// DPs named only by expired certificates stay in the plan, uncounted
+	planner.NotBefore = time.Date(2050, 03, 01, 0, 0, 0, 0, time.UTC)
+	plan, err = planner.Plan(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int64)
+	for _, entry := range plan.CRLs {
+		counts[entry.URL] = entry.Certificates
+	}
+	if len(counts) != 4 || counts["http://sharded.example/1.crl"] != 0 || counts["http://sharded.example/2.crl"] != 1 {
+		t.Errorf("Expected only unexpired certificates counted: %+v", plan.CRLs)
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net/url"
-	// "strconv"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +22,11 @@ type FilesystemDatabase struct {
 	knownCertsCache gcache.Cache
 	metaMutex       *sync.RWMutex
 	meta            map[string]*IssuerMetadata
+	storedIssuers   map[string]struct{}
+	changeFeed      *ChangeFeed
+	serialEncoding  SerialEncoding
+	serialFilter    float64
+	granularity     BucketGranularity
 }
 
 func NewFilesystemDatabase(aBackend StorageBackend, aExtCache RemoteCache) (*FilesystemDatabase,
@@ -32,11 +37,40 @@ func NewFilesystemDatabase(aBackend StorageBackend, aExtCache RemoteCache) (*Fil
 		knownCertsCache: gcache.New(8 * 1024).ARC().Build(),
 		metaMutex:       &sync.RWMutex{},
 		meta:            make(map[string]*IssuerMetadata),
+		storedIssuers:   make(map[string]struct{}),
+		serialEncoding:  SetSerialEncoding,
+		serialFilter:    0,
+		granularity:     HourGranularity,
 	}
 
 	return db, nil
 }
 
+// Publishes every newly-known certificate to a change feed retaining about
+// aMaxLen entries.
+func (db *FilesystemDatabase) EnableChangeFeed(aMaxLen int64) *ChangeFeed {
+	db.changeFeed = NewChangeFeed(db.extCache, aMaxLen)
+	return db.changeFeed
+}
+
+// Selects how KnownCertificates store serials in the cache. It must be set
+// before any are retrieved.
+func (db *FilesystemDatabase) SetSerialEncoding(aEncoding SerialEncoding) {
+	db.serialEncoding = aEncoding
+}
+
+// Selects the span of expiration times grouped into each bucket. It must be
+// set before any certificates are stored.
+func (db *FilesystemDatabase) SetBucketGranularity(aGranularity BucketGranularity) {
+	db.granularity = aGranularity
+}
+
+// Puts a Bloom filter with the given false positive rate in front of each
+// KnownCertificates. See KnownCertificates.EnableFilter.
+func (db *FilesystemDatabase) EnableSerialFilter(aFalsePositiveRate float64) {
+	db.serialFilter = aFalsePositiveRate
+}
+
 func (db *FilesystemDatabase) GetIssuerMetadata(aIssuer Issuer) *IssuerMetadata {
 	db.metaMutex.RLock()
 
@@ -49,27 +83,36 @@ func (db *FilesystemDatabase) GetIssuerMetadata(aIssuer Issuer) *IssuerMetadata
 	db.metaMutex.RUnlock()
 	db.metaMutex.Lock()
 
-	im = NewIssuerMetadata(aIssuer, db.extCache)
+	im = NewIssuerMetadataWithGranularity(aIssuer, db.extCache, db.granularity)
 	db.meta[aIssuer.ID()] = im
 
 	db.metaMutex.Unlock()
 	return im
 }
 
+// Like GetIssuerMetadata, but not kept for later calls, so that issuers named
+// by clients can't fill memory.
+func (db *FilesystemDatabase) PeekIssuerMetadata(aIssuer Issuer) *IssuerMetadata {
+	return NewIssuerMetadataWithGranularity(aIssuer, db.extCache, db.granularity)
+}
+
 func (db *FilesystemDatabase) GetIssuerAndDatesFromCache() ([]IssuerDate, error) {
 	issuerMap := make(map[string]IssuerDate)
 	allChan := make(chan string)
+	errChan := make(chan error, 1)
 	go func() {
-		err := db.extCache.KeysToChan("serials::*", allChan)
-		if err != nil {
-			glog.Fatalf("Couldn't list from cache")
-		}
+		errChan <- db.extCache.KeysToChan(db.serialEncoding.keyPrefix()+"::*", allChan)
 	}()
 
+	var formatErr error
 	for entry := range allChan {
 		parts := strings.Split(entry, "::")
 		if len(parts) != 3 {
-			return []IssuerDate{}, fmt.Errorf("Unexpected key format: %s", entry)
+			// Keep draining so KeysToChan finishes.
+			if formatErr == nil {
+				formatErr = fmt.Errorf("Unexpected key format: %s", entry)
+			}
+			continue
 		}
 
 		issuer := NewIssuerFromString(parts[2])
@@ -92,6 +135,13 @@ func (db *FilesystemDatabase) GetIssuerAndDatesFromCache() ([]IssuerDate, error)
 		issuerMap[issuer.ID()] = tmp
 	}
 
+	if err := <-errChan; err != nil {
+		return []IssuerDate{}, fmt.Errorf("Couldn't list from cache: %v", err)
+	}
+	if formatErr != nil {
+		return []IssuerDate{}, formatErr
+	}
+
 	issuerList := make([]IssuerDate, 0, len(issuerMap))
 	for _, v := range issuerMap {
 		issuerList = append(issuerList, v)
@@ -138,9 +188,104 @@ func (db *FilesystemDatabase) GetLogState(aUrl *url.URL) (*CertificateLog, error
 	}, nil
 }
 
-func (db *FilesystemDatabase) markDirty(aExpiration *time.Time) error {
-	subdirName := aExpiration.Format(kExpirationFormat)
-	return db.backend.MarkDirty(subdirName)
+func (db *FilesystemDatabase) ListDirtyBuckets(aSince time.Time) ([]DirtyBucket, error) {
+	return db.backend.ListDirty(context.Background(), aSince)
+}
+
+// Clears each bucket, unless it has been marked dirty again since it was
+// listed.
+func (db *FilesystemDatabase) ClearDirtyBuckets(aBuckets []DirtyBucket) error {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	for _, bucket := range aBuckets {
+		if err := db.backend.ClearDirty(ctx, bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counts the serials known in each bucket, in one round trip to the cache.
+func (db *FilesystemDatabase) CountBuckets(aBuckets []IssuerAndDate) ([]int64, error) {
+	keys := make([]string, len(aBuckets))
+	for i, bucket := range aBuckets {
+		keys[i] = NewKnownCertificatesWithEncoding(bucket.ExpDate, bucket.Issuer, db.extCache,
+			db.serialEncoding).serialId()
+	}
+
+	if db.serialEncoding != PackedSerialEncoding {
+		return db.extCache.SetCardinalities(keys)
+	}
+
+	values, err := db.extCache.HashGetEach(keys, kPackedCountField)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int64, len(values))
+	for i, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		counts[i], err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse count of %s, %s: %s", keys[i], value, err)
+		}
+	}
+	return counts, nil
+}
+
+// Finds every bucket whose known certificates include aSerial, searching
+// only aIssuer's buckets if it is set.
+func (db *FilesystemDatabase) LocateSerial(aSerial Serial, aIssuer *Issuer) ([]IssuerAndDate, error) {
+	issuerList, err := db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []IssuerAndDate{}
+	keys := []string{}
+	for _, issuerObj := range issuerList {
+		if aIssuer != nil && issuerObj.Issuer.ID() != aIssuer.ID() {
+			continue
+		}
+		for _, expDate := range issuerObj.ExpDates {
+			candidates = append(candidates, IssuerAndDate{ExpDate: expDate, Issuer: issuerObj.Issuer})
+			keys = append(keys, NewKnownCertificatesWithEncoding(expDate, issuerObj.Issuer, db.extCache,
+				db.serialEncoding).serialId())
+		}
+	}
+
+	found := []IssuerAndDate{}
+	for start := 0; start < len(keys); start += kPipelineBatchSize {
+		end := start + kPipelineBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var known []bool
+		if db.serialEncoding == PackedSerialEncoding {
+			blobs, err := db.extCache.HashGetEach(keys[start:end], packedField(aSerial))
+			if err != nil {
+				return found, err
+			}
+			known = make([]bool, len(blobs))
+			for i, blob := range blobs {
+				known[i] = packedContains(blob, aSerial.BinaryString())
+			}
+		} else {
+			known, err = db.extCache.SetContainsEach(keys[start:end], aSerial.BinaryString())
+			if err != nil {
+				return found, err
+			}
+		}
+
+		for i, isKnown := range known {
+			if isKnown {
+				found = append(found, candidates[start+i])
+			}
+		}
+	}
+	return found, nil
 }
 
 func getSpki(aCert *x509.Certificate) SPKI {
@@ -157,17 +302,19 @@ func getSpki(aCert *x509.Certificate) SPKI {
 
 func (db *FilesystemDatabase) Store(aCert *x509.Certificate, aIssuer *x509.Certificate,
 	aLogURL string, aEntryId int64) error {
-	expDate := NewExpDateFromTime(aCert.NotAfter)
+	expDate := NewExpDateFromTimeWithGranularity(aCert.NotAfter, db.granularity)
 	issuer := NewIssuer(aIssuer)
 	knownCerts := db.GetKnownCertificates(expDate, issuer)
 
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	defer ctxCancel()
 
-	headers := make(map[string]string)
-	// headers["Log"] = aLogURL
-	// headers["Recorded-at"] = time.Now().Format(time.RFC3339)
-	// headers["Entry-id"] = strconv.FormatInt(aEntryId, 10)
+	// Where the certificate was first seen, for ct-lookup
+	headers := map[string]string{
+		"Log":         aLogURL,
+		"Recorded-at": time.Now().Format(time.RFC3339),
+		"Entry-id":    strconv.FormatInt(aEntryId, 10),
+	}
 	pemblock := pem.Block{
 		Type:    "CERTIFICATE",
 		Headers: headers,
@@ -175,6 +322,25 @@ func (db *FilesystemDatabase) Store(aCert *x509.Certificate, aIssuer *x509.Certi
 	}
 
 	serialNum := NewSerial(aCert)
+	certId := UniqueCertIdentifier{
+		ExpDate:   expDate,
+		Issuer:    issuer,
+		SerialNum: serialNum,
+	}
+
+	// Published before the serial is recorded, so if publishing fails, the
+	// retried Store finds it still unknown and publishes it again.
+	if db.changeFeed != nil {
+		known, err := knownCerts.mayBeKnown(serialNum)
+		if err != nil {
+			return err
+		}
+		if !known {
+			if err := db.changeFeed.Publish(certId); err != nil {
+				return err
+			}
+		}
+	}
 
 	certWasUnknown, err := knownCerts.WasUnknown(serialNum)
 	if err != nil {
@@ -199,14 +365,42 @@ func (db *FilesystemDatabase) Store(aCert *x509.Certificate, aIssuer *x509.Certi
 		if errStore != nil {
 			return errStore
 		}
+
+		// Only new certificates change the bucket
+		err = db.backend.MarkDirty(ctx, IssuerAndDate{
+			ExpDate: expDate,
+			Issuer:  issuer,
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	// Mark the directory dirty
-	err = db.markDirty(&aCert.NotAfter)
+	return nil
+}
+
+// Stores the issuer's certificate the first time this database sees it.
+func (db *FilesystemDatabase) StoreIssuerCertificate(aIssuer *x509.Certificate) error {
+	issuer := NewIssuer(aIssuer)
+	db.metaMutex.RLock()
+	_, stored := db.storedIssuers[issuer.ID()]
+	db.metaMutex.RUnlock()
+	if stored {
+		return nil
+	}
+
+	pemblock := pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: aIssuer.Raw,
+	}
+	err := db.backend.StoreIssuerPEM(context.Background(), issuer, pem.EncodeToMemory(&pemblock))
 	if err != nil {
 		return err
 	}
 
+	db.metaMutex.Lock()
+	db.storedIssuers[issuer.ID()] = struct{}{}
+	db.metaMutex.Unlock()
 	return nil
 }
 
@@ -219,7 +413,10 @@ func (db *FilesystemDatabase) GetKnownCertificates(aExpDate ExpDate,
 	cacheObj, err := db.knownCertsCache.GetIFPresent(id)
 	if err != nil {
 		if err == gcache.KeyNotFoundError {
-			kc = NewKnownCertificates(aExpDate, aIssuer, db.extCache)
+			kc = NewKnownCertificatesWithEncoding(aExpDate, aIssuer, db.extCache, db.serialEncoding)
+			if db.serialFilter > 0 {
+				kc.EnableFilter(db.serialFilter)
+			}
 			err = db.knownCertsCache.Set(id, kc)
 			if err != nil {
 				glog.Fatalf("Couldn't set into the cache expDate=%s issuer=%s from cache: %s",
@@ -360,11 +360,16 @@ func Test_NoopBackend(t *testing.T) {
 		t.Error(err)
 	}
 
-	err = storageDB.markDirty(&time.Time{})
+	err = noopBackend.MarkDirty(context.TODO(), IssuerAndDate{ExpDate: expDate})
 	if err != nil {
 		t.Error(err)
 	}
 
+	_, err = storageDB.ListDirtyBuckets(time.Time{})
+	if err == nil {
+		t.Errorf("Should have emitted an error")
+	}
+
 	_, err = storageDB.ListExpirationDates(time.Time{})
 	if err == nil {
 		t.Errorf("Should have emitted an error")
@@ -375,3 +380,157 @@ func Test_NoopBackend(t *testing.T) {
 		t.Errorf("Should have emitted an error")
 	}
 }
+
+func Test_DirtyBuckets(t *testing.T) {
+	_, _, storageDB := getTestHarness(t)
+
+	issuerCert := makeCert(t, "Dirty Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	firstCert := makeCert(t, "Dirty Issuer", "2050-01-01", NewSerialFromHex("01"))
+	secondCert := makeCert(t, "Dirty Issuer", "2050-01-02", NewSerialFromHex("02"))
+
+	if err := storageDB.Store(firstCert, issuerCert, "log.ct", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	dirty, err := storageDB.ListDirtyBuckets(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 1 || dirty[0].ExpDate.ID() != "2050-01-01-00" {
+		t.Fatalf("Expected one dirty bucket: %+v", dirty)
+	}
+
+	checkpoint := dirty[0].MarkedAt
+	if err := storageDB.Store(secondCert, issuerCert, "log.ct", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	since, err := storageDB.ListDirtyBuckets(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 1 || since[0].ExpDate.ID() != "2050-01-02-00" {
+		t.Errorf("Expected only the second bucket since the checkpoint: %+v", since)
+	}
+
+	// Known certificates don't dirty their bucket again
+	if err := storageDB.Store(firstCert, issuerCert, "log.ct", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := storageDB.ClearDirtyBuckets(dirty); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := storageDB.ListDirtyBuckets(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].ExpDate.ID() != "2050-01-02-00" {
+		t.Errorf("Expected only the second bucket to remain: %+v", remaining)
+	}
+}
+
+func Test_LocateSerial(t *testing.T) {
+	for _, encoding := range kEncodings {
+		storageDB, err := NewFilesystemDatabase(NewMockBackend(), NewMockRemoteCache())
+		if err != nil {
+			t.Fatal(err)
+		}
+		storageDB.SetSerialEncoding(encoding)
+
+		firstIssuerCert := makeCert(t, "First Issuer", "2060-01-01", NewSerialFromHex("FF"))
+		secondIssuerCert := makeCert(t, "Second Issuer", "2060-01-01", NewSerialFromHex("FF"))
+		stores := []struct {
+			issuerCert *x509.Certificate
+			date       string
+			serial     string
+		}{
+			{firstIssuerCert, "2050-01-01", "01"},
+			{firstIssuerCert, "2050-01-02", "02"},
+			{secondIssuerCert, "2050-01-03", "01"},
+		}
+		for i, s := range stores {
+			cert := makeCert(t, "Leaf", s.date, NewSerialFromHex(s.serial))
+			if err := storageDB.Store(cert, s.issuerCert, "log.ct", int64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		found, err := storageDB.LocateSerial(NewSerialFromHex("01"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 2 {
+			t.Errorf("[%s] Expected 01 in two buckets: %+v", encoding, found)
+		}
+
+		secondIssuer := NewIssuer(secondIssuerCert)
+		found, err = storageDB.LocateSerial(NewSerialFromHex("01"), &secondIssuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].ExpDate.ID() != "2050-01-03-00" {
+			t.Errorf("[%s] Expected 01 only in the second issuer's bucket: %+v", encoding, found)
+		}
+
+		found, err = storageDB.LocateSerial(NewSerialFromHex("03"), nil)
+		if err != nil || len(found) != 0 {
+			t.Errorf("[%s] Expected 03 nowhere: %+v %v", encoding, found, err)
+		}
+	}
+}
+
+func Test_StoreIssuerCertificate(t *testing.T) {
+	mockBackend, _, storageDB := getTestHarness(t)
+	issuerCert := makeCert(t, "Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	issuer := NewIssuer(issuerCert)
+
+	if _, err := mockBackend.LoadIssuerPEM(context.TODO(), issuer); err == nil {
+		t.Fatal("Expected no issuer certificate before it's seen")
+	}
+	if err := storageDB.StoreIssuerCertificate(issuerCert); err != nil {
+		t.Fatal(err)
+	}
+	data, err := mockBackend.LoadIssuerPEM(context.TODO(), issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || !bytes.Equal(block.Bytes, issuerCert.Raw) {
+		t.Errorf("Expected the issuer's certificate, got %s", data)
+	}
+
+	// Only the first sighting is stored
+	if err := mockBackend.StoreIssuerPEM(context.TODO(), issuer, []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storageDB.StoreIssuerCertificate(issuerCert); err != nil {
+		t.Fatal(err)
+	}
+	data, err = mockBackend.LoadIssuerPEM(context.TODO(), issuer)
+	if err != nil || string(data) != "replaced" {
+		t.Errorf("Expected the issuer not to be stored again: %s %v", data, err)
+	}
+}
+
+func Test_StoreRecordsObservation(t *testing.T) {
+	mockBackend, _, storageDB := getTestHarness(t)
+	issuerCert := makeCert(t, "Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	cert := makeCert(t, "Issuer", "2050-01-01", NewSerialFromHex("01"))
+	if err := storageDB.Store(cert, issuerCert, "log.ct/2050", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	expDate := NewExpDateFromTimeWithGranularity(cert.NotAfter, HourGranularity)
+	data, err := mockBackend.LoadCertificatePEM(context.TODO(), NewSerial(cert), expDate, NewIssuer(issuerCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Headers["Log"] != "log.ct/2050" || block.Headers["Entry-id"] != "42" {
+		t.Errorf("Expected the CT observation in the PEM headers, got %s", data)
+	}
+	if _, err := time.Parse(time.RFC3339, block.Headers["Recorded-at"]); err != nil {
+		t.Errorf("Expected when it was recorded: %v", err)
+	}
+}
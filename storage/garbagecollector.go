@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/golang/glog"
+)
+
+// GCPolicy decides what CollectGarbage may remove.
+type GCPolicy struct {
+	// Buckets are kept until this long after they expire.
+	Grace time.Duration
+	// Log states not updated within this period are removed. Zero keeps
+	// them forever.
+	LogRetention time.Duration
+	// Report what would be removed without removing anything.
+	DryRun bool
+}
+
+type GCReport struct {
+	Cutoff           time.Time `json:"cutoff"`
+	DryRun           bool      `json:"dryRun"`
+	SerialBuckets    int64     `json:"serialBuckets"`
+	Serials          int64     `json:"serials"`
+	IssuerKeys       int64     `json:"issuerKeys"`
+	LogStates        int64     `json:"logStates"`
+	BackendExpDates  int64     `json:"backendExpDates"`
+	ForgottenDates   int64     `json:"forgottenDates"`
+	ForgottenIssuers int64     `json:"forgottenIssuers"`
+}
+
+func (db *FilesystemDatabase) keysMatching(aPattern string) ([]string, error) {
+	keyChan := make(chan string)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- db.extCache.KeysToChan(aPattern, keyChan)
+	}()
+
+	keys := []string{}
+	for key := range keyChan {
+		keys = append(keys, key)
+	}
+	return keys, <-errChan
+}
+
+func (db *FilesystemDatabase) deleteKey(aKey string, aPolicy GCPolicy) error {
+	if aPolicy.DryRun {
+		return nil
+	}
+	return db.extCache.Delete(aKey)
+}
+
+// Removes buckets which expired before the policy's grace period from the
+// cache and the backend, along with the metadata of issuers left without
+// any buckets.
+func (db *FilesystemDatabase) CollectGarbage(aPolicy GCPolicy) (*GCReport, error) {
+	defer metrics.MeasureSince([]string{"CollectGarbage"}, time.Now())
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+
+	now := time.Now()
+	report := &GCReport{
+		Cutoff: now.Add(-aPolicy.Grace),
+		DryRun: aPolicy.DryRun,
+	}
+
+	liveIssuers := make(map[string]struct{})
+	for _, encoding := range []SerialEncoding{SetSerialEncoding, PackedSerialEncoding} {
+		keys, err := db.keysMatching(encoding.keyPrefix() + "::*")
+		if err != nil {
+			return report, err
+		}
+
+		for _, key := range keys {
+			parts := strings.Split(key, "::")
+			if len(parts) != 3 {
+				glog.Warningf("Unexpected key format: %s", key)
+				continue
+			}
+			expDate, err := NewExpDate(parts[1])
+			if err != nil {
+				glog.Warningf("Couldn't parse expiration date %s: %s", key, err)
+				continue
+			}
+			issuer := NewIssuerFromString(parts[2])
+			if !expDate.IsExpiredAt(report.Cutoff) {
+				liveIssuers[issuer.ID()] = struct{}{}
+				continue
+			}
+
+			count := NewKnownCertificatesWithEncoding(expDate, issuer, db.extCache, encoding).Count()
+			glog.V(1).Infof("[%s] Removing %d expired serials", key, count)
+			if err := db.deleteKey(key, aPolicy); err != nil {
+				return report, err
+			}
+			if !aPolicy.DryRun {
+				db.knownCertsCache.Remove(expDate.ID() + issuer.ID())
+			}
+			report.SerialBuckets++
+			report.Serials += count
+		}
+	}
+
+	for _, prefix := range []string{kCrls, kIssuers, kOCSPResponders, kIssuerURLs, kStatistics} {
+		keys, err := db.keysMatching(prefix + "::*")
+		if err != nil {
+			return report, err
+		}
+		for _, key := range keys {
+			parts := strings.Split(key, "::")
+			if len(parts) != 2 {
+				glog.Warningf("Unexpected key format: %s", key)
+				continue
+			}
+			if _, ok := liveIssuers[parts[1]]; ok {
+				continue
+			}
+			glog.V(1).Infof("[%s] Removing, the issuer has no unexpired buckets", key)
+			if err := db.deleteKey(key, aPolicy); err != nil {
+				return report, err
+			}
+			report.IssuerKeys++
+		}
+	}
+
+	if aPolicy.LogRetention > 0 {
+		keys, err := db.keysMatching(kLogState + "::*")
+		if err != nil {
+			return report, err
+		}
+		for _, key := range keys {
+			log, err := db.extCache.LoadLogState(strings.TrimPrefix(key, kLogState+"::"))
+			if err != nil {
+				glog.Warningf("Couldn't load log state %s: %s", key, err)
+				continue
+			}
+			if now.Sub(log.LastUpdateTime) < aPolicy.LogRetention {
+				continue
+			}
+			glog.V(1).Infof("[%s] Removing log state last updated %s", key, log.LastUpdateTime)
+			if err := db.deleteKey(key, aPolicy); err != nil {
+				return report, err
+			}
+			report.LogStates++
+		}
+	}
+
+	expDates, err := db.backend.ListExpirationDates(ctx, time.Time{})
+	if err != nil {
+		glog.Warningf("Couldn't list expiration dates from the backend: %s", err)
+	}
+	for _, expDate := range expDates {
+		if !expDate.IsExpiredAt(report.Cutoff) {
+			continue
+		}
+		glog.V(1).Infof("[%s] Removing expired certificates from the backend", expDate.ID())
+		if !aPolicy.DryRun {
+			if err := db.backend.DeleteExpirationDate(ctx, expDate); err != nil {
+				return report, err
+			}
+		}
+		report.BackendExpDates++
+	}
+
+	if !aPolicy.DryRun {
+		db.metaMutex.Lock()
+		for id, im := range db.meta {
+			report.ForgottenDates += int64(im.forgetExpDates(report.Cutoff))
+			if _, ok := liveIssuers[id]; !ok {
+				delete(db.meta, id)
+				report.ForgottenIssuers++
+			}
+		}
+		db.metaMutex.Unlock()
+	}
+
+	metrics.IncrCounter([]string{"CollectGarbage", "serialBuckets"}, float32(report.SerialBuckets))
+	metrics.IncrCounter([]string{"CollectGarbage", "serials"}, float32(report.Serials))
+	metrics.IncrCounter([]string{"CollectGarbage", "backendExpDates"}, float32(report.BackendExpDates))
+	return report, nil
+}
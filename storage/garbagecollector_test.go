@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_CollectGarbage(t *testing.T) {
+	mockBackend, cache, storageDB := getTestHarness(t)
+
+	goneIssuerCert := makeCert(t, "Gone Issuer", "2002-01-01", NewSerialFromHex("FE"))
+	liveIssuerCert := makeCert(t, "Live Issuer", "2060-01-01", NewSerialFromHex("FF"))
+	certs := []struct {
+		expDate string
+		issuer  int
+	}{
+		{"2001-01-01", 0},
+		{"2001-01-02", 0},
+		{"2001-01-01", 1},
+		{"2050-01-01", 1},
+	}
+	for i, c := range certs {
+		issuerCert := goneIssuerCert
+		if c.issuer == 1 {
+			issuerCert = liveIssuerCert
+		}
+		cert := makeCert(t, "Leaf", c.expDate, NewSerialFromBytes([]byte{byte(i + 1)}))
+		if err := storageDB.Store(cert, issuerCert, "log.ct", int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stand in for buckets stored without a TTL, which the cache won't expire
+	// on its own
+	cache.Expirations = make(map[string]time.Time)
+
+	goneIssuer := NewIssuer(goneIssuerCert)
+	liveIssuer := NewIssuer(liveIssuerCert)
+
+	staleLog := &CertificateLog{ShortURL: "stale.ct", LastUpdateTime: time.Now().AddDate(-1, 0, 0)}
+	freshLog := &CertificateLog{ShortURL: "fresh.ct", LastUpdateTime: time.Now()}
+	for _, log := range []*CertificateLog{staleLog, freshLog} {
+		if err := cache.StoreLogState(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := GCPolicy{
+		Grace:        24 * time.Hour,
+		LogRetention: 30 * 24 * time.Hour,
+		DryRun:       true,
+	}
+	dryReport, err := storageDB.CollectGarbage(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dryReport.SerialBuckets != 3 || dryReport.Serials != 3 {
+		t.Errorf("Expected 3 buckets of 3 serials in the dry run: %+v", dryReport)
+	}
+	if exists, _ := cache.Exists("serials::2001-01-01-00::" + goneIssuer.ID()); !exists {
+		t.Error("A dry run shouldn't remove anything")
+	}
+
+	policy.DryRun = false
+	report, err := storageDB.CollectGarbage(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SerialBuckets != 3 || report.Serials != 3 {
+		t.Errorf("Expected 3 buckets of 3 serials: %+v", report)
+	}
+	if report.IssuerKeys != 2 {
+		t.Errorf("Expected only the gone issuer's DNs and statistics to be removed: %+v", report)
+	}
+	if report.LogStates != 1 {
+		t.Errorf("Expected the stale log state to be removed: %+v", report)
+	}
+	if report.BackendExpDates != 2 {
+		t.Errorf("Expected two expiration dates removed from the backend: %+v", report)
+	}
+	if report.ForgottenIssuers != 1 {
+		t.Errorf("Expected the gone issuer's metadata to be forgotten: %+v", report)
+	}
+
+	issuerDates, err := storageDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuerDates) != 1 || issuerDates[0].Issuer.ID() != liveIssuer.ID() ||
+		len(issuerDates[0].ExpDates) != 1 || issuerDates[0].ExpDates[0].ID() != "2050-01-01-00" {
+		t.Errorf("Expected only the live bucket to remain: %+v", issuerDates)
+	}
+
+	for key, expected := range map[string]bool{
+		"issuer::" + goneIssuer.ID(): false,
+		"issuer::" + liveIssuer.ID(): true,
+		"stats::" + goneIssuer.ID():  false,
+		"stats::" + liveIssuer.ID():  true,
+		"log::stale.ct":              false,
+		"log::fresh.ct":              true,
+	} {
+		if exists, _ := cache.Exists(key); exists != expected {
+			t.Errorf("Expected %s to exist=%v", key, expected)
+		}
+	}
+
+	expDates, err := mockBackend.ListExpirationDates(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expDatesAndStringsEqual(t, []string{"2050-01-01-00"}, expDates)
+
+	again, err := storageDB.CollectGarbage(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.SerialBuckets != 0 || again.IssuerKeys != 0 || again.BackendExpDates != 0 {
+		t.Errorf("Nothing should remain to collect: %+v", again)
+	}
+}
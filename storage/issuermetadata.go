@@ -3,8 +3,10 @@ package storage
 import (
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/certificate-transparency-go/x509"
@@ -12,6 +14,37 @@ import (
 
 const kIssuers = "issuer"
 const kCrls = "crl"
+const kOCSPResponders = "ocsp"
+const kIssuerURLs = "aia"
+
+// URLSighting records how many certificates named a URL, and the earliest and
+// latest NotBefore among them.
+type URLSighting struct {
+	URL       string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Count     int64
+}
+
+// A tally is kept in the cache as "count first last", in Unix seconds.
+type tally struct {
+	count int64
+	first int64
+	last  int64
+}
+
+func formatTally(t tally) string {
+	return fmt.Sprintf("%d %d %d", t.count, t.first, t.last)
+}
+
+func parseTally(aValue string) (tally, error) {
+	var t tally
+	_, err := fmt.Sscanf(aValue, "%d %d %d", &t.count, &t.first, &t.last)
+	if err != nil {
+		return t, fmt.Errorf("Malformed tally %q: %v", aValue, err)
+	}
+	return t, nil
+}
 
 type IssuerMetadata struct {
 	issuer         Issuer
@@ -20,12 +53,19 @@ type IssuerMetadata struct {
 	knownCrlDPs    map[string]struct{}
 	knownIssuerDNs map[string]struct{}
 	knownExpDates  map[string]struct{}
+	granularity    BucketGranularity
 }
 
 func NewIssuerMetadata(aIssuer Issuer, aCache RemoteCache) *IssuerMetadata {
+	return NewIssuerMetadataWithGranularity(aIssuer, aCache, HourGranularity)
+}
+
+func NewIssuerMetadataWithGranularity(aIssuer Issuer, aCache RemoteCache,
+	aGranularity BucketGranularity) *IssuerMetadata {
 	return &IssuerMetadata{
 		issuer:         aIssuer,
 		cache:          aCache,
+		granularity:    aGranularity,
 		mutex:          &sync.RWMutex{},
 		knownCrlDPs:    make(map[string]struct{}),
 		knownIssuerDNs: make(map[string]struct{}),
@@ -45,29 +85,48 @@ func (im *IssuerMetadata) issuersId() string {
 	return fmt.Sprintf("%s::%s", kIssuers, im.id())
 }
 
-func (im *IssuerMetadata) addCRL(aCRL string) error {
-	url, err := url.Parse(strings.TrimSpace(aCRL))
+func (im *IssuerMetadata) ocspId() string {
+	return fmt.Sprintf("%s::%s", kOCSPResponders, im.id())
+}
+
+func (im *IssuerMetadata) aiaId() string {
+	return fmt.Sprintf("%s::%s", kIssuerURLs, im.id())
+}
+
+// Returns the canonical form of a CRL DP, OCSP or AIA URL, and false if the
+// URL isn't one we track.
+func normalizeURL(aURL string) (string, bool) {
+	url, err := url.Parse(strings.TrimSpace(aURL))
 	if err != nil {
-		glog.Warningf("Not a valid CRL DP URL: %s %s", aCRL, err)
-		return nil
+		glog.Warningf("Not a valid URL: %s %s", aURL, err)
+		return "", false
 	}
 
 	if url.Scheme == "ldap" || url.Scheme == "ldaps" {
-		return nil
+		return "", false
 	} else if url.Scheme != "http" && url.Scheme != "https" {
-		glog.V(3).Infof("Ignoring unknown CRL scheme: %v", url)
+		glog.V(3).Infof("Ignoring unknown URL scheme: %v", url)
+		return "", false
+	}
+
+	return url.String(), true
+}
+
+func (im *IssuerMetadata) addCRL(aCRL string) error {
+	crl, ok := normalizeURL(aCRL)
+	if !ok {
 		return nil
 	}
 
-	result, err := im.cache.SetInsert(im.crlId(), url.String())
+	result, err := im.cache.SetInsert(im.crlId(), crl)
 	if err != nil {
 		return err
 	}
 
 	if result {
-		glog.V(3).Infof("[%s] CRL unknown: %s", im.id(), url.String())
+		glog.V(3).Infof("[%s] CRL unknown: %s", im.id(), crl)
 	} else {
-		glog.V(3).Infof("[%s] CRL already known: %s", im.id(), url.String())
+		glog.V(3).Infof("[%s] CRL already known: %s", im.id(), crl)
 	}
 	return nil
 }
@@ -86,11 +145,56 @@ func (im *IssuerMetadata) addIssuerDN(aIssuerDN string) error {
 	return nil
 }
 
-// Must tolerate duplicate information
+func (im *IssuerMetadata) tallyURL(aKey string, aURL string, aAt time.Time) error {
+	u, ok := normalizeURL(aURL)
+	if !ok {
+		return nil
+	}
+
+	result, err := im.cache.HashTally(aKey, u, aAt)
+	if err != nil {
+		return err
+	}
+
+	if result {
+		glog.V(3).Infof("[%s] URL unknown in %s: %s", im.id(), aKey, u)
+	}
+	return nil
+}
+
+// Records aCert in the issuer's metadata, returning whether its expiration
+// date had been seen before. Its CRLs and DN may be recorded any number of
+// times, but the URL tallies and statistics count each call, so each
+// certificate must only be accumulated once.
+func (im *IssuerMetadata) Accumulate(aCert *x509.Certificate) (bool, error) {
+	seenExpDateBefore, err := im.accumulateIdempotent(aCert)
+	if err != nil {
+		return seenExpDateBefore, err
+	}
+
+	for _, ocsp := range aCert.OCSPServer {
+		if err := im.tallyURL(im.ocspId(), ocsp, aCert.NotBefore); err != nil {
+			return seenExpDateBefore, fmt.Errorf("Could not accumulate OCSP %s: %v", im.id(), err)
+		}
+	}
+	for _, aia := range aCert.IssuingCertificateURL {
+		if err := im.tallyURL(im.aiaId(), aia, aCert.NotBefore); err != nil {
+			return seenExpDateBefore, fmt.Errorf("Could not accumulate AIA %s: %v", im.id(), err)
+		}
+	}
+
+	if err := im.accumulateStatistics(aCert); err != nil {
+		return seenExpDateBefore, fmt.Errorf("Could not accumulate statistics %s: %v", im.id(), err)
+	}
+	return seenExpDateBefore, nil
+}
+
+// Records the parts of aCert's metadata which tolerate duplicate information:
+// its expiration date, CRLs and issuer DN.
 // TODO: See which is faster, locking on these local caches, or just using extCache
 // solely
-func (im *IssuerMetadata) Accumulate(aCert *x509.Certificate) (bool, error) {
-	expDate := NewExpDateFromTime(aCert.NotAfter)
+func (im *IssuerMetadata) accumulateIdempotent(aCert *x509.Certificate) (bool, error) {
+	expDate := NewExpDateFromTimeWithGranularity(aCert.NotAfter, im.granularity)
 	dn := aCert.Issuer.String()
 	im.mutex.RLock()
 	_, seenExpDateBefore := im.knownExpDates[expDate.ID()]
@@ -137,18 +241,98 @@ func (im *IssuerMetadata) Accumulate(aCert *x509.Certificate) (bool, error) {
 	return seenExpDateBefore, nil
 }
 
+// Forgets the expiration dates expired at aCutoff, returning how many.
+func (im *IssuerMetadata) forgetExpDates(aCutoff time.Time) int {
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+	var count int
+	for id := range im.knownExpDates {
+		expDate, err := NewExpDate(id)
+		if err == nil && expDate.IsExpiredAt(aCutoff) {
+			delete(im.knownExpDates, id)
+			count++
+		}
+	}
+	return count
+}
+
 func (im *IssuerMetadata) Issuers() []string {
-	strList, err := im.cache.SetList(im.issuersId())
+	strList, err := im.ListIssuers()
 	if err != nil {
 		glog.Fatalf("Error obtaining list of issuers: %v", err)
 	}
 	return strList
 }
 
+// Like Issuers, but returns errors rather than exiting.
+func (im *IssuerMetadata) ListIssuers() ([]string, error) {
+	return im.cache.SetList(im.issuersId())
+}
+
 func (im *IssuerMetadata) CRLs() []string {
-	strList, err := im.cache.SetList(im.crlId())
+	strList, err := im.ListCRLs()
 	if err != nil {
 		glog.Fatalf("Error obtaining list of CRLs: %v", err)
 	}
 	return strList
 }
+
+// Like CRLs, but returns errors rather than exiting.
+func (im *IssuerMetadata) ListCRLs() ([]string, error) {
+	return im.cache.SetList(im.crlId())
+}
+
+func (im *IssuerMetadata) urlSightings(aKey string) ([]URLSighting, error) {
+	entryChan := make(chan HashEntry)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- im.cache.HashToChan(aKey, entryChan)
+	}()
+
+	// HSCAN may return a field more than once, so they're keyed by URL.
+	sightingMap := make(map[string]URLSighting)
+	var parseErr error
+	for entry := range entryChan {
+		t, err := parseTally(entry.Value)
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		sightingMap[entry.Field] = URLSighting{
+			URL:       entry.Field,
+			FirstSeen: time.Unix(t.first, 0).UTC(),
+			LastSeen:  time.Unix(t.last, 0).UTC(),
+			Count:     t.count,
+		}
+	}
+
+	sightings := make([]URLSighting, 0, len(sightingMap))
+	for _, sighting := range sightingMap {
+		sightings = append(sightings, sighting)
+	}
+	sort.Slice(sightings, func(i, j int) bool {
+		return sightings[i].URL < sightings[j].URL
+	})
+	if err := <-errChan; err != nil {
+		return sightings, err
+	}
+	return sightings, parseErr
+}
+
+// The OCSP responders named by this issuer's certificates
+func (im *IssuerMetadata) OCSPResponders() []URLSighting {
+	sightings, err := im.urlSightings(im.ocspId())
+	if err != nil {
+		glog.Fatalf("Error obtaining list of OCSP responders: %v", err)
+	}
+	return sightings
+}
+
+// The AIA caIssuers URLs named by this issuer's certificates
+func (im *IssuerMetadata) IssuerURLs() []URLSighting {
+	sightings, err := im.urlSightings(im.aiaId())
+	if err != nil {
+		glog.Fatalf("Error obtaining list of issuer URLs: %v", err)
+	}
+	return sightings
+}
@@ -60,6 +60,11 @@ func Test_DuplicateCRLs(t *testing.T) {
 }
 
 func makeCert(t *testing.T, issuerDN string, expDate string, serial Serial) *newx509.Certificate {
+	return makeCertWithCRLs(t, issuerDN, expDate, serial, nil)
+}
+
+func makeCertWithCRLs(t *testing.T, issuerDN string, expDate string, serial Serial,
+	crls []string) *newx509.Certificate {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		t.Error(err)
@@ -80,6 +85,8 @@ func makeCert(t *testing.T, issuerDN string, expDate string, serial Serial) *new
 		NotBefore: notBefore,
 		NotAfter:  notAfter,
 		IsCA:      true,
+
+		CRLDistributionPoints: crls,
 	}
 	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template,
 		privKey.Public(), privKey)
@@ -134,3 +141,80 @@ func Test_Accumulate(t *testing.T) {
 		t.Errorf("Expected %s but got %s", issuerDN, meta.Issuers()[0])
 	}
 }
+
+func Test_AccumulateOCSPAndAIA(t *testing.T) {
+	issuerCN := "Responding Issuer"
+	firstCert := makeCert(t, issuerCN, "2001-01-01", NewSerialFromHex("00"))
+	firstCert.OCSPServer = []string{"http://ocsp.example/", "ldap://ocsp.example/"}
+	firstCert.IssuingCertificateURL = []string{"http://aia.example/issuer.der"}
+
+	secondCert := makeCert(t, issuerCN, "2001-02-01", NewSerialFromHex("01"))
+	secondCert.OCSPServer = []string{" http://ocsp.example/", "http://ocsp2.example/"}
+
+	meta := NewIssuerMetadata(NewIssuer(firstCert), NewMockRemoteCache())
+	for _, cert := range []*newx509.Certificate{secondCert, firstCert} {
+		if _, err := meta.Accumulate(cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	responders := make(map[string]URLSighting)
+	for _, s := range meta.OCSPResponders() {
+		responders[s.URL] = s
+	}
+	if len(responders) != 2 {
+		t.Fatalf("Expected two OCSP responders: %+v", responders)
+	}
+	shared := responders["http://ocsp.example/"]
+	if shared.Count != 2 {
+		t.Errorf("Expected the shared responder to be counted twice: %+v", shared)
+	}
+	if !shared.FirstSeen.Equal(firstCert.NotBefore) || !shared.LastSeen.Equal(secondCert.NotBefore) {
+		t.Errorf("Expected the shared responder's times to span both certs: %+v", shared)
+	}
+	if responders["http://ocsp2.example/"].Count != 1 {
+		t.Errorf("Expected the second responder once: %+v", responders)
+	}
+
+	issuerURLs := meta.IssuerURLs()
+	if len(issuerURLs) != 1 || issuerURLs[0].URL != "http://aia.example/issuer.der" || issuerURLs[0].Count != 1 {
+		t.Errorf("Expected a single AIA URL: %+v", issuerURLs)
+	}
+}
+
+// Sends every hash entry twice, as HSCAN may
+type duplicatingCache struct {
+	*MockRemoteCache
+}
+
+func (c *duplicatingCache) HashToChan(key string, ch chan<- HashEntry) error {
+	defer close(ch)
+	entries := make(chan HashEntry)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.MockRemoteCache.HashToChan(key, entries)
+	}()
+	for entry := range entries {
+		ch <- entry
+		ch <- entry
+	}
+	return <-errChan
+}
+
+func Test_URLSightingsDeduplicated(t *testing.T) {
+	cert := makeCert(t, "Responding Issuer", "2001-01-01", NewSerialFromHex("00"))
+	cert.OCSPServer = []string{"http://ocsp.example/", "http://ocsp2.example/"}
+	cert.IssuingCertificateURL = []string{"http://aia.example/issuer.der"}
+
+	meta := NewIssuerMetadata(NewIssuer(cert), &duplicatingCache{NewMockRemoteCache()})
+	if _, err := meta.Accumulate(cert); err != nil {
+		t.Fatal(err)
+	}
+
+	if responders := meta.OCSPResponders(); len(responders) != 2 {
+		t.Errorf("Expected each OCSP responder once: %+v", responders)
+	}
+	if issuerURLs := meta.IssuerURLs(); len(issuerURLs) != 1 {
+		t.Errorf("Expected the AIA URL once: %+v", issuerURLs)
+	}
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/certificate-transparency-go/asn1"
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+const kStatistics = "stats"
+
+// Fields of the statistics hash. Those ending in ':' are histograms, with
+// the bin appended.
+const (
+	kStatIssued     = "issued"
+	kStatPrecert    = "precert"
+	kStatMustStaple = "mustStaple"
+	kStatKey        = "key:"
+	kStatValidity   = "validity:"
+	kStatSANs       = "sans:"
+)
+
+// RFC 7633 TLS Feature, which carries status_request for must-staple
+var kOIDTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+var kValidityBins = []struct {
+	days  int
+	label string
+}{
+	{90, "<=90d"},
+	{180, "<=180d"},
+	{398, "<=398d"},
+	{825, "<=825d"},
+}
+
+var kSANBins = []struct {
+	count int
+	label string
+}{
+	{0, "0"},
+	{1, "1"},
+	{5, "2-5"},
+	{20, "6-20"},
+	{100, "21-100"},
+}
+
+// IssuerStatistics aggregates the certificates accumulated for an issuer.
+// A precertificate and its final certificate share a serial, so only
+// whichever was seen first is counted.
+type IssuerStatistics struct {
+	Certificates    int64            `json:"certificates"`
+	FirstIssued     time.Time        `json:"firstIssued"`
+	LastIssued      time.Time        `json:"lastIssued"`
+	Precertificates int64            `json:"precertificates"`
+	MustStaple      int64            `json:"mustStaple"`
+	KeyTypes        map[string]int64 `json:"keyTypes"`
+	ValidityPeriods map[string]int64 `json:"validityPeriods"`
+	SANCounts       map[string]int64 `json:"sanCounts"`
+}
+
+func NewIssuerStatistics() *IssuerStatistics {
+	return &IssuerStatistics{
+		KeyTypes:        make(map[string]int64),
+		ValidityPeriods: make(map[string]int64),
+		SANCounts:       make(map[string]int64),
+	}
+}
+
+func (s *IssuerStatistics) PrecertRatio() float64 {
+	if s.Certificates == 0 {
+		return 0
+	}
+	return float64(s.Precertificates) / float64(s.Certificates)
+}
+
+// Adds another issuer's statistics into these, e.g. for overall totals.
+func (s *IssuerStatistics) Add(aOther *IssuerStatistics) {
+	if aOther.Certificates > 0 {
+		if s.Certificates == 0 || aOther.FirstIssued.Before(s.FirstIssued) {
+			s.FirstIssued = aOther.FirstIssued
+		}
+		if aOther.LastIssued.After(s.LastIssued) {
+			s.LastIssued = aOther.LastIssued
+		}
+	}
+	s.Certificates += aOther.Certificates
+	s.Precertificates += aOther.Precertificates
+	s.MustStaple += aOther.MustStaple
+	for k, v := range aOther.KeyTypes {
+		s.KeyTypes[k] += v
+	}
+	for k, v := range aOther.ValidityPeriods {
+		s.ValidityPeriods[k] += v
+	}
+	for k, v := range aOther.SANCounts {
+		s.SANCounts[k] += v
+	}
+}
+
+func keyType(aCert *x509.Certificate) string {
+	switch key := aCert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA-%d", key.Curve.Params().BitSize)
+	}
+	return aCert.PublicKeyAlgorithm.String()
+}
+
+func validityBin(aCert *x509.Certificate) string {
+	days := int(aCert.NotAfter.Sub(aCert.NotBefore).Hours() / 24)
+	for _, bin := range kValidityBins {
+		if days <= bin.days {
+			return bin.label
+		}
+	}
+	return ">825d"
+}
+
+func sanBin(aCert *x509.Certificate) string {
+	count := len(aCert.DNSNames) + len(aCert.IPAddresses) + len(aCert.EmailAddresses) + len(aCert.URIs)
+	for _, bin := range kSANBins {
+		if count <= bin.count {
+			return bin.label
+		}
+	}
+	return ">100"
+}
+
+func isMustStaple(aCert *x509.Certificate) bool {
+	for _, ext := range aCert.Extensions {
+		if ext.Id.Equal(kOIDTLSFeature) {
+			return true
+		}
+	}
+	return false
+}
+
+// The statistics hash fields to count for aCert
+func statisticsFields(aCert *x509.Certificate) []string {
+	fields := []string{
+		kStatKey + keyType(aCert),
+		kStatValidity + validityBin(aCert),
+		kStatSANs + sanBin(aCert),
+	}
+	if aCert.IsPrecertificate() {
+		fields = append(fields, kStatPrecert)
+	}
+	if isMustStaple(aCert) {
+		fields = append(fields, kStatMustStaple)
+	}
+	return fields
+}
+
+func (im *IssuerMetadata) statsId() string {
+	return fmt.Sprintf("%s::%s", kStatistics, im.id())
+}
+
+func (im *IssuerMetadata) accumulateStatistics(aCert *x509.Certificate) error {
+	if err := im.cache.HashIncrement(im.statsId(), statisticsFields(aCert)...); err != nil {
+		return err
+	}
+	_, err := im.cache.HashTally(im.statsId(), kStatIssued, aCert.NotBefore)
+	return err
+}
+
+// The aggregates of every certificate accumulated for this issuer
+func (im *IssuerMetadata) Statistics() (*IssuerStatistics, error) {
+	entryChan := make(chan HashEntry)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- im.cache.HashToChan(im.statsId(), entryChan)
+	}()
+
+	stats := NewIssuerStatistics()
+	var parseErr error
+	for entry := range entryChan {
+		if entry.Field == kStatIssued {
+			t, err := parseTally(entry.Value)
+			if err != nil {
+				parseErr = err
+				continue
+			}
+			stats.Certificates = t.count
+			stats.FirstIssued = time.Unix(t.first, 0).UTC()
+			stats.LastIssued = time.Unix(t.last, 0).UTC()
+			continue
+		}
+
+		count, err := strconv.ParseInt(entry.Value, 10, 64)
+		if err != nil {
+			parseErr = fmt.Errorf("Malformed statistic %s=%q: %v", entry.Field, entry.Value, err)
+			continue
+		}
+		switch {
+		case entry.Field == kStatPrecert:
+			stats.Precertificates = count
+		case entry.Field == kStatMustStaple:
+			stats.MustStaple = count
+		case strings.HasPrefix(entry.Field, kStatKey):
+			stats.KeyTypes[strings.TrimPrefix(entry.Field, kStatKey)] = count
+		case strings.HasPrefix(entry.Field, kStatValidity):
+			stats.ValidityPeriods[strings.TrimPrefix(entry.Field, kStatValidity)] = count
+		case strings.HasPrefix(entry.Field, kStatSANs):
+			stats.SANCounts[strings.TrimPrefix(entry.Field, kStatSANs)] = count
+		}
+	}
+	if err := <-errChan; err != nil {
+		return stats, err
+	}
+	return stats, parseErr
+}
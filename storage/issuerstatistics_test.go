@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+func Test_IssuerStatistics(t *testing.T) {
+	issuerCN := "Counted Issuer"
+	first := makeCert(t, issuerCN, "2001-01-01", NewSerialFromHex("01"))
+	first.DNSNames = []string{"a.example", "b.example"}
+
+	precert := makeCert(t, issuerCN, "2001-06-01", NewSerialFromHex("02"))
+	precert.Extensions = append(precert.Extensions,
+		pkix.Extension{Id: x509.OIDExtensionCTPoison, Critical: true})
+
+	stapled := makeCert(t, issuerCN, "2001-03-01", NewSerialFromHex("03"))
+	stapled.Extensions = append(stapled.Extensions, pkix.Extension{Id: kOIDTLSFeature})
+	stapled.NotBefore = stapled.NotAfter.AddDate(0, 0, -90)
+
+	meta := NewIssuerMetadata(NewIssuer(first), NewMockRemoteCache())
+	for _, cert := range []*x509.Certificate{precert, first, stapled} {
+		if _, err := meta.Accumulate(cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := meta.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Certificates != 3 || stats.Precertificates != 1 || stats.MustStaple != 1 {
+		t.Errorf("Unexpected counts: %+v", stats)
+	}
+	if stats.PrecertRatio() < 0.33 || stats.PrecertRatio() > 0.34 {
+		t.Errorf("Expected a third to be precertificates, got %f", stats.PrecertRatio())
+	}
+	if !stats.FirstIssued.Equal(first.NotBefore) || !stats.LastIssued.Equal(stapled.NotBefore) {
+		t.Errorf("Unexpected issuance times %s - %s", stats.FirstIssued, stats.LastIssued)
+	}
+	if stats.KeyTypes["ECDSA-256"] != 3 {
+		t.Errorf("Expected three P-256 keys: %+v", stats.KeyTypes)
+	}
+	if stats.ValidityPeriods["<=398d"] != 2 || stats.ValidityPeriods["<=90d"] != 1 {
+		t.Errorf("Unexpected validity periods: %+v", stats.ValidityPeriods)
+	}
+	if stats.SANCounts["0"] != 2 || stats.SANCounts["2-5"] != 1 {
+		t.Errorf("Unexpected SAN counts: %+v", stats.SANCounts)
+	}
+
+	totals := NewIssuerStatistics()
+	totals.Add(stats)
+	totals.Add(stats)
+	if totals.Certificates != 6 || totals.KeyTypes["ECDSA-256"] != 6 || !totals.FirstIssued.Equal(stats.FirstIssued) {
+		t.Errorf("Unexpected totals: %+v", totals)
+	}
+}
@@ -2,26 +2,138 @@ package storage
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/golang/glog"
 )
 
 const kSerials = "serials"
 
+// How long to wait to rebuild a serial filter after failing to
+const kFilterRetryPeriod = time.Minute
+
 type KnownCertificates struct {
 	expDate   ExpDate
 	issuer    Issuer
 	cache     RemoteCache
+	encoding  SerialEncoding
 	expirySet bool
+
+	filterRate     float64
+	filterMutex    *sync.Mutex
+	filter         *serialFilter
+	filterBuilding bool
+	filterPending  []Serial
+	filterRetry    time.Time
 }
 
 func NewKnownCertificates(aExpDate ExpDate, aIssuer Issuer, aCache RemoteCache) *KnownCertificates {
+	return NewKnownCertificatesWithEncoding(aExpDate, aIssuer, aCache, SetSerialEncoding)
+}
+
+func NewKnownCertificatesWithEncoding(aExpDate ExpDate, aIssuer Issuer, aCache RemoteCache,
+	aEncoding SerialEncoding) *KnownCertificates {
 	return &KnownCertificates{
 		expDate:   aExpDate,
 		issuer:    aIssuer,
 		cache:     aCache,
+		encoding:  aEncoding,
 		expirySet: false,
+
+		filterRate:  0,
+		filterMutex: &sync.Mutex{},
+		filter:      nil,
+	}
+}
+
+// Puts an in-process Bloom filter of this bucket's serials in front of
+// Store's check of whether a serial is known, so serials the filter has never
+// seen are taken as unknown without asking the cache. That saves one round
+// trip for each new certificate, and only when the change feed is enabled;
+// WasUnknown still inserts every serial. The filter is wrong about a known
+// serial with probability at most aFalsePositiveRate, which costs the lookup
+// it would have made anyway. Serials other processes record after the filter
+// is built are missed, and are then published to the change feed again. To
+// keep its bound, whenever the filter fills beyond what it was sized for it
+// is rebuilt from the cache.
+func (kc *KnownCertificates) EnableFilter(aFalsePositiveRate float64) {
+	kc.filterMutex.Lock()
+	defer kc.filterMutex.Unlock()
+	kc.filterRate = aFalsePositiveRate
+	kc.filter = nil
+}
+
+// Marks the filter as building, if it's missing or saturated and a failed
+// build isn't too recent. If so, the caller must then warmFilter.
+func (kc *KnownCertificates) beginWarming() bool {
+	kc.filterMutex.Lock()
+	defer kc.filterMutex.Unlock()
+	if kc.filterBuilding || (kc.filter != nil && !kc.filter.saturated()) ||
+		time.Now().Before(kc.filterRetry) {
+		return false
+	}
+	kc.filterBuilding = true
+	kc.filter = nil
+	kc.filterPending = []Serial{}
+	return true
+}
+
+// Builds the filter from the cache. Meanwhile, other callers find no filter
+// and go to the cache, and serials they insert are added once it's built.
+func (kc *KnownCertificates) warmFilter() {
+	known, err := kc.ListKnown()
+
+	kc.filterMutex.Lock()
+	defer kc.filterMutex.Unlock()
+	pending := kc.filterPending
+	kc.filterBuilding = false
+	kc.filterPending = nil
+	if err != nil {
+		metrics.IncrCounter([]string{"KnownCertificates", "filter", "warm", "error"}, 1)
+		glog.Warningf("[%s] Couldn't warm serial filter, retrying after %s: %v", kc.id(),
+			kFilterRetryPeriod, err)
+		kc.filterRetry = time.Now().Add(kFilterRetryPeriod)
+		return
+	}
+
+	filter := newSerialFilter(uint64(2*(len(known)+len(pending))), kc.filterRate)
+	for _, serial := range known {
+		filter.add(serial)
+	}
+	for _, serial := range pending {
+		filter.add(serial)
+	}
+	metrics.IncrCounter([]string{"KnownCertificates", "filter", "warm"}, 1)
+	glog.V(1).Infof("[%s] Warmed serial filter with %d serials", kc.id(), len(known))
+	kc.filter = filter
+}
+
+// Whether the filter may hold aSerial. A false answer is certain, but there
+// is no answer until the filter has been built in the background.
+func (kc *KnownCertificates) filterContains(aSerial Serial) (contains bool, ok bool) {
+	if kc.beginWarming() {
+		go kc.warmFilter()
+	}
+
+	kc.filterMutex.Lock()
+	defer kc.filterMutex.Unlock()
+	if kc.filter == nil {
+		return false, false
+	}
+	return kc.filter.test(aSerial), true
+}
+
+func (kc *KnownCertificates) filterAdd(aSerial Serial) {
+	kc.filterMutex.Lock()
+	defer kc.filterMutex.Unlock()
+	if kc.filter != nil {
+		kc.filter.add(aSerial)
+	} else if kc.filterBuilding {
+		kc.filterPending = append(kc.filterPending, aSerial)
 	}
 }
 
@@ -30,17 +142,28 @@ func (kc *KnownCertificates) id(params ...string) string {
 }
 
 func (kc *KnownCertificates) serialId(params ...string) string {
-	return fmt.Sprintf("%s::%s", kSerials, kc.id(params...))
+	return fmt.Sprintf("%s::%s", kc.encoding.keyPrefix(), kc.id(params...))
+}
+
+func (kc *KnownCertificates) insert(aSerial Serial) (bool, error) {
+	if kc.encoding == PackedSerialEncoding {
+		return kc.cache.PackedSetInsert(kc.serialId(), packedField(aSerial), aSerial.BinaryString())
+	}
+	return kc.cache.SetInsert(kc.serialId(), aSerial.BinaryString())
 }
 
 // Returns true if this serial was unknown. Subsequent calls with the same serial
 // will return false, as it will be known then.
 func (kc *KnownCertificates) WasUnknown(aSerial Serial) (bool, error) {
-	result, err := kc.cache.SetInsert(kc.serialId(), aSerial.BinaryString())
+	result, err := kc.insert(aSerial)
 	if err != nil {
 		return false, err
 	}
 
+	if kc.filterRate > 0 {
+		kc.filterAdd(aSerial)
+	}
+
 	if !kc.expirySet {
 		kc.setExpiryFlag()
 		kc.expirySet = true
@@ -54,7 +177,64 @@ func (kc *KnownCertificates) WasUnknown(aSerial Serial) (bool, error) {
 	return result, nil
 }
 
+// Returns true if this serial is known, without recording it.
+func (kc *KnownCertificates) IsKnown(aSerial Serial) (bool, error) {
+	if kc.encoding != PackedSerialEncoding {
+		return kc.cache.SetContains(kc.serialId(), aSerial.BinaryString())
+	}
+
+	blob, err := kc.cache.HashGet(kc.serialId(), packedField(aSerial))
+	if err != nil {
+		return false, err
+	}
+	return packedContains(blob, aSerial.BinaryString()), nil
+}
+
+// Like IsKnown, but with a filter enabled, takes serials the filter has never
+// seen as unknown without asking the cache. Those may have been recorded
+// since by other processes, so this only decides whether work is needed.
+func (kc *KnownCertificates) mayBeKnown(aSerial Serial) (bool, error) {
+	filtered := false
+	if kc.filterRate > 0 {
+		contains, ok := kc.filterContains(aSerial)
+		if ok && !contains {
+			metrics.IncrCounter([]string{"KnownCertificates", "filter", "miss"}, 1)
+			return false, nil
+		}
+		filtered = ok
+	}
+
+	known, err := kc.IsKnown(aSerial)
+	if err != nil {
+		return false, err
+	}
+	if filtered {
+		if known {
+			metrics.IncrCounter([]string{"KnownCertificates", "filter", "hit"}, 1)
+		} else {
+			metrics.IncrCounter([]string{"KnownCertificates", "filter", "falsePositive"}, 1)
+		}
+	}
+	return known, nil
+}
+
 func (kc *KnownCertificates) Count() int64 {
+	if kc.encoding == PackedSerialEncoding {
+		countStr, err := kc.cache.HashGet(kc.serialId(), kPackedCountField)
+		if err != nil {
+			glog.Errorf("Couldn't determine count of %s: %s", kc.id(), err)
+			return 0
+		}
+		if len(countStr) == 0 {
+			return 0
+		}
+		count, err := strconv.ParseInt(countStr, 10, 64)
+		if err != nil {
+			glog.Errorf("Couldn't parse count of %s, %s: %s", kc.id(), countStr, err)
+		}
+		return count
+	}
+
 	count, err := kc.cache.SetCardinality(kc.serialId())
 	if err != nil {
 		glog.Errorf("Couldn't determine count of %s, now at %d: %s", kc.id(), count, err)
@@ -62,36 +242,85 @@ func (kc *KnownCertificates) Count() int64 {
 	return int64(count)
 }
 
-func (kc *KnownCertificates) Known() []Serial {
+func (kc *KnownCertificates) knownToChan(strChan chan<- string) error {
+	if kc.encoding != PackedSerialEncoding {
+		return kc.cache.SetToChan(kc.serialId(), strChan)
+	}
+
+	defer close(strChan)
+	hashChan := make(chan HashEntry)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- kc.cache.HashToChan(kc.serialId(), hashChan)
+	}()
+	for entry := range hashChan {
+		if entry.Field == kPackedCountField {
+			continue
+		}
+		for _, str := range unpackEntries(entry.Value) {
+			strChan <- str
+		}
+	}
+	return <-errChan
+}
+
+// Sends each known serial to serialChan once, closing it when done. The
+// caller must drain serialChan even after an error.
+func (kc *KnownCertificates) StreamKnown(serialChan chan<- Serial) error {
+	defer close(serialChan)
+
 	// Redis' scan methods regularly provide duplicates. The duplication
-	// happens at this level, pulling from SetToChan, so we make a hash-set
+	// happens at this level, pulling from SetToChan, so we keep a hash-set
 	// here to de-duplicate when the memory impacts are the most minimal.
-	serials := make(map[string]struct{})
-	var count int
+	seen := make(map[string]struct{})
 
 	strChan := make(chan string)
+	errChan := make(chan error, 1)
 	go func() {
-		err := kc.cache.SetToChan(kc.serialId(), strChan)
-		if err != nil {
-			glog.Fatalf("Error obtaining list of known certificates: %v", err)
-		}
+		errChan <- kc.knownToChan(strChan)
 	}()
 
 	for str := range strChan {
-		serials[str] = struct{}{}
-		count += 1
-	}
+		if _, ok := seen[str]; ok {
+			continue
+		}
+		seen[str] = struct{}{}
 
-	serialList := make([]Serial, 0, count)
-	for str := range serials {
 		bs, err := NewSerialFromBinaryString(str)
 		if err != nil {
 			glog.Errorf("Failed to populate serial str=[%s] %v", str, err)
 			continue
 		}
-		serialList = append(serialList, bs)
+		serialChan <- bs
 	}
+	return <-errChan
+}
 
+// Returns every known serial, or an error if they couldn't all be read.
+func (kc *KnownCertificates) ListKnown() ([]Serial, error) {
+	serialList := []Serial{}
+
+	serialChan := make(chan Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- kc.StreamKnown(serialChan)
+	}()
+
+	for serial := range serialChan {
+		serialList = append(serialList, serial)
+	}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+
+	return serialList, nil
+}
+
+func (kc *KnownCertificates) Known() []Serial {
+	serialList, err := kc.ListKnown()
+	if err != nil {
+		glog.Fatalf("Error obtaining list of known certificates: %v", err)
+	}
 	return serialList
 }
 
@@ -82,6 +82,46 @@ func Test_KnownCertificatesKnown(t *testing.T) {
 	}
 }
 
+func Test_KnownCertificatesStreamKnown(t *testing.T) {
+	backend := NewMockRemoteCache()
+	backend.Duplicate = 2
+	testIssuer := NewIssuerFromString("test issuer")
+
+	expDate, err := NewExpDate("2029-01-30")
+	if err != nil {
+		t.Error(err)
+	}
+
+	testList := SerialList{NewSerialFromHex("01"), NewSerialFromHex("03"), NewSerialFromHex("05")}
+	for _, encoding := range []SerialEncoding{SetSerialEncoding, PackedSerialEncoding} {
+		kc := NewKnownCertificatesWithEncoding(expDate, testIssuer, backend, encoding)
+		for _, serial := range testList {
+			if _, err := kc.WasUnknown(serial); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		serialChan := make(chan Serial)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- kc.StreamKnown(serialChan)
+		}()
+
+		result := SerialList{}
+		for serial := range serialChan {
+			result = append(result, serial)
+		}
+		if err := <-errChan; err != nil {
+			t.Error(err)
+		}
+
+		sort.Sort(result)
+		if !reflect.DeepEqual(testList, result) {
+			t.Errorf("StreamKnown should send each serial once: %+v // %+v", testList, result)
+		}
+	}
+}
+
 func Test_ExpireAt(t *testing.T) {
 	backend := NewMockRemoteCache()
 	testIssuer := NewIssuerFromString("test issuer")
@@ -103,7 +143,7 @@ func Test_ExpireAt(t *testing.T) {
 	if !ok {
 		t.Errorf("Expected exp date of 2004-01-20-04 but got %+v", backend.Expirations)
 	}
-	expected := time.Date(2004, 01, 20, 4, 0, 0, 0, time.UTC)
+	expected := time.Date(2004, 01, 20, 5, 0, 0, 0, time.UTC)
 	if val != expected {
 		t.Errorf("Expected the expiration date to match: %v != %v", val, expected)
 	}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
@@ -15,18 +17,23 @@ import (
 )
 
 const (
-	kStateDirName       = "state"
-	kSuffixCertificates = ".pem"
-	kDirtyMarker        = "dirty"
+	kStateDirName   = "state"
+	kDirtyDirName   = "dirty"
+	kRunsDirName    = "runs"
+	kIssuersDirName = "issuers"
+	// Names in the dirty tree with this prefix are markers being written or
+	// cleared, not markers.
+	kDirtyTempPrefix = "."
 )
 
 type LocalDiskBackend struct {
-	perms    os.FileMode
-	rootPath string
+	perms      os.FileMode
+	rootPath   string
+	dirtyMutex *sync.Mutex
 }
 
 func NewLocalDiskBackend(perms os.FileMode, aPath string) StorageBackend {
-	return &LocalDiskBackend{perms, aPath}
+	return &LocalDiskBackend{perms, aPath, &sync.Mutex{}}
 }
 
 func isDirectory(aPath string) bool {
@@ -86,8 +93,139 @@ func (db *LocalDiskBackend) load(path string) ([]byte, error) {
 	return data, err
 }
 
-func (db *LocalDiskBackend) MarkDirty(id string) error {
-	return db.store(filepath.Join(id, kDirtyMarker), []byte{0})
+func (db *LocalDiskBackend) dirtyPath(bucket IssuerAndDate) string {
+	return filepath.Join(db.rootPath, kDirtyDirName, bucket.ExpDate.ID(), bucket.Issuer.ID())
+}
+
+// A path beside aPath unique to this process and moment, for aPurpose.
+func dirtyTempPath(aPath string, aPurpose string) string {
+	return filepath.Join(filepath.Dir(aPath), fmt.Sprintf("%s%s-%s-%d-%d", kDirtyTempPrefix,
+		filepath.Base(aPath), aPurpose, os.Getpid(), time.Now().UnixNano()))
+}
+
+func (db *LocalDiskBackend) loadDirty(path string) (time.Time, error) {
+	data, err := db.load(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, string(data))
+}
+
+// Dirty markers live in their own tree, dirty/<expDate>/<issuer>, each
+// holding the time it was last marked. Markers are written aside and renamed
+// into place, so other processes only ever see whole ones.
+func (db *LocalDiskBackend) MarkDirty(_ context.Context, bucket IssuerAndDate) error {
+	db.dirtyMutex.Lock()
+	defer db.dirtyMutex.Unlock()
+	path := db.dirtyPath(bucket)
+	markedAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	for attempt := 1; ; attempt++ {
+		temp := dirtyTempPath(path, "marking")
+		err := db.store(temp, []byte(markedAt))
+		if err == nil {
+			err = os.Rename(temp, path)
+		}
+		if err == nil {
+			return nil
+		}
+		_ = os.Remove(temp)
+		// Another process's ClearDirty may have tidied the directory away
+		// between creating and writing to it.
+		if !os.IsNotExist(err) || attempt >= 3 {
+			return err
+		}
+	}
+}
+
+func (db *LocalDiskBackend) ListDirty(_ context.Context, aSince time.Time) ([]DirtyBucket, error) {
+	db.dirtyMutex.Lock()
+	defer db.dirtyMutex.Unlock()
+	buckets := make([]DirtyBucket, 0)
+
+	dirtyRoot := filepath.Join(db.rootPath, kDirtyDirName)
+	expDirs, err := ioutil.ReadDir(dirtyRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return buckets, nil
+		}
+		return buckets, err
+	}
+
+	for _, expDir := range expDirs {
+		expDate, err := NewExpDate(expDir.Name())
+		if err != nil || !expDir.IsDir() {
+			glog.Warningf("Ignoring unexpected dirty marker %s: %v", expDir.Name(), err)
+			continue
+		}
+
+		markers, err := ioutil.ReadDir(filepath.Join(dirtyRoot, expDir.Name()))
+		if err != nil {
+			return buckets, err
+		}
+		for _, marker := range markers {
+			if strings.HasPrefix(marker.Name(), kDirtyTempPrefix) {
+				continue
+			}
+			bucket := DirtyBucket{
+				IssuerAndDate: IssuerAndDate{
+					ExpDate: expDate,
+					Issuer:  NewIssuerFromString(marker.Name()),
+				},
+			}
+			bucket.MarkedAt, err = db.loadDirty(db.dirtyPath(bucket.IssuerAndDate))
+			if os.IsNotExist(err) {
+				// Cleared since we listed it
+				continue
+			}
+			if err != nil {
+				return buckets, err
+			}
+			if bucket.MarkedAt.After(aSince) {
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+
+	return buckets, nil
+}
+
+// ClearDirty only removes the marker if it hasn't been marked again since
+// bucket.MarkedAt, so changes made while processing aren't lost. The marker is
+// renamed aside before it's compared, so a MarkDirty from another process
+// either lands first, and is seen and put back, or lands after, and is left be.
+func (db *LocalDiskBackend) ClearDirty(_ context.Context, bucket DirtyBucket) error {
+	db.dirtyMutex.Lock()
+	defer db.dirtyMutex.Unlock()
+
+	path := db.dirtyPath(bucket.IssuerAndDate)
+	aside := dirtyTempPath(path, "clearing")
+	if err := os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	markedAt, err := db.loadDirty(aside)
+	if err != nil || markedAt.After(bucket.MarkedAt) {
+		// Put it back, unless it's been marked again meanwhile.
+		if linkErr := os.Link(aside, path); linkErr != nil && !os.IsExist(linkErr) {
+			return linkErr
+		}
+		if removeErr := os.Remove(aside); removeErr != nil {
+			return removeErr
+		}
+		return err
+	}
+
+	if err := os.Remove(aside); err != nil {
+		return err
+	}
+	// Tidy up the expiration date's directory, which fails harmlessly if it
+	// isn't yet empty.
+	_ = os.Remove(filepath.Dir(path))
+	return nil
 }
 
 func (db *LocalDiskBackend) ListExpirationDates(_ context.Context,
@@ -102,7 +240,8 @@ func (db *LocalDiskBackend) ListExpirationDates(_ context.Context,
 			return err
 		}
 		if info.IsDir() {
-			if info.Name() == kStateDirName {
+			if info.Name() == kStateDirName || info.Name() == kDirtyDirName ||
+				info.Name() == kRunsDirName || info.Name() == kIssuersDirName {
 				return filepath.SkipDir
 			}
 
@@ -122,20 +261,18 @@ func (db *LocalDiskBackend) ListIssuersForExpirationDate(_ context.Context,
 	expDate ExpDate) ([]Issuer, error) {
 	issuers := make([]Issuer, 0)
 
-	err := filepath.Walk(filepath.Join(db.rootPath, expDate.ID()), func(path string, info os.FileInfo,
-		err error) error {
-		if err != nil {
-			glog.Warningf("prevent panic by handling failure accessing a path %q: %v", path, err)
-			return err
-		}
-		if strings.HasSuffix(info.Name(), kSuffixCertificates) {
-			id := strings.TrimSuffix(info.Name(), kSuffixCertificates)
-			issuers = append(issuers, NewIssuerFromString(id))
+	infos, err := ioutil.ReadDir(filepath.Join(db.rootPath, expDate.ID()))
+	if err != nil {
+		return issuers, err
+	}
+
+	for _, info := range infos {
+		if info.IsDir() {
+			issuers = append(issuers, NewIssuerFromString(info.Name()))
 		}
-		return nil
-	})
+	}
 
-	return issuers, err
+	return issuers, nil
 }
 
 func (db *LocalDiskBackend) ListSerialsForExpirationDateAndIssuer(ctx context.Context,
@@ -158,46 +295,70 @@ func (db *LocalDiskBackend) ListSerialsForExpirationDateAndIssuer(ctx context.Co
 	return serials, nil
 }
 
-func (db *LocalDiskBackend) StreamSerialsForExpirationDateAndIssuer(_ context.Context,
-	expDate ExpDate, issuer Issuer, _ <-chan struct{}, sChan chan<- UniqueCertIdentifier) error {
+func (db *LocalDiskBackend) StreamSerialsForExpirationDateAndIssuer(ctx context.Context,
+	expDate ExpDate, issuer Issuer, quitChan <-chan struct{}, sChan chan<- UniqueCertIdentifier) error {
+	infos, err := ioutil.ReadDir(filepath.Join(db.rootPath, expDate.ID(), issuer.ID()))
+	if err != nil {
+		return err
+	}
 
-	return filepath.Walk(filepath.Join(db.rootPath, expDate.ID(), issuer.ID()), func(path string,
-		info os.FileInfo, err error) error {
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		serial, err := NewSerialFromIDString(info.Name())
 		if err != nil {
-			glog.Warningf("prevent panic by handling failure accessing a path %q: %v", path, err)
-			return err
+			glog.Warningf("Ignoring unexpected file %s in %s/%s: %v", info.Name(), expDate.ID(),
+				issuer.ID(), err)
+			continue
 		}
-		if strings.HasSuffix(info.Name(), kSuffixCertificates) {
-			id := strings.TrimSuffix(info.Name(), kSuffixCertificates)
-			// TODO: read file, pull out serials -- this isn't right
-			serial, err := NewSerialFromIDString(id)
-			if err != nil {
-				return err
-			}
-			sChan <- UniqueCertIdentifier{
-				SerialNum: serial,
-				Issuer:    issuer,
-				ExpDate:   expDate,
-			}
-			return fmt.Errorf("Unimplemented")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-quitChan:
+			return nil
+		case sChan <- UniqueCertIdentifier{
+			SerialNum: serial,
+			Issuer:    issuer,
+			ExpDate:   expDate,
+		}:
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 func (db *LocalDiskBackend) AllocateExpDateAndIssuer(_ context.Context, expDate ExpDate,
 	issuer Issuer) error {
 	path := filepath.Join(db.rootPath, expDate.ID(), issuer.ID())
-	return makeDirectoryIfNotExist(path)
+	if isDirectory(path) {
+		return nil
+	}
+	return os.MkdirAll(path, os.ModeDir|0777)
+}
+
+// Removes every certificate and dirty marker for expDate.
+func (db *LocalDiskBackend) DeleteExpirationDate(_ context.Context, expDate ExpDate) error {
+	db.dirtyMutex.Lock()
+	defer db.dirtyMutex.Unlock()
+	if err := os.RemoveAll(filepath.Join(db.rootPath, kDirtyDirName, expDate.ID())); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(db.rootPath, expDate.ID()))
 }
 
 func (db *LocalDiskBackend) StoreCertificatePEM(ctx context.Context, serial Serial, expDate ExpDate,
 	issuer Issuer, b []byte) error {
-	db.AllocateExpDateAndIssuer(ctx, expDate, issuer)
+	if err := db.AllocateExpDateAndIssuer(ctx, expDate, issuer); err != nil {
+		return err
+	}
 	path := filepath.Join(db.rootPath, expDate.ID(), issuer.ID(), serial.ID())
 	return db.store(path, b)
 }
 
+func (db *LocalDiskBackend) StoreIssuerPEM(_ context.Context, issuer Issuer, b []byte) error {
+	return db.store(filepath.Join(db.rootPath, kIssuersDirName, issuer.ID()), b)
+}
+
 func (db *LocalDiskBackend) StoreLogState(_ context.Context, log *CertificateLog) error {
 	path := filepath.Join(db.rootPath, kStateDirName, log.ID())
 
@@ -238,7 +399,12 @@ func (db *LocalDiskBackend) StoreKnownCertificateList(ctx context.Context, issue
 
 func (db *LocalDiskBackend) LoadCertificatePEM(_ context.Context, serial Serial, expDate ExpDate,
 	issuer Issuer) ([]byte, error) {
-	return nil, fmt.Errorf("Unimplemented")
+	path := filepath.Join(db.rootPath, expDate.ID(), issuer.ID(), serial.ID())
+	return db.load(path)
+}
+
+func (db *LocalDiskBackend) LoadIssuerPEM(_ context.Context, issuer Issuer) ([]byte, error) {
+	return db.load(filepath.Join(db.rootPath, kIssuersDirName, issuer.ID()))
 }
 
 func (db *LocalDiskBackend) LoadLogState(_ context.Context, logURL string) (*CertificateLog, error) {
@@ -259,3 +425,49 @@ func (db *LocalDiskBackend) LoadLogState(_ context.Context, logURL string) (*Cer
 
 	return &log, nil
 }
+
+// Run files live under runs/<runID>/<name>.
+func (db *LocalDiskBackend) runFilePath(runID string, name string) (string, error) {
+	if runID == "" || strings.ContainsAny(runID, "/\\") || runID == "." || runID == ".." {
+		return "", fmt.Errorf("Invalid run ID %q", runID)
+	}
+	cleaned := path.Clean("/" + name)
+	if name == "" || cleaned == "/" || cleaned[1:] != name {
+		return "", fmt.Errorf("Invalid run file name %q", name)
+	}
+	return filepath.Join(db.rootPath, kRunsDirName, runID, filepath.FromSlash(name)), nil
+}
+
+func (db *LocalDiskBackend) StoreRunFile(_ context.Context, runID string, name string, b []byte) error {
+	path, err := db.runFilePath(runID, name)
+	if err != nil {
+		return err
+	}
+	return db.store(path, b)
+}
+
+func (db *LocalDiskBackend) LoadRunFile(_ context.Context, runID string, name string) ([]byte, error) {
+	path, err := db.runFilePath(runID, name)
+	if err != nil {
+		return nil, err
+	}
+	return db.load(path)
+}
+
+// Lists run IDs in lexical order.
+func (db *LocalDiskBackend) ListRuns(_ context.Context) ([]string, error) {
+	runs := []string{}
+	infos, err := ioutil.ReadDir(filepath.Join(db.rootPath, kRunsDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return runs, nil
+		}
+		return runs, err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			runs = append(runs, info.Name())
+		}
+	}
+	return runs, nil
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func makeLocalDiskHarness(t *testing.T) *LocalDiskTestHarness {
@@ -38,25 +39,105 @@ func (h *LocalDiskTestHarness) cleanup() {
 }
 
 func Test_LocalDiskStoreLoad(t *testing.T) {
-	t.Skip("Disabled")
 	h := makeLocalDiskHarness(t)
 	defer h.cleanup()
 	BackendTestStoreLoad(t, h.db)
 }
 
 func Test_LocalDiskListFiles(t *testing.T) {
-	t.Skip("Disabled")
 	h := makeLocalDiskHarness(t)
 	defer h.cleanup()
 	BackendTestListFiles(t, h.db)
 }
 
+func Test_LocalDiskListingCertificates(t *testing.T) {
+	h := makeLocalDiskHarness(t)
+	defer h.cleanup()
+	BackendTestListingCertificates(t, h.db)
+}
+
+func Test_LocalDiskDirty(t *testing.T) {
+	h := makeLocalDiskHarness(t)
+	defer h.cleanup()
+	BackendTestDirty(t, h.db)
+}
+
+func Test_LocalDiskDirtyWithinRoot(t *testing.T) {
+	h := makeLocalDiskHarness(t)
+	defer h.cleanup()
+	bucket := IssuerAndDate{ExpDate: mkExpDate("2050-01-01"), Issuer: NewIssuerFromString("issuer")}
+	if err := h.db.MarkDirty(context.TODO(), bucket); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(h.root, kDirtyDirName, bucket.ExpDate.ID(), "issuer")); err != nil {
+		t.Errorf("Expected the marker within the root: %v", err)
+	}
+	if _, err := os.Stat(bucket.ExpDate.ID()); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing marked relative to the working directory: %v", err)
+	}
+}
+
+func Test_LocalDiskDirtyAcrossBackends(t *testing.T) {
+	h := makeLocalDiskHarness(t)
+	defer h.cleanup()
+	// Separate backends over one root stand in for separate processes.
+	other := NewLocalDiskBackend(0644, h.root)
+	bucket := IssuerAndDate{ExpDate: mkExpDate("2050-01-01"), Issuer: NewIssuerFromString("issuer")}
+
+	if err := h.db.MarkDirty(context.TODO(), bucket); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := h.db.ListDirty(context.TODO(), time.Time{})
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("Expected one dirty bucket, got %+v %v", listed, err)
+	}
+	if err := other.MarkDirty(context.TODO(), bucket); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.db.ClearDirty(context.TODO(), listed[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leftovers of interrupted marks and clears aren't markers
+	leftover := dirtyTempPath(filepath.Join(h.root, kDirtyDirName, "2050-01-01", "issuer"), "clearing")
+	if err := ioutil.WriteFile(leftover, []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := other.ListDirty(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || !remaining[0].MarkedAt.After(listed[0].MarkedAt) {
+		t.Errorf("Expected the other backend's later mark to survive, got %+v", remaining)
+	}
+}
+
 func Test_LocalDiskLogState(t *testing.T) {
 	h := makeLocalDiskHarness(t)
 	defer h.cleanup()
 	BackendTestLogState(t, h.db)
 }
 
+func Test_LocalDiskIssuerPEM(t *testing.T) {
+	h := makeLocalDiskHarness(t)
+	defer h.cleanup()
+	BackendTestIssuerPEM(t, h.db)
+}
+
+func Test_LocalDiskRunFiles(t *testing.T) {
+	h := makeLocalDiskHarness(t)
+	defer h.cleanup()
+	BackendTestRunFiles(t, h.db)
+
+	if err := h.db.StoreRunFile(context.TODO(), "..", "escape", []byte{}); err == nil {
+		t.Error("Expected an invalid run ID to fail")
+	}
+	if err := h.db.StoreRunFile(context.TODO(), "run", "../escape", []byte{}); err == nil {
+		t.Error("Expected a run file name outside the run to fail")
+	}
+}
+
 func Test_KnownCertificateList(t *testing.T) {
 	h := makeLocalDiskHarness(t)
 	defer h.cleanup()
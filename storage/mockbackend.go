@@ -13,6 +13,8 @@ type MockBackend struct {
 	expDateToIssuer          map[string][]Issuer
 	expDateIssuerIDToSerials map[string][]Serial
 	store                    map[string][]byte
+	dirty                    map[string]DirtyBucket
+	runs                     map[string]map[string][]byte
 }
 
 func NewMockBackend() *MockBackend {
@@ -20,10 +22,34 @@ func NewMockBackend() *MockBackend {
 		expDateToIssuer:          make(map[string][]Issuer),
 		expDateIssuerIDToSerials: make(map[string][]Serial),
 		store:                    make(map[string][]byte),
+		dirty:                    make(map[string]DirtyBucket),
+		runs:                     make(map[string]map[string][]byte),
 	}
 }
 
-func (db *MockBackend) MarkDirty(id string) error {
+func (db *MockBackend) MarkDirty(_ context.Context, bucket IssuerAndDate) error {
+	db.dirty[bucket.String()] = DirtyBucket{
+		IssuerAndDate: bucket,
+		MarkedAt:      time.Now(),
+	}
+	return nil
+}
+
+func (db *MockBackend) ListDirty(_ context.Context, aSince time.Time) ([]DirtyBucket, error) {
+	buckets := []DirtyBucket{}
+	for _, bucket := range db.dirty {
+		if bucket.MarkedAt.After(aSince) {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets, nil
+}
+
+func (db *MockBackend) ClearDirty(_ context.Context, bucket DirtyBucket) error {
+	current, ok := db.dirty[bucket.String()]
+	if ok && !current.MarkedAt.After(bucket.MarkedAt) {
+		delete(db.dirty, bucket.String())
+	}
 	return nil
 }
 
@@ -47,6 +73,22 @@ func (db *MockBackend) AllocateExpDateAndIssuer(_ context.Context, expDate ExpDa
 	return nil
 }
 
+func (db *MockBackend) DeleteExpirationDate(_ context.Context, expDate ExpDate) error {
+	for _, issuer := range db.expDateToIssuer[expDate.ID()] {
+		for _, serial := range db.expDateIssuerIDToSerials[expDate.ID()+issuer.ID()] {
+			delete(db.store, "pem"+expDate.ID()+issuer.ID()+serial.ID())
+		}
+		delete(db.expDateIssuerIDToSerials, expDate.ID()+issuer.ID())
+	}
+	delete(db.expDateToIssuer, expDate.ID())
+	for id, bucket := range db.dirty {
+		if bucket.ExpDate.ID() == expDate.ID() {
+			delete(db.dirty, id)
+		}
+	}
+	return nil
+}
+
 func (db *MockBackend) StoreCertificatePEM(_ context.Context, serial Serial, expDate ExpDate,
 	issuer Issuer, b []byte) error {
 	db.store["pem"+expDate.ID()+issuer.ID()+serial.ID()] = b
@@ -58,6 +100,11 @@ func (db *MockBackend) StoreCertificatePEM(_ context.Context, serial Serial, exp
 	return nil
 }
 
+func (db *MockBackend) StoreIssuerPEM(_ context.Context, issuer Issuer, b []byte) error {
+	db.store["issuerpem"+issuer.ID()] = b
+	return nil
+}
+
 func (db *MockBackend) StoreLogState(_ context.Context, log *CertificateLog) error {
 	data, err := json.Marshal(log)
 	if err != nil {
@@ -87,6 +134,14 @@ func (db *MockBackend) LoadCertificatePEM(_ context.Context, serial Serial, expD
 	return []byte{}, fmt.Errorf("Couldn't find")
 }
 
+func (db *MockBackend) LoadIssuerPEM(_ context.Context, issuer Issuer) ([]byte, error) {
+	data, ok := db.store["issuerpem"+issuer.ID()]
+	if ok {
+		return data, nil
+	}
+	return []byte{}, fmt.Errorf("Couldn't find")
+}
+
 func (db *MockBackend) LoadLogState(_ context.Context, logURL string) (*CertificateLog, error) {
 	data, ok := db.store["logstate"+logURL]
 	if ok {
@@ -105,15 +160,11 @@ func (db *MockBackend) ListExpirationDates(_ context.Context, aNotBefore time.Ti
 		aNotBefore.Day(), 0, 0, 0, 0, time.UTC)
 
 	for key := range db.expDateToIssuer {
-		v, err := time.Parse(kExpirationFormat, key)
+		ed, err := NewExpDate(key)
 		if err != nil {
 			return []ExpDate{}, err
 		}
-		if !v.Before(truncatedNotBefore) {
-			ed, err := NewExpDate(key)
-			if err != nil {
-				return dates, err
-			}
+		if !ed.IsExpiredAt(truncatedNotBefore) {
 			dates = append(dates, ed)
 		}
 	}
@@ -146,3 +197,30 @@ func (db *MockBackend) StreamSerialsForExpirationDateAndIssuer(ctx context.Conte
 	}
 	return nil
 }
+
+func (db *MockBackend) StoreRunFile(_ context.Context, runID string, name string, b []byte) error {
+	files, ok := db.runs[runID]
+	if !ok {
+		files = make(map[string][]byte)
+		db.runs[runID] = files
+	}
+	files[name] = b
+	return nil
+}
+
+func (db *MockBackend) LoadRunFile(_ context.Context, runID string, name string) ([]byte, error) {
+	data, ok := db.runs[runID][name]
+	if ok {
+		return data, nil
+	}
+	return []byte{}, fmt.Errorf("Couldn't find")
+}
+
+func (db *MockBackend) ListRuns(_ context.Context) ([]string, error) {
+	runs := []string{}
+	for runID := range db.runs {
+		runs = append(runs, runID)
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
@@ -2,10 +2,13 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath" // used for glob-like matching in Keys
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -14,28 +17,48 @@ import (
 type MockRemoteCache struct {
 	Data        map[string][]string
 	Expirations map[string]time.Time
+	Hashes      map[string]map[string]string
+	Streams     map[string][]StreamEntry
+	Lists       map[string][]string
 	Duplicate   int
+	streamSeq   uint64
+	mutex       *sync.Mutex
 }
 
 func NewMockRemoteCache() *MockRemoteCache {
 	return &MockRemoteCache{
 		Data:        make(map[string][]string),
 		Expirations: make(map[string]time.Time),
+		Hashes:      make(map[string]map[string]string),
+		Streams:     make(map[string][]StreamEntry),
+		Lists:       make(map[string][]string),
 		Duplicate:   0,
+		mutex:       &sync.Mutex{},
 	}
 }
 
 func (ec *MockRemoteCache) CleanupExpiry() {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+}
+
+// Callers must hold the mutex
+func (ec *MockRemoteCache) cleanupExpiry() {
 	now := time.Now()
 	for key, timestamp := range ec.Expirations {
 		if timestamp.Before(now) {
 			delete(ec.Data, key)
+			delete(ec.Hashes, key)
+			delete(ec.Lists, key)
 			delete(ec.Expirations, key)
 		}
 	}
 }
 
 func (ec *MockRemoteCache) SetInsert(key string, entry string) (bool, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
 	count := len(ec.Data[key])
 
 	idx := sort.Search(count, func(i int) bool {
@@ -61,7 +84,9 @@ func (ec *MockRemoteCache) SetInsert(key string, entry string) (bool, error) {
 }
 
 func (ec *MockRemoteCache) SetRemove(key string, entry string) (bool, error) {
-	ec.CleanupExpiry()
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
 	count := len(ec.Data[key])
 
 	idx := sort.Search(count, func(i int) bool {
@@ -74,7 +99,7 @@ func (ec *MockRemoteCache) SetRemove(key string, entry string) (bool, error) {
 	}
 
 	if idx < count && cmp == 0 {
-		ec.Data[key] = append(ec.Data[key][:idx], ec.Data[key][idx:]...)
+		ec.Data[key] = append(ec.Data[key][:idx], ec.Data[key][idx+1:]...)
 		return true, nil
 	}
 
@@ -82,7 +107,9 @@ func (ec *MockRemoteCache) SetRemove(key string, entry string) (bool, error) {
 }
 
 func (ec *MockRemoteCache) SetContains(key string, entry string) (bool, error) {
-	ec.CleanupExpiry()
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
 	count := len(ec.Data[key])
 
 	idx := sort.Search(count, func(i int) bool {
@@ -102,15 +129,21 @@ func (ec *MockRemoteCache) SetContains(key string, entry string) (bool, error) {
 }
 
 func (ec *MockRemoteCache) SetList(key string) ([]string, error) {
-	ec.CleanupExpiry()
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
 	return ec.Data[key], nil
 }
 
 func (ec *MockRemoteCache) SetToChan(key string, c chan<- string) error {
 	defer close(c)
-	ec.CleanupExpiry()
+	ec.mutex.Lock()
+	ec.cleanupExpiry()
+	values := append([]string{}, ec.Data[key]...)
+	ec.mutex.Unlock()
+
 	for i := 0; i < ec.Duplicate+1; i++ {
-		for _, v := range ec.Data[key] {
+		for _, v := range values {
 			c <- v
 		}
 	}
@@ -118,41 +151,270 @@ func (ec *MockRemoteCache) SetToChan(key string, c chan<- string) error {
 }
 
 func (ec *MockRemoteCache) SetCardinality(key string) (int, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
 	return len(ec.Data[key]), nil
 }
 
+func (ec *MockRemoteCache) SetCardinalities(keys []string) ([]int64, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	counts := make([]int64, len(keys))
+	for i, key := range keys {
+		counts[i] = int64(len(ec.Data[key]))
+	}
+	return counts, nil
+}
+
+func (ec *MockRemoteCache) SetContainsEach(keys []string, entry string) ([]bool, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		for _, v := range ec.Data[key] {
+			if v == entry {
+				found[i] = true
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
 func (ec *MockRemoteCache) Exists(key string) (bool, error) {
-	ec.CleanupExpiry()
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
 	_, ok := ec.Data[key]
+	if !ok {
+		_, ok = ec.Hashes[key]
+	}
+	if !ok {
+		_, ok = ec.Lists[key]
+	}
 	return ok, nil
 }
 
+func (ec *MockRemoteCache) Delete(key string) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	delete(ec.Data, key)
+	delete(ec.Hashes, key)
+	delete(ec.Streams, key)
+	delete(ec.Lists, key)
+	delete(ec.Expirations, key)
+	return nil
+}
+
+func (ec *MockRemoteCache) SetsReplace(aSets map[string][]string, aExpTime time.Time) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	for key, entries := range aSets {
+		delete(ec.Data, key)
+		delete(ec.Expirations, key)
+		if len(entries) == 0 {
+			continue
+		}
+		set := append([]string{}, entries...)
+		sort.Strings(set)
+		unique := set[:0]
+		for i, entry := range set {
+			if i == 0 || entry != set[i-1] {
+				unique = append(unique, entry)
+			}
+		}
+		ec.Data[key] = unique
+		ec.Expirations[key] = aExpTime
+	}
+	return nil
+}
+
+func (ec *MockRemoteCache) PackedSetInsert(key string, field string, entry string) (bool, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if len(entry) > 255 {
+		return false, fmt.Errorf("Entry too long to pack: %d bytes", len(entry))
+	}
+	hash, ok := ec.Hashes[key]
+	if !ok {
+		hash = make(map[string]string)
+		ec.Hashes[key] = hash
+	}
+	blob, added := packedInsert(hash[field], entry)
+	if !added {
+		return false, nil
+	}
+	hash[field] = blob
+	count, _ := strconv.ParseInt(hash[kPackedCountField], 10, 64)
+	hash[kPackedCountField] = strconv.FormatInt(count+1, 10)
+	return true, nil
+}
+
+func (ec *MockRemoteCache) HashTally(key string, field string, aAt time.Time) (bool, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	hash, ok := ec.Hashes[key]
+	if !ok {
+		hash = make(map[string]string)
+		ec.Hashes[key] = hash
+	}
+	at := aAt.Unix()
+	value, ok := hash[field]
+	if !ok {
+		hash[field] = formatTally(tally{count: 1, first: at, last: at})
+		return true, nil
+	}
+	t, err := parseTally(value)
+	if err != nil {
+		return false, err
+	}
+	t.count++
+	if at < t.first {
+		t.first = at
+	}
+	if at > t.last {
+		t.last = at
+	}
+	hash[field] = formatTally(t)
+	return false, nil
+}
+
+func (ec *MockRemoteCache) HashIncrement(key string, fields ...string) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	hash, ok := ec.Hashes[key]
+	if !ok {
+		hash = make(map[string]string)
+		ec.Hashes[key] = hash
+	}
+	for _, field := range fields {
+		count, _ := strconv.ParseInt(hash[field], 10, 64)
+		hash[field] = strconv.FormatInt(count+1, 10)
+	}
+	return nil
+}
+
+func (ec *MockRemoteCache) HashGet(key string, field string) (string, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	return ec.Hashes[key][field], nil
+}
+
+func (ec *MockRemoteCache) HashGetEach(keys []string, field string) ([]string, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = ec.Hashes[key][field]
+	}
+	return values, nil
+}
+
+func (ec *MockRemoteCache) HashToChan(key string, c chan<- HashEntry) error {
+	defer close(c)
+	ec.mutex.Lock()
+	ec.cleanupExpiry()
+	entries := make([]HashEntry, 0, len(ec.Hashes[key]))
+	for field, value := range ec.Hashes[key] {
+		entries = append(entries, HashEntry{Field: field, Value: value})
+	}
+	ec.mutex.Unlock()
+
+	for i := 0; i < ec.Duplicate+1; i++ {
+		for _, entry := range entries {
+			c <- entry
+		}
+	}
+	return nil
+}
+
 func (ec *MockRemoteCache) ExpireAt(key string, expTime time.Time) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
 	ec.Expirations[key] = expTime
 	return nil
 }
 
 func (ec *MockRemoteCache) ExpireIn(key string, dur time.Duration) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
 	ec.Expirations[key] = time.Now().Add(dur)
 	return nil
 }
 
 func (ec *MockRemoteCache) Queue(key string, identifier string) (int64, error) {
-	return int64(0), fmt.Errorf("Queue unimplemented")
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.Lists[key] = append(ec.Lists[key], identifier)
+	return int64(len(ec.Lists[key])), nil
+}
+
+func (ec *MockRemoteCache) QueueHead(key string, identifier string) (int64, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.Lists[key] = append([]string{identifier}, ec.Lists[key]...)
+	return int64(len(ec.Lists[key])), nil
+}
+
+// Callers must hold the mutex. Like Redis, empty lists cease to exist.
+func (ec *MockRemoteCache) listRemoveAt(key string, idx int) string {
+	list := ec.Lists[key]
+	v := list[idx]
+	ec.Lists[key] = append(list[:idx:idx], list[idx+1:]...)
+	if len(ec.Lists[key]) == 0 {
+		delete(ec.Lists, key)
+	}
+	return v
 }
 
 func (ec *MockRemoteCache) Pop(key string) (string, error) {
-	return "", fmt.Errorf("Pop unimplemented")
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	if len(ec.Lists[key]) == 0 {
+		return "", errors.New(EMPTY_QUEUE)
+	}
+	return ec.listRemoveAt(key, 0), nil
 }
 
 func (ec *MockRemoteCache) QueueLength(key string) (int64, error) {
-	return int64(0), fmt.Errorf("QueueLength unimplemented")
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	return int64(len(ec.Lists[key])), nil
+}
+
+func (ec *MockRemoteCache) QueueList(key string) ([]string, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	return append([]string{}, ec.Lists[key]...), nil
 }
 
 func (ec *MockRemoteCache) KeysToChan(pattern string, c chan<- string) error {
 	defer close(c)
 
+	ec.mutex.Lock()
+	keys := make([]string, 0, len(ec.Data)+len(ec.Hashes)+len(ec.Streams)+len(ec.Lists))
 	for key := range ec.Data {
+		keys = append(keys, key)
+	}
+	for key := range ec.Hashes {
+		keys = append(keys, key)
+	}
+	for key := range ec.Streams {
+		keys = append(keys, key)
+	}
+	for key := range ec.Lists {
+		keys = append(keys, key)
+	}
+	ec.mutex.Unlock()
+
+	for _, key := range keys {
 		matched, err := filepath.Match(pattern, key)
 		if err != nil {
 			return err
@@ -166,45 +428,184 @@ func (ec *MockRemoteCache) KeysToChan(pattern string, c chan<- string) error {
 }
 
 func (ec *MockRemoteCache) TrySet(key string, v string, life time.Duration) (string, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
 	val, ok := ec.Data[key]
 	if ok {
 		return val[0], nil
 	}
 	ec.Data[key] = []string{v}
-	err := ec.ExpireAt(key, time.Now().Add(life))
-	return v, err
+	ec.Expirations[key] = time.Now().Add(life)
+	return v, nil
+}
+
+func (ec *MockRemoteCache) Get(key string) (string, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.cleanupExpiry()
+	if val, ok := ec.Data[key]; ok && len(val) > 0 {
+		return val[0], nil
+	}
+	return "", nil
+}
+
+// Callers must hold the mutex
+func (ec *MockRemoteCache) leaseHolder(key string) (string, bool) {
+	ec.cleanupExpiry()
+	val, ok := ec.Data[key]
+	if !ok || len(val) == 0 {
+		return "", false
+	}
+	return val[0], true
+}
+
+func (ec *MockRemoteCache) LeaseAcquire(key string, tokenKey string, holder string,
+	life time.Duration) (string, int64, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	var token int64
+	if val, ok := ec.Data[tokenKey]; ok && len(val) > 0 {
+		var err error
+		token, err = strconv.ParseInt(val[0], 10, 64)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	current, held := ec.leaseHolder(key)
+	if !held {
+		token++
+		ec.Data[tokenKey] = []string{strconv.FormatInt(token, 10)}
+		ec.Data[key] = []string{holder}
+		ec.Expirations[key] = time.Now().Add(life)
+		return holder, token, nil
+	}
+	if current == holder {
+		ec.Expirations[key] = time.Now().Add(life)
+	}
+	return current, token, nil
+}
+
+func (ec *MockRemoteCache) LeaseRenew(key string, holder string, life time.Duration) (bool, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if current, held := ec.leaseHolder(key); !held || current != holder {
+		return false, nil
+	}
+	ec.Expirations[key] = time.Now().Add(life)
+	return true, nil
+}
+
+func (ec *MockRemoteCache) LeaseRelease(key string, holder string) (bool, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if current, held := ec.leaseHolder(key); !held || current != holder {
+		return false, nil
+	}
+	delete(ec.Data, key)
+	delete(ec.Expirations, key)
+	return true, nil
 }
 
+// Like BRPOPLPUSH, moves the tail of key to the head of dest, polling until
+// timeout for key to be non-empty.
 func (ec *MockRemoteCache) BlockingPopCopy(key string, dest string,
 	timeout time.Duration) (string, error) {
-	v, err := ec.Pop(key)
-	if err != nil {
-		return "", err
-	}
-	_, err = ec.Queue(dest, v)
-	if err != nil {
-		return "", err
+	deadline := time.Now().Add(timeout)
+	for {
+		ec.mutex.Lock()
+		ec.cleanupExpiry()
+		if count := len(ec.Lists[key]); count > 0 {
+			v := ec.listRemoveAt(key, count-1)
+			ec.Lists[dest] = append([]string{v}, ec.Lists[dest]...)
+			ec.mutex.Unlock()
+			return v, nil
+		}
+		ec.mutex.Unlock()
+
+		if !time.Now().Before(deadline) {
+			return "", errors.New(EMPTY_QUEUE)
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
-	return v, err
 }
 
 func (ec *MockRemoteCache) ListRemove(key string, value string) error {
-	_, err := ec.SetRemove(key, value)
-	return err
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	for idx, v := range ec.Lists[key] {
+		if v == value {
+			ec.listRemoveAt(key, idx)
+			break
+		}
+	}
+	return nil
+}
+
+func (ec *MockRemoteCache) StreamAdd(key string, value string, maxLen int64) (string, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.streamSeq++
+	id := fmt.Sprintf("0-%d", ec.streamSeq)
+	ec.Streams[key] = append(ec.Streams[key], StreamEntry{ID: id, Value: value})
+	if maxLen > 0 && int64(len(ec.Streams[key])) > maxLen {
+		ec.Streams[key] = ec.Streams[key][int64(len(ec.Streams[key]))-maxLen:]
+	}
+	return id, nil
+}
+
+func mockStreamSeq(id string) uint64 {
+	var ms, seq uint64
+	if _, err := fmt.Sscanf(id, "%d-%d", &ms, &seq); err != nil {
+		return 0
+	}
+	return seq
+}
+
+func (ec *MockRemoteCache) StreamRead(key string, afterID string, count int64,
+	block time.Duration) ([]StreamEntry, error) {
+	after := mockStreamSeq(afterID)
+	entries := []StreamEntry{}
+	ec.mutex.Lock()
+	for _, entry := range ec.Streams[key] {
+		if mockStreamSeq(entry.ID) > after {
+			entries = append(entries, entry)
+		}
+		if count > 0 && int64(len(entries)) >= count {
+			break
+		}
+	}
+	ec.mutex.Unlock()
+	if len(entries) == 0 && block > 0 {
+		time.Sleep(block)
+	}
+	return entries, nil
+}
+
+func (ec *MockRemoteCache) StreamLength(key string) (int64, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return int64(len(ec.Streams[key])), nil
 }
 
 func (ec *MockRemoteCache) StoreLogState(log *CertificateLog) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
 	encoded, err := json.Marshal(log)
 	if err != nil {
 		return err
 	}
 
-	ec.Data[log.ShortURL] = []string{string(encoded)}
+	ec.Data[shortUrlToLogKey(log.ShortURL)] = []string{string(encoded)}
 	return nil
 }
 
 func (ec *MockRemoteCache) LoadLogState(shortUrl string) (*CertificateLog, error) {
-	data, ok := ec.Data[shortUrl]
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	data, ok := ec.Data[shortUrlToLogKey(shortUrl)]
 	if !ok {
 		return nil, fmt.Errorf("Log state not found")
 	}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_MockSetRemove(t *testing.T) {
+	mc := NewMockRemoteCache()
+	for _, v := range []string{"a", "b", "c"} {
+		if _, err := mc.SetInsert("set", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := mc.SetRemove("set", "b")
+	if err != nil || !removed {
+		t.Errorf("Expected to remove b: %v %v", removed, err)
+	}
+	removed, err = mc.SetRemove("set", "z")
+	if err != nil || removed {
+		t.Errorf("Expected not to remove z: %v %v", removed, err)
+	}
+
+	list, err := mc.SetList("set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, []string{"a", "c"}) {
+		t.Errorf("Expected [a c] but got %v", list)
+	}
+}
+
+func Test_MockQueue(t *testing.T) {
+	mc := NewMockRemoteCache()
+
+	if _, err := mc.Pop("q"); err == nil || err.Error() != EMPTY_QUEUE {
+		t.Errorf("Expected %s but got %v", EMPTY_QUEUE, err)
+	}
+
+	for i, v := range []string{"one", "two", "three"} {
+		count, err := mc.Queue("q", v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != int64(i+1) {
+			t.Errorf("Expected length %d but got %d", i+1, count)
+		}
+	}
+
+	v, err := mc.Pop("q")
+	if err != nil || v != "one" {
+		t.Errorf("Expected one but got %s %v", v, err)
+	}
+
+	if _, err := mc.QueueHead("q", "zero"); err != nil {
+		t.Fatal(err)
+	}
+	v, err = mc.Pop("q")
+	if err != nil || v != "zero" {
+		t.Errorf("Expected zero but got %s %v", v, err)
+	}
+
+	// Like BRPOPLPUSH, the tail moves to the head of the destination
+	v, err = mc.BlockingPopCopy("q", "dest", time.Second)
+	if err != nil || v != "three" {
+		t.Errorf("Expected three but got %s %v", v, err)
+	}
+	list, err := mc.QueueList("dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, []string{"three"}) {
+		t.Errorf("Expected [three] but got %v", list)
+	}
+
+	if err := mc.ListRemove("q", "two"); err != nil {
+		t.Fatal(err)
+	}
+	exists, err := mc.Exists("q")
+	if err != nil || exists {
+		t.Errorf("Empty lists shouldn't exist: %v %v", exists, err)
+	}
+
+	start := time.Now()
+	_, err = mc.BlockingPopCopy("q", "dest", 50*time.Millisecond)
+	if err == nil || err.Error() != EMPTY_QUEUE {
+		t.Errorf("Expected %s but got %v", EMPTY_QUEUE, err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Should have waited for the timeout")
+	}
+}
+
+func Test_MockLease(t *testing.T) {
+	mc := NewMockRemoteCache()
+	expectLease(t, mc, "lease", "token")
+}
+
+func Test_MockSetsReplace(t *testing.T) {
+	mc := NewMockRemoteCache()
+	expectSetsReplace(t, mc, "replace", "other")
+}
+
+func Test_MockGet(t *testing.T) {
+	mc := NewMockRemoteCache()
+	if _, err := mc.TrySet("key", "value", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	v, err := mc.Get("key")
+	if err != nil || v != "value" {
+		t.Errorf("Expected value but got %s %v", v, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	v, err = mc.Get("key")
+	if err != nil || v != "" {
+		t.Errorf("Expired keys should be empty, got %s %v", v, err)
+	}
+}
@@ -17,7 +17,15 @@ func (db *NoopBackend) noopLoadError() error {
 	return fmt.Errorf("Unable to load from the NoopBackend.")
 }
 
-func (db *NoopBackend) MarkDirty(id string) error {
+func (db *NoopBackend) MarkDirty(_ context.Context, _ IssuerAndDate) error {
+	return nil
+}
+
+func (db *NoopBackend) ListDirty(_ context.Context, _ time.Time) ([]DirtyBucket, error) {
+	return []DirtyBucket{}, db.noopLoadError()
+}
+
+func (db *NoopBackend) ClearDirty(_ context.Context, _ DirtyBucket) error {
 	return nil
 }
 
@@ -26,11 +34,19 @@ func (db *NoopBackend) AllocateExpDateAndIssuer(_ context.Context, _ ExpDate,
 	return nil
 }
 
+func (db *NoopBackend) DeleteExpirationDate(_ context.Context, _ ExpDate) error {
+	return nil
+}
+
 func (db *NoopBackend) StoreCertificatePEM(_ context.Context, _ Serial, _ ExpDate,
 	_ Issuer, _ []byte) error {
 	return nil
 }
 
+func (db *NoopBackend) StoreIssuerPEM(_ context.Context, _ Issuer, _ []byte) error {
+	return nil
+}
+
 func (db *NoopBackend) StoreLogState(_ context.Context, _ *CertificateLog) error {
 	return nil
 }
@@ -45,6 +61,10 @@ func (db *NoopBackend) LoadCertificatePEM(_ context.Context, _ Serial, _ ExpDate
 	return []byte{}, db.noopLoadError()
 }
 
+func (db *NoopBackend) LoadIssuerPEM(_ context.Context, _ Issuer) ([]byte, error) {
+	return []byte{}, db.noopLoadError()
+}
+
 func (db *NoopBackend) LoadLogState(_ context.Context, _ string) (*CertificateLog, error) {
 	return nil, db.noopLoadError()
 }
@@ -67,3 +87,15 @@ func (db *NoopBackend) StreamSerialsForExpirationDateAndIssuer(_ context.Context
 	_ Issuer, _ <-chan struct{}, _ chan<- UniqueCertIdentifier) error {
 	return db.noopLoadError()
 }
+
+func (db *NoopBackend) StoreRunFile(_ context.Context, _ string, _ string, _ []byte) error {
+	return nil
+}
+
+func (db *NoopBackend) LoadRunFile(_ context.Context, _ string, _ string) ([]byte, error) {
+	return []byte{}, db.noopLoadError()
+}
+
+func (db *NoopBackend) ListRuns(_ context.Context) ([]string, error) {
+	return []string{}, db.noopLoadError()
+}
@@ -106,6 +106,48 @@ func (rc *RedisCache) SetCardinality(key string) (int, error) {
 	return int(v), err
 }
 
+// Pipelines SCARD of each key, in one round trip.
+func (rc *RedisCache) SetCardinalities(keys []string) ([]int64, error) {
+	defer metrics.MeasureSince([]string{"SetCardinalities"}, time.Now())
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := rc.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.SCard(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, len(keys))
+	for i, cmd := range cmds {
+		counts[i] = cmd.Val()
+	}
+	return counts, nil
+}
+
+// Pipelines SISMEMBER of entry in each key, in one round trip.
+func (rc *RedisCache) SetContainsEach(keys []string, entry string) ([]bool, error) {
+	defer metrics.MeasureSince([]string{"SetContainsEach"}, time.Now())
+	cmds := make([]*redis.BoolCmd, len(keys))
+	_, err := rc.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.SIsMember(key, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]bool, len(keys))
+	for i, cmd := range cmds {
+		found[i] = cmd.Val()
+	}
+	return found, nil
+}
+
 func (rc *RedisCache) Exists(key string) (bool, error) {
 	defer metrics.MeasureSince([]string{"Exists"}, time.Now())
 	ir := rc.client.Exists(key)
@@ -129,6 +171,11 @@ func (rc *RedisCache) Queue(key string, identifier string) (int64, error) {
 	return ir.Result()
 }
 
+func (rc *RedisCache) QueueHead(key string, identifier string) (int64, error) {
+	ir := rc.client.LPush(key, identifier)
+	return ir.Result()
+}
+
 func (rc *RedisCache) BlockingPopCopy(key string, dest string,
 	timeout time.Duration) (string, error) {
 	sr := rc.client.BRPopLPush(key, dest, timeout)
@@ -150,6 +197,11 @@ func (rc *RedisCache) QueueLength(key string) (int64, error) {
 	return ir.Result()
 }
 
+func (rc *RedisCache) QueueList(key string) ([]string, error) {
+	sr := rc.client.LRange(key, 0, -1)
+	return sr.Result()
+}
+
 func (rc *RedisCache) KeysToChan(pattern string, c chan<- string) error {
 	defer close(c)
 	defer metrics.MeasureSince([]string{"KeysToChan"}, time.Now())
@@ -168,6 +220,295 @@ func (rc *RedisCache) KeysToChan(pattern string, c chan<- string) error {
 	return iter.Err()
 }
 
+func (rc *RedisCache) Delete(key string) error {
+	defer metrics.MeasureSince([]string{"Delete"}, time.Now())
+	return rc.client.Del(key).Err()
+}
+
+// The number of entries each SADD stages in SetsReplace
+const kReplaceBatchSize = 1000
+
+// How long a SetsReplace which fails partway leaves its staged sets
+const kReplaceStagingLife = time.Hour
+
+// Stages each set in a new key with pipelined, batched SADDs, then renames
+// them all into place in one transaction, so readers see either the old sets
+// or the new ones.
+func (rc *RedisCache) SetsReplace(aSets map[string][]string, aExpTime time.Time) error {
+	defer metrics.MeasureSince([]string{"SetsReplace"}, time.Now())
+	stagingSuffix := fmt.Sprintf("::staging::%d", time.Now().UnixNano())
+
+	for key, entries := range aSets {
+		if len(entries) == 0 {
+			continue
+		}
+		staging := key + stagingSuffix
+		_, err := rc.client.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(staging)
+			for start := 0; start < len(entries); start += kReplaceBatchSize {
+				end := start + kReplaceBatchSize
+				if end > len(entries) {
+					end = len(entries)
+				}
+				members := make([]interface{}, 0, end-start)
+				for _, entry := range entries[start:end] {
+					members = append(members, entry)
+				}
+				pipe.SAdd(staging, members...)
+			}
+			pipe.Expire(staging, kReplaceStagingLife)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("Couldn't stage %s: %v", key, err)
+		}
+	}
+
+	_, err := rc.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		for key, entries := range aSets {
+			if len(entries) == 0 {
+				pipe.Del(key)
+				continue
+			}
+			pipe.Rename(key+stagingSuffix, key)
+			pipe.ExpireAt(key, aExpTime)
+		}
+		return nil
+	})
+	return err
+}
+
+// Inserts an entry in its place in the packed blob in the hash field unless it
+// is already there, counting entries in the field kPackedCountField. Must
+// match packedInsert. Entries are compared bytewise, since Lua's < collates.
+var packedSetInsertScript = redis.NewScript(`
+local blob = redis.call('HGET', KEYS[1], ARGV[1]) or ''
+local entry = ARGV[2]
+local len = string.len(entry)
+
+local function less(a, b)
+	for k = 1, len do
+		local x, y = string.byte(a, k), string.byte(b, k)
+		if x ~= y then
+			return x < y
+		end
+	end
+	return false
+end
+
+-- Find the group of entries of this length, or where it belongs
+local offset = string.len(blob) + 1
+local count = 0
+local i = 1
+while i + 4 <= string.len(blob) do
+	local l, a, b, c, d = string.byte(blob, i, i + 4)
+	local n = ((a * 256 + b) * 256 + c) * 256 + d
+	if l >= len then
+		offset = i
+		if l == len then
+			count = n
+		end
+		break
+	end
+	i = i + 5 + l * n
+end
+
+-- Binary-search the group
+local start = offset + 5
+local lo, hi = 0, count
+while lo < hi do
+	local mid = math.floor((lo + hi) / 2)
+	local at = start + mid * len
+	local v = string.sub(blob, at, at + len - 1)
+	if v == entry then
+		return 0
+	end
+	if less(v, entry) then
+		lo = mid + 1
+	else
+		hi = mid
+	end
+end
+
+local n = count + 1
+local header = string.char(len, math.floor(n / 16777216) % 256, math.floor(n / 65536) % 256,
+	math.floor(n / 256) % 256, n % 256)
+if count == 0 then
+	blob = string.sub(blob, 1, offset - 1) .. header .. entry .. string.sub(blob, offset)
+else
+	local at = start + lo * len
+	blob = string.sub(blob, 1, offset - 1) .. header .. string.sub(blob, start, at - 1) .. entry ..
+		string.sub(blob, at)
+end
+redis.call('HSET', KEYS[1], ARGV[1], blob)
+redis.call('HINCRBY', KEYS[1], ARGV[3], 1)
+return 1
+`)
+
+func (rc *RedisCache) PackedSetInsert(key string, field string, entry string) (bool, error) {
+	defer metrics.MeasureSince([]string{"PackedSetInsert"}, time.Now())
+	if len(entry) > 255 {
+		return false, fmt.Errorf("Entry too long to pack: %d bytes", len(entry))
+	}
+	added, err := packedSetInsertScript.Run(rc.client, []string{key}, field, entry,
+		kPackedCountField).Int()
+	if err != nil && strings.HasPrefix(err.Error(), "OOM") {
+		glog.Fatalf("Out of memory on Redis insert of entry %s into key %s, error %v", entry, key, err.Error())
+	}
+	return added == 1, err
+}
+
+var hashTallyScript = redis.NewScript(`
+local at = tonumber(ARGV[2])
+local value = redis.call('HGET', KEYS[1], ARGV[1])
+if not value then
+	redis.call('HSET', KEYS[1], ARGV[1], '1 ' .. at .. ' ' .. at)
+	return 1
+end
+local count, first, last = string.match(value, '^(%d+) (%-?%d+) (%-?%d+)$')
+redis.call('HSET', KEYS[1], ARGV[1], string.format('%d %d %d', tonumber(count) + 1,
+	math.min(tonumber(first), at), math.max(tonumber(last), at)))
+return 0
+`)
+
+// Counts a sighting of field at aAt, keeping the earliest and latest times
+// seen. Returns true if field had never been seen.
+func (rc *RedisCache) HashTally(key string, field string, aAt time.Time) (bool, error) {
+	defer metrics.MeasureSince([]string{"HashTally"}, time.Now())
+	added, err := hashTallyScript.Run(rc.client, []string{key}, field, aAt.Unix()).Int()
+	return added == 1, err
+}
+
+// Increments each field by one, in a single round trip.
+func (rc *RedisCache) HashIncrement(key string, fields ...string) error {
+	defer metrics.MeasureSince([]string{"HashIncrement"}, time.Now())
+	_, err := rc.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, field := range fields {
+			pipe.HIncrBy(key, field, 1)
+		}
+		return nil
+	})
+	return err
+}
+
+func (rc *RedisCache) HashGet(key string, field string) (string, error) {
+	defer metrics.MeasureSince([]string{"HashGet"}, time.Now())
+	val, err := rc.client.HGet(key, field).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+// Pipelines HGET of field from each key, in one round trip. Missing fields
+// are empty.
+func (rc *RedisCache) HashGetEach(keys []string, field string) ([]string, error) {
+	defer metrics.MeasureSince([]string{"HashGetEach"}, time.Now())
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := rc.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGet(key, field)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	values := make([]string, len(keys))
+	for i, cmd := range cmds {
+		values[i] = cmd.Val()
+	}
+	return values, nil
+}
+
+func (rc *RedisCache) HashToChan(key string, c chan<- HashEntry) error {
+	defer close(c)
+	defer metrics.MeasureSince([]string{"HashToChan"}, time.Now())
+	scanres := rc.client.HScan(key, 0, "", 0)
+	err := scanres.Err()
+	if err != nil {
+		return err
+	}
+
+	iter := scanres.Iterator()
+
+	// HSCAN alternates fields and values
+	for iter.Next() {
+		field := iter.Val()
+		if !iter.Next() {
+			break
+		}
+		c <- HashEntry{
+			Field: field,
+			Value: iter.Val(),
+		}
+	}
+
+	return iter.Err()
+}
+
+// Reports the bytes Redis uses to hold key
+func (rc *RedisCache) MemoryUsage(key string) (int64, error) {
+	return rc.client.MemoryUsage(key).Result()
+}
+
+const kStreamField = "v"
+
+// Appends to the stream at key, trimming it to approximately maxLen entries
+// if maxLen is positive.
+func (rc *RedisCache) StreamAdd(key string, value string, maxLen int64) (string, error) {
+	defer metrics.MeasureSince([]string{"StreamAdd"}, time.Now())
+	sr := rc.client.XAdd(&redis.XAddArgs{
+		Stream:       key,
+		MaxLenApprox: maxLen,
+		Values:       map[string]interface{}{kStreamField: value},
+	})
+	return sr.Result()
+}
+
+// Reads up to count entries after afterID, waiting up to block for any to
+// arrive. A non-positive block returns immediately.
+func (rc *RedisCache) StreamRead(key string, afterID string, count int64,
+	block time.Duration) ([]StreamEntry, error) {
+	defer metrics.MeasureSince([]string{"StreamRead"}, time.Now())
+	if block <= 0 {
+		block = -1
+	}
+	xr := rc.client.XRead(&redis.XReadArgs{
+		Streams: []string{key, afterID},
+		Count:   count,
+		Block:   block,
+	})
+	streams, err := xr.Result()
+	if err == redis.Nil {
+		return []StreamEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []StreamEntry{}
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			value, ok := msg.Values[kStreamField].(string)
+			if !ok {
+				return entries, fmt.Errorf("Unexpected stream entry %s in %s: %+v", msg.ID, key, msg.Values)
+			}
+			entries = append(entries, StreamEntry{
+				ID:    msg.ID,
+				Value: value,
+			})
+		}
+	}
+	return entries, nil
+}
+
+func (rc *RedisCache) StreamLength(key string) (int64, error) {
+	ir := rc.client.XLen(key)
+	return ir.Result()
+}
+
 func (rc *RedisCache) TrySet(k string, v string, life time.Duration) (string, error) {
 	br := rc.client.SetNX(k, v, life)
 	if br.Err() != nil {
@@ -177,8 +518,79 @@ func (rc *RedisCache) TrySet(k string, v string, life time.Duration) (string, er
 	return sr.Result()
 }
 
+func (rc *RedisCache) Get(key string) (string, error) {
+	val, err := rc.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+// Takes the lease in KEYS[1] for ARGV[1] if no one holds it, incrementing the
+// fencing token in KEYS[2]. A holder asking again renews instead. Returns the
+// holder and the current token.
+var leaseAcquireScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if not holder then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return {ARGV[1], redis.call('INCR', KEYS[2])}
+end
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return {holder, tonumber(redis.call('GET', KEYS[2]) or '0')}
+`)
+
+var leaseRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var leaseReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (rc *RedisCache) LeaseAcquire(key string, tokenKey string, holder string,
+	life time.Duration) (string, int64, error) {
+	result, err := leaseAcquireScript.Run(rc.client, []string{key, tokenKey}, holder,
+		life.Milliseconds()).Result()
+	if err != nil {
+		return "", 0, err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return "", 0, fmt.Errorf("Unexpected lease result: %v", result)
+	}
+	current, ok := values[0].(string)
+	if !ok {
+		return "", 0, fmt.Errorf("Unexpected lease holder: %v", values[0])
+	}
+	token, ok := values[1].(int64)
+	if !ok {
+		return "", 0, fmt.Errorf("Unexpected lease token: %v", values[1])
+	}
+	return current, token, nil
+}
+
+func (rc *RedisCache) LeaseRenew(key string, holder string, life time.Duration) (bool, error) {
+	renewed, err := leaseRenewScript.Run(rc.client, []string{key}, holder, life.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (rc *RedisCache) LeaseRelease(key string, holder string) (bool, error) {
+	released, err := leaseReleaseScript.Run(rc.client, []string{key}, holder).Int()
+	return released == 1, err
+}
+
+const kLogState = "log"
+
 func shortUrlToLogKey(shortUrl string) string {
-	return fmt.Sprintf("log::%s", shortUrl)
+	return fmt.Sprintf("%s::%s", kLogState, shortUrl)
 }
 
 func (ec *RedisCache) StoreLogState(log *CertificateLog) error {
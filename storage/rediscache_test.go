@@ -317,6 +317,24 @@ func Test_RedisQueue(t *testing.T) {
 	if result != 1 {
 		t.Errorf("Queue should no longer be empty")
 	}
+
+	queueInsert(t, q, "six", 2, rc)
+	list, err := rc.QueueList(q)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(list) != 2 || list[0] != "five" || list[1] != "six" {
+		t.Errorf("Expected [five six] but got %v", list)
+	}
+
+	count, err := rc.QueueHead(q, "four")
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 entries but got %d", count)
+	}
+	queueExpect(t, q, "four", rc)
 }
 
 func isKeyPatternExpected(t *testing.T, rc *RedisCache, pattern string, expectedCount int) {
@@ -387,6 +405,15 @@ func Test_RedisTrySet(t *testing.T) {
 	if v2 != "me" {
 		t.Errorf("Should not have changed from me, is now %s", v2)
 	}
+
+	v3, err := rc.Get(q)
+	if err != nil || v3 != "me" {
+		t.Errorf("Expected to get me, got %s %v", v3, err)
+	}
+	missing, err := rc.Get(q + "missing")
+	if err != nil || missing != "" {
+		t.Errorf("Missing keys should be empty, got %s %v", missing, err)
+	}
 }
 
 func Test_RedisBlockingQueue(t *testing.T) {
@@ -479,3 +506,140 @@ func TestRedisLogState(t *testing.T) {
 	expectNilLogState(t, rc, "")
 	expectNilLogState(t, rc, fmt.Sprintf("%s/a", log.ShortURL))
 }
+
+func TestRedisHashTally(t *testing.T) {
+	t.Parallel()
+	rc := getRedisCache(t)
+
+	key := "TestRedisHashTally"
+	defer rc.client.Del(key)
+
+	early := time.Unix(1000, 0)
+	late := time.Unix(2000, 0)
+	for i, at := range []time.Time{late, early, late} {
+		added, err := rc.HashTally(key, "http://ocsp.example/", at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != (i == 0) {
+			t.Errorf("Only the first tally should be new, tally %d was %v", i, added)
+		}
+	}
+
+	value, err := rc.HashGet(key, "http://ocsp.example/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "3 1000 2000" {
+		t.Errorf("Unexpected tally %q", value)
+	}
+}
+
+// Checks the set replacement shared by every RemoteCache.
+func expectSetsReplace(t *testing.T, rc RemoteCache, key string, otherKey string) {
+	if _, err := rc.SetInsert(key, "stale"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.SetInsert(otherKey, "stale"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []string{"b", "a", "b"}
+	for i := 0; i < 2500; i++ {
+		entries = append(entries, fmt.Sprintf("entry-%d", i))
+	}
+	err := rc.SetsReplace(map[string][]string{key: entries, otherKey: {}}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := rc.SetCardinalities([]string{key, otherKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[0] != 2502 || counts[1] != 0 {
+		t.Errorf("Expected 2502 entries and an empty other set, got %v", counts)
+	}
+	if stale, err := rc.SetContains(key, "stale"); err != nil || stale {
+		t.Errorf("Expected the stale entry replaced: %v", err)
+	}
+}
+
+// Checks the lease semantics shared by every RemoteCache.
+func expectLease(t *testing.T, rc RemoteCache, key string, tokenKey string) {
+	holder, first, err := rc.LeaseAcquire(key, tokenKey, "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "a" {
+		t.Errorf("Expected a to hold the lease, got %s", holder)
+	}
+
+	holder, token, err := rc.LeaseAcquire(key, tokenKey, "b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "a" || token != first {
+		t.Errorf("Expected a to keep the lease with token %d, got %s %d", first, holder, token)
+	}
+
+	renewed, err := rc.LeaseRenew(key, "b", time.Minute)
+	if err != nil || renewed {
+		t.Errorf("Only the holder may renew: %v %v", renewed, err)
+	}
+	renewed, err = rc.LeaseRenew(key, "a", time.Minute)
+	if err != nil || !renewed {
+		t.Errorf("The holder should renew: %v %v", renewed, err)
+	}
+
+	released, err := rc.LeaseRelease(key, "b")
+	if err != nil || released {
+		t.Errorf("Only the holder may release: %v %v", released, err)
+	}
+	released, err = rc.LeaseRelease(key, "a")
+	if err != nil || !released {
+		t.Errorf("The holder should release: %v %v", released, err)
+	}
+	renewed, err = rc.LeaseRenew(key, "a", time.Minute)
+	if err != nil || renewed {
+		t.Errorf("A released lease can't be renewed: %v %v", renewed, err)
+	}
+
+	holder, token, err = rc.LeaseAcquire(key, tokenKey, "b", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "b" || token != first+1 {
+		t.Errorf("Expected b to hold the lease with token %d, got %s %d", first+1, holder, token)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	holder, token, err = rc.LeaseAcquire(key, tokenKey, "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != "a" || token != first+2 {
+		t.Errorf("Expected a to take the lapsed lease with token %d, got %s %d", first+2, holder, token)
+	}
+}
+
+func Test_RedisSetsReplace(t *testing.T) {
+	t.Parallel()
+	rc := getRedisCache(t)
+	key := "replaceTest"
+	otherKey := "replaceOtherTest"
+	defer rc.client.Del(key, otherKey)
+
+	expectSetsReplace(t, rc, key, otherKey)
+}
+
+func Test_RedisLease(t *testing.T) {
+	t.Parallel()
+	rc := getRedisCache(t)
+	key := "leaseTest"
+	tokenKey := "leaseTokenTest"
+	defer rc.client.Del(key, tokenKey)
+	rc.client.Del(key)
+
+	expectLease(t, rc, key, tokenKey)
+}
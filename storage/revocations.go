@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+const kRevoked = "revoked"
+const kUnrevoked = "unrevoked"
+
+// Revocations partitions a bucket's known serials into those its issuer's
+// CRLs revoke and those they don't. Each partition is a snapshot of the
+// known serials when the CRLs were checked, so serials learned since are in
+// neither.
+type Revocations struct {
+	expDate ExpDate
+	issuer  Issuer
+	cache   RemoteCache
+}
+
+func NewRevocations(aExpDate ExpDate, aIssuer Issuer, aCache RemoteCache) *Revocations {
+	return &Revocations{
+		expDate: aExpDate,
+		issuer:  aIssuer,
+		cache:   aCache,
+	}
+}
+
+func (r *Revocations) id(aPrefix string) string {
+	return fmt.Sprintf("%s::%s::%s", aPrefix, r.expDate.ID(), r.issuer.ID())
+}
+
+func binaryStrings(aSerials []Serial) []string {
+	strs := make([]string, 0, len(aSerials))
+	for _, serial := range aSerials {
+		strs = append(strs, serial.BinaryString())
+	}
+	return strs
+}
+
+// Replaces both partitions at once, so a failure partway leaves the previous
+// ones in place.
+func (r *Revocations) Store(aRevoked []Serial, aUnrevoked []Serial) error {
+	return r.cache.SetsReplace(map[string][]string{
+		r.id(kRevoked):   binaryStrings(aRevoked),
+		r.id(kUnrevoked): binaryStrings(aUnrevoked),
+	}, r.expDate.ExpireTime())
+}
+
+func (r *Revocations) stream(aKey string, aSerialChan chan<- Serial) error {
+	defer close(aSerialChan)
+
+	strChan := make(chan string)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- r.cache.SetToChan(aKey, strChan)
+	}()
+
+	seen := make(map[string]struct{})
+	for str := range strChan {
+		if _, ok := seen[str]; ok {
+			continue
+		}
+		seen[str] = struct{}{}
+		serial, err := NewSerialFromBinaryString(str)
+		if err != nil {
+			glog.Errorf("Failed to populate serial str=[%s] %v", str, err)
+			continue
+		}
+		aSerialChan <- serial
+	}
+	return <-errChan
+}
+
+// Sends each revoked serial to serialChan once, closing it when done.
+func (r *Revocations) StreamRevoked(aSerialChan chan<- Serial) error {
+	return r.stream(r.id(kRevoked), aSerialChan)
+}
+
+// Sends each serial known not to be revoked to serialChan once, closing it
+// when done.
+func (r *Revocations) StreamUnrevoked(aSerialChan chan<- Serial) error {
+	return r.stream(r.id(kUnrevoked), aSerialChan)
+}
+
+// The sizes of the revoked and unrevoked partitions.
+func (r *Revocations) Counts() (int64, int64, error) {
+	counts, err := r.cache.SetCardinalities([]string{r.id(kRevoked), r.id(kUnrevoked)})
+	if err != nil {
+		return 0, 0, err
+	}
+	return counts[0], counts[1], nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"sort"
+	"testing"
+)
+
+func streamHex(t *testing.T, aStream func(chan<- Serial) error) []string {
+	serialChan := make(chan Serial)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- aStream(serialChan)
+	}()
+	found := []string{}
+	for serial := range serialChan {
+		found = append(found, serial.HexString())
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+	return found
+}
+
+func Test_Revocations(t *testing.T) {
+	cache := NewMockRemoteCache()
+	revocations := NewRevocations(mkExp("2050-01-01"), NewIssuerFromString("issuer"), cache)
+
+	err := revocations.Store([]Serial{NewSerialFromHex("01")},
+		[]Serial{NewSerialFromHex("02"), NewSerialFromHex("03")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, unrevoked, err := revocations.Counts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 1 || unrevoked != 2 {
+		t.Errorf("Expected 1 revoked and 2 unrevoked, got %d and %d", revoked, unrevoked)
+	}
+
+	// A later CRL revokes another, replacing both partitions
+	err = revocations.Store([]Serial{NewSerialFromHex("01"), NewSerialFromHex("03")},
+		[]Serial{NewSerialFromHex("02")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := streamHex(t, revocations.StreamRevoked); len(found) != 2 || found[0] != "01" || found[1] != "03" {
+		t.Errorf("Expected 01 and 03 revoked, got %v", found)
+	}
+	if found := streamHex(t, revocations.StreamUnrevoked); len(found) != 1 || found[0] != "02" {
+		t.Errorf("Expected 02 unrevoked, got %v", found)
+	}
+
+	other := NewRevocations(mkExp("2050-01-02"), NewIssuerFromString("issuer"), cache)
+	if revoked, unrevoked, err := other.Counts(); err != nil || revoked != 0 || unrevoked != 0 {
+		t.Errorf("Expected other buckets empty, got %d and %d: %v", revoked, unrevoked, err)
+	}
+}
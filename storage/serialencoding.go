@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// SerialEncoding is how KnownCertificates lays out serials in the
+// RemoteCache.
+type SerialEncoding string
+
+const (
+	// Each serial is a member of a Redis set.
+	SetSerialEncoding SerialEncoding = "set"
+	// Serials are packed into blobs in the fields of a Redis hash, spread
+	// over kPackedFields fields by a hash of the serial. Each blob groups its
+	// serials by length, and keeps each group sorted, so lookups and inserts
+	// binary-search it. This trades CPU on insert for far less memory per
+	// serial.
+	PackedSerialEncoding SerialEncoding = "packed"
+)
+
+const (
+	kPackedSerials    = "pserials"
+	kPackedFields     = 256
+	kPackedCountField = "#"
+)
+
+func ParseSerialEncoding(s string) (SerialEncoding, error) {
+	switch SerialEncoding(s) {
+	case SetSerialEncoding, PackedSerialEncoding:
+		return SerialEncoding(s), nil
+	}
+	return SetSerialEncoding, fmt.Errorf("Unknown serial encoding: %s", s)
+}
+
+func (e SerialEncoding) keyPrefix() string {
+	if e == PackedSerialEncoding {
+		return kPackedSerials
+	}
+	return kSerials
+}
+
+func packedField(aSerial Serial) string {
+	h := fnv.New32a()
+	_, _ = h.Write(aSerial.serial)
+	return fmt.Sprintf("%02x", h.Sum32()%kPackedFields)
+}
+
+// Each group in a packed blob starts with its entries' length and their
+// count, then holds the entries, sorted. Groups are in order of length.
+const kPackedGroupHeaderLen = 5
+
+type packedGroup struct {
+	// Offset of the group's header
+	offset int
+	length int
+	count  int
+}
+
+func (g packedGroup) start() int {
+	return g.offset + kPackedGroupHeaderLen
+}
+
+func (g packedGroup) end() int {
+	return g.start() + g.length*g.count
+}
+
+func (g packedGroup) entry(aBlob string, i int) string {
+	at := g.start() + i*g.length
+	return aBlob[at : at+g.length]
+}
+
+// Calls f with each whole group in aBlob until it returns false.
+func eachPackedGroup(aBlob string, f func(packedGroup) bool) {
+	for i := 0; i+kPackedGroupHeaderLen <= len(aBlob); {
+		g := packedGroup{
+			offset: i,
+			length: int(aBlob[i]),
+			count:  int(binary.BigEndian.Uint32([]byte(aBlob[i+1 : i+kPackedGroupHeaderLen]))),
+		}
+		if g.end() > len(aBlob) || !f(g) {
+			return
+		}
+		i = g.end()
+	}
+}
+
+func unpackEntries(aBlob string) []string {
+	entries := []string{}
+	eachPackedGroup(aBlob, func(g packedGroup) bool {
+		for i := 0; i < g.count; i++ {
+			entries = append(entries, g.entry(aBlob, i))
+		}
+		return true
+	})
+	return entries
+}
+
+// Returns the group of aEntry's length, and the index in it where aEntry is or
+// belongs. If there's no such group, it's returned empty, at the offset it
+// belongs.
+func searchPacked(aBlob string, aEntry string) (packedGroup, int) {
+	group := packedGroup{offset: len(aBlob), length: len(aEntry)}
+	eachPackedGroup(aBlob, func(g packedGroup) bool {
+		if g.length < len(aEntry) {
+			return true
+		}
+		if g.length == len(aEntry) {
+			group = g
+		} else {
+			group.offset = g.offset
+		}
+		return false
+	})
+	idx := sort.Search(group.count, func(i int) bool {
+		return group.entry(aBlob, i) >= aEntry
+	})
+	return group, idx
+}
+
+func packedContains(aBlob string, aEntry string) bool {
+	group, idx := searchPacked(aBlob, aEntry)
+	return idx < group.count && group.entry(aBlob, idx) == aEntry
+}
+
+// Returns aBlob with aEntry inserted in its place, and whether it was added.
+// Must match packedSetInsertScript.
+func packedInsert(aBlob string, aEntry string) (string, bool) {
+	group, idx := searchPacked(aBlob, aEntry)
+	if idx < group.count && group.entry(aBlob, idx) == aEntry {
+		return aBlob, false
+	}
+
+	header := make([]byte, kPackedGroupHeaderLen)
+	header[0] = byte(len(aEntry))
+	binary.BigEndian.PutUint32(header[1:], uint32(group.count+1))
+	if group.count == 0 {
+		return aBlob[:group.offset] + string(header) + aEntry + aBlob[group.offset:], true
+	}
+	at := group.start() + idx*group.length
+	return aBlob[:group.offset] + string(header) + aBlob[group.start():at] + aEntry + aBlob[at:], true
+}
+
+// Inserts every serial aFrom knows into aTo, then checks each is there, so
+// aFrom can safely be deleted. Returns how many were inserted.
+func copyKnown(aFrom *KnownCertificates, aTo *KnownCertificates) (int64, error) {
+	serials, err := aFrom.ListKnown()
+	if err != nil {
+		return 0, err
+	}
+
+	var copied int64
+	for _, serial := range serials {
+		if _, err := aTo.WasUnknown(serial); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+
+	for _, serial := range serials {
+		known, err := aTo.IsKnown(serial)
+		if err != nil {
+			return copied, err
+		}
+		if !known {
+			return copied, fmt.Errorf("Serial %s of %s wasn't copied to %s", serial, aFrom.id(),
+				aTo.serialId())
+		}
+	}
+	return copied, nil
+}
+
+// Moves one bucket's serials from one encoding to the other, deleting the
+// original. Writers must be stopped, or serials they add to the original
+// during the migration will be lost.
+func MigrateSerialEncoding(aCache RemoteCache, aBucket IssuerAndDate, aFrom SerialEncoding,
+	aTo SerialEncoding) (int64, error) {
+	from := NewKnownCertificatesWithEncoding(aBucket.ExpDate, aBucket.Issuer, aCache, aFrom)
+	to := NewKnownCertificatesWithEncoding(aBucket.ExpDate, aBucket.Issuer, aCache, aTo)
+
+	migrated, err := copyKnown(from, to)
+	if err != nil {
+		return migrated, fmt.Errorf("Migration of %s from %s to %s failed: %v", aBucket.String(), aFrom,
+			aTo, err)
+	}
+
+	return migrated, aCache.Delete(from.serialId())
+}
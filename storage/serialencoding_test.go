@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var kEncodings = []SerialEncoding{SetSerialEncoding, PackedSerialEncoding}
+
+func exerciseEncoding(t *testing.T, cache RemoteCache, encoding SerialEncoding, issuerName string) {
+	expDate := mkExpDate("2050-01-30-05")
+	kc := NewKnownCertificatesWithEncoding(expDate, NewIssuerFromString(issuerName), cache, encoding)
+
+	testList := SerialList{
+		NewSerialFromHex("00"),
+		NewSerialFromHex("01"),
+		NewSerialFromHex("0001"),
+		NewSerialFromHex("03DEADBEEF"),
+		NewSerialFromHex("7FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"),
+	}
+
+	for _, serial := range testList {
+		if u, err := kc.WasUnknown(serial); err != nil || !u {
+			t.Errorf("[%s] %s should have been unknown: %v", encoding, serial, err)
+		}
+	}
+	for _, serial := range testList {
+		if u, err := kc.WasUnknown(serial); err != nil || u {
+			t.Errorf("[%s] %s should have been known: %v", encoding, serial, err)
+		}
+		if k, err := kc.IsKnown(serial); err != nil || !k {
+			t.Errorf("[%s] %s should be known: %v", encoding, serial, err)
+		}
+	}
+	if k, err := kc.IsKnown(NewSerialFromHex("02")); err != nil || k {
+		t.Errorf("[%s] 02 should not be known: %v", encoding, err)
+	}
+
+	if kc.Count() != int64(len(testList)) {
+		t.Errorf("[%s] Expected %d, got %d", encoding, len(testList), kc.Count())
+	}
+
+	result := SerialList(kc.Known())
+	sort.Sort(result)
+	sort.Sort(testList)
+	if !reflect.DeepEqual(testList, result) {
+		t.Errorf("[%s] Known should get the data: %+v // %+v", encoding, testList, result)
+	}
+}
+
+func Test_SerialEncodings(t *testing.T) {
+	for _, encoding := range kEncodings {
+		exerciseEncoding(t, NewMockRemoteCache(), encoding, "test issuer")
+	}
+}
+
+func Test_SerialEncodingsRedis(t *testing.T) {
+	rc := getRedisCache(t)
+	for _, encoding := range kEncodings {
+		issuerName := fmt.Sprintf("%s-%s", t.Name(), encoding)
+		kc := NewKnownCertificatesWithEncoding(mkExpDate("2050-01-30-05"), NewIssuerFromString(issuerName),
+			rc, encoding)
+		defer rc.client.Del(kc.serialId())
+		exerciseEncoding(t, rc, encoding, issuerName)
+	}
+}
+
+func Test_ParseSerialEncoding(t *testing.T) {
+	for _, encoding := range kEncodings {
+		parsed, err := ParseSerialEncoding(string(encoding))
+		if err != nil || parsed != encoding {
+			t.Errorf("Expected to parse %s: %v", encoding, err)
+		}
+	}
+	if _, err := ParseSerialEncoding("roaring"); err == nil {
+		t.Error("Expected an error for an unknown encoding")
+	}
+}
+
+func Test_PackedInsert(t *testing.T) {
+	blob := ""
+	inserted := map[string]bool{}
+	for _, hex := range []string{"03DEADBEEF", "01", "0001", "FF", "00", "01", "0000", "0300000000", "00"} {
+		entry := NewSerialFromHex(hex).BinaryString()
+		var added bool
+		blob, added = packedInsert(blob, entry)
+		if added == inserted[entry] {
+			t.Errorf("Expected %s added only the first time, got %v", hex, added)
+		}
+		inserted[entry] = true
+		if !packedContains(blob, entry) {
+			t.Errorf("Expected %s found once inserted", hex)
+		}
+	}
+	if packedContains(blob, NewSerialFromHex("02").BinaryString()) {
+		t.Error("02 should not be found")
+	}
+
+	// Grouped by length, each group sorted
+	expected := []string{"00", "01", "ff", "0000", "0001", "0300000000", "03deadbeef"}
+	unpacked := []string{}
+	for _, entry := range unpackEntries(blob) {
+		serial, err := NewSerialFromBinaryString(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unpacked = append(unpacked, serial.HexString())
+	}
+	if !reflect.DeepEqual(unpacked, expected) {
+		t.Errorf("Expected %v, got %v", expected, unpacked)
+	}
+}
+
+func Test_MigrateSerialEncoding(t *testing.T) {
+	cache := NewMockRemoteCache()
+	bucket := IssuerAndDate{
+		ExpDate: mkExpDate("2050-01-30-05"),
+		Issuer:  NewIssuerFromString("test issuer"),
+	}
+
+	source := NewKnownCertificates(bucket.ExpDate, bucket.Issuer, cache)
+	for _, serial := range []string{"01", "02", "03"} {
+		if _, err := source.WasUnknown(NewSerialFromHex(serial)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := MigrateSerialEncoding(cache, bucket, SetSerialEncoding, PackedSerialEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 migrated, got %d", count)
+	}
+
+	if exists, _ := cache.Exists(source.serialId()); exists {
+		t.Error("The set encoding should have been deleted")
+	}
+
+	migrated := NewKnownCertificatesWithEncoding(bucket.ExpDate, bucket.Issuer, cache, PackedSerialEncoding)
+	if migrated.Count() != 3 {
+		t.Errorf("Expected 3 packed serials, got %d", migrated.Count())
+	}
+	if _, ok := cache.Expirations[migrated.serialId()]; !ok {
+		t.Error("Expected the packed serials to expire")
+	}
+
+	storageDB, err := NewFilesystemDatabase(NewMockBackend(), cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageDB.SetSerialEncoding(PackedSerialEncoding)
+	issuers, err := storageDB.GetIssuerAndDatesFromCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuers) != 1 || len(issuers[0].ExpDates) != 1 {
+		t.Errorf("Expected the packed bucket to be listed: %+v", issuers)
+	}
+}
+
+// Claims to insert, without inserting, the packed serials in drop
+type droppingCache struct {
+	*MockRemoteCache
+	drop map[string]bool
+}
+
+func (c *droppingCache) PackedSetInsert(key string, field string, entry string) (bool, error) {
+	if c.drop[entry] {
+		return true, nil
+	}
+	return c.MockRemoteCache.PackedSetInsert(key, field, entry)
+}
+
+func Test_MigrateSerialEncodingChecksEachSerial(t *testing.T) {
+	cache := &droppingCache{MockRemoteCache: NewMockRemoteCache(), drop: map[string]bool{}}
+	bucket := IssuerAndDate{
+		ExpDate: mkExpDate("2050-01-30-05"),
+		Issuer:  NewIssuerFromString("test issuer"),
+	}
+
+	source := NewKnownCertificates(bucket.ExpDate, bucket.Issuer, cache)
+	dest := NewKnownCertificatesWithEncoding(bucket.ExpDate, bucket.Issuer, cache, PackedSerialEncoding)
+	for _, serial := range []string{"01", "02"} {
+		if _, err := source.WasUnknown(NewSerialFromHex(serial)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Enough already in the destination to make up for the lost serial
+	if _, err := dest.WasUnknown(NewSerialFromHex("03")); err != nil {
+		t.Fatal(err)
+	}
+	cache.drop[NewSerialFromHex("02").BinaryString()] = true
+
+	if _, err := MigrateSerialEncoding(cache, bucket, SetSerialEncoding, PackedSerialEncoding); err == nil {
+		t.Fatal("Expected the lost serial to fail the migration")
+	}
+	if source.Count() != 2 {
+		t.Errorf("Expected the source kept after a failed migration, got %d serials", source.Count())
+	}
+
+	delete(cache.drop, NewSerialFromHex("02").BinaryString())
+	count, err := MigrateSerialEncoding(cache, bucket, SetSerialEncoding, PackedSerialEncoding)
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 migrated, got %d %v", count, err)
+	}
+	if exists, _ := cache.Exists(source.serialId()); exists {
+		t.Error("The set encoding should have been deleted")
+	}
+	if dest.Count() != 3 {
+		t.Errorf("Expected 3 packed serials, got %d", dest.Count())
+	}
+}
+
+// Compares the memory Redis uses per serial in each encoding. Run with, e.g.,
+// -bench SerialEncodingMemory -benchtime 100000x
+func BenchmarkSerialEncodingMemory(b *testing.B) {
+	rc := getRedisCache(b)
+
+	for _, encoding := range kEncodings {
+		b.Run(string(encoding), func(b *testing.B) {
+			kc := NewKnownCertificatesWithEncoding(mkExpDate("2050-01-30-05"),
+				NewIssuerFromString(b.Name()), rc, encoding)
+			defer rc.client.Del(kc.serialId())
+
+			buf := make([]byte, 16)
+			for i := 0; i < b.N; i++ {
+				if _, err := rand.Read(buf); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := kc.WasUnknown(NewSerialFromBytes(append([]byte{}, buf...))); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			bytes, err := rc.MemoryUsage(kc.serialId())
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(bytes)/float64(b.N), "bytes/serial")
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+const kSerialFilterMinCapacity = 1024
+
+// serialFilter is a Bloom filter of serials. It never reports a serial it
+// was given as absent, and reports an absent serial as present with
+// probability at most falsePositiveRate, so long as it holds no more than
+// capacity serials.
+type serialFilter struct {
+	bits              []uint64
+	numBits           uint64
+	numHashes         uint64
+	count             uint64
+	capacity          uint64
+	falsePositiveRate float64
+}
+
+func newSerialFilter(aCapacity uint64, aFalsePositiveRate float64) *serialFilter {
+	if aCapacity < kSerialFilterMinCapacity {
+		aCapacity = kSerialFilterMinCapacity
+	}
+	n := float64(aCapacity)
+	m := math.Ceil(-n * math.Log(aFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+	numBits := uint64(m)
+
+	return &serialFilter{
+		bits:              make([]uint64, (numBits+63)/64),
+		numBits:           numBits,
+		numHashes:         uint64(k),
+		count:             0,
+		capacity:          aCapacity,
+		falsePositiveRate: aFalsePositiveRate,
+	}
+}
+
+// Double hashing, per Kirsch and Mitzenmacher, from the two halves of a
+// 128-bit FNV-1a digest
+func (f *serialFilter) hashes(aSerial Serial) (uint64, uint64) {
+	h := fnv.New128a()
+	_, _ = h.Write(aSerial.serial)
+	sum := h.Sum(nil)
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+	return h1, h2 | 1
+}
+
+func (f *serialFilter) add(aSerial Serial) {
+	h1, h2 := f.hashes(aSerial)
+	for i := uint64(0); i < f.numHashes; i++ {
+		bit := (h1 + i*h2) % f.numBits
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+func (f *serialFilter) test(aSerial Serial) bool {
+	h1, h2 := f.hashes(aSerial)
+	for i := uint64(0); i < f.numHashes; i++ {
+		bit := (h1 + i*h2) % f.numBits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Once over capacity, the false positive rate is no longer assured.
+func (f *serialFilter) saturated() bool {
+	return f.count > f.capacity
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func serialFromInt(i uint64) Serial {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, i)
+	return NewSerialFromBytes(buf)
+}
+
+func Test_SerialFilterNoFalseNegatives(t *testing.T) {
+	f := newSerialFilter(10000, 0.01)
+	for i := uint64(0); i < 10000; i++ {
+		f.add(serialFromInt(i))
+	}
+	for i := uint64(0); i < 10000; i++ {
+		if !f.test(serialFromInt(i)) {
+			t.Fatalf("Serial %d was added but not found", i)
+		}
+	}
+	if f.saturated() {
+		t.Error("Should not be saturated at capacity")
+	}
+	f.add(serialFromInt(10000))
+	if !f.saturated() {
+		t.Error("Should be saturated beyond capacity")
+	}
+}
+
+func Test_SerialFilterFalsePositiveRate(t *testing.T) {
+	f := newSerialFilter(10000, 0.01)
+	for i := uint64(0); i < 10000; i++ {
+		f.add(serialFromInt(i))
+	}
+
+	var falsePositives int
+	for i := uint64(1 << 32); i < (1<<32)+100000; i++ {
+		if f.test(serialFromInt(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 100000; rate > 0.02 {
+		t.Errorf("False positive rate %f is well beyond 0.01", rate)
+	}
+}
+
+type countingRemoteCache struct {
+	*MockRemoteCache
+	lookups int
+	failing bool
+}
+
+func (c *countingRemoteCache) SetContains(key string, entry string) (bool, error) {
+	c.lookups++
+	return c.MockRemoteCache.SetContains(key, entry)
+}
+
+func (c *countingRemoteCache) SetToChan(key string, c2 chan<- string) error {
+	if c.failing {
+		close(c2)
+		return fmt.Errorf("SetToChan failed")
+	}
+	return c.MockRemoteCache.SetToChan(key, c2)
+}
+
+func Test_KnownCertificatesFilter(t *testing.T) {
+	cache := &countingRemoteCache{MockRemoteCache: NewMockRemoteCache()}
+	expDate := mkExpDate("2050-01-30-05")
+	issuer := NewIssuerFromString("test issuer")
+
+	// Known before the filter exists, so only warming can find it
+	preexisting := NewKnownCertificates(expDate, issuer, cache)
+	if _, err := preexisting.WasUnknown(NewSerialFromHex("01")); err != nil {
+		t.Fatal(err)
+	}
+
+	kc := NewKnownCertificates(expDate, issuer, cache)
+	kc.EnableFilter(1e-9)
+
+	// A failed warming leaves no filter, so everything is looked up
+	cache.failing = true
+	if !kc.beginWarming() {
+		t.Fatal("Expected to warm a missing filter")
+	}
+	kc.warmFilter()
+	if kc.beginWarming() {
+		t.Error("Expected a failed warming not to be retried at once")
+	}
+	if known, err := kc.mayBeKnown(NewSerialFromHex("02")); err != nil || known || cache.lookups != 1 {
+		t.Errorf("Without a filter, 02 should have been looked up and unknown: %v, %d lookups", err,
+			cache.lookups)
+	}
+
+	cache.failing = false
+	kc.filterRetry = time.Time{}
+	if !kc.beginWarming() {
+		t.Fatal("Expected to warm a missing filter")
+	}
+	kc.warmFilter()
+	cache.lookups = 0
+
+	// Definite misses skip the lookup, while hits are confirmed
+	if known, err := kc.mayBeKnown(NewSerialFromHex("02")); err != nil || known || cache.lookups != 0 {
+		t.Errorf("02 should have been unknown without a lookup: %v, %d lookups", err, cache.lookups)
+	}
+	if known, err := kc.mayBeKnown(NewSerialFromHex("01")); err != nil || !known || cache.lookups != 1 {
+		t.Errorf("01 should have been known from warming: %v, %d lookups", err, cache.lookups)
+	}
+	if u, err := kc.WasUnknown(NewSerialFromHex("02")); err != nil || !u {
+		t.Errorf("02 should have been unknown: %v", err)
+	}
+	if known, err := kc.mayBeKnown(NewSerialFromHex("02")); err != nil || !known {
+		t.Errorf("02 should now be known: %v", err)
+	}
+
+	// A false positive costs only the lookup
+	falsePositive := NewSerialFromHex("0badf00d")
+	kc.filter.add(falsePositive)
+	if known, err := kc.mayBeKnown(falsePositive); err != nil || known {
+		t.Errorf("A serial only the filter holds should have been unknown: %v", err)
+	}
+
+	// Serials inserted while the filter is built are added to it
+	for i := uint64(0); i < kSerialFilterMinCapacity+1; i++ {
+		if _, err := kc.WasUnknown(serialFromInt(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !kc.beginWarming() {
+		t.Fatal("Expected to rebuild a saturated filter")
+	}
+	if _, err := kc.WasUnknown(NewSerialFromHex("03")); err != nil {
+		t.Fatal(err)
+	}
+	kc.filterMutex.Lock()
+	pending := len(kc.filterPending)
+	kc.filterMutex.Unlock()
+	if pending != 1 {
+		t.Errorf("Expected 03 held until the filter is built, got %d pending", pending)
+	}
+	kc.warmFilter()
+	if kc.filter.saturated() || !kc.filter.test(NewSerialFromHex("03")) ||
+		!kc.filter.test(NewSerialFromHex("02")) {
+		t.Error("Expected the rebuilt filter to hold every serial")
+	}
+	if kc.Count() != kSerialFilterMinCapacity+4 {
+		t.Errorf("Expected %d serials, got %d", kSerialFilterMinCapacity+4, kc.Count())
+	}
+}
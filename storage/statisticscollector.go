@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// Round trips to the cache are pipelined this many commands at a time
+const kPipelineBatchSize = 512
+
+type ExpDateReport struct {
+	ExpDate string `json:"expDate"`
+	Serials int64  `json:"serials"`
+}
+
+type IssuerReport struct {
+	Issuer     string            `json:"issuer"`
+	IssuerDNs  []string          `json:"issuerDNs"`
+	CRLs       []string          `json:"crls"`
+	ExpDates   []ExpDateReport   `json:"expDates"`
+	Serials    int64             `json:"serials"`
+	Statistics *IssuerStatistics `json:"statistics"`
+}
+
+type TotalsReport struct {
+	Issuers    int               `json:"issuers"`
+	ExpDates   int               `json:"expDates"`
+	Serials    int64             `json:"serials"`
+	CRLs       int               `json:"crls"`
+	Statistics *IssuerStatistics `json:"statistics"`
+}
+
+type StatisticsReport struct {
+	Issuers []IssuerReport    `json:"issuers"`
+	Totals  TotalsReport      `json:"totals"`
+	Logs    []*CertificateLog `json:"logs"`
+}
+
+// StatisticsCollector reports on the issuers and buckets in the cache,
+// with NumWorkers issuers in flight at once and each issuer's buckets
+// counted BatchSize at a time in a single round trip.
+type StatisticsCollector struct {
+	db         CertDatabase
+	NumWorkers int
+	BatchSize  int
+	// Issuer IDs to report on. Empty reports on all of them.
+	Issuers map[string]struct{}
+	// Only buckets unexpired at NotBefore and starting before NotAfter are
+	// reported, when these are set.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Called after each issuer is complete
+	Progress func(aIssuersDone int, aIssuersTotal int)
+}
+
+func NewStatisticsCollector(aDB CertDatabase) *StatisticsCollector {
+	return &StatisticsCollector{
+		db:         aDB,
+		NumWorkers: 1,
+		BatchSize:  kPipelineBatchSize,
+		Issuers:    make(map[string]struct{}),
+	}
+}
+
+func (sc *StatisticsCollector) includes(aExpDate ExpDate) bool {
+	if !sc.NotBefore.IsZero() && aExpDate.IsExpiredAt(sc.NotBefore) {
+		return false
+	}
+	return sc.NotAfter.IsZero() || aExpDate.date.Before(sc.NotAfter)
+}
+
+func (sc *StatisticsCollector) filter(aIssuerList []IssuerDate) []IssuerDate {
+	filtered := make([]IssuerDate, 0, len(aIssuerList))
+	for _, issuerObj := range aIssuerList {
+		if _, ok := sc.Issuers[issuerObj.Issuer.ID()]; len(sc.Issuers) > 0 && !ok {
+			continue
+		}
+		expDates := make([]ExpDate, 0, len(issuerObj.ExpDates))
+		for _, expDate := range issuerObj.ExpDates {
+			if sc.includes(expDate) {
+				expDates = append(expDates, expDate)
+			}
+		}
+		if len(expDates) == 0 {
+			continue
+		}
+		sort.Sort(ExpDateList(expDates))
+		filtered = append(filtered, IssuerDate{Issuer: issuerObj.Issuer, ExpDates: expDates})
+	}
+	return filtered
+}
+
+func (sc *StatisticsCollector) collectIssuer(aIssuerObj IssuerDate) (IssuerReport, error) {
+	meta := sc.db.GetIssuerMetadata(aIssuerObj.Issuer)
+	report := IssuerReport{
+		Issuer:    aIssuerObj.Issuer.ID(),
+		IssuerDNs: meta.Issuers(),
+		CRLs:      meta.CRLs(),
+		ExpDates:  make([]ExpDateReport, 0, len(aIssuerObj.ExpDates)),
+	}
+
+	for start := 0; start < len(aIssuerObj.ExpDates); start += sc.BatchSize {
+		end := start + sc.BatchSize
+		if end > len(aIssuerObj.ExpDates) {
+			end = len(aIssuerObj.ExpDates)
+		}
+		buckets := make([]IssuerAndDate, 0, end-start)
+		for _, expDate := range aIssuerObj.ExpDates[start:end] {
+			buckets = append(buckets, IssuerAndDate{ExpDate: expDate, Issuer: aIssuerObj.Issuer})
+		}
+
+		counts, err := sc.db.CountBuckets(buckets)
+		if err != nil {
+			return report, err
+		}
+		for i, bucket := range buckets {
+			report.ExpDates = append(report.ExpDates, ExpDateReport{
+				ExpDate: bucket.ExpDate.ID(),
+				Serials: counts[i],
+			})
+			report.Serials += counts[i]
+		}
+	}
+
+	stats, err := meta.Statistics()
+	report.Statistics = stats
+	return report, err
+}
+
+type issuerResult struct {
+	report IssuerReport
+	err    error
+}
+
+// Collects the report, without any log states.
+func (sc *StatisticsCollector) Collect() (*StatisticsReport, error) {
+	issuerList, err := sc.db.GetIssuerAndDatesFromCache()
+	if err != nil {
+		return nil, err
+	}
+	issuerList = sc.filter(issuerList)
+
+	issuerChan := make(chan IssuerDate, len(issuerList))
+	for _, issuerObj := range issuerList {
+		issuerChan <- issuerObj
+	}
+	close(issuerChan)
+
+	resultChan := make(chan issuerResult)
+	workers := sc.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for issuerObj := range issuerChan {
+				report, err := sc.collectIssuer(issuerObj)
+				resultChan <- issuerResult{report, err}
+			}
+		}()
+	}
+
+	report := &StatisticsReport{
+		Issuers: make([]IssuerReport, 0, len(issuerList)),
+		Totals: TotalsReport{
+			Issuers:    len(issuerList),
+			Statistics: NewIssuerStatistics(),
+		},
+		Logs: []*CertificateLog{},
+	}
+
+	var firstErr error
+	for done := 1; done <= len(issuerList); done++ {
+		result := <-resultChan
+		if result.err != nil && firstErr == nil {
+			firstErr = result.err
+		}
+
+		issuerReport := result.report
+		report.Totals.ExpDates += len(issuerReport.ExpDates)
+		report.Totals.Serials += issuerReport.Serials
+		report.Totals.CRLs += len(issuerReport.CRLs)
+		if issuerReport.Statistics != nil {
+			report.Totals.Statistics.Add(issuerReport.Statistics)
+		}
+		report.Issuers = append(report.Issuers, issuerReport)
+
+		if sc.Progress != nil {
+			sc.Progress(done, len(issuerList))
+		}
+	}
+
+	sort.Slice(report.Issuers, func(i, j int) bool {
+		return report.Issuers[i].Issuer < report.Issuers[j].Issuer
+	})
+	return report, firstErr
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_StatisticsCollector(t *testing.T) {
+	for _, encoding := range kEncodings {
+		storageDB, err := NewFilesystemDatabase(NewMockBackend(), NewMockRemoteCache())
+		if err != nil {
+			t.Fatal(err)
+		}
+		storageDB.SetSerialEncoding(encoding)
+
+		for i := 0; i < 4; i++ {
+			issuerCert := makeCert(t, fmt.Sprintf("Issuer %d", i), "2060-01-01", NewSerialFromHex("FF"))
+			for j, date := range []string{"2050-01-01", "2050-01-01", "2050-06-01"} {
+				cert := makeCert(t, "Leaf", date, NewSerialFromBytes([]byte{byte(i), byte(j)}))
+				if err := storageDB.Store(cert, issuerCert, "log.ct", int64(j)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		collector := NewStatisticsCollector(storageDB)
+		collector.NumWorkers = 3
+		collector.BatchSize = 1
+		var progressCalls int
+		collector.Progress = func(aDone int, aTotal int) {
+			progressCalls++
+			if aTotal != 4 || aDone != progressCalls {
+				t.Errorf("[%s] Unexpected progress %d/%d", encoding, aDone, aTotal)
+			}
+		}
+
+		report, err := collector.Collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if progressCalls != 4 {
+			t.Errorf("[%s] Expected progress for each issuer, got %d", encoding, progressCalls)
+		}
+		if report.Totals.Issuers != 4 || report.Totals.ExpDates != 8 || report.Totals.Serials != 12 {
+			t.Errorf("[%s] Unexpected totals: %+v", encoding, report.Totals)
+		}
+		if report.Totals.Statistics.Certificates != 12 {
+			t.Errorf("[%s] Expected statistics of 12 certificates: %+v", encoding, report.Totals.Statistics)
+		}
+		for _, issuerReport := range report.Issuers {
+			if len(issuerReport.ExpDates) != 2 || issuerReport.ExpDates[0].Serials != 2 ||
+				issuerReport.ExpDates[1].Serials != 1 || len(issuerReport.IssuerDNs) != 1 {
+				t.Errorf("[%s] Unexpected issuer report: %+v", encoding, issuerReport)
+			}
+		}
+
+		filtered := NewStatisticsCollector(storageDB)
+		filtered.Issuers[report.Issuers[0].Issuer] = struct{}{}
+		filtered.NotBefore = time.Date(2050, 03, 01, 0, 0, 0, 0, time.UTC)
+		filteredReport, err := filtered.Collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(filteredReport.Issuers) != 1 || filteredReport.Totals.ExpDates != 1 ||
+			filteredReport.Totals.Serials != 1 {
+			t.Errorf("[%s] Expected only one issuer's June bucket: %+v", encoding, filteredReport)
+		}
+	}
+}
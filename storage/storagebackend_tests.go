@@ -213,3 +213,146 @@ func BackendTestListingCertificates(t *testing.T, db StorageBackend) {
 		t.Errorf("Found %d entries, expected %d", count, len(expectedSerials))
 	}
 }
+
+func BackendTestDirty(t *testing.T, db StorageBackend) {
+	first := IssuerAndDate{ExpDate: mkExpDate("2050-05-20-01"), Issuer: NewIssuerFromString("first")}
+	second := IssuerAndDate{ExpDate: mkExpDate("2050-05-20-02"), Issuer: NewIssuerFromString("second")}
+
+	dirty, err := db.ListDirty(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 0 {
+		t.Errorf("Expected nothing dirty yet: %+v", dirty)
+	}
+
+	if err := db.MarkDirty(context.TODO(), first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkDirty(context.TODO(), second); err != nil {
+		t.Fatal(err)
+	}
+
+	dirty, err = db.ListDirty(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 2 {
+		t.Fatalf("Expected two dirty buckets: %+v", dirty)
+	}
+	sort.Slice(dirty, func(i, j int) bool {
+		return dirty[i].ExpDate.ID() < dirty[j].ExpDate.ID()
+	})
+	if dirty[0].String() != first.String() || dirty[1].String() != second.String() {
+		t.Errorf("Unexpected dirty buckets: %+v", dirty)
+	}
+
+	since, err := db.ListDirty(context.TODO(), dirty[1].MarkedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 0 {
+		t.Errorf("Nothing should be dirty since the last mark: %+v", since)
+	}
+
+	// Re-marking while processing must survive clearing the older listing
+	time.Sleep(time.Millisecond)
+	if err := db.MarkDirty(context.TODO(), second); err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range dirty {
+		if err := db.ClearDirty(context.TODO(), bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirty, err = db.ListDirty(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 1 || dirty[0].String() != second.String() {
+		t.Fatalf("Expected only the re-marked bucket: %+v", dirty)
+	}
+
+	if err := db.ClearDirty(context.TODO(), dirty[0]); err != nil {
+		t.Fatal(err)
+	}
+	dirty, err = db.ListDirty(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 0 {
+		t.Errorf("Expected everything cleared: %+v", dirty)
+	}
+}
+
+func BackendTestRunFiles(t *testing.T, db StorageBackend) {
+	runs, err := db.ListRuns(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 0 {
+		t.Errorf("Expected no runs yet: %+v", runs)
+	}
+
+	// Run files named like buckets mustn't be mistaken for them
+	if err := db.StoreRunFile(context.TODO(), "20501002-000000", "snapshot/2050-05-20/issuer",
+		[]byte{0x01, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StoreRunFile(context.TODO(), "20501001-000000", "manifest.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err = db.ListRuns(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(runs, []string{"20501001-000000", "20501002-000000"}) {
+		t.Errorf("Unexpected runs: %+v", runs)
+	}
+
+	data, err := db.LoadRunFile(context.TODO(), "20501002-000000", "snapshot/2050-05-20/issuer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0x01, 0x02}) {
+		t.Errorf("Unexpected run file contents: %x", data)
+	}
+	if _, err := db.LoadRunFile(context.TODO(), "20501001-000000", "missing"); err == nil {
+		t.Error("Should not have loaded a missing run file")
+	}
+
+	expDates, err := db.ListExpirationDates(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expDates) != 0 {
+		t.Errorf("Run files shouldn't be listed as expiration dates: %+v", expDates)
+	}
+}
+
+func BackendTestIssuerPEM(t *testing.T, db StorageBackend) {
+	issuer := NewIssuerFromString("issuer")
+	if _, err := db.LoadIssuerPEM(context.TODO(), issuer); err == nil {
+		t.Fatal("Should not have loaded a missing issuer")
+	}
+	if err := db.StoreIssuerPEM(context.TODO(), issuer, []byte("pem")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := db.LoadIssuerPEM(context.TODO(), issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "pem" {
+		t.Errorf("Unexpected issuer PEM: %s", data)
+	}
+
+	expDates, err := db.ListExpirationDates(context.TODO(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expDates) != 0 {
+		t.Errorf("Issuers shouldn't be listed as expiration dates: %+v", expDates)
+	}
+}
@@ -44,7 +44,9 @@ func (o *CertificateLog) ID() string {
 type DocumentType int
 
 type StorageBackend interface {
-	MarkDirty(id string) error
+	MarkDirty(ctx context.Context, bucket IssuerAndDate) error
+	ListDirty(ctx context.Context, aSince time.Time) ([]DirtyBucket, error)
+	ClearDirty(ctx context.Context, bucket DirtyBucket) error
 
 	StoreCertificatePEM(ctx context.Context, serial Serial, expDate ExpDate,
 		issuer Issuer, b []byte) error
@@ -54,9 +56,15 @@ type StorageBackend interface {
 
 	LoadCertificatePEM(ctx context.Context, serial Serial, expDate ExpDate,
 		issuer Issuer) ([]byte, error)
+
+	// Issuers' own certificates, which unlike the certificates they issue
+	// never expire from storage.
+	StoreIssuerPEM(ctx context.Context, issuer Issuer, b []byte) error
+	LoadIssuerPEM(ctx context.Context, issuer Issuer) ([]byte, error)
 	LoadLogState(ctx context.Context, logURL string) (*CertificateLog, error)
 
 	AllocateExpDateAndIssuer(ctx context.Context, expDate ExpDate, issuer Issuer) error
+	DeleteExpirationDate(ctx context.Context, expDate ExpDate) error
 
 	ListExpirationDates(ctx context.Context, aNotBefore time.Time) ([]ExpDate, error)
 	ListIssuersForExpirationDate(ctx context.Context, expDate ExpDate) ([]Issuer, error)
@@ -65,6 +73,12 @@ type StorageBackend interface {
 		issuer Issuer) ([]Serial, error)
 	StreamSerialsForExpirationDateAndIssuer(ctx context.Context, expDate ExpDate,
 		issuer Issuer, quitChan <-chan struct{}, stream chan<- UniqueCertIdentifier) error
+
+	// Runs hold the named files each generation run writes, such as its
+	// snapshot and stash. Names may contain slashes.
+	StoreRunFile(ctx context.Context, runID string, name string, b []byte) error
+	LoadRunFile(ctx context.Context, runID string, name string) ([]byte, error)
+	ListRuns(ctx context.Context) ([]string, error)
 }
 
 type CertDatabase interface {
@@ -73,11 +87,18 @@ type CertDatabase interface {
 	GetLogState(url *url.URL) (*CertificateLog, error)
 	Store(aCert *x509.Certificate, aIssuer *x509.Certificate, aURL string,
 		aEntryId int64) error
+	StoreIssuerCertificate(aIssuer *x509.Certificate) error
 	ListExpirationDates(aNotBefore time.Time) ([]ExpDate, error)
 	ListIssuersForExpirationDate(expDate ExpDate) ([]Issuer, error)
 	GetKnownCertificates(aExpDate ExpDate, aIssuer Issuer) *KnownCertificates
 	GetIssuerMetadata(aIssuer Issuer) *IssuerMetadata
+	PeekIssuerMetadata(aIssuer Issuer) *IssuerMetadata
 	GetIssuerAndDatesFromCache() ([]IssuerDate, error)
+	ListDirtyBuckets(aSince time.Time) ([]DirtyBucket, error)
+	ClearDirtyBuckets(aBuckets []DirtyBucket) error
+	CollectGarbage(aPolicy GCPolicy) (*GCReport, error)
+	CountBuckets(aBuckets []IssuerAndDate) ([]int64, error)
+	LocateSerial(aSerial Serial, aIssuer *Issuer) ([]IssuerAndDate, error)
 }
 
 type RemoteCache interface {
@@ -88,19 +109,55 @@ type RemoteCache interface {
 	SetList(key string) ([]string, error)
 	SetToChan(key string, c chan<- string) error
 	SetCardinality(key string) (int, error)
+	SetCardinalities(keys []string) ([]int64, error)
+	SetContainsEach(keys []string, entry string) ([]bool, error)
 	ExpireAt(key string, aExpTime time.Time) error
 	ExpireIn(key string, aDur time.Duration) error
 	Queue(key string, identifier string) (int64, error)
+	// Queue adds to the tail of a list, QueueHead to the head.
+	QueueHead(key string, identifier string) (int64, error)
 	Pop(key string) (string, error)
 	QueueLength(key string) (int64, error)
+	QueueList(key string) ([]string, error)
 	BlockingPopCopy(key string, dest string, timeout time.Duration) (string, error)
 	ListRemove(key string, value string) error
 	TrySet(k string, v string, life time.Duration) (string, error)
+	// Missing keys are empty.
+	Get(key string) (string, error)
+	// A lease is a key holding its holder's name until it expires. Only the
+	// holder can renew or release it, and each new holder gets a fencing token
+	// greater than the last, counted in tokenKey.
+	LeaseAcquire(key string, tokenKey string, holder string, life time.Duration) (string, int64, error)
+	LeaseRenew(key string, holder string, life time.Duration) (bool, error)
+	LeaseRelease(key string, holder string) (bool, error)
 	KeysToChan(pattern string, c chan<- string) error
+	Delete(key string) error
+	// Replaces the set in each key with its entries, all at once, expiring
+	// them at aExpTime. Keys without entries are deleted.
+	SetsReplace(aSets map[string][]string, aExpTime time.Time) error
+	PackedSetInsert(key string, field string, entry string) (bool, error)
+	HashGet(key string, field string) (string, error)
+	HashGetEach(keys []string, field string) ([]string, error)
+	HashToChan(key string, c chan<- HashEntry) error
+	HashTally(key string, field string, aAt time.Time) (bool, error)
+	HashIncrement(key string, fields ...string) error
+	StreamAdd(key string, value string, maxLen int64) (string, error)
+	StreamRead(key string, afterID string, count int64, block time.Duration) ([]StreamEntry, error)
+	StreamLength(key string) (int64, error)
 	StoreLogState(aLogObj *CertificateLog) error
 	LoadLogState(aLogUrl string) (*CertificateLog, error)
 }
 
+type HashEntry struct {
+	Field string
+	Value string
+}
+
+type StreamEntry struct {
+	ID    string
+	Value string
+}
+
 type Issuer struct {
 	id   *string
 	spki SPKI
@@ -330,28 +387,58 @@ func (t *IssuerAndDate) String() string {
 	return fmt.Sprintf("%s/%s", t.ExpDate.ID(), t.Issuer.ID())
 }
 
+// DirtyBucket is an IssuerAndDate which gained certificates, as of the last
+// time it was marked.
+type DirtyBucket struct {
+	IssuerAndDate
+	MarkedAt time.Time
+}
+
 type ExpDate struct {
-	date           time.Time
-	lastGood       time.Time
-	hourResolution bool
+	date        time.Time
+	lastGood    time.Time
+	granularity BucketGranularity
 }
 
 func NewExpDateFromTime(t time.Time) ExpDate {
 	truncTime := t.Truncate(time.Hour)
 	return ExpDate{
-		date:           truncTime,
-		lastGood:       truncTime.Add(-1 * time.Millisecond),
-		hourResolution: true,
+		date:        truncTime,
+		lastGood:    truncTime.Add(time.Hour - time.Millisecond),
+		granularity: HourGranularity,
+	}
+}
+
+// Returns the bucket of the given granularity holding t. Hourly buckets are
+// as NewExpDateFromTime.
+func NewExpDateFromTimeWithGranularity(t time.Time, aGranularity BucketGranularity) ExpDate {
+	if aGranularity == HourGranularity {
+		return NewExpDateFromTime(t)
+	}
+	start := aGranularity.truncate(t)
+	return ExpDate{
+		date:        start,
+		lastGood:    aGranularity.end(start).Add(-1 * time.Millisecond),
+		granularity: aGranularity,
 	}
 }
 
 func NewExpDate(s string) (ExpDate, error) {
+	if strings.Contains(s, "-W") {
+		t, err := parseISOWeek(s)
+		if err != nil {
+			return ExpDate{}, err
+		}
+		lastGood := WeekGranularity.end(t).Add(-1 * time.Millisecond)
+		return ExpDate{t, lastGood, WeekGranularity}, nil
+	}
+
 	if len(s) > 10 {
 		t, err := time.Parse(kExpirationFormatWithHour, s)
 		if err == nil {
 			lastGood := t.Add(1 * time.Hour)
 			lastGood = lastGood.Add(-1 * time.Millisecond)
-			return ExpDate{t, lastGood, true}, nil
+			return ExpDate{t, lastGood, HourGranularity}, nil
 		}
 	}
 
@@ -359,7 +446,7 @@ func NewExpDate(s string) (ExpDate, error) {
 	if err == nil {
 		lastGood := t.Add(24 * time.Hour)
 		lastGood = lastGood.Add(-1 * time.Millisecond)
-		return ExpDate{t, lastGood, false}, nil
+		return ExpDate{t, lastGood, DayGranularity}, nil
 	}
 	return ExpDate{}, err
 }
@@ -368,8 +455,13 @@ func (e ExpDate) IsExpiredAt(t time.Time) bool {
 	return e.lastGood.Before(t)
 }
 
+// The moment the bucket is expired, and can be dropped from the cache.
 func (e ExpDate) ExpireTime() time.Time {
-	return e.date
+	return e.lastGood.Add(time.Millisecond)
+}
+
+func (e ExpDate) Granularity() BucketGranularity {
+	return e.granularity
 }
 
 func (e ExpDate) String() string {
@@ -377,8 +469,12 @@ func (e ExpDate) String() string {
 }
 
 func (e ExpDate) ID() string {
-	if e.hourResolution {
+	switch e.granularity {
+	case HourGranularity:
 		return e.date.Format(kExpirationFormatWithHour)
+	case WeekGranularity:
+		year, week := e.date.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
 	}
 	return e.date.Format(kExpirationFormat)
 }
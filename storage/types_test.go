@@ -239,14 +239,16 @@ func TestExpDate(t *testing.T) {
 
 func TestExpDateFromTime(t *testing.T) {
 	date := time.Date(2004, 01, 20, 4, 22, 19, 44, time.UTC)
-	truncDate := time.Date(2004, 01, 20, 0, 0, 0, 0, time.UTC)
+	endDate := time.Date(2004, 01, 20, 5, 0, 0, 0, time.UTC)
 
 	expDate := NewExpDateFromTime(date)
-	if !expDate.IsExpiredAt(date) {
-		t.Errorf("Should have expired at its own time")
+	if expDate.IsExpiredAt(date) {
+		t.Errorf("Should not have expired within its hour")
 	}
-
-	if expDate.IsExpiredAt(truncDate.Add(-1 * time.Millisecond)) {
+	if !expDate.IsExpiredAt(endDate) {
+		t.Errorf("Should have expired at the end of its hour")
+	}
+	if expDate.IsExpiredAt(endDate.Add(-1 * time.Millisecond)) {
 		t.Errorf("Should not be expired a moment earlier")
 	}
 }